@@ -0,0 +1,388 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 管理接口令牌，为空时关闭管理接口
+var adminToken = flag.String("admin-token", "", "管理接口令牌（为空则关闭管理接口）")
+
+// 管理接口返回的连接信息
+type ConnectionInfo struct {
+	SiteID      string            `json:"siteId"`
+	IP          string            `json:"ip"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	Excluded    bool              `json:"excluded,omitempty"`
+	ConnectedAt int64             `json:"connectedAt"`
+	Transport   string            `json:"transport"`
+	Protocol    int               `json:"protocol"`
+	Encoding    string            `json:"encoding"`
+	Compressed  bool              `json:"compressed"`
+
+	// 挂件上报的脚本版本，未上报时为空
+	ClientVersion string `json:"clientVersion,omitempty"`
+}
+
+// 管理接口返回的站点信息
+type SiteInfo struct {
+	ID        string     `json:"id"`
+	Count     int        `json:"count"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	ExpiresIn *int64     `json:"expiresIn,omitempty"`
+}
+
+// 创建或修改站点的请求体
+type siteRequest struct {
+	ID        string     `json:"id"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// 管理接口返回的分组信息
+type GroupInfo struct {
+	Name    string         `json:"group"`
+	Sites   []string       `json:"sites"`
+	Count   int            `json:"count"`
+	Members map[string]int `json:"members"`
+}
+
+// 修改分组的请求体
+type groupRequest struct {
+	Sites []string `json:"sites"`
+}
+
+// 校验管理令牌，支持 Authorization: Bearer 与 token 查询参数
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if *adminToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// 处理连接列表请求，支持 siteId 与 meta.<key> 过滤
+func handleAdminConnections(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	siteFilter := params.Get("siteId")
+	transportFilter := params.Get("transport")
+	encodingFilter := params.Get("encoding")
+	metaFilter := make(map[string]string)
+	for key, values := range params {
+		if strings.HasPrefix(key, "meta.") && len(values) > 0 {
+			metaFilter[strings.TrimPrefix(key, "meta.")] = values[0]
+		}
+	}
+
+	connections := hub.listConnections(func(site *Site, client *Client) bool {
+		if siteFilter != "" && site.ID != siteFilter {
+			return false
+		}
+		if transportFilter != "" && client.kind.Transport != transportFilter {
+			return false
+		}
+		if encodingFilter != "" && client.kind.Encoding != encodingFilter {
+			return false
+		}
+		for key, value := range metaFilter {
+			if client.meta[key] != value {
+				return false
+			}
+		}
+		return true
+	})
+
+	writeJSON(w, http.StatusOK, connections)
+}
+
+// 列出满足条件的连接
+func (h *Hub) listConnections(match func(*Site, *Client) bool) []ConnectionInfo {
+	connections := []ConnectionInfo{}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, site := range h.sites {
+		site.mutex.RLock()
+		for _, clients := range []map[*Client]bool{site.Connections, site.Observers} {
+			for client := range clients {
+				if !match(site, client) {
+					continue
+				}
+				connections = append(connections, ConnectionInfo{
+					SiteID:      site.ID,
+					IP:          client.ip,
+					Meta:        client.meta,
+					Excluded:    client.observer,
+					ConnectedAt: client.connectedAt.Unix(),
+					Transport:   client.kind.Transport,
+					Protocol:    client.kind.Protocol,
+					Encoding:    client.kind.Encoding,
+					Compressed:  client.kind.Compressed,
+
+					ClientVersion: client.version,
+				})
+			}
+		}
+		site.mutex.RUnlock()
+	}
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ConnectedAt < connections[j].ConnectedAt
+	})
+	return connections
+}
+
+// 处理站点列表与创建请求
+func handleAdminSites(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, hub.listSites(time.Now()))
+	case "POST":
+		var req siteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.ID) == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		saveSiteExpiry(w, strings.TrimSpace(req.ID), req.ExpiresAt, http.StatusCreated)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// 处理单个站点的修改请求
+func handleAdminSite(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	siteID := strings.TrimPrefix(r.URL.Path, "/admin/api/sites/")
+	if id, ok := strings.CutSuffix(siteID, "/settings"); ok {
+		handleAdminSiteSettings(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(siteID, "/data"); ok {
+		handleAdminSiteData(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(siteID, "/disconnect"); ok && id != "" && !strings.Contains(id, "/") {
+		if r.Method != "POST" {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleAdminSiteDisconnect(w, r, id, false)
+		return
+	}
+	if siteID == "" || strings.Contains(siteID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "DELETE" {
+		handleAdminSiteDisconnect(w, r, siteID, true)
+		return
+	}
+	if r.Method != "PUT" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req siteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	saveSiteExpiry(w, siteID, req.ExpiresAt, http.StatusOK)
+}
+
+// 查看站点配置：GET /admin/api/sites/{id}/settings
+//
+// 带 resolved=true 时返回各字段的生效值及来源（site、instance 或 builtin）。
+func handleAdminSiteSettings(w http.ResponseWriter, r *http.Request, siteID string) {
+	if siteID == "" || strings.Contains(siteID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Query().Get("resolved") == "true" {
+		writeJSON(w, http.StatusOK, settings.Resolve(siteID).Fields())
+		return
+	}
+	writeJSON(w, http.StatusOK, settings.Get(siteID))
+}
+
+// 保存站点过期时间，nil 表示取消过期
+func saveSiteExpiry(w http.ResponseWriter, siteID string, expiresAt *time.Time, status int) {
+	now := time.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	err := settings.Update(siteID, func(site *SiteSettings) {
+		site.ExpiresAt = expiresAt
+	})
+	if err != nil {
+		log.Printf("保存站点配置失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, status, hub.siteInfo(siteID, now))
+}
+
+// 列出在线站点及设置了过期时间的站点
+func (h *Hub) listSites(now time.Time) []SiteInfo {
+	ids := make(map[string]bool)
+	h.mutex.RLock()
+	for id := range h.sites {
+		if _, ok := groupName(id); !ok {
+			ids[id] = true
+		}
+	}
+	h.mutex.RUnlock()
+	for id := range settings.Expiring() {
+		ids[id] = true
+	}
+
+	sites := make([]SiteInfo, 0, len(ids))
+	for id := range ids {
+		sites = append(sites, h.siteInfo(id, now))
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].ID < sites[j].ID
+	})
+	return sites
+}
+
+// 获取单个站点信息
+func (h *Hub) siteInfo(siteID string, now time.Time) SiteInfo {
+	info := SiteInfo{ID: siteID}
+
+	h.mutex.RLock()
+	site, exists := h.sites[siteID]
+	h.mutex.RUnlock()
+	if exists {
+		site.mutex.RLock()
+		info.Count = site.Count
+		site.mutex.RUnlock()
+	}
+
+	if expiresAt := settings.Get(siteID).ExpiresAt; expiresAt != nil {
+		expiresIn := int64(expiresAt.Sub(now).Seconds())
+		if expiresIn < 0 {
+			expiresIn = 0
+		}
+		info.ExpiresAt = expiresAt
+		info.ExpiresIn = &expiresIn
+	}
+
+	return info
+}
+
+// 处理分组列表请求
+func handleAdminGroups(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groups := []GroupInfo{}
+	for _, group := range settings.Groups() {
+		groups = append(groups, hub.groupInfo(group.Name, group.Sites))
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+// 处理单个分组的查询、修改与删除请求
+func handleAdminGroup(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/admin/api/groups/")
+	if name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		sites, exists := settings.Group(name)
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, hub.groupInfo(name, sites))
+	case "PUT":
+		var req groupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		sites, err := cleanGroupSites(req.Sites)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := settings.SetGroup(name, sites); err != nil {
+			log.Printf("保存分组配置失败: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		hub.queueGroupChanged(name)
+		writeJSON(w, http.StatusOK, hub.groupInfo(name, sites))
+	case "DELETE":
+		if _, exists := settings.Group(name); !exists {
+			http.NotFound(w, r)
+			return
+		}
+		if err := settings.SetGroup(name, nil); err != nil {
+			log.Printf("保存分组配置失败: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		hub.queueGroupChanged(name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// 获取分组信息
+func (h *Hub) groupInfo(name string, sites []string) GroupInfo {
+	count, members := h.groupCount(name)
+	return GroupInfo{Name: name, Sites: sites, Count: count, Members: members}
+}
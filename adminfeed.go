@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 全站人数流的发送队列长度，队列满时丢弃消息，不阻塞广播
+var adminFeedBuffer = flag.Int("admin-feed-buffer", 256, "全站人数流（/admin/feed）每个订阅者的发送队列长度，消费过慢时丢弃超出的消息")
+
+// 全站人数流的订阅者，接收所有站点的人数更新
+type AdminFeed struct {
+	conn    *websocket.Conn
+	hub     *Hub
+	send    chan []byte
+	dropped atomic.Int64
+}
+
+// 快照中一个站点的人数，seq 与该站点 update 中的 seq 相同
+type AdminFeedSite struct {
+	SiteID string `json:"siteId"`
+	Count  int    `json:"count"`
+	Peak   int    `json:"peak,omitempty"`
+	Seq    int64  `json:"seq,omitempty"`
+}
+
+// 连接建立时发送的全站快照
+type AdminFeedSnapshot struct {
+	Type      string          `json:"type"`
+	Sites     []AdminFeedSite `json:"sites"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+// 所有在线站点的当前人数，按 siteId 排序，不含分组
+func (h *Hub) adminFeedSnapshot() AdminFeedSnapshot {
+	h.mutex.RLock()
+	sites := make([]AdminFeedSite, 0, len(h.sites))
+	for id, site := range h.sites {
+		if _, ok := groupName(id); ok {
+			continue
+		}
+		var seq int64
+		if stats, exists := h.stats[id]; exists {
+			seq = stats.Seq
+		}
+		site.mutex.RLock()
+		sites = append(sites, AdminFeedSite{SiteID: id, Count: globalCount(id, site.Count), Peak: site.Peak, Seq: seq})
+		site.mutex.RUnlock()
+	}
+	h.mutex.RUnlock()
+
+	sort.Slice(sites, func(i, j int) bool { return sites[i].SiteID < sites[j].SiteID })
+	return AdminFeedSnapshot{Type: "snapshot", Sites: sites, Timestamp: time.Now().Unix()}
+}
+
+// 登记订阅者，快照在登记的同一把锁内入队，之后的人数更新都排在快照之后
+func (h *Hub) subscribeAdminFeed(feed *AdminFeed) error {
+	h.adminFeedMutex.Lock()
+	defer h.adminFeedMutex.Unlock()
+
+	data, err := json.Marshal(h.adminFeedSnapshot())
+	if err != nil {
+		return err
+	}
+	feed.send <- data
+	h.adminFeeds[feed] = true
+	return nil
+}
+
+// 取消订阅并关闭发送队列
+func (h *Hub) unsubscribeAdminFeed(feed *AdminFeed) {
+	h.adminFeedMutex.Lock()
+	if _, exists := h.adminFeeds[feed]; exists {
+		delete(h.adminFeeds, feed)
+		close(feed.send)
+	}
+	h.adminFeedMutex.Unlock()
+}
+
+// 全站人数流的订阅者数
+func (h *Hub) adminFeedSubscribers() int {
+	h.adminFeedMutex.RLock()
+	defer h.adminFeedMutex.RUnlock()
+	return len(h.adminFeeds)
+}
+
+// 把人数更新转发给全站人数流，只编码一次；订阅者队列已满时丢弃并计数
+func (h *Hub) publishAdminFeed(message Message) {
+	h.adminFeedMutex.RLock()
+	defer h.adminFeedMutex.RUnlock()
+	if len(h.adminFeeds) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	for feed := range h.adminFeeds {
+		select {
+		case feed.send <- data:
+		default:
+			feed.dropped.Add(1)
+			h.adminFeedDropped.Add(1)
+		}
+	}
+}
+
+// 处理全站人数流：GET /admin/feed（WebSocket），令牌取自 Authorization 头或 token 参数
+//
+// 连接后先收到 {"type":"snapshot","sites":[...]}，之后是所有站点的 update，
+// 与挂件收到的内容相同，以 siteId 区分；同一站点 seq 不大于快照中 seq 的 update 可以忽略。
+func handleAdminFeed(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if !isWebSocketRequest(r) {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if hub.closed.Load() {
+		writeError(w, ErrHubClosed)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	feed := &AdminFeed{
+		conn: conn,
+		hub:  hub,
+		send: make(chan []byte, max(*adminFeedBuffer, 1)),
+	}
+	if err := hub.subscribeAdminFeed(feed); err != nil {
+		conn.Close()
+		return
+	}
+
+	hub.pumps.Add(2)
+	go feed.readPump()
+	go feed.writePump()
+}
+
+// 读取订阅者连接，仅用于感知断开
+func (f *AdminFeed) readPump() {
+	defer func() {
+		f.hub.unsubscribeAdminFeed(f)
+		f.conn.Close()
+		f.hub.pumps.Done()
+	}()
+
+	f.conn.SetReadLimit(256)
+	f.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	f.conn.SetPongHandler(func(string) error {
+		f.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	for {
+		if _, _, err := f.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+// 向订阅者发送人数更新
+func (f *AdminFeed) writePump() {
+	ping := time.NewTicker(54 * time.Second)
+	defer func() {
+		ping.Stop()
+		f.conn.Close()
+		f.hub.pumps.Done()
+	}()
+
+	for {
+		select {
+		case data, ok := <-f.send:
+			f.conn.SetWriteDeadline(time.Now().Add(*writeTimeout))
+			if !ok {
+				f.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := f.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+
+		case <-ping.C:
+			f.conn.SetWriteDeadline(time.Now().Add(*writeTimeout))
+			if err := f.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
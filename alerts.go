@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 告警规则的评估间隔
+var alertInterval = flag.Duration("alert-interval", 10*time.Second, "告警规则的评估间隔")
+
+// 告警级别
+var alertSeverities = []string{"info", "warning", "critical"}
+
+// 告警状态
+const (
+	alertOK        = "ok"
+	alertPending   = "pending"
+	alertFiring    = "firing"
+	alertResolving = "resolving"
+	alertResolved  = "resolved"
+)
+
+// 可用于告警的指标及说明；*_per_min 为相邻两次评估之间的每分钟增量
+var alertMetrics = map[string]string{
+	"connections":            "本实例的连接数",
+	"sites":                  "本实例的在线站点数",
+	"goroutines":             "协程数",
+	"queue_depth":            "Hub 命令队列中排队的命令数",
+	"queue_oldest_ms":        "排队最久的命令已等待的毫秒数",
+	"queue_p99_ms":           "近期命令排队延迟的 p99（毫秒）",
+	"joins_per_min":          "每分钟加入次数",
+	"dropped_frames_per_min": "每分钟因发送队列已满而丢弃的消息数",
+	"ip_rejected_per_min":    "每分钟因单 IP 连接限制而拒绝的连接数",
+}
+
+// 计算速率的累计计数
+var alertCounters = map[string]func() int64{
+	"joins_per_min":          func() int64 { return hub.counters.SinceStart().Joins },
+	"dropped_frames_per_min": func() int64 { return hub.counters.SinceStart().DroppedFrames },
+	"ip_rejected_per_min":    func() int64 { return hub.ipRejected.Load() },
+}
+
+// 告警规则，定义在站点配置文件的 alerts 中
+type AlertRule struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+
+	// 条件持续满足多久才触发，恢复同样需要持续不满足这么久，如 "2m"
+	For      string `json:"for,omitempty"`
+	Severity string `json:"severity,omitempty"`
+
+	sustain time.Duration
+}
+
+// 校验规则并解析持续时间
+func (r *AlertRule) check() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return fmt.Errorf("告警规则缺少 name")
+	}
+	if _, exists := alertMetrics[r.Metric]; !exists {
+		return fmt.Errorf("告警规则 %q: 未知的指标 %q", r.Name, r.Metric)
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("告警规则 %q: op 须为 >、>=、< 或 <=", r.Name)
+	}
+	if r.For != "" {
+		sustain, err := time.ParseDuration(r.For)
+		if err != nil || sustain < 0 {
+			return fmt.Errorf("告警规则 %q: 无效的 for %q", r.Name, r.For)
+		}
+		r.sustain = sustain
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	for _, severity := range alertSeverities {
+		if r.Severity == severity {
+			return nil
+		}
+	}
+	return fmt.Errorf("告警规则 %q: severity 须为 %s", r.Name, strings.Join(alertSeverities, "、"))
+}
+
+// 校验一组规则，名称不能重复
+func checkAlertRules(rules []AlertRule) error {
+	names := make(map[string]bool, len(rules))
+	for i := range rules {
+		if err := rules[i].check(); err != nil {
+			return err
+		}
+		if names[rules[i].Name] {
+			return fmt.Errorf("告警规则 %q 重复定义", rules[i].Name)
+		}
+		names[rules[i].Name] = true
+	}
+	return nil
+}
+
+// 判断条件是否满足
+func (r AlertRule) matches(value float64) bool {
+	switch r.Op {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	default:
+		return value <= r.Threshold
+	}
+}
+
+// 单条规则的评估状态
+type alertState struct {
+	rule  AlertRule
+	state string
+	value float64
+
+	// 进入当前状态的时间与最近一次评估的时间
+	since       time.Time
+	evaluatedAt time.Time
+	firingSince time.Time
+}
+
+// 告警评估器
+//
+// 每条规则按 ok → pending → firing → resolving → ok 流转：条件满足后进入 pending，
+// 持续 for 后才触发；触发后条件不再满足进入 resolving，同样持续 for 后才恢复，
+// 期间条件再次满足则回到 firing 而不重复通知，指标在阈值附近波动时不会反复告警。
+type alertEvaluator struct {
+	mutex  sync.Mutex
+	rules  []string
+	states map[string]*alertState
+
+	// 上一次评估时的累计计数，用于计算速率
+	counters   map[string]int64
+	countersAt time.Time
+
+	// 状态变化时调用，在持有锁时调用，不能阻塞
+	notify func(AlertPayload)
+}
+
+// 全局告警评估器
+var alerts = newAlertEvaluator()
+
+func newAlertEvaluator() *alertEvaluator {
+	return &alertEvaluator{
+		states: make(map[string]*alertState),
+		notify: notifyAlert,
+	}
+}
+
+// 替换规则；定义未变的规则保留当前状态，修改过的规则从 ok 重新开始
+func (e *alertEvaluator) setRules(rules []AlertRule) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	states := make(map[string]*alertState, len(rules))
+	names := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if state, exists := e.states[rule.Name]; exists && state.rule == rule {
+			states[rule.Name] = state
+		} else {
+			states[rule.Name] = &alertState{rule: rule, state: alertOK}
+		}
+		names = append(names, rule.Name)
+	}
+	e.rules = names
+	e.states = states
+}
+
+// 按评估间隔采样并评估，规则为空时只采样
+func (e *alertEvaluator) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		e.evaluate(now, e.sample(now))
+	}
+}
+
+// 采集各指标的当前值；速率指标在第二次采样后才有值
+func (e *alertEvaluator) sample(now time.Time) map[string]float64 {
+	connections, sites := hub.totals()
+	queue := hub.queueInfo()
+	values := map[string]float64{
+		"connections":     float64(connections),
+		"sites":           float64(sites),
+		"goroutines":      float64(runtime.NumGoroutine()),
+		"queue_depth":     float64(queue.Depth),
+		"queue_oldest_ms": queue.OldestMs,
+		"queue_p99_ms":    queue.P99Ms,
+	}
+
+	counters := make(map[string]int64, len(alertCounters))
+	for name, read := range alertCounters {
+		counters[name] = read()
+	}
+	e.mutex.Lock()
+	if elapsed := now.Sub(e.countersAt); e.counters != nil && elapsed > 0 {
+		for name, count := range counters {
+			values[name] = float64(count-e.counters[name]) / elapsed.Minutes()
+		}
+	}
+	e.counters, e.countersAt = counters, now
+	e.mutex.Unlock()
+	return values
+}
+
+// 用一次采样推进各规则的状态，触发与恢复时通知
+func (e *alertEvaluator) evaluate(now time.Time, values map[string]float64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, name := range e.rules {
+		s := e.states[name]
+		value, exists := values[s.rule.Metric]
+		if !exists {
+			continue
+		}
+		s.value = value
+		s.evaluatedAt = now
+		matched := s.rule.matches(value)
+
+		switch s.state {
+		case alertOK:
+			if matched {
+				s.state, s.since = alertPending, now
+			}
+		case alertPending:
+			if !matched {
+				s.state, s.since = alertOK, now
+			}
+		case alertFiring:
+			if !matched {
+				s.state, s.since = alertResolving, now
+			}
+		case alertResolving:
+			if matched {
+				s.state, s.since = alertFiring, now
+			}
+		}
+
+		// 持续时间为 0 时同一次评估内即可完成转换
+		if now.Sub(s.since) < s.rule.sustain {
+			continue
+		}
+		switch s.state {
+		case alertPending:
+			s.state, s.since, s.firingSince = alertFiring, now, now
+			e.notify(newAlertPayload(s.rule, alertFiring, value, now))
+		case alertResolving:
+			s.state, s.since, s.firingSince = alertOK, now, time.Time{}
+			e.notify(newAlertPayload(s.rule, alertResolved, value, now))
+		}
+	}
+}
+
+// 告警通知的 Webhook 请求体；text 供 Slack 等直接展示
+type AlertPayload struct {
+	Text      string  `json:"text"`
+	Alert     string  `json:"alert"`
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+	Value     float64 `json:"value"`
+	Severity  string  `json:"severity"`
+	State     string  `json:"state"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+func newAlertPayload(rule AlertRule, state string, value float64, now time.Time) AlertPayload {
+	text := fmt.Sprintf("[%s] 告警 %s 触发：%s = %.4g（%s %g）", rule.Severity, rule.Name, rule.Metric, value, rule.Op, rule.Threshold)
+	if state == alertResolved {
+		text = fmt.Sprintf("[%s] 告警 %s 已恢复：%s = %.4g", rule.Severity, rule.Name, rule.Metric, value)
+	}
+	return AlertPayload{
+		Text:      text,
+		Alert:     rule.Name,
+		Metric:    rule.Metric,
+		Op:        rule.Op,
+		Threshold: rule.Threshold,
+		Value:     value,
+		Severity:  rule.Severity,
+		State:     state,
+		Timestamp: now.Unix(),
+	}
+}
+
+// 记录日志并通过 Webhook 发送（未启用 Webhook 时只记录日志）
+func notifyAlert(payload AlertPayload) {
+	log.Print(payload.Text)
+	hub.webhooks.enqueue(payload, "", "告警 "+payload.Alert)
+}
+
+// 告警规则的当前状态
+type AlertStatus struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+	For       string  `json:"for,omitempty"`
+	Severity  string  `json:"severity"`
+	State     string  `json:"state"`
+
+	// 最近一次评估的值与时间（Unix 秒），尚未评估时为 0
+	Value       float64 `json:"value"`
+	EvaluatedAt int64   `json:"evaluatedAt,omitempty"`
+
+	// 进入当前状态与开始触发的时间（Unix 秒）
+	Since       int64 `json:"since,omitempty"`
+	FiringSince int64 `json:"firingSince,omitempty"`
+}
+
+// 按规则名排列的状态
+func (e *alertEvaluator) statuses() []AlertStatus {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	statuses := make([]AlertStatus, 0, len(e.rules))
+	for _, name := range e.rules {
+		s := e.states[name]
+		status := AlertStatus{
+			Name:      s.rule.Name,
+			Metric:    s.rule.Metric,
+			Op:        s.rule.Op,
+			Threshold: s.rule.Threshold,
+			For:       s.rule.For,
+			Severity:  s.rule.Severity,
+			State:     s.state,
+			Value:     s.value,
+		}
+		if !s.evaluatedAt.IsZero() {
+			status.EvaluatedAt = s.evaluatedAt.Unix()
+		}
+		if !s.since.IsZero() {
+			status.Since = s.since.Unix()
+		}
+		if !s.firingSince.IsZero() {
+			status.FiringSince = s.firingSince.Unix()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// 写入告警指标，没有规则时不输出
+func (e *alertEvaluator) writeMetrics(buf *bytes.Buffer) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.rules) == 0 {
+		return
+	}
+	var firing int64
+	for _, s := range e.states {
+		if s.state == alertFiring || s.state == alertResolving {
+			firing++
+		}
+	}
+	writeMetric(buf, "liveuser_alerts_firing", "gauge", "正在触发的告警规则数", firing)
+}
+
+// 处理告警查询：GET /admin/api/alerts
+func handleAdminAlerts(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, alerts.statuses())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// 记录通知的评估器，规则 connections > 100 持续 2 分钟
+func newTestAlerts(t *testing.T, sustain string) (*alertEvaluator, *[]AlertPayload) {
+	t.Helper()
+
+	rules := []AlertRule{{Name: "conns", Metric: "connections", Op: ">", Threshold: 100, For: sustain}}
+	if err := checkAlertRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	var notified []AlertPayload
+	e := newAlertEvaluator()
+	e.notify = func(payload AlertPayload) { notified = append(notified, payload) }
+	e.setRules(rules)
+	return e, &notified
+}
+
+// 按分钟推进的一组采样，返回每一步之后的状态
+func evaluateSeries(e *alertEvaluator, start time.Time, values []float64) []string {
+	states := make([]string, len(values))
+	for i, value := range values {
+		e.evaluate(start.Add(time.Duration(i)*time.Minute), map[string]float64{"connections": value})
+		states[i] = e.statuses()[0].State
+	}
+	return states
+}
+
+// 条件持续满足 for 后触发，持续不满足 for 后恢复，各通知一次
+func TestAlertFireAndResolve(t *testing.T) {
+	e, notified := newTestAlerts(t, "2m")
+	start := time.Unix(1700000000, 0)
+
+	states := evaluateSeries(e, start, []float64{50, 150, 160, 170, 180, 90, 80, 70, 60})
+	want := []string{alertOK, alertPending, alertPending, alertFiring, alertFiring, alertResolving, alertResolving, alertOK, alertOK}
+	if !slices.Equal(states, want) {
+		t.Fatalf("状态序列为 %v，应为 %v", states, want)
+	}
+	if len(*notified) != 2 {
+		t.Fatalf("通知了 %d 次，应为触发与恢复各一次：%+v", len(*notified), *notified)
+	}
+	fired, resolved := (*notified)[0], (*notified)[1]
+	if fired.State != alertFiring || fired.Value != 170 || fired.Timestamp != start.Add(3*time.Minute).Unix() {
+		t.Fatalf("触发通知为 %+v", fired)
+	}
+	if resolved.State != alertResolved || resolved.Value != 70 || resolved.Timestamp != start.Add(7*time.Minute).Unix() {
+		t.Fatalf("恢复通知为 %+v", resolved)
+	}
+
+	status := e.statuses()[0]
+	if status.FiringSince != 0 || status.Since != start.Add(7*time.Minute).Unix() {
+		t.Fatalf("恢复后的状态为 %+v", status)
+	}
+}
+
+// 指标在阈值附近波动：未持续满足 for 时不触发，触发后短暂回落不恢复，均不重复通知
+func TestAlertFlapping(t *testing.T) {
+	e, notified := newTestAlerts(t, "2m")
+	start := time.Unix(1700000000, 0)
+
+	// 每次超过阈值都不满 2 分钟
+	states := evaluateSeries(e, start, []float64{150, 160, 90, 150, 160, 90, 150, 90})
+	want := []string{alertPending, alertPending, alertOK, alertPending, alertPending, alertOK, alertPending, alertOK}
+	if !slices.Equal(states, want) || len(*notified) != 0 {
+		t.Fatalf("波动期间的状态为 %v，通知 %d 次，不应触发", states, len(*notified))
+	}
+
+	// 触发后每次回落都不满 2 分钟
+	later := start.Add(time.Hour)
+	states = evaluateSeries(e, later, []float64{150, 150, 150, 90, 90, 150, 90, 150, 90, 90, 150})
+	want = []string{alertPending, alertPending, alertFiring, alertResolving, alertResolving, alertFiring,
+		alertResolving, alertFiring, alertResolving, alertResolving, alertFiring}
+	if !slices.Equal(states, want) {
+		t.Fatalf("状态序列为 %v，应为 %v", states, want)
+	}
+	if len(*notified) != 1 || (*notified)[0].State != alertFiring {
+		t.Fatalf("通知为 %+v，应只有一次触发", *notified)
+	}
+}
+
+// 未设置 for 时同一次评估内触发与恢复；缺少指标的采样不改变状态
+func TestAlertWithoutSustain(t *testing.T) {
+	e, notified := newTestAlerts(t, "")
+	start := time.Unix(1700000000, 0)
+
+	states := evaluateSeries(e, start, []float64{150, 90})
+	if !slices.Equal(states, []string{alertFiring, alertOK}) || len(*notified) != 2 {
+		t.Fatalf("状态序列为 %v，通知 %d 次", states, len(*notified))
+	}
+
+	e.evaluate(start.Add(time.Hour), map[string]float64{"sites": 1000})
+	if status := e.statuses()[0]; status.State != alertOK || status.EvaluatedAt != start.Add(time.Minute).Unix() {
+		t.Fatalf("缺少指标时状态变为 %+v", status)
+	}
+}
+
+// 重新加载规则：定义未变的规则保留状态，修改过的规则从 ok 重新开始
+func TestAlertSetRulesKeepsState(t *testing.T) {
+	e, _ := newTestAlerts(t, "")
+	e.evaluate(time.Unix(1700000000, 0), map[string]float64{"connections": 150})
+
+	rules := []AlertRule{{Name: "conns", Metric: "connections", Op: ">", Threshold: 100}}
+	checkAlertRules(rules)
+	e.setRules(rules)
+	if state := e.statuses()[0].State; state != alertFiring {
+		t.Fatalf("规则未变时状态为 %s，应保持 firing", state)
+	}
+
+	rules[0].Threshold = 200
+	e.setRules(rules)
+	if state := e.statuses()[0].State; state != alertOK {
+		t.Fatalf("规则修改后状态为 %s，应为 ok", state)
+	}
+}
+
+// 速率指标为相邻两次采样之间的每分钟增量，第一次采样没有值
+func TestAlertSampleRate(t *testing.T) {
+	previousHub := hub
+	hub = NewHub()
+	t.Cleanup(func() { hub = previousHub })
+
+	e := newAlertEvaluator()
+	start := time.Unix(1700000000, 0)
+	if _, exists := e.sample(start)["dropped_frames_per_min"]; exists {
+		t.Fatal("第一次采样就有速率")
+	}
+	hub.counters.droppedFrames.Add(30)
+	if rate := e.sample(start.Add(30 * time.Second))["dropped_frames_per_min"]; rate != 60 {
+		t.Fatalf("30 秒丢弃 30 条，速率为 %v，应为 60/min", rate)
+	}
+}
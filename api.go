@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 版本接口响应
+type VersionInfo struct {
+	Version   string          `json:"version"`
+	GoVersion string          `json:"goVersion"`
+	BuiltAt   string          `json:"builtAt"`
+	Runtime   RuntimeSettings `json:"runtime"`
+}
+
+// 人数接口响应
+type CountInfo struct {
+	SiteID    string `json:"siteId"`
+	Count     int    `json:"count"`
+	Seq       int64  `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+	SiteStatus
+
+	// 分组各成员站点的人数，不含 private 站点
+	Members map[string]int `json:"members,omitempty"`
+}
+
+// 序号未变化时的响应
+type UnchangedInfo struct {
+	Unchanged bool  `json:"unchanged"`
+	Seq       int64 `json:"seq"`
+}
+
+// 统计接口响应
+type StatsInfo struct {
+	Connections int              `json:"connections"`
+	Sites       int              `json:"sites"`
+	Goroutines  int              `json:"goroutines"`
+	ClockSteps  int64            `json:"clockSteps"`
+	Counters    StatsCounters    `json:"counters"`
+	Transports  []TransportStats `json:"transports"`
+	Queue       QueueInfo        `json:"queue"`
+	Cluster     *ClusterInfo     `json:"cluster,omitempty"`
+	Hub         HubResources     `json:"hub"`
+	Runtime     RuntimeStats     `json:"runtime"`
+}
+
+// 累计计数，lifetime 包含从状态文件恢复的值
+type StatsCounters struct {
+	SinceStart CounterValues `json:"sinceStart"`
+	Lifetime   CounterValues `json:"lifetime"`
+}
+
+// Hub 内部资源占用，用于排查泄漏
+type HubResources struct {
+	Sites         int `json:"sites"`
+	SiteStats     int `json:"siteStats"`
+	Feeds         int `json:"feeds"`
+	SiteSettings  int `json:"siteSettings"`
+	ExpiringSites int `json:"expiringSites"`
+	Uniques       int `json:"uniques"`
+	Peaks         int `json:"peaks"`
+}
+
+// 运行时统计
+type RuntimeStats struct {
+	RuntimeSettings
+	HeapAlloc    uint64  `json:"heapAlloc"`
+	NumGC        uint32  `json:"numGC"`
+	GCPauseP95Ms float64 `json:"gcPauseP95Ms"`
+}
+
+// 输出 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// 处理版本信息请求
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, VersionInfo{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		BuiltAt:   BuiltAt,
+		Runtime:   runtimeSettings,
+	})
+}
+
+// 处理站点人数请求，不注册为访客；带 callback 参数时以 JSONP 返回
+func handleCount(w http.ResponseWriter, r *http.Request) {
+	callback, ok := jsonpCallback(r)
+	if !ok {
+		http.Error(w, "Invalid callback", http.StatusBadRequest)
+		return
+	}
+	params := r.URL.Query()
+	if len(params["siteId"]) > 1 {
+		handleCounts(w, r, params["siteId"], callback)
+		return
+	}
+
+	siteID := resolveSiteID(strings.TrimSpace(params.Get("siteId")))
+	if group := strings.TrimSpace(params.Get("group")); group != "" {
+		siteID = groupPrefix + group
+	}
+	if siteID == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// 私有站点需要站点密钥
+	if settings.Resolve(siteID).Private && !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name, isGroup := groupName(siteID)
+	if isGroup {
+		if _, exists := settings.Group(name); !exists {
+			writeError(w, fmt.Errorf("分组 %s: %w", name, ErrSiteNotFound))
+			return
+		}
+	}
+	if !checkNotModified(w, r, siteID, "") {
+		return
+	}
+
+	var members map[string]int
+	count, seq := hub.countAndSeq(siteID)
+	if isGroup {
+		count, members = hub.groupCount(name)
+	}
+
+	if value := params.Get("ifSeqGreaterThan"); value != "" {
+		since, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if seq <= since {
+			writeJSONP(w, http.StatusOK, callback, UnchangedInfo{Unchanged: true, Seq: seq})
+			return
+		}
+	}
+
+	writeJSONP(w, http.StatusOK, callback, CountInfo{
+		SiteID:     siteID,
+		Count:      count,
+		Seq:        seq,
+		Timestamp:  time.Now().Unix(),
+		SiteStatus: hub.siteStatus(siteID, count),
+		Members:    members,
+	})
+}
+
+// 一次查询的站点数上限
+const maxCountSites = 50
+
+// 批量查询多个站点的人数，返回以 siteId 为键的映射
+func handleCounts(w http.ResponseWriter, r *http.Request, siteIDs []string, callback string) {
+	if len(siteIDs) > maxCountSites {
+		http.Error(w, "Too many siteId values", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().Unix()
+	counts := make(map[string]CountInfo, len(siteIDs))
+	for _, siteID := range siteIDs {
+		siteID = resolveSiteID(strings.TrimSpace(siteID))
+		if siteID == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if _, ok := groupName(siteID); ok {
+			http.Error(w, "Use group= to query groups", http.StatusBadRequest)
+			return
+		}
+		if settings.Resolve(siteID).Private && !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		count, seq := hub.countAndSeq(siteID)
+		counts[siteID] = CountInfo{
+			SiteID:     siteID,
+			Count:      count,
+			Seq:        seq,
+			Timestamp:  now,
+			SiteStatus: hub.siteStatus(siteID, count),
+		}
+	}
+
+	writeJSONP(w, http.StatusOK, callback, counts)
+}
+
+// 处理统计信息请求
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	connections, sites := hub.totals()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	writeJSON(w, http.StatusOK, StatsInfo{
+		Connections: connections,
+		Sites:       sites,
+		Goroutines:  runtime.NumGoroutine(),
+		ClockSteps:  hub.clock.Steps(),
+		Counters: StatsCounters{
+			SinceStart: hub.counters.SinceStart(),
+			Lifetime:   hub.counters.Lifetime(),
+		},
+		Transports: hub.transportStats(),
+		Queue:      hub.queueInfo(),
+		Cluster:    broker.Info(),
+		Hub:        hub.resources(),
+		Runtime: RuntimeStats{
+			RuntimeSettings: runtimeSettings,
+			HeapAlloc:       mem.HeapAlloc,
+			NumGC:           mem.NumGC,
+			GCPauseP95Ms:    float64(gcPauseP95().Microseconds()) / 1000,
+		},
+	})
+}
+
+// 统计在线连接数与站点数
+func (h *Hub) totals() (connections int, sites int) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for id, site := range h.sites {
+		if _, ok := groupName(id); ok {
+			continue
+		}
+		site.mutex.RLock()
+		connections += site.Count
+		site.mutex.RUnlock()
+		sites++
+	}
+	return connections, sites
+}
+
+// 统计 Hub 内部资源
+func (h *Hub) resources() HubResources {
+	h.mutex.RLock()
+	sites := len(h.sites)
+	siteStats := len(h.stats)
+	h.mutex.RUnlock()
+
+	h.feedMutex.RLock()
+	feeds := len(h.feeds)
+	h.feedMutex.RUnlock()
+
+	h.uniquesMutex.Lock()
+	uniques := len(h.uniques)
+	h.uniquesMutex.Unlock()
+
+	h.peakMutex.Lock()
+	peaks := len(h.peaks)
+	h.peakMutex.Unlock()
+
+	return HubResources{
+		Sites:         sites,
+		SiteStats:     siteStats,
+		Feeds:         feeds,
+		SiteSettings:  settings.Len(),
+		ExpiringSites: len(settings.Expiring()),
+		Uniques:       uniques,
+		Peaks:         peaks,
+	}
+}
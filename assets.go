@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// 覆盖内置挂件脚本与演示页面的目录，为空时使用可执行文件所在目录
+var assetsDir = flag.String("assets-dir", "", "覆盖内置 main.js 与 demo.html 的目录，其中存在的文件优先于内置副本；为空时使用可执行文件所在目录。main.js 修改后自动重新解析")
+
+// 启动时解析的演示页面模板及其内容摘要
+var (
+	demoTemplate *htmltemplate.Template
+	demoHash     string
+)
+
+// 已解析的挂件脚本模板；path 为覆盖文件的路径，modTime 为解析时的修改时间（使用内置副本时为零值），
+// hash 为模板内容的摘要，用于生成 ETag
+type jsTemplateCache struct {
+	tmpl    *template.Template
+	path    string
+	modTime time.Time
+	hash    string
+}
+
+// 当前的挂件脚本模板，请求直接读取；重新解析时以 jsTemplateReload 保证同一时间只有一个请求读取文件
+var (
+	jsTemplateCurrent atomic.Pointer[jsTemplateCache]
+	jsTemplateReload  sync.Mutex
+)
+
+// 查找覆盖文件的目录，首次调用时确定（在解析命令行参数之后）
+var assetsPath = sync.OnceValue(func() string {
+	if *assetsDir != "" {
+		return *assetsDir
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	return filepath.Dir(executable)
+})
+
+// 读取静态文件：目录中存在同名文件时使用该文件，否则使用内置副本；
+// source 为使用的文件路径，modTime 为其修改时间，使用内置副本时均为零值
+func loadAsset(name, embedded string) (text, source string, modTime time.Time, err error) {
+	dir := assetsPath()
+	if dir == "" {
+		return embedded, "", time.Time{}, nil
+	}
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return embedded, "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return string(data), path, info.ModTime(), nil
+}
+
+// 读取并解析挂件脚本模板
+func loadJSTemplate() (*jsTemplateCache, error) {
+	text, source, modTime, err := loadAsset("main.js", mainJS)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := parseJSTemplate("main.js", text)
+	if err != nil {
+		return nil, err
+	}
+	return &jsTemplateCache{tmpl: tmpl, path: source, modTime: modTime, hash: contentHash(text)}, nil
+}
+
+// 当前的挂件脚本模板：覆盖文件的修改时间变化（或文件出现、被删除）时重新解析，
+// 解析失败时打印告警并继续使用原模板，直到文件再次修改
+func currentJSTemplate() *jsTemplateCache {
+	cached := jsTemplateCurrent.Load()
+	dir := assetsPath()
+	if dir == "" {
+		return cached
+	}
+	info, err := os.Stat(filepath.Join(dir, "main.js"))
+	var modTime time.Time
+	if err == nil {
+		modTime = info.ModTime()
+	}
+	if modTime.Equal(cached.modTime) {
+		return cached
+	}
+
+	jsTemplateReload.Lock()
+	defer jsTemplateReload.Unlock()
+	// 等待期间其他请求可能已重新解析
+	if latest := jsTemplateCurrent.Load(); latest != cached {
+		return latest
+	}
+	reloaded, err := loadJSTemplate()
+	if err != nil {
+		log.Printf("警告: 重新加载挂件脚本模板失败，继续使用原模板: %v", err)
+		// 记下新的修改时间，避免每个请求都重试
+		stale := *cached
+		stale.modTime = modTime
+		jsTemplateCurrent.Store(&stale)
+		return &stale
+	}
+	jsTemplateCurrent.Store(reloaded)
+	if reloaded.path != "" {
+		log.Printf("挂件脚本模板已重新加载: %s", reloaded.path)
+	} else {
+		log.Printf("覆盖文件已删除，改用内置挂件脚本模板")
+	}
+	return reloaded
+}
+
+// 读取并解析演示页面模板，返回模板、内容摘要与来源
+func loadDemoTemplate() (*htmltemplate.Template, string, string, error) {
+	text, source, _, err := loadAsset("demo.html", demoHTML)
+	if err != nil {
+		return nil, "", "", err
+	}
+	tmpl, err := htmltemplate.New("demo").Parse(text)
+	return tmpl, contentHash(text), source, err
+}
+
+// 启动时解析挂件脚本与演示页面，使用磁盘上的覆盖文件时打印其路径；挂件脚本之后随文件修改重新解析
+func loadAssets() {
+	cached, err := loadJSTemplate()
+	if err != nil {
+		log.Fatalf("挂件脚本模板无效: %v", err)
+	}
+	jsTemplateCurrent.Store(cached)
+	if cached.path != "" {
+		log.Printf("使用挂件脚本模板 %s", cached.path)
+	}
+
+	demo, hash, source, err := loadDemoTemplate()
+	if err != nil {
+		log.Fatalf("演示页面模板无效: %v", err)
+	}
+	demoTemplate, demoHash = demo, hash
+	if source != "" {
+		log.Printf("使用演示页面模板 %s", source)
+	}
+}
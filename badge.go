@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 徽章颜色名称
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"green":       "#97ca00",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+	"blue":        "#007ec6",
+	"lightgrey":   "#9f9f9f",
+	"grey":        "#555",
+}
+
+// 十六进制颜色
+var badgeHexColor = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// 徽章文字的最大长度
+const badgeMaxLabel = 32
+
+// 徽章颜色，无法识别时使用默认值
+func badgeColor(value string) string {
+	if color, exists := badgeColors[strings.ToLower(value)]; exists {
+		return color
+	}
+	if badgeHexColor.MatchString(value) {
+		return "#" + strings.TrimPrefix(value, "#")
+	}
+	return badgeColors["brightgreen"]
+}
+
+// 估算 11px Verdana 下的文字宽度
+func badgeTextWidth(text string) int {
+	width := 0
+	for _, r := range text {
+		switch {
+		case r >= 0x2E80:
+			width += 11
+		case strings.ContainsRune("iljtf.,:;!|' ", r):
+			width += 4
+		case strings.ContainsRune("mwMW", r):
+			width += 10
+		case r >= 'A' && r <= 'Z':
+			width += 8
+		default:
+			width += 7
+		}
+	}
+	return width
+}
+
+// 生成徽章 SVG，style 为 flat 或 flat-square
+func renderBadge(label, value, color, style string) string {
+	labelWidth := badgeTextWidth(label) + 10
+	valueWidth := badgeTextWidth(value) + 10
+	width := labelWidth + valueWidth
+
+	radius := "3"
+	gradient := `<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`
+	overlay := fmt.Sprintf(`<rect width="%d" height="20" fill="url(#s)"/>`, width)
+	if style == "flat-square" {
+		radius, gradient, overlay = "0", "", ""
+	}
+
+	label = html.EscapeString(label)
+	value = html.EscapeString(value)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`, width, label, value)
+	fmt.Fprintf(&b, `<title>%s: %s</title>%s`, label, value, gradient)
+	fmt.Fprintf(&b, `<clipPath id="r"><rect width="%d" height="20" rx="%s" fill="#fff"/></clipPath>`, width, radius)
+	fmt.Fprintf(&b, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/>%s</g>`,
+		labelWidth, labelWidth, valueWidth, color, overlay)
+	b.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(&b, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`,
+		labelWidth/2, label, labelWidth/2, label)
+	fmt.Fprintf(&b, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`,
+		labelWidth+valueWidth/2, value, labelWidth+valueWidth/2, value)
+	b.WriteString(`</g></svg>`)
+	return b.String()
+}
+
+// 处理徽章请求：GET /badge/{siteId}.svg 或 /badge?siteId=
+func handleBadge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	siteID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/badge/"), ".svg")
+	if r.URL.Path == "/badge" || siteID == "" {
+		siteID = params.Get("siteId")
+	}
+	siteID = resolveSiteID(strings.TrimSpace(siteID))
+	if siteID == "" || strings.Contains(siteID, "/") {
+		writeError(w, &InvalidSiteIDError{SiteID: siteID, Reason: "缺少 siteId"})
+		return
+	}
+
+	// 私有站点需要站点密钥
+	if settings.Resolve(siteID).Private && !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// 未指定 label 时文字随语言变化，ETag 需要区分语言
+	label, variant := params.Get("label"), ""
+	if label == "" {
+		locale := selectLocale(params.Get("lang"), r.Header.Get("Accept-Language"))
+		label, variant = locale.T("badge.label"), locale.Code
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	if !checkNotModified(w, r, siteID, variant) {
+		return
+	}
+	if utf8.RuneCountInString(label) > badgeMaxLabel {
+		label = string([]rune(label)[:badgeMaxLabel])
+	}
+
+	// 无人在线时使用离线颜色
+	count, _ := hub.countAndSeq(siteID)
+	color := params.Get("color")
+	if count == 0 {
+		color = getParam(params, "offlineColor", *badgeOfflineColor)
+	}
+	svg := renderBadge(label, strconv.Itoa(count), badgeColor(color), params.Get("style"))
+
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Write([]byte(svg))
+}
@@ -0,0 +1,27 @@
+package main
+
+// 站点配置的人数基数（addBase），有人在线时加到对外显示的人数上，没有人在线时仍为 0
+//
+// 基数在 globalCount 中加上，广播、welcome 与 HTTP 接口的人数因此一致；站点内部的人数、
+// 峰值统计、Webhook 与实例间同步的人数都是真实人数，多实例部署时基数不会重复累加。
+func withBase(siteID string, count int) int {
+	if count <= 0 {
+		return count
+	}
+	return count + settings.Get(siteID).AddBase
+}
+
+// 去掉对外人数中的基数，得到真实人数
+func withoutBase(siteID string, count int) int {
+	if count <= 0 {
+		return count
+	}
+	return max(count-settings.Get(siteID).AddBase, 1)
+}
+
+// 对外显示的峰值，与人数加上相同的基数
+func (p PeakStats) withBase(siteID string) PeakStats {
+	p.Peak = withBase(siteID, p.Peak)
+	p.AllTime = withBase(siteID, p.AllTime)
+	return p
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// 查询人数
+func getCount(t *testing.T, query string) CountInfo {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleCount(rec, httptest.NewRequest("GET", "/api/count?"+query, nil))
+	if rec.Code != 200 {
+		t.Fatalf("/api/count?%s 返回 %d: %s", query, rec.Code, rec.Body.String())
+	}
+	var info CountInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// addBase 只在有人在线时加上，广播、welcome、/api/count 与分组明细中的成员人数一致
+func TestAddBaseConsistent(t *testing.T) {
+	h := startTestHub(t)
+	server := startTestServer(t)
+	settings.Update("a.example", func(site *SiteSettings) { site.AddBase = 10 })
+	settings.SetGroup("net", []string{"a.example", "b.example"})
+
+	if got := getCount(t, "siteId=a.example").Count; got != 0 {
+		t.Fatalf("无人在线时人数为 %d，应为 0", got)
+	}
+
+	client := dialTestClient(t, server, "")
+	client.join("a.example")
+	if welcome := client.expect("welcome"); welcome.Count != 11 {
+		t.Fatalf("welcome 人数为 %d，应为 11", welcome.Count)
+	}
+	eventually(t, "a.example 人数变为 1", func() bool { return siteCount(h, "a.example") == 1 })
+
+	if got := getCount(t, "siteId=a.example").Count; got != 11 {
+		t.Fatalf("/api/count 人数为 %d，应为 11", got)
+	}
+	group := getCount(t, "group=net")
+	if group.Count != 1 {
+		t.Fatalf("分组合计为 %d，应为真实人数 1", group.Count)
+	}
+	if group.Members["a.example"] != 11 || group.Members["b.example"] != 0 {
+		t.Fatalf("分组明细为 %v，应为 a.example=11、b.example=0", group.Members)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GIF 信标的在线时长
+var beaconTTL = flag.Duration("beacon-ttl", time.Minute, "GIF 信标（/beacon.gif）的在线时长，期间同一 IP 再次请求只续期，不重复计数")
+
+// 信标的来源标记，用于废弃行为的记录；信标不是连接，不计入传输方式统计
+const transportBeacon = "beacon"
+
+// 1x1 透明 GIF
+var beaconGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// 处理信标请求：GET /beacon.gif?siteId=example.com
+//
+// 供无法运行脚本的页面（AMP、邮件等）计入人数：每次请求为该 IP 在站点上登记一个在线记录，
+// -beacon-ttl 内没有再次请求即视为离开。信标与 WebSocket 等连接分开计数，人数为两者之和。
+func handleBeacon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	rawSiteID := params.Get("siteId")
+	if rawSiteID == "" {
+		writeError(w, &InvalidSiteIDError{Reason: "缺少 siteId"})
+		return
+	}
+	siteID := joinSiteID(rawSiteID, r.Header.Get("Origin"), transportBeacon)
+	if multiTenant() {
+		tenant, ok := tenantForKey(params.Get("apiKey"))
+		if !ok {
+			writeError(w, ErrTenantUnauthorized)
+			return
+		}
+		siteID = tenantSiteID(tenant.Name, siteID)
+	}
+	if _, isGroup := groupName(siteID); isGroup {
+		http.Error(w, "Beacons cannot join groups", http.StatusBadRequest)
+		return
+	}
+	ip := getRealIP(r)
+	err := checkBlocklist(ip)
+	if err == nil {
+		err = hub.checkJoin(siteID)
+	}
+	if err == nil {
+		err = hub.checkOrigin(requestOriginHost(r), siteID, "")
+	}
+	if err == nil {
+		err = checkSiteToken(siteID, params.Get("token"))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	bot, err := classifyBot(r, transportBeacon)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// 爬虫照常返回图片，但不登记在线
+	if !bot {
+		hub.beaconHits.Add(1)
+		hub.enqueue(hub.beacon, hubCommand{siteID: siteID, ip: dedupKey(ip)})
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Content-Length", strconv.Itoa(len(beaconGIF)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(beaconGIF)
+}
+
+// 处理信标命令：登记或续期在线记录，remove 为 true 时移除全部信标在线记录（在 Hub 协程中调用）
+func (h *Hub) handleBeaconCommand(command hubCommand) {
+	if command.remove {
+		h.dropBeacons()
+		return
+	}
+	h.handleBeaconHit(command)
+}
+
+// 关闭时移除全部信标在线记录
+//
+// 信标没有连接可断开，不移除时只剩信标的站点要等 -beacon-ttl 才离开 Hub，排空会一直等到超时。
+func (h *Hub) queueDropBeacons() {
+	h.enqueue(h.beacon, hubCommand{remove: true})
+}
+
+// 登记或续期一个信标在线记录（在 Hub 协程中调用）
+func (h *Hub) handleBeaconHit(command hubCommand) {
+	// 进入关闭流程后不再登记：全部信标已在排空时移除
+	if h.closed.Load() {
+		return
+	}
+	site, err := h.getSite(command.siteID)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	site.mutex.Lock()
+	if site.beacons == nil {
+		site.beacons = make(map[string]time.Time)
+	}
+	_, renewed := site.beacons[command.ip]
+	site.beacons[command.ip] = now.Add(*beaconTTL)
+	h.beaconSites[site.ID] = true
+	if renewed {
+		site.mutex.Unlock()
+		return
+	}
+	site.Count++
+	correction := site.auditLocked()
+	count := site.Count
+	bursting := site.burst.active
+	peak := h.observePeak(site.ID, count)
+	site.Peak, site.PeakAt = peak.Peak, peak.PeakAt
+	site.mutex.Unlock()
+
+	h.beaconPresences.Add(1)
+	h.reportCorrection(correction)
+	h.recordDailyJoin(site.ID, count, now)
+
+	seq := h.countChanged(site.ID, 1)
+	broker.Publish(site.ID, count)
+	h.webhooks.observe(site.ID, count, now)
+	if !bursting {
+		h.broadcastToSite(site.ID, count, seq)
+	}
+	h.refreshGroups(site.ID)
+}
+
+// 移除已到期的信标在线记录，站点随之清空时移出 Hub（在 Hub 协程中调用）
+func (h *Hub) expireBeacons(now time.Time) {
+	h.removeBeacons(now, false)
+}
+
+// 移除全部信标在线记录（在 Hub 协程中调用）
+func (h *Hub) dropBeacons() {
+	h.removeBeacons(time.Now(), true)
+}
+
+// 移除到期（all 为 true 时为全部）的信标在线记录，人数随之减少并广播
+func (h *Hub) removeBeacons(now time.Time, all bool) {
+	for siteID := range h.beaconSites {
+		h.mutex.RLock()
+		site, exists := h.sites[siteID]
+		h.mutex.RUnlock()
+		if !exists {
+			delete(h.beaconSites, siteID)
+			continue
+		}
+
+		site.mutex.Lock()
+		expired := 0
+		for ip, expiresAt := range site.beacons {
+			if all || !now.Before(expiresAt) {
+				delete(site.beacons, ip)
+				expired++
+			}
+		}
+		if len(site.beacons) == 0 {
+			delete(h.beaconSites, siteID)
+		}
+		if expired == 0 {
+			site.mutex.Unlock()
+			continue
+		}
+		site.Count -= expired
+		correction := site.auditLocked()
+		count := site.Count
+		bursting := site.burst.active
+		empty := len(site.Connections) == 0 && len(site.Observers) == 0 && len(site.beacons) == 0
+		site.mutex.Unlock()
+
+		h.beaconPresences.Add(-int64(expired))
+		h.reportCorrection(correction)
+		h.observePeak(siteID, count)
+
+		seq := h.countChanged(siteID, -expired)
+		broker.Publish(siteID, count)
+		h.webhooks.observe(siteID, count, now)
+		if empty && h.removeSite(site) {
+			h.publishOffline(siteID, now)
+		}
+		if !bursting || empty {
+			h.broadcastToSite(siteID, count, seq)
+		}
+		h.refreshGroups(siteID)
+	}
+}
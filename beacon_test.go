@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 请求一次信标
+func hitBeacon(t *testing.T, siteID, ip string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/beacon.gif?siteId="+siteID, nil)
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) LiveUserTest")
+	rec := httptest.NewRecorder()
+	handleBeacon(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("信标返回 %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// 只有信标在线的站点不会让排空等到超时：信标在线记录随排空移除，站点离开 Hub
+func TestDrainDropsBeacons(t *testing.T) {
+	h := startTestHub(t)
+	hitBeacon(t, "a.example", "192.0.2.1")
+	hitBeacon(t, "a.example", "192.0.2.2")
+	eventually(t, "信标计入人数", func() bool { return siteCount(h, "a.example") == 2 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := h.drain(ctx); err != nil {
+		t.Fatalf("排空失败: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("排空用时 %v，应立即完成", elapsed)
+	}
+	if got := h.beaconPresences.Load(); got != 0 {
+		t.Fatalf("仍有 %d 条信标在线记录", got)
+	}
+
+	// 排空前已通过检查的信标请求在关闭流程中到达 Hub，同样不再登记
+	h.enqueue(h.beacon, hubCommand{siteID: "b.example", ip: "192.0.2.3"})
+	h.Stop()
+	if len(h.sites) != 0 || len(h.beaconSites) != 0 {
+		t.Fatalf("关闭后 Hub 中仍有站点 %d 个、信标站点 %d 个", len(h.sites), len(h.beaconSites))
+	}
+}
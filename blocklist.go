@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	blocklistFile = flag.String("blocklist", "", "封禁的 IP 与 CIDR 列表文件，每行一条，# 开头为注释；SIGHUP 或文件修改后重新读取")
+	blocklistKick = flag.Bool("blocklist-kick", false, "重新读取封禁列表后断开 IP 已被封禁的现有连接")
+)
+
+// 检查封禁列表文件修改时间的最短间隔
+const blocklistCheckInterval = 5 * time.Second
+
+// 当前的封禁列表
+var blocklist = struct {
+	mutex   sync.RWMutex
+	ranges  ipRanges
+	modTime time.Time
+
+	// 上次检查文件修改时间的时间（UnixNano），只由抢到检查的一方读取文件
+	checkedAt atomic.Int64
+	rejected  atomic.Int64
+}{}
+
+// 解析封禁列表：单个地址按 /32 或 /128 处理
+func parseBlocklist(text string) (ipRanges, error) {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if ip := net.ParseIP(line); ip != nil && !strings.Contains(line, "/") {
+			if ip.To4() != nil {
+				line += "/32"
+			} else {
+				line += "/128"
+			}
+		}
+		lines[i] = line
+	}
+	return parseIPRanges(strings.Join(lines, "\n"))
+}
+
+// 读取 -blocklist，未配置时返回空列表
+func loadBlocklist() (ipRanges, time.Time, error) {
+	if *blocklistFile == "" {
+		return nil, time.Time{}, nil
+	}
+	info, err := os.Stat(*blocklistFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(*blocklistFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	ranges, err := parseBlocklist(string(data))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("封禁列表: %v", err)
+	}
+	return ranges, info.ModTime(), nil
+}
+
+// 替换当前的封禁列表
+func setBlocklist(ranges ipRanges, modTime time.Time) {
+	blocklist.mutex.Lock()
+	defer blocklist.mutex.Unlock()
+	blocklist.ranges = ranges
+	blocklist.modTime = modTime
+}
+
+// 重新读取封禁列表，读取失败时保留原有列表；开启 -blocklist-kick 时断开已被封禁的连接
+func reloadBlocklist() {
+	ranges, modTime, err := loadBlocklist()
+	if err != nil {
+		log.Printf("重新加载封禁列表失败: %v", err)
+		return
+	}
+	setBlocklist(ranges, modTime)
+	log.Printf("封禁列表已重新加载，共 %d 个网段", len(ranges))
+	if *blocklistKick {
+		if n := hub.kickBlocked(); n > 0 {
+			log.Printf("断开 %d 个已被封禁的连接", n)
+		}
+	}
+}
+
+// 距上次检查超过 blocklistCheckInterval 时查看文件修改时间，有变化则重新读取
+func checkBlocklistFile(now time.Time) {
+	last := blocklist.checkedAt.Load()
+	if *blocklistFile == "" || now.UnixNano()-last < int64(blocklistCheckInterval) ||
+		!blocklist.checkedAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	info, err := os.Stat(*blocklistFile)
+	if err != nil {
+		return
+	}
+	blocklist.mutex.RLock()
+	changed := !info.ModTime().Equal(blocklist.modTime)
+	blocklist.mutex.RUnlock()
+	if changed {
+		go reloadBlocklist()
+	}
+}
+
+// IP 是否被封禁
+func isBlockedIP(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	blocklist.mutex.RLock()
+	defer blocklist.mutex.RUnlock()
+	return blocklist.ranges.contains(ip)
+}
+
+// 建立连接前检查客户端 IP，被封禁时返回 ErrIPBlocked
+func checkBlocklist(clientIP string) error {
+	checkBlocklistFile(time.Now())
+	if isBlockedIP(clientIP) {
+		blocklist.rejected.Add(1)
+		return ErrIPBlocked
+	}
+	return nil
+}
+
+// 断开 IP 已被封禁的连接（含尚未加入站点的 WebSocket 连接），返回断开的数量
+//
+// 连接先收到以 4403 关闭的 error 消息，发送队列已满时直接断开。
+func (h *Hub) kickBlocked() int {
+	clients := make(map[*Client]bool)
+	h.mutex.RLock()
+	for _, site := range h.sites {
+		site.mutex.RLock()
+		for _, set := range []map[*Client]bool{site.Connections, site.Observers} {
+			for client := range set {
+				if isBlockedIP(client.ip) {
+					clients[client] = true
+				}
+			}
+		}
+		site.mutex.RUnlock()
+	}
+	h.mutex.RUnlock()
+	for _, client := range h.trackedClients() {
+		if isBlockedIP(client.ip) {
+			clients[client] = true
+		}
+	}
+
+	for client := range clients {
+		if !client.enqueue(errorMessage("", ErrIPBlocked)) {
+			client.disconnect()
+		}
+	}
+	return len(clients)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 内置的爬虫与无头浏览器规则，不区分大小写
+var botPatternsDefault = []string{
+	`googlebot`,
+	`bingbot`,
+	`headlesschrome`,
+	`uptimerobot`,
+	`^curl/`,
+	`python-requests`,
+}
+
+// 空 User-Agent 的规则名，用于日志
+const emptyUserAgentPattern = "(empty)"
+
+var (
+	botPatternsFile = flag.String("bot-patterns-file", "", "额外的爬虫 User-Agent 规则文件，每行一个正则表达式（不区分大小写），# 开头为注释；与内置规则合并，SIGHUP 重新读取")
+	rejectBots      = flag.Bool("reject-bots", false, "直接拒绝被识别为爬虫的连接（403），默认允许连接但不计入人数")
+	logBots         = flag.Bool("log-bots", false, "记录被识别为爬虫的连接及匹配的规则")
+)
+
+// 当前的爬虫规则与计数
+var bots = struct {
+	mutex    sync.RWMutex
+	patterns []*regexp.Regexp
+
+	observed atomic.Int64
+	rejected atomic.Int64
+}{}
+
+// 解析规则列表，每行一个正则表达式
+func parseBotPatterns(text string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, err := regexp.Compile("(?i)" + line)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: 无效的规则 %q: %v", i+1, line, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// 内置规则加上 -bot-patterns-file 中的规则
+func loadBotPatterns() ([]*regexp.Regexp, error) {
+	patterns, err := parseBotPatterns(strings.Join(botPatternsDefault, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	if *botPatternsFile == "" {
+		return patterns, nil
+	}
+	data, err := os.ReadFile(*botPatternsFile)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := parseBotPatterns(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("爬虫规则: %v", err)
+	}
+	return append(patterns, extra...), nil
+}
+
+// 替换当前的爬虫规则
+func setBotPatterns(patterns []*regexp.Regexp) {
+	bots.mutex.Lock()
+	defer bots.mutex.Unlock()
+	bots.patterns = patterns
+}
+
+// User-Agent 是否属于爬虫，返回匹配的规则；空 User-Agent 同样视为爬虫
+func matchBot(userAgent string) (pattern string, ok bool) {
+	if strings.TrimSpace(userAgent) == "" {
+		return emptyUserAgentPattern, true
+	}
+	bots.mutex.RLock()
+	defer bots.mutex.RUnlock()
+	for _, p := range bots.patterns {
+		if p.MatchString(userAgent) {
+			return strings.TrimPrefix(p.String(), "(?i)"), true
+		}
+	}
+	return "", false
+}
+
+// 识别请求是否来自爬虫：开启 -reject-bots 时返回 ErrBotRejected，
+// 否则返回 true，调用方把连接作为观察者加入（照常接收人数，但不计入）
+func classifyBot(r *http.Request, transport string) (bool, error) {
+	pattern, ok := matchBot(r.UserAgent())
+	if !ok {
+		return false, nil
+	}
+	if *logBots {
+		log.Printf("识别为爬虫: %s 连接，IP %s，规则 %s，User-Agent %q", transport, getRealIP(r), pattern, r.UserAgent())
+	}
+	if *rejectBots {
+		bots.rejected.Add(1)
+		return true, ErrBotRejected
+	}
+	bots.observed.Add(1)
+	return true, nil
+}
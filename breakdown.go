@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 设备类别
+const (
+	deviceDesktop = "desktop"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceBot     = "bot"
+	deviceUnknown = "unknown"
+)
+
+// 按 User-Agent 粗略划分设备类别，只看几个常见标记，不追求精确
+func deviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return deviceUnknown
+	case strings.Contains(ua, "bot"), strings.Contains(ua, "spider"), strings.Contains(ua, "crawler"),
+		strings.Contains(ua, "headless"):
+		return deviceBot
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"),
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return deviceTablet
+	case strings.Contains(ua, "mobi"), strings.Contains(ua, "iphone"), strings.Contains(ua, "android"):
+		return deviceMobile
+	}
+	return deviceDesktop
+}
+
+// 来源页面的主机名（已规范化），无法解析时返回空字符串
+func referrerHost(referrer string) string {
+	u, err := url.Parse(strings.TrimSpace(referrer))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return siteIDHost(normalizeSiteID(u.Host))
+}
+
+// 分组计数
+type BreakdownEntry struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// 站点在线访客的构成
+type BreakdownInfo struct {
+	SiteID    string           `json:"siteId"`
+	Count     int              `json:"count"`
+	Devices   []BreakdownEntry `json:"devices"`
+	Referrers []BreakdownEntry `json:"referrers"`
+	Paths     []BreakdownEntry `json:"paths"`
+	Timestamp int64            `json:"timestamp"`
+}
+
+// 按数量从高到低排列，最多保留 limit 项
+func topEntries(counts map[string]int, limit int) []BreakdownEntry {
+	entries := make([]BreakdownEntry, 0, len(counts))
+	for key, count := range counts {
+		entries = append(entries, BreakdownEntry{Key: key, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// 在站点读锁下遍历计入人数的连接，按设备类别、来源主机与页面分组计数
+//
+// 按需计算，不在加入与离开时维护；没有来源或路径的连接不计入对应的分组。
+func (h *Hub) siteBreakdown(siteID string, limit int) BreakdownInfo {
+	info := BreakdownInfo{SiteID: siteID, Timestamp: time.Now().Unix()}
+	devices := make(map[string]int)
+	referrers := make(map[string]int)
+	paths := make(map[string]int)
+
+	h.mutex.RLock()
+	site, exists := h.sites[siteID]
+	h.mutex.RUnlock()
+	if exists {
+		site.mutex.RLock()
+		for client := range site.Connections {
+			info.Count++
+			devices[client.device]++
+			if client.referrer != "" {
+				referrers[client.referrer]++
+			}
+			if client.path != "" {
+				paths[client.path]++
+			}
+		}
+		site.mutex.RUnlock()
+	}
+
+	info.Devices = topEntries(devices, limit)
+	info.Referrers = topEntries(referrers, limit)
+	info.Paths = topEntries(paths, limit)
+	return info
+}
+
+// 处理访客构成请求：GET /api/breakdown?siteId=example.com&limit=10
+//
+// 连接数按连接计（不按 IP 或会话去重），只统计本实例的连接。
+func handleBreakdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+	siteID := resolveSiteID(strings.TrimSpace(params.Get("siteId")))
+	if siteID == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if _, ok := groupName(siteID); ok {
+		http.Error(w, "Groups have no breakdown", http.StatusBadRequest)
+		return
+	}
+	if settings.Resolve(siteID).Private && !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 10
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 100)
+	}
+	writeJSON(w, http.StatusOK, hub.siteBreakdown(siteID, limit))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 像 SSE 订阅者一样直接加入 Hub 的连接（没有 WebSocket），queue 为发送队列长度
+func registerTestClient(t *testing.T, h *Hub, siteID string, n, queue int) *Client {
+	t.Helper()
+
+	site, err := h.getSite(siteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{
+		hub:         h,
+		site:        site,
+		send:        make(chan Message, queue),
+		id:          nextClientID.Add(1),
+		ip:          fmt.Sprintf("10.0.%d.%d", n/256, n%256),
+		connectedAt: time.Now(),
+		kind:        newConnKind(transportSSE),
+	}
+	h.queueRegister(client)
+	return client
+}
+
+// 读取发送队列直到关闭
+func drainSend(client *Client) {
+	for range client.send {
+	}
+}
+
+// 并发加入、离开与广播，其中三分之一的连接从不读取、队列一直是满的：
+// 在 -race 下不出现数据竞争与重复关闭，结束后人数归零、站点移出 Hub
+func TestBroadcastHammer(t *testing.T) {
+	const (
+		clients     = 150
+		broadcasts  = 4
+		siteID      = "a.example"
+		fullQueue   = 1
+		normalQueue = 16
+	)
+	setFlag(t, "dedup", "connection")
+	h := startTestHub(t)
+
+	stop := make(chan struct{})
+	var broadcasters sync.WaitGroup
+	for i := 0; i < broadcasts; i++ {
+		broadcasters.Add(1)
+		go func() {
+			defer broadcasters.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(100 * time.Microsecond):
+					h.broadcastToSite(siteID, 1, 0)
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(i%10) * time.Millisecond)
+
+			if i%3 == 0 {
+				// 从不读取：等广播因队列已满关闭它，或停留一会儿后自行离开
+				client := registerTestClient(t, h, siteID, i, fullQueue)
+				deadline := time.Now().Add(time.Duration(i%7) * time.Millisecond)
+				for !client.sendIsClosed() && time.Now().Before(deadline) {
+					time.Sleep(time.Millisecond)
+				}
+				h.queueUnregister(client, siteID)
+				return
+			}
+
+			client := registerTestClient(t, h, siteID, i, normalQueue)
+			done := make(chan struct{})
+			go func() {
+				drainSend(client)
+				close(done)
+			}()
+			time.Sleep(time.Duration(i%5) * time.Millisecond)
+			h.queueUnregister(client, siteID)
+			<-done
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	broadcasters.Wait()
+
+	eventually(t, "全部注销", func() bool { return h.counters.unregistered.Load() == clients })
+	if joins := h.counters.joins.Load(); joins != clients {
+		t.Fatalf("加入了 %d 次，应为 %d", joins, clients)
+	}
+	if h.counters.droppedFrames.Load() == 0 {
+		t.Fatal("没有连接因队列已满被丢弃，未覆盖丢弃路径")
+	}
+	if count := siteCount(h, siteID); count != -1 {
+		t.Fatalf("全部离开后站点仍在 Hub 中，人数 %d", count)
+	}
+	if total := h.total.Load(); total != 0 {
+		t.Fatalf("全部离开后总连接数为 %d", total)
+	}
+}
+
+// 广播因队列已满丢弃连接后，该连接照常注销（以及重复注销）：不会重复关闭发送队列，
+// 人数只减去这一个连接
+func TestBroadcastDropThenUnregister(t *testing.T) {
+	setFlag(t, "dedup", "connection")
+	h := startTestHub(t)
+
+	stays := registerTestClient(t, h, "a.example", 1, 16)
+	go drainSend(stays)
+	slow := registerTestClient(t, h, "a.example", 2, 1)
+	expectCounts(t, h, "两个连接加入", map[string]int{"a.example": 2})
+
+	// 欢迎消息占满慢连接的队列，随后广播新人数时丢弃它
+	h.broadcastToSite("a.example", 2, 0)
+	if !slow.sendIsClosed() || h.counters.droppedFrames.Load() != 1 {
+		t.Fatalf("广播后队列关闭为 %v，丢弃 %d 次，应只丢弃慢连接一次", slow.sendIsClosed(), h.counters.droppedFrames.Load())
+	}
+	if count := siteCount(h, "a.example"); count != 2 {
+		t.Fatalf("广播丢弃后人数为 %d，应等注销时再减少", count)
+	}
+	if slow.enqueue(Message{Type: "update"}) {
+		t.Fatal("队列关闭后仍能入队")
+	}
+
+	h.queueUnregister(slow, "a.example")
+	h.queueUnregister(slow, "a.example")
+	eventually(t, "慢连接注销", func() bool { return h.counters.unregistered.Load() == 1 })
+	expectCounts(t, h, "慢连接离开", map[string]int{"a.example": 1})
+	h.broadcastToSite("a.example", 1, 0)
+	if dropped := h.counters.droppedFrames.Load(); dropped != 1 {
+		t.Fatalf("注销后又丢弃了 %d 次", dropped-1)
+	}
+
+	h.queueUnregister(stays, "a.example")
+	expectCounts(t, h, "全部离开", map[string]int{"a.example": 0})
+}
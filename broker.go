@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 多实例部署时交换人数的消息代理，为空时只统计本实例的连接
+var brokerAddr = flag.String("broker", "", "多实例交换人数的消息代理：nats://host:4222 或 redis://host:6379，为空时只统计本实例")
+
+// 多实例部署时共享人数的 Redis 地址，等同于 -broker redis://...
+var redisAddr = flag.String("redis", "", "多实例共享人数的 Redis 地址（host:port 或 redis://[:password@]host:port/db），等同于 -broker redis://...")
+
+// 实例人数的存活时长：实例每 clusterHeartbeat 广播一次快照，崩溃后其人数在 clusterTTL 内失效
+const (
+	clusterTTL       = 30 * time.Second
+	clusterHeartbeat = 10 * time.Second
+)
+
+// 多实例之间交换人数的消息代理
+//
+// Hub 在站点人数变化时调用 Publish 上报本实例的人数；代理汇总其他实例的人数，
+// 变化时调用 Subscribe 注册的回调，由 Hub 向本地客户端推送合计人数。
+// 上报的是本实例人数的绝对值而不是增量：实例崩溃后其人数随心跳超时整体失效，
+// 消息丢失或重连后下一次快照即可纠正，不会累积误差。
+type Broker interface {
+	// 上报本实例站点人数，不阻塞
+	Publish(siteID string, count int)
+
+	// 注册回调，delta 为其他实例合计人数的变化
+	Subscribe(fn func(siteID string, delta int))
+
+	// 站点在其他实例上的人数之和
+	RemoteCount(siteID string) int
+
+	// 同步状态，单实例时为 nil
+	Info() *ClusterInfo
+
+	// 停止同步并通知其他实例移除本实例的人数，关闭时调用；ctx 限定等待时长
+	Close(ctx context.Context) error
+}
+
+// 单实例时使用的空代理
+type noopBroker struct{}
+
+func (noopBroker) Publish(siteID string, count int)            {}
+func (noopBroker) Subscribe(fn func(siteID string, delta int)) {}
+func (noopBroker) RemoteCount(siteID string) int               { return 0 }
+func (noopBroker) Info() *ClusterInfo                          { return nil }
+func (noopBroker) Close(ctx context.Context) error             { return nil }
+
+// 当前的消息代理
+var broker Broker = noopBroker{}
+
+// 按 -broker 与 -redis 创建消息代理（不连接），未配置时返回 nil
+func newBroker() (func() Broker, error) {
+	addr := *brokerAddr
+	if addr == "" && *redisAddr != "" {
+		addr = *redisAddr
+		if !strings.Contains(addr, "://") {
+			addr = "redis://" + addr
+		}
+	}
+	if addr == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的消息代理地址 %q", addr)
+	}
+	switch u.Scheme {
+	case "redis":
+		options, err := parseRedisAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		return func() Broker { return startRedisBroker(options) }, nil
+	case "nats":
+		options, err := parseNATSAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		return func() Broker { return startNATSBroker(options) }, nil
+	}
+	return nil, fmt.Errorf("不支持的消息代理 %q，可用 nats:// 或 redis://", addr)
+}
+
+// 全部站点的人数：本实例加上其他实例
+func globalCount(siteID string, local int) int {
+	return withBase(siteID, local+broker.RemoteCount(siteID))
+}
+
+// 实例间同步的消息：count 为单个站点的本实例人数，snapshot 为本实例全部站点的人数，
+// hello 为新实例上线，收到后立即回复快照
+type clusterMessage struct {
+	Type     string         `json:"type"`
+	Instance string         `json:"instance"`
+	SiteID   string         `json:"siteId,omitempty"`
+	Count    int            `json:"count,omitempty"`
+	Counts   map[string]int `json:"counts,omitempty"`
+}
+
+// 多实例同步状态
+type ClusterInfo struct {
+	Broker    string `json:"broker"`
+	Instance  string `json:"instance"`
+	Connected bool   `json:"connected"`
+	Peers     int    `json:"peers"`
+}
+
+// 其他实例上报的人数
+type remoteInstance struct {
+	counts map[string]int
+	seen   time.Time
+}
+
+// 各消息代理共用的状态：其他实例的人数表与待上报的本实例人数
+type brokerState struct {
+	kind     string
+	instance string
+
+	mutex     sync.RWMutex
+	remote    map[string]*remoteInstance
+	connected bool
+	onChange  func(siteID string, delta int)
+
+	// 待上报的本实例人数，按站点合并
+	pendingMutex sync.Mutex
+	pending      map[string]int
+	wake         chan struct{}
+
+	// 需要立即广播快照（如收到新实例的 hello）
+	resync chan struct{}
+
+	// 关闭时取消，结束连接、重连与收发循环
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// 正在运行的连接循环
+	loops sync.WaitGroup
+}
+
+// 随机实例 ID
+func newInstanceID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func newBrokerState(kind string) *brokerState {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &brokerState{
+		kind:     kind,
+		instance: newInstanceID(),
+		remote:   make(map[string]*remoteInstance),
+		pending:  make(map[string]int),
+		wake:     make(chan struct{}, 1),
+		resync:   make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (s *brokerState) Publish(siteID string, count int) {
+	s.pendingMutex.Lock()
+	s.pending[siteID] = count
+	s.pendingMutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *brokerState) Subscribe(fn func(siteID string, delta int)) {
+	s.mutex.Lock()
+	s.onChange = fn
+	s.mutex.Unlock()
+}
+
+func (s *brokerState) RemoteCount(siteID string) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	total := 0
+	for _, instance := range s.remote {
+		total += instance.counts[siteID]
+	}
+	return total
+}
+
+func (s *brokerState) Info() *ClusterInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return &ClusterInfo{Broker: s.kind, Instance: s.instance, Connected: s.connected, Peers: len(s.remote)}
+}
+
+// 停止连接循环并等待其退出
+func (s *brokerState) stop(ctx context.Context) error {
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.loops.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 在后台运行连接循环，stop 等待其退出
+func (s *brokerState) goLoop(loop func()) {
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		loop()
+	}()
+}
+
+// 关闭时的最后一条消息：本实例已没有人数。其他实例收到后立即从合计中移除，不必等 clusterTTL；
+// 关闭前未上报的变化一并丢弃，空快照已经取代了它们
+func (s *brokerState) finalSnapshot() clusterMessage {
+	s.takePending()
+	return clusterMessage{Type: "snapshot", Instance: s.instance, Counts: map[string]int{}}
+}
+
+// 取出待上报的人数
+func (s *brokerState) takePending() map[string]int {
+	s.pendingMutex.Lock()
+	defer s.pendingMutex.Unlock()
+
+	pending := s.pending
+	s.pending = make(map[string]int)
+	return pending
+}
+
+// 通知回调（调用方不能持有锁）
+func (s *brokerState) notify(deltas map[string]int, fn func(siteID string, delta int)) {
+	if fn == nil {
+		return
+	}
+	for siteID, delta := range deltas {
+		if delta != 0 {
+			fn(siteID, delta)
+		}
+	}
+}
+
+// 连接建立或断开；断开时清空其他实例的人数，退回只统计本实例
+func (s *brokerState) setConnected(connected bool, err error) {
+	s.mutex.Lock()
+	wasConnected := s.connected
+	s.connected = connected
+	deltas := make(map[string]int)
+	if !connected {
+		for _, instance := range s.remote {
+			for siteID, count := range instance.counts {
+				deltas[siteID] -= count
+			}
+		}
+		s.remote = make(map[string]*remoteInstance)
+	}
+	fn := s.onChange
+	s.mutex.Unlock()
+
+	if connected && !wasConnected {
+		log.Printf("消息代理 %s 已连接，开始同步多实例人数", s.kind)
+	}
+	if !connected && wasConnected {
+		log.Printf("警告: 消息代理 %s 连接中断，暂时只统计本实例的人数: %v", s.kind, err)
+	}
+	s.notify(deltas, fn)
+}
+
+// 应用其他实例的消息；本实例自己的消息（代理回显）直接忽略
+func (s *brokerState) apply(message clusterMessage) {
+	if message.Instance == "" || message.Instance == s.instance {
+		return
+	}
+
+	deltas := make(map[string]int)
+	s.mutex.Lock()
+	instance, exists := s.remote[message.Instance]
+	if !exists {
+		instance = &remoteInstance{counts: make(map[string]int)}
+		s.remote[message.Instance] = instance
+		log.Printf("发现实例 %s", message.Instance)
+	}
+	instance.seen = time.Now()
+
+	switch message.Type {
+	case "count":
+		deltas[message.SiteID] = message.Count - instance.counts[message.SiteID]
+		if message.Count > 0 {
+			instance.counts[message.SiteID] = message.Count
+		} else {
+			delete(instance.counts, message.SiteID)
+		}
+	case "snapshot":
+		for siteID, count := range instance.counts {
+			deltas[siteID] -= count
+		}
+		instance.counts = make(map[string]int, len(message.Counts))
+		for siteID, count := range message.Counts {
+			if count > 0 {
+				instance.counts[siteID] = count
+				deltas[siteID] += count
+			}
+		}
+	case "hello":
+		select {
+		case s.resync <- struct{}{}:
+		default:
+		}
+	}
+	fn := s.onChange
+	s.mutex.Unlock()
+
+	s.notify(deltas, fn)
+}
+
+// 移除超过 TTL 未心跳的实例（例如已崩溃），其人数随之失效
+func (s *brokerState) expire(now time.Time) {
+	deltas := make(map[string]int)
+	s.mutex.Lock()
+	for id, instance := range s.remote {
+		if now.Sub(instance.seen) < clusterTTL {
+			continue
+		}
+		log.Printf("实例 %s 超过 %v 未心跳，移除其人数", id, clusterTTL)
+		for siteID, count := range instance.counts {
+			deltas[siteID] -= count
+		}
+		delete(s.remote, id)
+	}
+	fn := s.onChange
+	s.mutex.Unlock()
+
+	s.notify(deltas, fn)
+}
+
+// 本实例各站点的人数，不含分组与无人在线的站点
+func (h *Hub) localCounts() map[string]int {
+	counts := make(map[string]int)
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for id, site := range h.sites {
+		if _, ok := groupName(id); ok {
+			continue
+		}
+		site.mutex.RLock()
+		if site.Count > 0 {
+			counts[id] = site.Count
+		}
+		site.mutex.RUnlock()
+	}
+	return counts
+}
+
+// 其他实例的人数变化后更新序号，并向本地客户端推送合计人数
+func (h *Hub) remoteChanged(siteID string, delta int) {
+	seq := h.bumpSeq(siteID)
+
+	h.mutex.RLock()
+	site, exists := h.sites[siteID]
+	h.mutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	site.mutex.RLock()
+	count := site.Count
+	bursting := site.burst.active
+	site.mutex.RUnlock()
+	if !bursting {
+		h.broadcastToSite(siteID, count, seq)
+	}
+}
+
+// 连接消息代理的重试策略：不限次数，直到连接成功或消息代理关闭
+func brokerDialPolicy(kind string) retryPolicy {
+	return retryPolicy{
+		Target:      "broker-" + kind,
+		MaxAttempts: math.MaxInt,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+		Retryable:   func(error) bool { return true },
+	}
+}
+
+// 按退避间隔重试 dial，直到成功或消息代理关闭（此时返回错误）；首次失败时打印告警
+func (s *brokerState) dial(addr string, dial func(ctx context.Context) error) error {
+	attempt := 0
+	return retry(s.ctx, brokerDialPolicy(s.kind), func(ctx context.Context) error {
+		err := dial(ctx)
+		if attempt++; err != nil && attempt == 1 {
+			log.Printf("警告: 无法连接消息代理 %s %s，只统计本实例的人数: %v", s.kind, addr, err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 消息代理关闭后，等待中的重连立即结束，不再继续尝试
+func TestBrokerDialStopsOnClose(t *testing.T) {
+	s := newBrokerState("test")
+	var attempts atomic.Int64
+	done := make(chan error, 1)
+	go func() {
+		done <- s.dial("127.0.0.1:1", func(ctx context.Context) error {
+			attempts.Add(1)
+			return errors.New("connection refused")
+		})
+	}()
+	eventually(t, "第一次连接尝试", func() bool { return attempts.Load() == 1 })
+
+	// 第一次失败后至少等待 500ms 才重试，关闭应在此之前结束等待
+	s.stop(context.Background())
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("关闭后连接应返回错误")
+		}
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("关闭后重连仍在等待")
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Fatalf("关闭后又尝试了 %d 次", n-1)
+	}
+}
+
+// 记录回调收到的人数变化
+type deltaRecorder struct {
+	mutex  sync.Mutex
+	deltas map[string]int
+}
+
+func (r *deltaRecorder) record(siteID string, delta int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.deltas == nil {
+		r.deltas = make(map[string]int)
+	}
+	r.deltas[siteID] += delta
+}
+
+// 取出累计的变化
+func (r *deltaRecorder) take() map[string]int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	deltas := r.deltas
+	r.deltas = nil
+	return deltas
+}
+
+// 代理回显的本实例消息被忽略；其他实例的 count 与 snapshot 按绝对值换算成变化
+func TestBrokerStateApply(t *testing.T) {
+	s := newBrokerState("test")
+	var recorder deltaRecorder
+	s.Subscribe(recorder.record)
+
+	s.apply(clusterMessage{Type: "snapshot", Instance: s.instance, Counts: map[string]int{"a.example": 5}})
+	s.apply(clusterMessage{Type: "count", Instance: s.instance, SiteID: "a.example", Count: 7})
+	s.apply(clusterMessage{Type: "count", SiteID: "a.example", Count: 7})
+	if count := s.RemoteCount("a.example"); count != 0 {
+		t.Fatalf("本实例的回显计入了其他实例人数: %d", count)
+	}
+	if deltas := recorder.take(); len(deltas) != 0 {
+		t.Fatalf("本实例的回显触发了回调: %v", deltas)
+	}
+
+	s.apply(clusterMessage{Type: "snapshot", Instance: "peer-1", Counts: map[string]int{"a.example": 3, "b.example": 2}})
+	s.apply(clusterMessage{Type: "count", Instance: "peer-2", SiteID: "a.example", Count: 4})
+	// 重复的绝对值不产生变化
+	s.apply(clusterMessage{Type: "count", Instance: "peer-2", SiteID: "a.example", Count: 4})
+	s.apply(clusterMessage{Type: "count", Instance: "peer-1", SiteID: "b.example", Count: 0})
+
+	if count := s.RemoteCount("a.example"); count != 7 {
+		t.Fatalf("a.example 其他实例人数为 %d，应为 7", count)
+	}
+	if count := s.RemoteCount("b.example"); count != 0 {
+		t.Fatalf("b.example 其他实例人数为 %d，应为 0", count)
+	}
+	want := map[string]int{"a.example": 7, "b.example": 0}
+	if deltas := recorder.take(); !reflect.DeepEqual(deltas, want) {
+		t.Fatalf("回调累计变化为 %v，应为 %v", deltas, want)
+	}
+
+	// 空快照（实例关闭）移除该实例的全部人数
+	s.apply(clusterMessage{Type: "snapshot", Instance: "peer-1", Counts: map[string]int{}})
+	if count := s.RemoteCount("a.example"); count != 4 {
+		t.Fatalf("空快照后 a.example 其他实例人数为 %d，应为 4", count)
+	}
+	if deltas := recorder.take(); deltas["a.example"] != -3 {
+		t.Fatalf("空快照后的变化为 %v，应为 a.example -3", deltas)
+	}
+}
+
+// 连接中断时清空其他实例的人数并通知回调，重连后由快照恢复；超过 TTL 未心跳的实例被移除
+func TestBrokerStateReconnect(t *testing.T) {
+	s := newBrokerState("test")
+	var recorder deltaRecorder
+	s.Subscribe(recorder.record)
+
+	s.setConnected(true, nil)
+	s.apply(clusterMessage{Type: "snapshot", Instance: "peer-1", Counts: map[string]int{"a.example": 3}})
+	recorder.take()
+
+	s.setConnected(false, errors.New("connection reset"))
+	if info := s.Info(); info.Connected || info.Peers != 0 {
+		t.Fatalf("断开后状态为 %+v", info)
+	}
+	if count := s.RemoteCount("a.example"); count != 0 {
+		t.Fatalf("断开后仍计入其他实例人数 %d", count)
+	}
+	if deltas := recorder.take(); deltas["a.example"] != -3 {
+		t.Fatalf("断开后的变化为 %v，应为 a.example -3", deltas)
+	}
+
+	s.setConnected(true, nil)
+	s.apply(clusterMessage{Type: "snapshot", Instance: "peer-1", Counts: map[string]int{"a.example": 2}})
+	if count := s.RemoteCount("a.example"); count != 2 {
+		t.Fatalf("重连后 a.example 其他实例人数为 %d，应为 2", count)
+	}
+	if deltas := recorder.take(); deltas["a.example"] != 2 {
+		t.Fatalf("重连后的变化为 %v，应为 a.example +2", deltas)
+	}
+
+	s.expire(time.Now().Add(clusterTTL - time.Second))
+	if count := s.RemoteCount("a.example"); count != 2 {
+		t.Fatal("未超过 TTL 的实例被移除")
+	}
+	s.expire(time.Now().Add(clusterTTL))
+	if info := s.Info(); info.Peers != 0 {
+		t.Fatalf("超过 TTL 后仍有 %d 个实例", info.Peers)
+	}
+	if deltas := recorder.take(); deltas["a.example"] != -2 {
+		t.Fatalf("超时移除后的变化为 %v，应为 a.example -2", deltas)
+	}
+}
+
+// 只实现文本协议中发布与 PING 的 NATS 服务器，把收到的消息转发到 published
+func startFakeNATS(t *testing.T) (addr string, published <-chan clusterMessage) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan clusterMessage, 64)
+	var conns sync.WaitGroup
+	t.Cleanup(func() {
+		listener.Close()
+		conns.Wait()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conns.Done()
+				defer conn.Close()
+				serveFakeNATS(conn, messages)
+			}()
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func serveFakeNATS(conn net.Conn, messages chan<- clusterMessage) {
+	reader := bufio.NewReader(conn)
+	io.WriteString(conn, "INFO {}\r\n")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case strings.HasPrefix(line, "PUB "):
+			fields := strings.Fields(line)
+			n, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			var message clusterMessage
+			if json.Unmarshal(payload[:n], &message) == nil {
+				messages <- message
+			}
+		}
+	}
+}
+
+// 关闭 NATS 消息代理时连接循环退出，并广播本实例的空快照
+func TestNATSBrokerCloseSendsFinalSnapshot(t *testing.T) {
+	h := startTestHub(t)
+	site, err := h.getSite("a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	site.Count = 2
+
+	addr, published := startFakeNATS(t)
+	b := startNATSBroker(natsOptions{Addr: addr})
+	eventually(t, "连接消息代理", func() bool { return b.Info().Connected })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Close(ctx); err != nil {
+		t.Fatalf("关闭失败: %v", err)
+	}
+
+	var last clusterMessage
+	for {
+		select {
+		case message := <-published:
+			last = message
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	if last.Type != "snapshot" || last.Instance != b.instance || len(last.Counts) != 0 {
+		t.Fatalf("最后一条消息为 %+v，应为本实例的空快照", last)
+	}
+	if b.Info().Connected {
+		t.Fatal("关闭后仍显示已连接")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+)
+
+// 突发模式参数
+var burstJoins = flag.Int("burst-joins", 50, "每秒加入数超过该值时进入突发模式（0 关闭），可被实例默认值与站点配置覆盖")
+var burstInterval = flag.Duration("burst-interval", 2*time.Second, "突发模式下的推送间隔")
+
+// 加入速率低于阈值一半并持续该秒数后退出突发模式
+const burstCalmTicks = 5
+
+// 站点的突发模式状态（由 Hub 协程维护，读写需持有站点锁）
+type burstState struct {
+	joins    int
+	active   bool
+	calm     int
+	lastSync time.Time
+	lastSent int
+}
+
+// 站点的加入速率阈值
+func burstThreshold(siteID string) int {
+	return settings.Resolve(siteID).BurstJoins
+}
+
+// 每秒检查各站点的加入速率，切换突发模式并按固定间隔推送人数
+func (h *Hub) checkBursts(now time.Time) {
+	h.mutex.RLock()
+	sites := make([]*Site, 0, len(h.sites))
+	for _, site := range h.sites {
+		sites = append(sites, site)
+	}
+	h.mutex.RUnlock()
+
+	for _, site := range sites {
+		threshold := burstThreshold(site.ID)
+
+		site.mutex.Lock()
+		burst := &site.burst
+		rate := burst.joins
+		burst.joins = 0
+		count := site.Count
+
+		event, action := "", ""
+		sync := false
+		trend := ""
+		switch {
+		case !burst.active && threshold > 0 && rate >= threshold:
+			burst.active = true
+			burst.calm = 0
+			burst.lastSync = now
+			burst.lastSent = count
+			event, action = "burst-start", "进入突发模式"
+		case burst.active && (threshold <= 0 || rate*2 < threshold):
+			burst.calm++
+			if burst.calm >= burstCalmTicks {
+				burst.active = false
+				event, action = "burst-end", "退出突发模式"
+			}
+		case burst.active:
+			burst.calm = 0
+		}
+		if burst.active && now.Sub(burst.lastSync) >= *burstInterval {
+			sync = true
+			switch {
+			case count > burst.lastSent:
+				trend = "rising"
+			case count < burst.lastSent:
+				trend = "falling"
+			}
+			burst.lastSync = now
+			burst.lastSent = count
+		}
+		site.mutex.Unlock()
+
+		if event != "" {
+			log.Printf("站点 %s %s，加入速率 %d/s", site.ID, action, rate)
+			h.publishEvent(FeedEvent{Type: event, SiteID: site.ID, Count: count, Timestamp: now.Unix()})
+		}
+
+		// 退出时立即推送一次，恢复正常节奏
+		if sync || event == "burst-end" {
+			_, seq := h.countAndSeq(site.ID)
+			message := Message{
+				Type:      "update",
+				SiteID:    site.ID,
+				Count:     globalCount(site.ID, count),
+				Timestamp: now.Unix(),
+				Seq:       seq,
+			}
+			if sync {
+				message.Reason = "sync"
+				message.Trend = trend
+			}
+			h.broadcastMessage(site.ID, message)
+			h.publishAdminFeed(message)
+		}
+	}
+}
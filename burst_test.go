@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 不运行 Hub 协程，由测试按虚拟时间逐秒调用 checkBursts
+type burstClock struct {
+	t      *testing.T
+	hub    *Hub
+	site   *Site
+	viewer *Client
+	feed   *Feed
+	now    time.Time
+}
+
+func newBurstClock(t *testing.T) *burstClock {
+	setFlag(t, "burst-joins", "10")
+	setFlag(t, "burst-interval", "2s")
+	useTestSettings(t)
+
+	h := NewHub()
+	site, err := h.getSite("a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer := &Client{hub: h, site: site, send: make(chan Message, 16)}
+	site.Observers[viewer] = true
+	feed := &Feed{hub: h, siteID: site.ID, send: make(chan FeedEvent, 16)}
+	h.subscribeFeed(feed, 0)
+	return &burstClock{t: t, hub: h, site: site, viewer: viewer, feed: feed, now: time.Unix(1700000000, 0)}
+}
+
+// 经过一秒：这一秒内加入 joins 次，在线人数为 count；返回模式切换事件与推送的人数消息
+func (c *burstClock) tick(joins, count int) (events []string, updates []Message) {
+	c.now = c.now.Add(time.Second)
+	c.site.mutex.Lock()
+	c.site.burst.joins = joins
+	c.site.Count = count
+	c.site.mutex.Unlock()
+	c.hub.checkBursts(c.now)
+
+	for {
+		select {
+		case event := <-c.feed.send:
+			events = append(events, event.Type)
+		case message := <-c.viewer.send:
+			updates = append(updates, message)
+		default:
+			return events, updates
+		}
+	}
+}
+
+func (c *burstClock) active() bool {
+	c.site.mutex.RLock()
+	defer c.site.mutex.RUnlock()
+	return c.site.burst.active
+}
+
+// 加入速率达到阈值时进入突发模式，之后按 -burst-interval 推送带 reason:"sync" 与趋势的人数
+func TestBurstEntry(t *testing.T) {
+	c := newBurstClock(t)
+
+	if events, _ := c.tick(9, 9); len(events) != 0 || c.active() {
+		t.Fatalf("低于阈值时进入了突发模式：%v", events)
+	}
+	if events, _ := c.tick(10, 19); len(events) != 1 || events[0] != "burst-start" || !c.active() {
+		t.Fatalf("达到阈值时的事件为 %v，应进入突发模式", events)
+	}
+	if _, updates := c.tick(20, 39); len(updates) != 0 {
+		t.Fatalf("间隔未到就推送了 %d 条", len(updates))
+	}
+
+	_, updates := c.tick(20, 59)
+	if len(updates) != 1 || updates[0].Reason != "sync" || updates[0].Trend != "rising" || updates[0].Count != 59 {
+		t.Fatalf("间隔到达时推送 %+v，应为一条 rising 的 sync", updates)
+	}
+	c.tick(20, 50)
+	if _, updates := c.tick(20, 40); len(updates) != 1 || updates[0].Trend != "falling" {
+		t.Fatalf("人数下降时推送 %+v，应为 falling", updates)
+	}
+	c.tick(20, 40)
+	if _, updates := c.tick(20, 40); len(updates) != 1 || updates[0].Trend != "" {
+		t.Fatalf("人数不变时推送 %+v，不应带趋势", updates)
+	}
+}
+
+// 加入速率低于阈值一半持续 burstCalmTicks 秒后退出，并立即推送一次不带 sync 的人数
+func TestBurstExit(t *testing.T) {
+	c := newBurstClock(t)
+	c.tick(10, 10)
+
+	for i := 1; i < burstCalmTicks; i++ {
+		if events, _ := c.tick(4, 10); len(events) != 0 || !c.active() {
+			t.Fatalf("平静 %d 秒就退出了突发模式", i)
+		}
+	}
+	events, updates := c.tick(4, 10)
+	if len(events) != 1 || events[0] != "burst-end" || c.active() {
+		t.Fatalf("平静 %d 秒后的事件为 %v，应退出突发模式", burstCalmTicks, events)
+	}
+	if len(updates) != 1 || updates[0].Reason != "" || updates[0].Count != 10 {
+		t.Fatalf("退出时推送 %+v，应为一条普通的人数消息", updates)
+	}
+	if events, updates := c.tick(0, 10); len(events) != 0 || len(updates) != 0 {
+		t.Fatalf("退出后仍有事件 %v 或推送 %v", events, updates)
+	}
+}
+
+// 速率在阈值一半上下来回波动时保持突发模式，不会反复切换
+func TestBurstFlapping(t *testing.T) {
+	c := newBurstClock(t)
+	c.tick(10, 10)
+
+	var events []string
+	for round := 0; round < 5; round++ {
+		for i := 1; i < burstCalmTicks; i++ {
+			tickEvents, _ := c.tick(4, 10)
+			events = append(events, tickEvents...)
+		}
+		// 介于阈值一半与阈值之间：不会重新进入，但打断退出的计时
+		tickEvents, _ := c.tick(6, 10)
+		events = append(events, tickEvents...)
+	}
+	if len(events) != 0 || !c.active() {
+		t.Fatalf("波动期间发生了切换：%v", events)
+	}
+
+	// 退出后速率回升但未达阈值，不会重新进入
+	for i := 0; i < burstCalmTicks; i++ {
+		c.tick(0, 10)
+	}
+	if events, _ := c.tick(9, 10); len(events) != 0 || c.active() {
+		t.Fatalf("退出后未达阈值就重新进入：%v", events)
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 允许对本实例做容量测试；未开启时 liveuser capacity 拒绝运行
+var allowLoadtest = flag.Bool("allow-loadtest", false, "允许 liveuser capacity 对本实例做容量测试（开放 /admin/api/capacity 采样接口）")
+
+// 服务端采样，供 liveuser capacity 判断瓶颈
+type CapacitySample struct {
+	Version    string    `json:"version"`
+	Time       time.Time `json:"time"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	GOMEMLIMIT int64     `json:"gomemlimit"`
+
+	Connections int64 `json:"connections"`
+	Goroutines  int   `json:"goroutines"`
+
+	// 堆与协程栈占用的内存，用于估算每个连接的内存
+	HeapInuse  uint64 `json:"heapInuse"`
+	StackInuse uint64 `json:"stackInuse"`
+	Sys        uint64 `json:"sys"`
+
+	// 进程累计使用的 CPU 时间（秒），无法获取时为 -1
+	CPUSeconds float64 `json:"cpuSeconds"`
+
+	// 处于突发模式的站点数，测量前等待其归零
+	Bursting int       `json:"bursting"`
+	Queue    QueueInfo `json:"queue"`
+
+	// 会影响测试结果的限制
+	MaxConnections int `json:"maxConnections"`
+	MaxPerSite     int `json:"maxPerSite"`
+	IPMaxConns     int `json:"ipMaxConns"`
+	IPMaxRate      int `json:"ipMaxRate"`
+}
+
+// 处于突发模式的站点数
+func (h *Hub) burstingSites() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	bursting := 0
+	for _, site := range h.sites {
+		site.mutex.RLock()
+		if site.burst.active {
+			bursting++
+		}
+		site.mutex.RUnlock()
+	}
+	return bursting
+}
+
+// 处理容量测试采样：GET /admin/api/capacity
+//
+// 只有以 -allow-loadtest 启动的实例才返回采样，否则返回 403，避免误压生产实例。
+func handleAdminCapacity(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !*allowLoadtest {
+		http.Error(w, "实例未以 -allow-loadtest 启动", http.StatusForbidden)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeJSON(w, http.StatusOK, CapacitySample{
+		Version:        Version,
+		Time:           time.Now(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		GOMEMLIMIT:     debug.SetMemoryLimit(-1),
+		Connections:    hub.wsConns.Load(),
+		Goroutines:     runtime.NumGoroutine(),
+		HeapInuse:      mem.HeapInuse,
+		StackInuse:     mem.StackInuse,
+		Sys:            mem.Sys,
+		CPUSeconds:     processCPUSeconds(),
+		Bursting:       hub.burstingSites(),
+		Queue:          hub.queueInfo(),
+		MaxConnections: *maxConnections,
+		MaxPerSite:     *maxPerSite,
+		IPMaxConns:     *ipMaxConns,
+		IPMaxRate:      *ipMaxRate,
+	})
+}
+
+// 容量测试参数，随报告一起保存，可通过 -rerun 原样重跑
+type CapacityParams struct {
+	Server        string `json:"server"`
+	SiteID        string `json:"siteId"`
+	Step          int    `json:"step"`
+	Max           int    `json:"max"`
+	StepDuration  string `json:"stepDuration"`
+	LatencyTarget string `json:"latencyTarget"`
+	ProbeInterval string `json:"probeInterval"`
+	Settle        string `json:"settle"`
+}
+
+// 每一级连接数的测量结果
+type CapacityStep struct {
+	Connections int `json:"connections"`
+	Probes      int `json:"probes"`
+
+	// 探测连接加入后，其他连接收到新人数的延迟
+	P50Ms float64 `json:"p50Ms"`
+	P99Ms float64 `json:"p99Ms"`
+
+	// 每秒送达的人数更新数
+	FanoutPerSec float64 `json:"fanoutPerSec"`
+
+	CPU          float64 `json:"cpu"`
+	HeapInuse    uint64  `json:"heapInuse"`
+	Goroutines   int     `json:"goroutines"`
+	QueueP99Ms   float64 `json:"queueP99Ms"`
+	QueueDegrade bool    `json:"queueDegraded"`
+}
+
+// 容量测试报告
+type CapacityReport struct {
+	Parameters CapacityParams `json:"parameters"`
+	Server     CapacitySample `json:"server"`
+	StartedAt  time.Time      `json:"startedAt"`
+	Steps      []CapacityStep `json:"steps"`
+
+	// p99 延迟不超过目标的最大连接数
+	MaxSustained       int     `json:"maxSustained"`
+	MemoryPerConnBytes float64 `json:"memoryPerConnBytes"`
+	FanoutPerSec       float64 `json:"fanoutPerSec"`
+
+	// 限制因素：cpu、memory、hub-queue、connections、none（达到 -max）或 unknown
+	LimitingResource string `json:"limitingResource"`
+	Reason           string `json:"reason"`
+}
+
+// 容量测试
+type capacityRun struct {
+	params        CapacityParams
+	adminToken    string
+	wsURL         string
+	stepDuration  time.Duration
+	latencyTarget time.Duration
+	probeInterval time.Duration
+	settle        time.Duration
+
+	mutex   sync.Mutex
+	clients []*loadClient
+}
+
+// 负载连接，记录探测期间收到的人数更新
+type loadClient struct {
+	conn *websocket.Conn
+
+	mutex   sync.Mutex
+	updates []loadUpdate
+}
+
+type loadUpdate struct {
+	count int
+	at    time.Time
+}
+
+// liveuser capacity：逐级增加连接数并测量人数推送延迟，输出容量报告
+func runCapacity(args []string) error {
+	fs := flag.NewFlagSet("capacity", flag.ContinueOnError)
+	params := CapacityParams{}
+	fs.StringVar(&params.Server, "server", "http://127.0.0.1:8080", "被测实例地址")
+	fs.StringVar(&params.SiteID, "site", "loadtest", "测试使用的站点 ID")
+	fs.IntVar(&params.Step, "step", 200, "每一级增加的连接数")
+	fs.IntVar(&params.Max, "max", 10000, "最多建立的连接数")
+	fs.StringVar(&params.StepDuration, "step-duration", "10s", "每一级的测量时长")
+	fs.StringVar(&params.LatencyTarget, "latency-target", "200ms", "人数推送 p99 延迟的目标")
+	fs.StringVar(&params.ProbeInterval, "probe-interval", "250ms", "探测连接加入的间隔，需低于突发模式阈值")
+	fs.StringVar(&params.Settle, "settle", "30s", "增加连接后等待突发模式结束的最长时间")
+	adminTokenValue := fs.String("admin-token", "", "被测实例的 -admin-token")
+	profile := fs.String("profile", "", "报告的 JSON 输出路径，为空时只打印摘要")
+	rerun := fs.String("rerun", "", "读取已有报告中的参数重新测试，忽略其他测试参数")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *rerun != "" {
+		data, err := os.ReadFile(*rerun)
+		if err != nil {
+			return err
+		}
+		var previous CapacityReport
+		if err := json.Unmarshal(data, &previous); err != nil {
+			return fmt.Errorf("%s: %v", *rerun, err)
+		}
+		params = previous.Parameters
+	}
+
+	run, err := newCapacityRun(params, *adminTokenValue)
+	if err != nil {
+		return err
+	}
+	report, err := run.execute()
+	if err != nil {
+		return err
+	}
+
+	if *profile != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(*profile, data); err != nil {
+			return err
+		}
+	}
+	fmt.Print(report.summary())
+	return nil
+}
+
+func newCapacityRun(params CapacityParams, adminToken string) (*capacityRun, error) {
+	run := &capacityRun{params: params, adminToken: adminToken}
+	u, err := url.Parse(params.Server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的实例地址 %q", params.Server)
+	}
+	wsScheme := "ws"
+	if u.Scheme == "https" {
+		wsScheme = "wss"
+	}
+	run.wsURL = wsScheme + "://" + u.Host + "/ws"
+	if params.Step <= 0 || params.Max < params.Step {
+		return nil, fmt.Errorf("-step 须大于 0 且不超过 -max")
+	}
+	for _, d := range []struct {
+		name   string
+		value  string
+		target *time.Duration
+	}{
+		{"-step-duration", params.StepDuration, &run.stepDuration},
+		{"-latency-target", params.LatencyTarget, &run.latencyTarget},
+		{"-probe-interval", params.ProbeInterval, &run.probeInterval},
+		{"-settle", params.Settle, &run.settle},
+	} {
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("无效的 %s %q", d.name, d.value)
+		}
+		*d.target = parsed
+	}
+	return run, nil
+}
+
+// 读取服务端采样；实例未开启 -allow-loadtest 时返回错误
+func (run *capacityRun) sample() (CapacitySample, error) {
+	var sample CapacitySample
+	req, err := http.NewRequest("GET", strings.TrimRight(run.params.Server, "/")+"/admin/api/capacity", nil)
+	if err != nil {
+		return sample, err
+	}
+	req.Header.Set("Authorization", "Bearer "+run.adminToken)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return sample, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return sample, errors.New("被测实例未以 -allow-loadtest 启动，拒绝测试")
+	case http.StatusNotFound:
+		return sample, errors.New("被测实例未设置 -admin-token 或版本过旧，无法采样")
+	case http.StatusUnauthorized:
+		return sample, errors.New("-admin-token 不正确")
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return sample, fmt.Errorf("采样失败: %d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return sample, json.NewDecoder(resp.Body).Decode(&sample)
+}
+
+// 建立一个连接并加入测试站点
+func (run *capacityRun) dial(id int) (*loadClient, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(run.wsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	clientID := "capacity-" + strconv.Itoa(id)
+	join, _ := json.Marshal(Message{Type: "join", SiteID: run.params.SiteID, VisitorID: clientID, ClientID: clientID})
+	if err := conn.WriteMessage(websocket.TextMessage, join); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client := &loadClient{conn: conn}
+	go client.read()
+	return client, nil
+}
+
+// 读取消息，记录人数更新；连接断开时退出
+func (c *loadClient) read() {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		var message Message
+		if json.Unmarshal(data, &message) != nil || message.Type != "update" {
+			continue
+		}
+		c.mutex.Lock()
+		c.updates = append(c.updates, loadUpdate{count: message.Count, at: now})
+		c.mutex.Unlock()
+	}
+}
+
+// 取出并清空记录的人数更新
+func (c *loadClient) take() []loadUpdate {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	updates := c.updates
+	c.updates = nil
+	return updates
+}
+
+// 关闭全部连接
+func (run *capacityRun) close() {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	for _, client := range run.clients {
+		client.conn.Close()
+	}
+	run.clients = nil
+}
+
+// 逐级增加连接数，直到 p99 延迟超过目标、无法建立连接或达到 -max
+func (run *capacityRun) execute() (*CapacityReport, error) {
+	baseline, err := run.sample()
+	if err != nil {
+		return nil, err
+	}
+	if baseline.IPMaxConns > 0 && baseline.IPMaxConns < run.params.Max {
+		return nil, fmt.Errorf("被测实例的 -ip-max-conns 为 %d，单个 IP 无法建立 %d 个连接，请以 -ip-max-conns 0 -ip-max-rate 0 启动", baseline.IPMaxConns, run.params.Max)
+	}
+	if baseline.IPMaxRate > 0 && baseline.IPMaxRate < run.params.Step {
+		return nil, fmt.Errorf("被测实例的 -ip-max-rate 为 %d，低于每级新建的 %d 个连接，请以 -ip-max-rate 0 启动", baseline.IPMaxRate, run.params.Step)
+	}
+	defer run.close()
+
+	report := &CapacityReport{
+		Parameters:       run.params,
+		Server:           baseline,
+		StartedAt:        time.Now(),
+		LimitingResource: "none",
+		Reason:           fmt.Sprintf("达到 -max %d 时 p99 延迟仍未超过目标", run.params.Max),
+	}
+	// 第一级与最后一级测量后的采样，用于估算每个连接的内存
+	var first, last CapacitySample
+	for target := run.params.Step; target <= run.params.Max; target += run.params.Step {
+		if err := run.ramp(target); err != nil {
+			report.LimitingResource = "connections"
+			report.Reason = fmt.Sprintf("建立第 %d 个连接失败: %v", len(run.clients)+1, err)
+			if strings.Contains(err.Error(), "503") || strings.Contains(err.Error(), "bad handshake") {
+				report.Reason += "（实例可能达到了 -max-connections 或 -max-per-site）"
+			}
+			break
+		}
+		before, err := run.waitCalm()
+		if err != nil {
+			return nil, err
+		}
+		step := run.measure(target)
+		after, err := run.sample()
+		if err != nil {
+			return nil, err
+		}
+		if len(report.Steps) == 0 {
+			first = after
+		}
+		last = after
+		step.HeapInuse = after.HeapInuse
+		step.Goroutines = after.Goroutines
+		step.QueueP99Ms = after.Queue.P99Ms
+		step.QueueDegrade = after.Queue.Degraded
+		if elapsed := after.Time.Sub(before.Time).Seconds(); elapsed > 0 && before.CPUSeconds >= 0 {
+			step.CPU = (after.CPUSeconds - before.CPUSeconds) / (elapsed * float64(after.GOMAXPROCS))
+		}
+		report.Steps = append(report.Steps, step)
+		report.FanoutPerSec = math.Max(report.FanoutPerSec, step.FanoutPerSec)
+
+		if step.P99Ms > durationMs(run.latencyTarget) || step.Probes == 0 {
+			report.LimitingResource, report.Reason = run.diagnose(step, after)
+			break
+		}
+		report.MaxSustained = target
+	}
+
+	// 多于一级时取两级之间的增量，不受测试前残留内存的影响
+	from := baseline
+	if len(report.Steps) > 1 {
+		from = first
+	}
+	if added := last.Connections - from.Connections; added > 0 {
+		used := float64(last.HeapInuse+last.StackInuse) - float64(from.HeapInuse+from.StackInuse)
+		report.MemoryPerConnBytes = math.Max(used, 0) / float64(added)
+	}
+	return report, nil
+}
+
+// 等待测试站点退出突发模式、命令队列清空，最多等待 -settle
+//
+// 突发模式下人数按固定间隔推送，测得的延迟不能反映正常推送的耗时。
+func (run *capacityRun) waitCalm() (CapacitySample, error) {
+	deadline := time.Now().Add(run.settle)
+	for {
+		sample, err := run.sample()
+		if err != nil {
+			return sample, err
+		}
+		if (sample.Bursting == 0 && sample.Queue.Depth == 0) || time.Now().After(deadline) {
+			return sample, nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// 建立连接直到共有 target 个
+func (run *capacityRun) ramp(target int) error {
+	for len(run.clients) < target {
+		client, err := run.dial(len(run.clients))
+		if err != nil {
+			return err
+		}
+		run.mutex.Lock()
+		run.clients = append(run.clients, client)
+		run.mutex.Unlock()
+	}
+	return nil
+}
+
+// 在测量时长内反复让探测连接加入，统计其他连接收到新人数的延迟
+func (run *capacityRun) measure(connections int) CapacityStep {
+	step := CapacityStep{Connections: connections}
+	var latencies []float64
+	var fanouts []float64
+	deadline := time.Now().Add(run.stepDuration)
+
+	for time.Now().Before(deadline) {
+		for _, client := range run.clients {
+			client.take()
+		}
+		sent := time.Now()
+		probe, err := run.dial(-1 - step.Probes)
+		if err != nil {
+			break
+		}
+		time.Sleep(run.probeInterval)
+		probe.conn.Close()
+
+		// 探测连接加入后人数为 connections+1，每个连接取第一次收到该人数的时间
+		var slowest time.Duration
+		delivered := 0
+		for _, client := range run.clients {
+			for _, update := range client.take() {
+				if update.count == connections+1 {
+					latency := update.at.Sub(sent)
+					latencies = append(latencies, durationMs(latency))
+					slowest = max(slowest, latency)
+					delivered++
+					break
+				}
+			}
+		}
+		if delivered > 0 && slowest > 0 {
+			fanouts = append(fanouts, float64(delivered)/slowest.Seconds())
+		}
+		step.Probes++
+
+		// 等待探测连接离开的推送，避免与下一次探测混在一起
+		time.Sleep(run.probeInterval)
+	}
+
+	// 未收到推送的连接按整段测量时长计入，使丢失的推送体现在 p99 中
+	missing := step.Probes*connections - len(latencies)
+	for i := 0; i < missing; i++ {
+		latencies = append(latencies, durationMs(run.stepDuration))
+	}
+	step.P50Ms = percentile(latencies, 0.5)
+	step.P99Ms = percentile(latencies, 0.99)
+	step.FanoutPerSec = percentile(fanouts, 0.5)
+	return step
+}
+
+// 判断延迟超标时的限制因素
+func (run *capacityRun) diagnose(step CapacityStep, sample CapacitySample) (string, string) {
+	switch {
+	case step.Probes == 0:
+		return "connections", "探测连接无法建立"
+	case step.QueueDegrade || step.QueueP99Ms > durationMs(run.latencyTarget)/2:
+		return "hub-queue", fmt.Sprintf("Hub 命令排队 p99 %.1fms，命令处理跟不上", step.QueueP99Ms)
+	case step.CPU >= 0.8:
+		return "cpu", fmt.Sprintf("CPU 使用率 %.0f%%（GOMAXPROCS=%d）", step.CPU*100, sample.GOMAXPROCS)
+	case sample.GOMEMLIMIT > 0 && sample.GOMEMLIMIT != math.MaxInt64 && float64(sample.Sys) >= 0.9*float64(sample.GOMEMLIMIT):
+		return "memory", fmt.Sprintf("内存 %d 字节已接近 GOMEMLIMIT %d，GC 频繁", sample.Sys, sample.GOMEMLIMIT)
+	default:
+		return "unknown", fmt.Sprintf("p99 延迟 %.1fms 超过目标，CPU、内存与 Hub 队列均未饱和，可能受网络或测试端限制", step.P99Ms)
+	}
+}
+
+// 百分位数，values 为空时为 0
+func percentile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[int(math.Ceil(q*float64(len(sorted))))-1]
+}
+
+// 人类可读的摘要
+func (r *CapacityReport) summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "容量测试: %s（LiveUser %s，GOMAXPROCS=%d），站点 %s\n",
+		r.Parameters.Server, r.Server.Version, r.Server.GOMAXPROCS, r.Parameters.SiteID)
+	fmt.Fprintf(&b, "%8s %8s %10s %10s %12s %6s %10s\n", "连接数", "探测", "p50(ms)", "p99(ms)", "推送/秒", "CPU", "堆(MB)")
+	for _, step := range r.Steps {
+		fmt.Fprintf(&b, "%8d %8d %10.1f %10.1f %12.0f %5.0f%% %10.1f\n",
+			step.Connections, step.Probes, step.P50Ms, step.P99Ms, step.FanoutPerSec, step.CPU*100, float64(step.HeapInuse)/(1<<20))
+	}
+	fmt.Fprintf(&b, "p99 延迟不超过 %s 的最大连接数: %d\n", r.Parameters.LatencyTarget, r.MaxSustained)
+	fmt.Fprintf(&b, "每个连接占用内存: 约 %.1f KB\n", r.MemoryPerConnBytes/1024)
+	fmt.Fprintf(&b, "推送吞吐: 约 %.0f 条/秒\n", r.FanoutPerSec)
+	fmt.Fprintf(&b, "限制因素: %s（%s）\n", r.LimitingResource, r.Reason)
+	return b.String()
+}
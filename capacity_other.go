@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// 非 Unix 平台不统计进程 CPU 时间
+func processCPUSeconds() float64 {
+	return -1
+}
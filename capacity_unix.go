@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// 进程累计使用的用户态与内核态 CPU 时间（秒）
+func processCPUSeconds() float64 {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return -1
+	}
+	return float64(usage.Utime.Nano()+usage.Stime.Nano()) / 1e9
+}
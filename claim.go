@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 认领流程的限制
+const (
+	claimTokenTTL     = 24 * time.Hour
+	maxPendingClaims  = 1000
+	claimFetchTimeout = 10 * time.Second
+	claimResponseCap  = 64 << 10
+	claimMaxRedirects = 3
+)
+
+// 校验抓取的重试策略
+var claimRetryPolicy = retryPolicy{
+	Target:         "claim",
+	MaxAttempts:    3,
+	MaxElapsed:     20 * time.Second,
+	BaseDelay:      500 * time.Millisecond,
+	MaxDelay:       2 * time.Second,
+	AttemptTimeout: claimFetchTimeout,
+}
+
+// 校验文件路径与页面中的 meta 标签名
+const (
+	claimWellKnownPath = "/.well-known/liveuser-verify.txt"
+	claimMetaName      = "liveuser-verify"
+)
+
+// 站点域名格式
+var claimHostPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// 页面中的校验 meta 标签
+var claimMetaPattern = regexp.MustCompile(`(?i)<meta\s+name=["']` + claimMetaName + `["']\s+content=["']([^"']+)["']`)
+
+// 待校验的认领
+type pendingClaim struct {
+	siteID    string
+	token     string
+	createdAt time.Time
+	verifying bool
+}
+
+// 认领请求体
+type claimRequest struct {
+	SiteID string `json:"siteId"`
+	Token  string `json:"token,omitempty"`
+}
+
+// 认领接口响应
+type ClaimInfo struct {
+	SiteID  string `json:"siteId"`
+	Token   string `json:"token,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Private bool   `json:"private,omitempty"`
+	File    string `json:"file,omitempty"`
+	Meta    string `json:"meta,omitempty"`
+}
+
+// 待校验的认领，按令牌保存：同一站点可同时有多个认领，再次申请不会使他人已拿到的令牌失效
+var claims = struct {
+	pending map[string]*pendingClaim
+	mutex   sync.Mutex
+}{pending: make(map[string]*pendingClaim)}
+
+// 记录认领流程的审计日志
+func auditClaim(r *http.Request, siteID, action string) {
+	log.Printf("审计: 站点认领 %s，站点 %s，来源 %s", action, siteID, getRealIP(r))
+}
+
+// 生成随机十六进制字符串
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// 解析可认领的站点：只接受域名，可带 80/443 端口
+func claimHost(siteID string) (string, bool) {
+	host := siteID
+	if h, port, err := net.SplitHostPort(siteID); err == nil {
+		if port != "80" && port != "443" {
+			return "", false
+		}
+		host = h
+	}
+	return host, claimHostPattern.MatchString(host)
+}
+
+// 处理认领请求：POST /api/claim
+//
+// 未认领的站点返回校验令牌；已认领的站点携带现有密钥时直接换发新密钥，
+// 否则需要重新完成校验。
+func handleClaim(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req claimRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	siteID := strings.ToLower(strings.TrimSpace(req.SiteID))
+	if _, ok := claimHost(siteID); !ok {
+		writeError(w, &InvalidSiteIDError{SiteID: siteID, Reason: "只能认领域名"})
+		return
+	}
+
+	if key := siteKeyFromRequest(r); key != "" {
+		// 换发只接受当前密钥，旧密钥只用于迁移期间的读取
+		if matchSiteKey(siteID, key) != keyCurrent {
+			auditClaim(r, siteID, "换发密钥被拒绝")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		info, err := issueSiteKey(siteID)
+		if err != nil {
+			log.Printf("保存站点配置失败: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		auditClaim(r, siteID, "凭现有密钥换发")
+		writeJSON(w, http.StatusOK, info)
+		return
+	}
+
+	token, err := randomHex(16)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	claims.mutex.Lock()
+	for pendingToken, claim := range claims.pending {
+		if now.Sub(claim.createdAt) > claimTokenTTL {
+			delete(claims.pending, pendingToken)
+		}
+	}
+	if len(claims.pending) >= maxPendingClaims {
+		claims.mutex.Unlock()
+		http.Error(w, "Too Many Pending Claims", http.StatusServiceUnavailable)
+		return
+	}
+	claims.pending[token] = &pendingClaim{siteID: siteID, token: token, createdAt: now}
+	claims.mutex.Unlock()
+
+	auditClaim(r, siteID, "申请令牌")
+	writeJSON(w, http.StatusOK, ClaimInfo{
+		SiteID: siteID,
+		Token:  token,
+		File:   "https://" + siteID + claimWellKnownPath,
+		Meta:   fmt.Sprintf(`<meta name="%s" content="%s">`, claimMetaName, token),
+	})
+}
+
+// 处理认领校验：POST /api/claim/verify
+func handleClaimVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req claimRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	siteID := strings.ToLower(strings.TrimSpace(req.SiteID))
+
+	claims.mutex.Lock()
+	claim, exists := claims.pending[req.Token]
+	if !exists || time.Since(claim.createdAt) > claimTokenTTL ||
+		subtle.ConstantTimeCompare([]byte(claim.siteID), []byte(siteID)) != 1 {
+		claims.mutex.Unlock()
+		auditClaim(r, siteID, "校验失败（令牌无效）")
+		http.Error(w, "Unknown or expired claim", http.StatusNotFound)
+		return
+	}
+	if claim.verifying {
+		claims.mutex.Unlock()
+		http.Error(w, "Verification in progress", http.StatusConflict)
+		return
+	}
+	claim.verifying = true
+	claims.mutex.Unlock()
+
+	err := claimVerifier(r.Context(), siteID, claim.token)
+
+	claims.mutex.Lock()
+	claim.verifying = false
+	// 校验期间该认领可能已过期被清理，只删除这一个认领
+	if err == nil && claims.pending[claim.token] == claim {
+		delete(claims.pending, claim.token)
+	}
+	claims.mutex.Unlock()
+
+	if err != nil {
+		auditClaim(r, siteID, "校验失败（"+err.Error()+"）")
+		http.Error(w, "Verification failed: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	info, err := issueSiteKey(siteID)
+	if err != nil {
+		log.Printf("保存站点配置失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	auditClaim(r, siteID, "校验通过，已签发密钥")
+	writeJSON(w, http.StatusOK, info)
+}
+
+// 签发新的站点密钥并将站点设为私有
+//
+// 已有密钥时保留为 previousKey，使用旧密钥的嵌入代码在 -key-grace 内继续有效。
+func issueSiteKey(siteID string) (ClaimInfo, error) {
+	key, err := randomHex(24)
+	if err != nil {
+		return ClaimInfo{}, err
+	}
+
+	now := time.Now().UTC()
+	private := true
+	err = settings.Update(siteID, func(site *SiteSettings) {
+		site.PreviousKey, site.PreviousKeyExpiresAt = site.Key, nil
+		if site.Key != "" && *keyGrace > 0 {
+			expiresAt := now.Add(*keyGrace)
+			site.PreviousKeyExpiresAt = &expiresAt
+		}
+		site.Key = key
+		site.Private = &private
+		site.ClaimedAt = &now
+	})
+	if err != nil {
+		return ClaimInfo{}, err
+	}
+	return ClaimInfo{SiteID: siteID, Key: key, Private: true}, nil
+}
+
+// 校验认领使用的函数，测试中替换以免访问网络
+var claimVerifier = verifyClaim
+
+// 抓取站点上的校验文件或首页 meta 标签并比对令牌
+func verifyClaim(ctx context.Context, siteID, token string) error {
+	host, ok := claimHost(siteID)
+	if !ok {
+		return errors.New("站点不是域名")
+	}
+	client := claimHTTPClient(host)
+
+	var lastErr error
+	for _, scheme := range []string{"https", "http"} {
+		body, err := fetchClaimURL(ctx, client, scheme+"://"+siteID+claimWellKnownPath)
+		if err == nil && strings.TrimSpace(body) == token {
+			return nil
+		}
+
+		page, pageErr := fetchClaimURL(ctx, client, scheme+"://"+siteID+"/")
+		if pageErr == nil {
+			if match := claimMetaPattern.FindStringSubmatch(page); match != nil && match[1] == token {
+				return nil
+			}
+		}
+
+		switch {
+		case err != nil:
+			lastErr = err
+		case pageErr != nil:
+			lastErr = pageErr
+		default:
+			lastErr = errors.New("未找到匹配的令牌")
+		}
+	}
+	return lastErr
+}
+
+// 读取 URL 内容，限制大小，失败时按 claimRetryPolicy 重试
+func fetchClaimURL(ctx context.Context, client *http.Client, url string) (string, error) {
+	var body string
+	err := retry(ctx, claimRetryPolicy, func(ctx context.Context) error {
+		var err error
+		body, err = fetchClaimURLOnce(ctx, client, url)
+		return err
+	})
+	return body, err
+}
+
+// 单次读取 URL 内容
+func fetchClaimURLOnce(ctx context.Context, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "LiveUser-Verify/"+Version)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &outboundStatusError{URL: url, Status: resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, claimResponseCap))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// 校验用的 HTTP 客户端：只连接认领的主机的公网地址与 80/443 端口，不跟随跳转到其他主机
+func claimHTTPClient(host string) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: claimFetchTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ip, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if port != "80" && port != "443" {
+				return fmt.Errorf("端口 %s: %w", port, errOutboundDenied)
+			}
+			if !publicIP(net.ParseIP(ip)) {
+				return fmt.Errorf("地址 %s: %w", ip, errOutboundDenied)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: claimFetchTimeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   claimFetchTimeout,
+			ResponseHeaderTimeout: claimFetchTimeout,
+			DisableKeepAlives:     true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= claimMaxRedirects {
+				return fmt.Errorf("跳转次数过多: %w", errOutboundDenied)
+			}
+			if !strings.EqualFold(req.URL.Hostname(), host) {
+				return fmt.Errorf("跳转到 %s: %w", req.URL.Hostname(), errOutboundDenied)
+			}
+			return nil
+		},
+	}
+}
+
+// 是否为公网地址
+func publicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() || ip.IsInterfaceLocalMulticast())
+}
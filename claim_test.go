@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 测试期间使用空的待校验认领，并以 verify 代替抓取站点的校验
+func useTestClaims(t *testing.T, verify func(ctx context.Context, siteID, token string) error) {
+	t.Helper()
+	claims.mutex.Lock()
+	previous := claims.pending
+	claims.pending = make(map[string]*pendingClaim)
+	claims.mutex.Unlock()
+	previousVerifier := claimVerifier
+	claimVerifier = verify
+	t.Cleanup(func() {
+		claims.mutex.Lock()
+		claims.pending = previous
+		claims.mutex.Unlock()
+		claimVerifier = previousVerifier
+	})
+}
+
+// 调用认领接口
+func postClaim(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/api/claim", strings.NewReader(body)))
+	return w
+}
+
+// 申请认领令牌
+func requestClaim(t *testing.T, siteID string) string {
+	t.Helper()
+	var info ClaimInfo
+	decodeJSON(t, postClaim(handleClaim, `{"siteId":"`+siteID+`"}`), &info)
+	if info.Token == "" {
+		t.Fatalf("没有返回令牌: %+v", info)
+	}
+	return info.Token
+}
+
+// 校验认领，返回状态码与签发的密钥
+func verifyClaimRequest(siteID, token string) (int, string) {
+	w := postClaim(handleClaimVerify, `{"siteId":"`+siteID+`","token":"`+token+`"}`)
+	if w.Code != http.StatusOK {
+		return w.Code, ""
+	}
+	var info ClaimInfo
+	json.Unmarshal(w.Body.Bytes(), &info)
+	return w.Code, info.Key
+}
+
+// 他人再次申请同一站点不会使站长手中的令牌失效，各自只能用自己的令牌完成校验
+func TestClaimNotReplaced(t *testing.T) {
+	useTestSettings(t)
+	published := map[string]string{}
+	useTestClaims(t, func(ctx context.Context, siteID, token string) error {
+		if published[siteID] != token {
+			return errors.New("未找到匹配的令牌")
+		}
+		return nil
+	})
+
+	owner := requestClaim(t, "a.example")
+	other := requestClaim(t, "a.example")
+	if owner == other {
+		t.Fatal("两次申请得到相同的令牌")
+	}
+	published["a.example"] = owner
+
+	if code, _ := verifyClaimRequest("b.example", owner); code != http.StatusNotFound {
+		t.Fatalf("用其他站点的令牌校验返回 %d，应为 404", code)
+	}
+	if code, _ := verifyClaimRequest("a.example", other); code != http.StatusForbidden {
+		t.Fatalf("令牌不在站点上时校验返回 %d，应为 403", code)
+	}
+	code, key := verifyClaimRequest("a.example", owner)
+	if code != http.StatusOK || key == "" {
+		t.Fatalf("站长校验返回 %d", code)
+	}
+	if site := settings.Get("a.example"); site.Key != key {
+		t.Fatalf("站点密钥为 %q，应为签发的 %q", site.Key, key)
+	}
+	if code, _ := verifyClaimRequest("a.example", owner); code != http.StatusNotFound {
+		t.Fatalf("令牌重复使用返回 %d，应为 404", code)
+	}
+}
+
+// 校验期间同一站点有新的认领、旧认领过期被清理：校验成功只删除自己的认领，新的认领仍可校验
+func TestClaimVerifyDeletesOnlyItsClaim(t *testing.T) {
+	useTestSettings(t)
+	started, release := make(chan string), make(chan struct{})
+	useTestClaims(t, func(ctx context.Context, siteID, token string) error {
+		started <- token
+		<-release
+		return nil
+	})
+
+	first := requestClaim(t, "a.example")
+	done := make(chan int)
+	go func() {
+		code, _ := verifyClaimRequest("a.example", first)
+		done <- code
+	}()
+	<-started
+
+	// 校验进行中：第一个认领过期，再次申请时被清理并创建新的认领
+	claims.mutex.Lock()
+	claims.pending[first].createdAt = claims.pending[first].createdAt.Add(-2 * claimTokenTTL)
+	claims.mutex.Unlock()
+	second := requestClaim(t, "a.example")
+	claims.mutex.Lock()
+	_, firstPending := claims.pending[first]
+	claims.mutex.Unlock()
+	if firstPending {
+		t.Fatal("过期的认领没有被清理")
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("第一个认领校验返回 %d", code)
+	}
+	claims.mutex.Lock()
+	claim, secondPending := claims.pending[second]
+	claims.mutex.Unlock()
+	if !secondPending || claim.siteID != "a.example" {
+		t.Fatal("第一个认领校验成功时删除了新的认领")
+	}
+
+	go func() { <-started }()
+	if code, _ := verifyClaimRequest("a.example", second); code != http.StatusOK {
+		t.Fatalf("新的认领校验返回 %d", code)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// 判定为时钟跳变的阈值
+var clockStepThreshold = flag.Duration("clock-step-threshold", 5*time.Second, "系统时钟跳变告警阈值")
+
+// 系统时钟跳变检测
+//
+// 连接的读写截止时间直接用 time.Now().Add 计算：time.Now() 带有单调时钟读数，
+// net 包设置截止时间时按单调时钟换算为剩余时长，系统时间被 NTP 向前或向后调整
+// 不会让已设置或之后设置的截止时间集体提前到期，检测到跳变后无需重新计算，只记录告警。
+// 截止时间不能来自 time.Unix、JSON 解析或 Round(0) 等丢弃单调读数的时间值。
+type clockMonitor struct {
+	last  time.Time
+	steps atomic.Int64
+}
+
+// 比较相邻两次 tick 的墙上时间与单调时间之差
+func (m *clockMonitor) observe(now time.Time) {
+	if !m.last.IsZero() {
+		m.check(now.Round(0).Sub(m.last.Round(0)), now.Sub(m.last))
+	}
+	m.last = now
+}
+
+// 两次 tick 之间墙上时间与单调时间的流逝相差超过阈值时计为一次跳变，返回是否跳变
+func (m *clockMonitor) check(wall, monotonic time.Duration) bool {
+	step := wall - monotonic
+	if step.Abs() <= *clockStepThreshold {
+		return false
+	}
+	m.steps.Add(1)
+	log.Printf("警告: 检测到系统时钟跳变 %v，连接截止时间基于单调时钟不受影响", step)
+	return true
+}
+
+// 累计检测到的跳变次数
+func (m *clockMonitor) Steps() int64 {
+	return m.steps.Load()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 墙上时间相对单调时间向前或向后跳变超过阈值时各计一次，正常的调度抖动不计
+func TestClockMonitorCheck(t *testing.T) {
+	setFlag(t, "clock-step-threshold", "5s")
+
+	tests := []struct {
+		name            string
+		wall, monotonic time.Duration
+		step            bool
+	}{
+		{"正常", time.Second, time.Second, false},
+		{"调度延迟", 1200 * time.Millisecond, 1200 * time.Millisecond, false},
+		{"小幅校时", 3 * time.Second, time.Second, false},
+		{"恰好等于阈值", 6 * time.Second, time.Second, false},
+		{"向前跳变", 41 * time.Second, time.Second, true},
+		{"向后跳变", -39 * time.Second, time.Second, true},
+	}
+	var m clockMonitor
+	want := int64(0)
+	for _, tt := range tests {
+		if got := m.check(tt.wall, tt.monotonic); got != tt.step {
+			t.Errorf("%s: 判定为 %v，应为 %v", tt.name, got, tt.step)
+		}
+		if tt.step {
+			want++
+		}
+	}
+	if steps := m.Steps(); steps != want {
+		t.Fatalf("累计 %d 次跳变，应为 %d", steps, want)
+	}
+}
+
+// 正常的 tick 序列不计为跳变
+func TestClockMonitorObserve(t *testing.T) {
+	var m clockMonitor
+	for i := 0; i < 3; i++ {
+		m.observe(time.Now())
+		time.Sleep(10 * time.Millisecond)
+	}
+	if steps := m.Steps(); steps != 0 {
+		t.Fatalf("未跳变时计入 %d 次", steps)
+	}
+}
+
+// 时钟向前与向后跳变后连接保持：截止时间按单调时钟换算，之后设置的截止时间照常生效
+func TestClockStepNoMassDisconnect(t *testing.T) {
+	setFlag(t, "clock-step-threshold", "5s")
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	clients := make([]*testClient, 5)
+	for i := range clients {
+		clients[i] = dialTestClient(t, server, "")
+		clients[i].join("a.example")
+	}
+	eventually(t, "全部加入", func() bool { return siteCount(h, "a.example") == len(clients) })
+
+	// 模拟 NTP 先把时钟向前拨 40 秒、再向后拨 40 秒
+	h.clock.check(41*time.Second, time.Second)
+	h.clock.check(-39*time.Second, time.Second)
+	if steps := h.clock.Steps(); steps != 2 {
+		t.Fatalf("检测到 %d 次跳变，应为 2", steps)
+	}
+
+	// 跳变后每个连接仍能往返消息，读写截止时间没有提前到期；
+	// 重复加入补发当前人数，之前收到的 welcome 与 update 跳过
+	for _, client := range clients {
+		client.send(Message{Type: "join", SiteID: "a.example", Version: scriptVersion})
+		for client.expect("welcome").Count != len(clients) {
+		}
+	}
+	if count := siteCount(h, "a.example"); count != len(clients) {
+		t.Fatalf("跳变后在线人数为 %d，应为 %d", count, len(clients))
+	}
+}
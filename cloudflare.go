@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内置的 Cloudflare IP 段
+//
+//go:embed cloudflare-ips.txt
+var cloudflareIPsDefault string
+
+// 自定义的 Cloudflare IP 段文件，为空时使用内置列表
+var cloudflareIPsFile = flag.String("cloudflare-ips-file", "", "Cloudflare IP 段文件，每行一个 CIDR，# 开头为注释；为空时使用内置列表，SIGHUP 重新读取")
+
+// 伪造 CF-Connecting-IP 的告警间隔
+const cloudflareWarnInterval = time.Minute
+
+// 闭区间 [start, end]，IPv4 按 IPv4 映射的 16 字节地址保存
+type ipRange struct {
+	start, end [16]byte
+}
+
+// 按起始地址排序且互不重叠的地址段，二分查找
+type ipRanges []ipRange
+
+// 解析 CIDR 列表，合并重叠的网段
+func parseIPRanges(text string) (ipRanges, error) {
+	var ranges ipRanges
+	for i, line := range strings.Split(text, "\n") {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(line)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: 无效的网段 %q", i+1, line)
+		}
+		var r ipRange
+		start := network.IP.To16()
+		mask := network.Mask
+		if len(mask) == net.IPv4len {
+			mask = append(net.CIDRMask(96, 128)[:12], mask...)
+		}
+		for k := range r.start {
+			r.start[k] = start[k]
+			r.end[k] = start[k] | ^mask[k]
+		}
+		ranges = append(ranges, r)
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].start[:], ranges[j].start[:]) < 0
+	})
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && bytes.Compare(r.start[:], merged[n-1].end[:]) <= 0 {
+			if bytes.Compare(r.end[:], merged[n-1].end[:]) > 0 {
+				merged[n-1].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// 地址是否在某个网段内
+func (ranges ipRanges) contains(ip net.IP) bool {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return false
+	}
+	// 第一个结束地址不小于 ip 的网段
+	i := sort.Search(len(ranges), func(i int) bool {
+		return bytes.Compare(ranges[i].end[:], ip16) >= 0
+	})
+	return i < len(ranges) && bytes.Compare(ranges[i].start[:], ip16) <= 0
+}
+
+// 当前的 Cloudflare IP 段与伪造告警的状态
+var cloudflare = struct {
+	mutex  sync.RWMutex
+	ranges ipRanges
+
+	warnMutex  sync.Mutex
+	warnedAt   time.Time
+	suppressed int
+}{}
+
+// 读取 -cloudflare-ips-file 或内置列表
+func loadCloudflareRanges() (ipRanges, error) {
+	text := cloudflareIPsDefault
+	if *cloudflareIPsFile != "" {
+		data, err := os.ReadFile(*cloudflareIPsFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	ranges, err := parseIPRanges(text)
+	if err != nil {
+		return nil, fmt.Errorf("Cloudflare IP 段: %v", err)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("Cloudflare IP 段为空")
+	}
+	return ranges, nil
+}
+
+// 替换当前的 Cloudflare IP 段
+func setCloudflareRanges(ranges ipRanges) {
+	cloudflare.mutex.Lock()
+	defer cloudflare.mutex.Unlock()
+	cloudflare.ranges = ranges
+}
+
+// 地址是否属于 Cloudflare
+func isCloudflareIP(ip net.IP) bool {
+	cloudflare.mutex.RLock()
+	defer cloudflare.mutex.RUnlock()
+	return cloudflare.ranges.contains(ip)
+}
+
+// 带 CF-Connecting-IP 的请求并非来自 Cloudflare，每分钟最多告警一次
+func warnSpoofedCloudflare(peer, header string) {
+	now := time.Now()
+	cloudflare.warnMutex.Lock()
+	defer cloudflare.warnMutex.Unlock()
+
+	if now.Sub(cloudflare.warnedAt) < cloudflareWarnInterval {
+		cloudflare.suppressed++
+		return
+	}
+	log.Printf("警告: 来自 %s 的请求带有 CF-Connecting-IP: %q，但对端不属于 Cloudflare，已忽略（上次告警以来另有 %d 次）", peer, header, cloudflare.suppressed)
+	cloudflare.warnedAt = now
+	cloudflare.suppressed = 0
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 关闭挂件脚本与演示页面的压缩，便于调试
+var noCompress = flag.Bool("no-compress", false, "不压缩挂件脚本与演示页面（调试用）")
+
+// 复用的 gzip 压缩器
+var gzipWriters = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// 请求是否接受 gzip：按 Accept-Encoding 中 gzip（或 *）的 q 值判断，q=0 表示拒绝
+func acceptsGzip(r *http.Request) bool {
+	accepted := false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		// 明确列出的 gzip 优先于 *
+		if coding != "*" {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
+
+// 压缩正文的 ResponseWriter：只有带正文的响应（非 204、304）才压缩并设置 Content-Encoding
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status != http.StatusNoContent && status != http.StatusNotModified {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.gz.Write(p)
+}
+
+// 结束压缩并归还压缩器
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
+
+// 按 Accept-Encoding 压缩响应，返回的 ResponseWriter 写入时压缩正文，处理结束时须调用 done；
+// gzipped 为 true 时响应使用 gzip 编码，ETag 需用 encodedETag 区分
+//
+// 开启压缩时无论是否压缩都带 Vary: Accept-Encoding，调用前设置的 Vary 会保留。
+func compressResponse(w http.ResponseWriter, r *http.Request) (cw http.ResponseWriter, gzipped bool, done func()) {
+	if *noCompress {
+		return w, false, func() {}
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return w, false, func() {}
+	}
+	gw := &gzipResponseWriter{ResponseWriter: w}
+	return gw, true, gw.close
+}
+
+// 压缩后的表示与原始内容字节不同，强 ETag 需要不同的值：在引号内加上 -gzip 后缀
+func encodedETag(etag string, gzipped bool) string {
+	if !gzipped {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + `-gzip"`
+}
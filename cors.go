@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 允许跨域读取 HTTP 接口的来源，与 WebSocket 的 -allowed-origins 相互独立
+var corsOrigins = flag.String("cors-origins", "", "允许跨域调用 HTTP 接口（/api/*、徽章、挂件脚本）的来源，逗号分隔的完整来源（如 https://example.com）或 *；为空时不发送 CORS 头")
+
+// 预检请求允许的方法与请求头，以及浏览器可读取的响应头
+const (
+	corsAllowMethods  = "GET, POST, OPTIONS"
+	corsAllowHeaders  = "Content-Type, X-API-Key, If-None-Match"
+	corsExposeHeaders = "ETag"
+	corsMaxAge        = "600"
+)
+
+// 跨域策略：any 表示允许所有来源，否则只允许 origins 中的来源
+type corsPolicy struct {
+	any     bool
+	origins map[string]bool
+}
+
+// 启动时解析的跨域策略，nil 表示不启用
+var corsAllowlist *corsPolicy
+
+// 解析 -cors-origins，为空时返回 nil；来源规范化为小写的 scheme://host[:port]
+func parseCORSOrigins(value string) (*corsPolicy, error) {
+	policy := &corsPolicy{origins: make(map[string]bool)}
+	for _, part := range strings.Split(value, ",") {
+		origin := strings.ToLower(strings.TrimSpace(part))
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			policy.any = true
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" || u.User != nil {
+			return nil, fmt.Errorf("无效的跨域来源 %q，需写成 https://example.com 或 *", part)
+		}
+		policy.origins[u.Scheme+"://"+u.Host] = true
+	}
+	if !policy.any && len(policy.origins) == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// 来源是否允许跨域访问
+func (p *corsPolicy) allow(origin string) bool {
+	return p.any || p.origins[strings.ToLower(origin)]
+}
+
+// 为 HTTP 接口添加 CORS 头并应答预检请求（204）
+//
+// 允许所有来源时返回 Access-Control-Allow-Origin: *，否则回显匹配的 Origin 并带 Vary: Origin；
+// 不带凭据，接口以 API 密钥或令牌鉴权。WebSocket 升级请求不经过这里，来源仍由 -allowed-origins 控制。
+func withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		policy := corsAllowlist
+		if policy == nil || isWebSocketRequest(r) {
+			next(w, r)
+			return
+		}
+		if !policy.any {
+			w.Header().Add("Vary", "Origin")
+		}
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" || !policy.allow(origin) {
+			if preflight {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		if policy.any {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		next(w, r)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 每个站点按天保留的统计天数
+var dailyDays = flag.Int("daily-days", 400, "每个站点按天保留的统计天数（加入数、峰值、独立访客、会话时长），0 不记录")
+
+// 站点一天的统计，日期为本地日期
+//
+// 会话计入结束的那一天；独立访客由每分钟的清理任务从当天的估计同步，最多滞后一分钟。
+type DailyStats struct {
+	Date           string `json:"date"`
+	Joins          int64  `json:"joins"`
+	Peak           int    `json:"peak"`
+	Uniques        int64  `json:"uniques"`
+	Sessions       int64  `json:"sessions"`
+	SessionSeconds int64  `json:"sessionSeconds"`
+}
+
+// 取站点某天的统计，不存在时追加（调用方需持有 dailyMutex）
+//
+// 行按日期升序排列，超过 -daily-days 时丢弃最早的行；系统时钟回拨时计入最后一行。
+func (h *Hub) dailyRowLocked(siteID, day string) *DailyStats {
+	rows := h.daily[siteID]
+	if n := len(rows); n > 0 && rows[n-1].Date >= day {
+		return rows[n-1]
+	}
+	row := &DailyStats{Date: day}
+	rows = append(rows, row)
+	if len(rows) > *dailyDays {
+		rows = rows[len(rows)-*dailyDays:]
+	}
+	h.daily[siteID] = rows
+	return row
+}
+
+// 记录一次计入人数的加入，count 为加入后的人数
+func (h *Hub) recordDailyJoin(siteID string, count int, now time.Time) {
+	if *dailyDays == 0 {
+		return
+	}
+	h.dailyMutex.Lock()
+	defer h.dailyMutex.Unlock()
+
+	row := h.dailyRowLocked(siteID, localDay(now))
+	row.Joins++
+	if count > row.Peak {
+		row.Peak = count
+	}
+}
+
+// 记录一次结束的会话
+func (h *Hub) recordDailySession(siteID string, duration time.Duration, now time.Time) {
+	if *dailyDays == 0 {
+		return
+	}
+	h.dailyMutex.Lock()
+	defer h.dailyMutex.Unlock()
+
+	row := h.dailyRowLocked(siteID, localDay(now))
+	row.Sessions++
+	row.SessionSeconds += int64(duration.Seconds())
+}
+
+// 把今天与昨天的独立访客估计同步到已有的行
+func (h *Hub) syncDailyUniques(now time.Time) {
+	type estimate struct {
+		siteID, day string
+		uniques     int64
+	}
+	var estimates []estimate
+	yesterday := localDay(now.AddDate(0, 0, -1))
+
+	h.uniquesMutex.Lock()
+	for siteID, stats := range h.uniques {
+		stats.rollover(now)
+		estimates = append(estimates, estimate{siteID, stats.day, stats.today.estimate()})
+		if stats.yesterday != nil {
+			estimates = append(estimates, estimate{siteID, yesterday, stats.yesterday.estimate()})
+		}
+	}
+	h.uniquesMutex.Unlock()
+
+	h.dailyMutex.Lock()
+	defer h.dailyMutex.Unlock()
+	for _, e := range estimates {
+		rows := h.daily[e.siteID]
+		for i := len(rows) - 1; i >= 0 && rows[i].Date >= e.day; i-- {
+			if rows[i].Date == e.day {
+				rows[i].Uniques = e.uniques
+				break
+			}
+		}
+	}
+}
+
+// 读取站点在 [from, to] 日期范围内的统计副本
+func (h *Hub) dailyRange(siteID, from, to string) []DailyStats {
+	h.dailyMutex.Lock()
+	defer h.dailyMutex.Unlock()
+
+	var rows []DailyStats
+	for _, row := range h.daily[siteID] {
+		if row.Date >= from && row.Date <= to {
+			rows = append(rows, *row)
+		}
+	}
+	return rows
+}
+
+// 删除站点早于 cutoff 的行，返回删除的行数
+func (h *Hub) trimDaily(siteID, cutoff string) int {
+	h.dailyMutex.Lock()
+	defer h.dailyMutex.Unlock()
+
+	rows := h.daily[siteID]
+	n := 0
+	for n < len(rows) && rows[n].Date < cutoff {
+		n++
+	}
+	if n == len(rows) {
+		delete(h.daily, siteID)
+	} else if n > 0 {
+		h.daily[siteID] = append([]*DailyStats(nil), rows[n:]...)
+	}
+	return n
+}
+
+// 可比较的指标
+var compareMetrics = map[string]string{
+	"avgSession": "平均会话时长（秒）",
+	"peak":       "最高在线人数",
+	"uniques":    "独立访客数（各天之和）",
+	"joins":      "加入次数",
+}
+
+// 按指标汇总一段日期的统计，没有数据时返回 nil
+func aggregateDaily(rows []DailyStats, metric string) *float64 {
+	var joins, uniques, sessions, seconds int64
+	peak := 0
+	for _, row := range rows {
+		joins += row.Joins
+		uniques += row.Uniques
+		sessions += row.Sessions
+		seconds += row.SessionSeconds
+		peak = max(peak, row.Peak)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var value float64
+	switch metric {
+	case "avgSession":
+		if sessions == 0 {
+			return nil
+		}
+		value = float64(seconds) / float64(sessions)
+	case "peak":
+		value = float64(peak)
+	case "uniques":
+		value = float64(uniques)
+	default:
+		value = float64(joins)
+	}
+	return &value
+}
+
+// 一段日期的汇总
+type ComparePeriod struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// 有数据的天数与汇总值，没有数据时 value 为 null
+	Days  int      `json:"days"`
+	Value *float64 `json:"value"`
+}
+
+// 对比结果，change 为相对基准期的变化比例，基准为 0 或没有数据时为 null
+type CompareResult struct {
+	SiteID   string        `json:"siteId"`
+	Metric   string        `json:"metric"`
+	Current  ComparePeriod `json:"current"`
+	Baseline ComparePeriod `json:"baseline"`
+	Change   *float64      `json:"change"`
+}
+
+// 解析日期范围，from 不能晚于 to
+func parseDayRange(from, to string) (string, string, error) {
+	start, err := time.ParseInLocation("2006-01-02", from, time.Local)
+	if err != nil {
+		return "", "", fmt.Errorf("无效的日期 %q，格式为 YYYY-MM-DD", from)
+	}
+	end, err := time.ParseInLocation("2006-01-02", to, time.Local)
+	if err != nil {
+		return "", "", fmt.Errorf("无效的日期 %q，格式为 YYYY-MM-DD", to)
+	}
+	if end.Before(start) {
+		return "", "", fmt.Errorf("日期范围 %s 至 %s 无效", from, to)
+	}
+	return localDay(start), localDay(end), nil
+}
+
+// 对比两段日期的站点统计：
+// GET /owner/api/sites/{id}/compare?metric=avgSession&from=...&to=...&baselineFrom=...&baselineTo=...
+//
+// 日期为本地日期（含首尾），两段日期不能重叠。数据来自按天的统计，保留 -daily-days 天。
+func handleOwnerCompare(w http.ResponseWriter, r *http.Request, siteID string) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	metric := getParam(params, "metric", "avgSession")
+	if _, exists := compareMetrics[metric]; !exists {
+		http.Error(w, fmt.Sprintf("未知的指标 %q，可选 avgSession、peak、uniques、joins", metric), http.StatusBadRequest)
+		return
+	}
+	if groupBy := params.Get("groupBy"); groupBy != "" {
+		// 只记录站点级的统计，没有按页面的汇总
+		http.Error(w, fmt.Sprintf("不支持按 %q 分组：没有页面级的统计", groupBy), http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseDayRange(params.Get("from"), params.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	baselineFrom, baselineTo, err := parseDayRange(params.Get("baselineFrom"), params.Get("baselineTo"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from <= baselineTo && baselineFrom <= to {
+		http.Error(w, "对比期与基准期不能重叠", http.StatusBadRequest)
+		return
+	}
+
+	hub.syncDailyUniques(time.Now())
+	current := hub.dailyRange(siteID, from, to)
+	baseline := hub.dailyRange(siteID, baselineFrom, baselineTo)
+	result := CompareResult{
+		SiteID:   siteID,
+		Metric:   metric,
+		Current:  ComparePeriod{From: from, To: to, Days: len(current), Value: aggregateDaily(current, metric)},
+		Baseline: ComparePeriod{From: baselineFrom, To: baselineTo, Days: len(baseline), Value: aggregateDaily(baseline, metric)},
+	}
+	if result.Current.Value != nil && result.Baseline.Value != nil && *result.Baseline.Value != 0 {
+		change := (*result.Current.Value - *result.Baseline.Value) / *result.Baseline.Value
+		result.Change = &change
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 请求对比接口
+func requestCompare(siteID, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handleOwnerCompare(w, httptest.NewRequest("GET", "/owner/api/sites/"+siteID+"/compare?"+query, nil), siteID)
+	return w
+}
+
+// 两段日期各有按天的统计时按指标汇总并计算变化比例；没有数据的一段 value 与 change 为 null
+func TestOwnerCompare(t *testing.T) {
+	h := startTestHub(t)
+	h.dailyMutex.Lock()
+	h.daily["a.example"] = []*DailyStats{
+		// 基准期：1 月第一周
+		{Date: "2026-01-02", Joins: 10, Peak: 4, Uniques: 8, Sessions: 5, SessionSeconds: 300},
+		{Date: "2026-01-05", Joins: 20, Peak: 6, Uniques: 12, Sessions: 5, SessionSeconds: 900},
+		// 不在任何一段中
+		{Date: "2026-01-20", Joins: 1000, Peak: 100, Uniques: 1000, Sessions: 1000, SessionSeconds: 1000},
+		// 对比期：2 月第一周
+		{Date: "2026-02-01", Joins: 45, Peak: 3, Uniques: 30, Sessions: 10, SessionSeconds: 1800},
+	}
+	h.dailyMutex.Unlock()
+
+	const baseline = "&baselineFrom=2026-01-01&baselineTo=2026-01-07"
+	tests := []struct {
+		metric        string
+		current, base float64
+		change        float64
+	}{
+		{"joins", 45, 30, 0.5},
+		{"peak", 3, 6, -0.5},
+		{"uniques", 30, 20, 0.5},
+		{"avgSession", 180, 120, 0.5},
+	}
+	for _, tt := range tests {
+		var result CompareResult
+		decodeJSON(t, requestCompare("a.example", "metric="+tt.metric+"&from=2026-02-01&to=2026-02-07"+baseline), &result)
+		if result.SiteID != "a.example" || result.Metric != tt.metric ||
+			result.Current.From != "2026-02-01" || result.Current.To != "2026-02-07" || result.Current.Days != 1 ||
+			result.Baseline.From != "2026-01-01" || result.Baseline.To != "2026-01-07" || result.Baseline.Days != 2 {
+			t.Fatalf("%s 的对比结果为 %+v", tt.metric, result)
+		}
+		if result.Current.Value == nil || *result.Current.Value != tt.current ||
+			result.Baseline.Value == nil || *result.Baseline.Value != tt.base ||
+			result.Change == nil || math.Abs(*result.Change-tt.change) > 1e-9 {
+			t.Errorf("%s: 对比期 %v，基准期 %v，变化 %v；应为 %v、%v、%v", tt.metric,
+				result.Current.Value, result.Baseline.Value, result.Change, tt.current, tt.base, tt.change)
+		}
+	}
+
+	// 对比期没有数据
+	var result CompareResult
+	decodeJSON(t, requestCompare("a.example", "metric=joins&from=2026-03-01&to=2026-03-07"+baseline), &result)
+	if result.Current.Days != 0 || result.Current.Value != nil || result.Change != nil ||
+		result.Baseline.Value == nil || *result.Baseline.Value != 30 {
+		t.Fatalf("对比期没有数据时结果为 %+v", result)
+	}
+	w := requestCompare("a.example", "metric=joins&from=2026-03-01&to=2026-03-07"+baseline)
+	if body := w.Body.String(); !strings.Contains(body, `"value":null`) || !strings.Contains(body, `"change":null`) {
+		t.Fatalf("没有数据时应返回 null: %s", body)
+	}
+
+	// 基准期没有数据
+	result = CompareResult{}
+	decodeJSON(t, requestCompare("a.example", "metric=joins&from=2026-02-01&to=2026-02-07&baselineFrom=2025-12-01&baselineTo=2025-12-07"), &result)
+	if result.Baseline.Days != 0 || result.Baseline.Value != nil || result.Change != nil ||
+		result.Current.Value == nil || *result.Current.Value != 45 {
+		t.Fatalf("基准期没有数据时结果为 %+v", result)
+	}
+
+	// 从未记录过的站点
+	result = CompareResult{}
+	decodeJSON(t, requestCompare("unknown.example", "metric=peak&from=2026-02-01&to=2026-02-07"+baseline), &result)
+	if result.Current.Value != nil || result.Baseline.Value != nil || result.Change != nil {
+		t.Fatalf("未知站点的结果为 %+v", result)
+	}
+}
+
+// 无效的指标、分组、日期与重叠的日期范围返回 400
+func TestOwnerCompareBadRequest(t *testing.T) {
+	startTestHub(t)
+	for _, query := range []string{
+		"metric=bounce&from=2026-02-01&to=2026-02-07&baselineFrom=2026-01-01&baselineTo=2026-01-07",
+		"groupBy=page&from=2026-02-01&to=2026-02-07&baselineFrom=2026-01-01&baselineTo=2026-01-07",
+		"from=2026-02-07&to=2026-02-01&baselineFrom=2026-01-01&baselineTo=2026-01-07",
+		"from=2026-02-01&to=2026-02-07&baselineFrom=2026/01/01&baselineTo=2026-01-07",
+		"from=2026-02-01&to=2026-02-07&baselineFrom=2026-02-05&baselineTo=2026-02-10",
+	} {
+		if w := requestCompare("a.example", query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s 返回 %d，应为 400", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// 人数去重方式
+const (
+	dedupConnection = "connection"
+	dedupIP         = "ip"
+)
+
+// 默认的人数去重方式，可被实例默认值与站点配置覆盖
+var dedupMode = flag.String("dedup", dedupConnection, "人数去重方式：connection 按连接计数，ip 同一 IP 只计一次")
+
+// 校验去重方式
+func checkDedupMode(mode string) error {
+	switch mode {
+	case "", dedupConnection, dedupIP:
+		return nil
+	}
+	return fmt.Errorf("未知的去重方式 %q", mode)
+}
+
+// 站点的去重方式：配置了站点值或实例默认值时以配置为准；否则由站点第一个计入人数的连接决定，
+// 采用它在 join 消息中请求的方式（来自挂件的 dedup 参数），未请求时使用 -dedup，
+// 之后请求了其他方式的连接沿用站点已确定的方式（调用方需持有站点锁）
+func (s *Site) resolveDedupLocked(requested string) string {
+	resolved := settings.Resolve(s.ID)
+	if resolved.Sources["dedup"] != sourceBuiltin {
+		return resolved.Dedup
+	}
+	switch {
+	case s.dedupDecided && s.dedupIP:
+		return dedupIP
+	case s.dedupDecided:
+		return dedupConnection
+	case requested != "":
+		return requested
+	}
+	return resolved.Dedup
+}
+
+// 去重使用的 IP，去掉直连时 RemoteAddr 带的端口
+func dedupKey(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// 切换站点的去重方式，返回人数是否变化（调用方需持有站点写锁）
+func (s *Site) setDedupLocked(mode string) bool {
+	s.dedupDecided = true
+	byIP := mode == dedupIP
+	if s.dedupIP == byIP {
+		return false
+	}
+	s.dedupIP = byIP
+	count := s.expectedCountLocked()
+	changed := count != s.Count
+	s.Count = count
+	return changed
+}
+
+// 连接所属的会话：同一浏览器的多个标签页共用 clientId，
+// 未携带 clientId 的旧版挂件每个连接单独成为一个会话
+func sessionKey(client *Client) string {
+	if client.clientID != "" {
+		return "c:" + client.clientID
+	}
+	return "#" + strconv.FormatUint(client.id, 10)
+}
+
+// 按当前去重方式应有的人数，加上 GIF 信标的在线记录（调用方需持有站点锁）
+func (s *Site) expectedCountLocked() int {
+	if s.dedupIP {
+		return len(s.ips) + len(s.beacons)
+	}
+	return len(s.sessions) + len(s.beacons)
+}
+
+// 计入一个连接，返回人数是否变化（调用方需持有站点写锁）
+func (s *Site) trackJoinLocked(client *Client) bool {
+	ip, session := dedupKey(client.ip), sessionKey(client)
+	s.ips[ip]++
+	s.sessions[session]++
+	if s.dedupIP && s.ips[ip] > 1 || !s.dedupIP && s.sessions[session] > 1 {
+		return false
+	}
+	s.Count++
+	return true
+}
+
+// 从计数表中减去一个连接，返回该键是否已没有连接
+func untrackKey(counts map[string]int, key string) bool {
+	counts[key]--
+	if counts[key] > 0 {
+		return false
+	}
+	delete(counts, key)
+	return true
+}
+
+// 移除一个连接的 IP 与会话记录，返回按当前去重方式该连接是否为最后一个（调用方需持有站点写锁）
+func (s *Site) untrackLocked(client *Client) bool {
+	lastForIP := untrackKey(s.ips, dedupKey(client.ip))
+	lastForSession := untrackKey(s.sessions, sessionKey(client))
+	if s.dedupIP {
+		return lastForIP
+	}
+	return lastForSession
+}
+
+// 移除一个计入人数的连接，返回人数是否变化（调用方需持有站点写锁）
+func (s *Site) trackLeaveLocked(client *Client) bool {
+	if !s.untrackLocked(client) {
+		return false
+	}
+	s.Count--
+	return true
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// 以指定的去重方式请求加入，clientId 各不相同
+func (c *testClient) joinDedup(siteID, clientID, dedup string) {
+	c.t.Helper()
+	c.send(Message{Type: "join", SiteID: siteID, ClientID: clientID, Dedup: dedup, Version: scriptVersion})
+	c.expect("joined")
+}
+
+// 同一 IP 的挂件请求不同的去重方式：站点的去重方式由第一个连接决定，之后的请求不改变人数；
+// 站点配置了去重方式时挂件参数不生效
+func TestDedupDecidedOncePerSite(t *testing.T) {
+	setFlag(t, "dedup", "connection")
+	h := startTestHub(t)
+	settings = loadTestSettings(t, `{"sites":{"fixed.example":{"dedup":"ip"}}}`)
+	server := startTestServer(t)
+
+	tests := []struct {
+		siteID   string
+		requests []string // 依次加入的连接请求的去重方式
+		counts   []int    // 每次加入后的人数
+	}{
+		{"ip.example", []string{"ip", "", "connection", ""}, []int{1, 1, 1, 1}},
+		{"conn.example", []string{"", "ip", "", "ip"}, []int{1, 2, 3, 4}},
+		{"fixed.example", []string{"connection", "", "connection"}, []int{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		for i, dedup := range tt.requests {
+			client := dialTestClient(t, server, "")
+			client.joinDedup(tt.siteID, "browser-"+string(rune('a'+i)), dedup)
+			if count := siteCount(h, tt.siteID); count != tt.counts[i] {
+				t.Fatalf("%s 第 %d 个连接（dedup=%q）加入后人数为 %d，应为 %d", tt.siteID, i+1, dedup, count, tt.counts[i])
+			}
+		}
+	}
+}
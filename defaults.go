@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// 实例默认值，未设置的字段继续使用内置默认值
+type InstanceDefaults struct {
+	Private    *bool  `json:"private,omitempty"`
+	BurstJoins int    `json:"burstJoins,omitempty"`
+	Dedup      string `json:"dedup,omitempty"`
+}
+
+// 配置值的来源
+const (
+	sourceSite     = "site"
+	sourceInstance = "instance"
+	sourceBuiltin  = "builtin"
+)
+
+// 站点的生效配置：站点值 → 实例默认值 → 内置默认值
+type ResolvedSettings struct {
+	Private    bool
+	BurstJoins int
+	Dedup      string
+
+	// 各字段的来源，键为 JSON 字段名
+	Sources map[string]string
+}
+
+// 带来源的配置值
+type ResolvedField struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// 以 JSON 字段名列出生效值与来源
+func (r ResolvedSettings) Fields() map[string]ResolvedField {
+	return map[string]ResolvedField{
+		"private":    {Value: r.Private, Source: r.Sources["private"]},
+		"burstJoins": {Value: r.BurstJoins, Source: r.Sources["burstJoins"]},
+		"dedup":      {Value: r.Dedup, Source: r.Sources["dedup"]},
+	}
+}
+
+// 按三层合并站点配置
+func resolveSettings(site SiteSettings, defaults InstanceDefaults, builtin ResolvedSettings) ResolvedSettings {
+	resolved := ResolvedSettings{Sources: make(map[string]string)}
+
+	switch {
+	case site.Private != nil:
+		resolved.Private, resolved.Sources["private"] = *site.Private, sourceSite
+	case defaults.Private != nil:
+		resolved.Private, resolved.Sources["private"] = *defaults.Private, sourceInstance
+	default:
+		resolved.Private, resolved.Sources["private"] = builtin.Private, sourceBuiltin
+	}
+
+	switch {
+	case site.BurstJoins != 0:
+		resolved.BurstJoins, resolved.Sources["burstJoins"] = site.BurstJoins, sourceSite
+	case defaults.BurstJoins != 0:
+		resolved.BurstJoins, resolved.Sources["burstJoins"] = defaults.BurstJoins, sourceInstance
+	default:
+		resolved.BurstJoins, resolved.Sources["burstJoins"] = builtin.BurstJoins, sourceBuiltin
+	}
+
+	switch {
+	case site.Dedup != "":
+		resolved.Dedup, resolved.Sources["dedup"] = site.Dedup, sourceSite
+	case defaults.Dedup != "":
+		resolved.Dedup, resolved.Sources["dedup"] = defaults.Dedup, sourceInstance
+	default:
+		resolved.Dedup, resolved.Sources["dedup"] = builtin.Dedup, sourceBuiltin
+	}
+
+	return resolved
+}
+
+// 内置默认值，来自命令行参数
+func builtinSettings() ResolvedSettings {
+	return ResolvedSettings{BurstJoins: *burstJoins, Dedup: *dedupMode}
+}
+
+// 获取站点的生效配置
+func (s *SettingsStore) Resolve(siteID string) ResolvedSettings {
+	s.mutex.RLock()
+	defaults := s.defaults
+	site := SiteSettings{}
+	if stored, exists := s.sites[siteID]; exists {
+		site = *stored
+	}
+	s.mutex.RUnlock()
+
+	return resolveSettings(site, defaults, builtinSettings())
+}
+
+// 重新读取配置文件，返回变更前后涉及的分组
+//
+// 生效值在读取时才合并，因此修改实例默认值后，
+// 所有未单独设置该字段的站点立即生效。
+func (s *SettingsStore) Reload() ([]string, error) {
+	if s.path == "" {
+		return nil, errors.New("未指定站点配置文件")
+	}
+	loaded, err := LoadSettings(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	groups := make(map[string]bool)
+	for name := range s.groups {
+		groups[name] = true
+	}
+	for name := range loaded.groups {
+		groups[name] = true
+	}
+
+	// 挂件配置有变化的站点推送新配置
+	now := time.Now()
+	for siteID, site := range s.sites {
+		if _, exists := loaded.sites[siteID]; !exists && site.Widget != nil {
+			queueConfigUpdate(siteID, site.Widget.clientConfig(), now)
+		}
+	}
+	for siteID, site := range loaded.sites {
+		var previous *WidgetSettings
+		if old, exists := s.sites[siteID]; exists {
+			previous = old.Widget.clientConfig()
+		}
+		if !reflect.DeepEqual(previous, site.Widget.clientConfig()) {
+			queueConfigUpdate(siteID, previous, now)
+		}
+	}
+
+	s.defaults = loaded.defaults
+	s.sites = loaded.sites
+	s.groups = loaded.groups
+	s.alerts = loaded.alerts
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	return names, nil
+}
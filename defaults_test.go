@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 写入站点配置文件并加载
+func loadTestSettings(t *testing.T, content string) *SettingsStore {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sites.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := LoadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// 每个字段按站点值 → 实例默认值 → 内置默认值（命令行参数）取第一个设置了的，并记录来源
+func TestResolveLayers(t *testing.T) {
+	setFlag(t, "burst-joins", "50")
+	setFlag(t, "dedup", "connection")
+
+	tests := []struct {
+		name    string
+		file    string
+		private bool
+		burst   int
+		dedup   string
+		sources [3]string // private、burstJoins、dedup
+	}{
+		{
+			name:    "全部内置",
+			file:    `{"sites":{}}`,
+			private: false, burst: 50, dedup: "connection",
+			sources: [3]string{sourceBuiltin, sourceBuiltin, sourceBuiltin},
+		},
+		{
+			name:    "实例默认值覆盖内置",
+			file:    `{"defaults":{"private":true,"burstJoins":20,"dedup":"ip"},"sites":{}}`,
+			private: true, burst: 20, dedup: "ip",
+			sources: [3]string{sourceInstance, sourceInstance, sourceInstance},
+		},
+		{
+			name:    "站点值覆盖实例默认值",
+			file:    `{"defaults":{"private":true,"burstJoins":20,"dedup":"ip"},"sites":{"a.example":{"private":false,"burstJoins":5,"dedup":"connection"}}}`,
+			private: false, burst: 5, dedup: "connection",
+			sources: [3]string{sourceSite, sourceSite, sourceSite},
+		},
+		{
+			name:    "各字段分别取自不同层",
+			file:    `{"defaults":{"burstJoins":20},"sites":{"a.example":{"dedup":"ip"}}}`,
+			private: false, burst: 20, dedup: "ip",
+			sources: [3]string{sourceBuiltin, sourceInstance, sourceSite},
+		},
+		{
+			name:    "站点只设置了无关字段",
+			file:    `{"defaults":{"private":true},"sites":{"a.example":{"addBase":3}}}`,
+			private: true, burst: 50, dedup: "connection",
+			sources: [3]string{sourceInstance, sourceBuiltin, sourceBuiltin},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved := loadTestSettings(t, tt.file).Resolve("a.example")
+			if resolved.Private != tt.private || resolved.BurstJoins != tt.burst || resolved.Dedup != tt.dedup {
+				t.Fatalf("生效值为 private=%v burstJoins=%d dedup=%q，应为 %v %d %q",
+					resolved.Private, resolved.BurstJoins, resolved.Dedup, tt.private, tt.burst, tt.dedup)
+			}
+			for i, field := range []string{"private", "burstJoins", "dedup"} {
+				if got := resolved.Fields()[field].Source; got != tt.sources[i] {
+					t.Errorf("%s 的来源为 %q，应为 %q", field, got, tt.sources[i])
+				}
+			}
+		})
+	}
+}
+
+// 内置默认值在读取时取命令行参数；修改实例默认值后重新加载，未单独设置的站点立即生效
+func TestResolveFollowsChanges(t *testing.T) {
+	setFlag(t, "burst-joins", "50")
+	store := loadTestSettings(t, `{"sites":{"a.example":{"burstJoins":5},"b.example":{"addBase":1}}}`)
+
+	setFlag(t, "burst-joins", "80")
+	if burst := store.Resolve("b.example").BurstJoins; burst != 80 {
+		t.Fatalf("修改 -burst-joins 后为 %d，应为 80", burst)
+	}
+
+	if err := os.WriteFile(store.path, []byte(`{"defaults":{"burstJoins":30},"sites":{"a.example":{"burstJoins":5},"b.example":{"addBase":1}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if burst := store.Resolve("b.example").BurstJoins; burst != 30 {
+		t.Fatalf("重新加载后 b.example 为 %d，应为实例默认值 30", burst)
+	}
+	if burst := store.Resolve("a.example").BurstJoins; burst != 5 {
+		t.Fatalf("重新加载后 a.example 为 %d，应保持站点值 5", burst)
+	}
+	if burst := store.Resolve("c.example").BurstJoins; burst != 30 {
+		t.Fatalf("未配置的站点为 %d，应为实例默认值 30", burst)
+	}
+}
+
+// 无效的去重方式在加载时拒绝
+func TestLoadSettingsRejectsInvalidDedup(t *testing.T) {
+	for _, file := range []string{
+		`{"defaults":{"dedup":"cookie"},"sites":{}}`,
+		`{"sites":{"a.example":{"dedup":"cookie"}}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "sites.json")
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSettings(path); err == nil {
+			t.Errorf("%s 应加载失败", file)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 严格模式，逐项关闭已废弃的行为
+var strict = flag.String("strict", "", "关闭的废弃行为，逗号分隔，all 表示全部")
+
+// 已废弃的行为
+const (
+	deprecatedCatchAll    = "catch-all-route"
+	deprecatedSiteID      = "uncanonical-site-id"
+	deprecatedDefaultSite = "default-site-fallback"
+	deprecatedV1Timestamp = "v1-timestamp"
+)
+
+// 全部废弃行为，用于校验 -strict 与输出报告
+var deprecatedBehaviors = []string{
+	deprecatedCatchAll,
+	deprecatedSiteID,
+	deprecatedDefaultSite,
+	deprecatedV1Timestamp,
+}
+
+// 每种行为保留的样本数与采样间隔
+const (
+	deprecationSamples        = 10
+	deprecationSampleInterval = 10 * time.Second
+)
+
+// 依赖废弃行为的请求样本
+type DeprecationSample struct {
+	Origin      string `json:"origin,omitempty"`
+	Path        string `json:"path,omitempty"`
+	SiteIDShape string `json:"siteIdShape,omitempty"`
+	Protocol    string `json:"protocol"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// 单项废弃行为的统计
+type DeprecationInfo struct {
+	Behavior string              `json:"behavior"`
+	Strict   bool                `json:"strict"`
+	Count    int64               `json:"count"`
+	Samples  []DeprecationSample `json:"samples"`
+}
+
+// 废弃行为统计
+type deprecationTracker struct {
+	counts     map[string]int64
+	samples    map[string][]DeprecationSample
+	lastSample map[string]time.Time
+	mutex      sync.Mutex
+}
+
+var deprecations = &deprecationTracker{
+	counts:     make(map[string]int64),
+	samples:    make(map[string][]DeprecationSample),
+	lastSample: make(map[string]time.Time),
+}
+
+// 该行为是否已被 -strict 关闭
+func strictMode(behavior string) bool {
+	for _, name := range strings.Split(*strict, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" || name == behavior {
+			return true
+		}
+	}
+	return false
+}
+
+// 校验 -strict 中的行为名
+func checkStrict() error {
+	for _, name := range strings.Split(*strict, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "all" {
+			continue
+		}
+		known := false
+		for _, behavior := range deprecatedBehaviors {
+			known = known || behavior == name
+		}
+		if !known {
+			return fmt.Errorf("-strict 中的未知行为 %q，可选: %s", name, strings.Join(deprecatedBehaviors, ", "))
+		}
+	}
+	return nil
+}
+
+// 记录一次废弃行为，按间隔采样
+func (d *deprecationTracker) record(behavior string, sample DeprecationSample) {
+	now := time.Now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.counts[behavior]++
+	if now.Sub(d.lastSample[behavior]) < deprecationSampleInterval {
+		return
+	}
+	d.lastSample[behavior] = now
+
+	sample.Timestamp = now.Unix()
+	samples := append(d.samples[behavior], sample)
+	if len(samples) > deprecationSamples {
+		samples = samples[len(samples)-deprecationSamples:]
+	}
+	d.samples[behavior] = samples
+}
+
+// 记录来自 HTTP 请求的废弃行为
+func (d *deprecationTracker) recordRequest(behavior string, r *http.Request, siteID string) {
+	protocol := "http"
+	if isWebSocketRequest(r) {
+		protocol = transportWebSocket
+	}
+	d.record(behavior, DeprecationSample{
+		Origin:      r.Header.Get("Origin"),
+		Path:        r.URL.Path,
+		SiteIDShape: siteIDShape(siteID),
+		Protocol:    protocol,
+	})
+}
+
+// 生成废弃行为报告
+func (d *deprecationTracker) report() []DeprecationInfo {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	infos := make([]DeprecationInfo, 0, len(deprecatedBehaviors))
+	for _, behavior := range deprecatedBehaviors {
+		samples := append([]DeprecationSample{}, d.samples[behavior]...)
+		infos = append(infos, DeprecationInfo{
+			Behavior: behavior,
+			Strict:   strictMode(behavior),
+			Count:    d.counts[behavior],
+			Samples:  samples,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Count > infos[j].Count
+	})
+	return infos
+}
+
+// 将 siteId 归纳为形状，例如 Example.com:8080 -> Aa.a:9，避免记录原始值
+func siteIDShape(siteID string) string {
+	var shape []byte
+	for _, r := range siteID {
+		var c byte
+		switch {
+		case r >= 'a' && r <= 'z':
+			c = 'a'
+		case r >= 'A' && r <= 'Z':
+			c = 'A'
+		case r >= '0' && r <= '9':
+			c = '9'
+		case r < 0x80:
+			c = byte(r)
+		default:
+			c = 'u'
+		}
+		if len(shape) > 0 && shape[len(shape)-1] == c && strings.IndexByte("aA9u", c) >= 0 {
+			continue
+		}
+		shape = append(shape, c)
+	}
+	return string(shape)
+}
+
+// 规范化的 siteId：去除首尾空白并转为小写
+func canonicalSiteID(siteID string) string {
+	return strings.ToLower(strings.TrimSpace(siteID))
+}
+
+// 废弃行为报告，附带各传输方式的使用情况，便于判断能否下线旧的传输
+type DeprecationReport struct {
+	Behaviors  []DeprecationInfo `json:"behaviors"`
+	Transports []TransportStats  `json:"transports"`
+}
+
+// 处理废弃行为报告请求
+func handleAdminDeprecations(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, DeprecationReport{
+		Behaviors:  deprecations.report(),
+		Transports: hub.transportStats(),
+	})
+}
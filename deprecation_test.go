@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试期间使用空的废弃行为统计，结束时恢复
+func resetDeprecations(t *testing.T) {
+	t.Helper()
+	previous := deprecations
+	deprecations = &deprecationTracker{
+		counts:     make(map[string]int64),
+		samples:    make(map[string][]DeprecationSample),
+		lastSample: make(map[string]time.Time),
+	}
+	t.Cleanup(func() { deprecations = previous })
+}
+
+// 报告中该行为的统计
+func deprecationOf(t *testing.T, behavior string) DeprecationInfo {
+	t.Helper()
+	for _, info := range deprecations.report() {
+		if info.Behavior == behavior {
+			return info
+		}
+	}
+	t.Fatalf("报告中没有 %s", behavior)
+	return DeprecationInfo{}
+}
+
+// 以 GET 请求兜底的处理函数
+func getRequest(target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+// 每种废弃行为：默认仍然可用并被计数采样，-strict 关闭后改用新的行为，同样计数
+func TestDeprecatedBehaviors(t *testing.T) {
+	tests := []struct {
+		behavior string
+		run      func(t *testing.T, strict bool)
+	}{
+		{deprecatedCatchAll, func(t *testing.T, strict bool) {
+			w := getRequest("/widget/liveuser.js?siteId=a.example")
+			if want := map[bool]int{false: http.StatusOK, true: http.StatusNotFound}[strict]; w.Code != want {
+				t.Fatalf("兜底路由返回 %d，应为 %d", w.Code, want)
+			}
+		}},
+		{deprecatedSiteID, func(t *testing.T, strict bool) {
+			want := map[bool]string{false: "a.example/Blog", true: "a.example/blog"}[strict]
+			if siteID := joinSiteID(" A.example/Blog", "https://a.example", transportWebSocket); siteID != want {
+				t.Fatalf("加入的 siteId 为 %q，应为 %q", siteID, want)
+			}
+		}},
+		{deprecatedDefaultSite, func(t *testing.T, strict bool) {
+			w := getRequest("/liveuser.js")
+			if strict {
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("没有 siteId 的脚本请求返回 %d，应为 400", w.Code)
+				}
+				return
+			}
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "'default-site'") {
+				t.Fatalf("没有 siteId 的脚本请求返回 %d，应回退到 default-site", w.Code)
+			}
+		}},
+		{deprecatedV1Timestamp, func(t *testing.T, strict bool) {
+			startTestHub(t)
+			server := startTestServer(t)
+			for _, protocol := range []string{"", "protocol=2"} {
+				client := dialTestClient(t, server, protocol)
+				client.send(Message{Type: "join", SiteID: "a.example", Version: scriptVersion})
+				welcome := client.expect("welcome")
+				if omitted := strict && protocol == ""; (welcome.Timestamp == 0) != omitted {
+					t.Fatalf("%q 连接的 welcome 中 timestamp 为 %d", protocol, welcome.Timestamp)
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			name := tt.behavior
+			if strict {
+				name += "/strict"
+			}
+			t.Run(name, func(t *testing.T) {
+				useTestSettings(t)
+				resetDeprecations(t)
+				if strict {
+					setFlag(t, "strict", tt.behavior)
+				}
+				if err := checkStrict(); err != nil {
+					t.Fatal(err)
+				}
+
+				tt.run(t, strict)
+
+				info := deprecationOf(t, tt.behavior)
+				if info.Count != 1 || len(info.Samples) != 1 || info.Strict != strict {
+					t.Fatalf("统计为 %+v，应计数并采样一次", info)
+				}
+				for _, other := range deprecatedBehaviors {
+					if count := deprecationOf(t, other).Count; other != tt.behavior && count != 0 {
+						t.Fatalf("%s 也被计数 %d 次", other, count)
+					}
+				}
+			})
+		}
+	}
+}
+
+// -strict 只接受已知的行为名与 all
+func TestCheckStrict(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"":                               true,
+		"all":                            true,
+		"v1-timestamp, catch-all-route":  true,
+		"catch-all-route,unknown-option": false,
+	} {
+		setFlag(t, "strict", value)
+		if err := checkStrict(); (err == nil) != valid {
+			t.Errorf("-strict %q 的校验结果为 %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 开发模式，开启后才接受测试参数与 /dev/ws
+var devMode = flag.Bool("dev-mode", false, "开发模式（启用 __testCount 参数与 /dev/ws）")
+
+// 脚本化更新的默认间隔与上限
+const (
+	devDefaultInterval = time.Second
+	devMinInterval     = 100 * time.Millisecond
+	devMaxScriptLen    = 100
+)
+
+// 解析 script 参数，例如 5,10,15,3
+func parseDevScript(value string) ([]int, bool) {
+	var counts []int
+	for _, part := range strings.Split(value, ",") {
+		count, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || count < 0 {
+			return nil, false
+		}
+		counts = append(counts, count)
+	}
+	if len(counts) == 0 || len(counts) > devMaxScriptLen {
+		return nil, false
+	}
+	return counts, true
+}
+
+// 处理开发用 WebSocket，按脚本循环推送人数
+func handleDevWS(w http.ResponseWriter, r *http.Request) {
+	if !*devMode {
+		http.NotFound(w, r)
+		return
+	}
+
+	params := r.URL.Query()
+	counts, ok := parseDevScript(getParam(params, "script", "1,2,3"))
+	if !ok {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	interval := devDefaultInterval
+	if value := params.Get("interval"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < devMinInterval {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// 等待 join 消息以获取站点 ID，与正式服务的协议保持一致
+	siteID := params.Get("siteId")
+	if siteID == "" {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		var msg Message
+		if _, data, err := conn.ReadMessage(); err != nil || json.Unmarshal(data, &msg) != nil {
+			return
+		}
+		siteID = msg.SiteID
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// 读取端只用于感知断开
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for i := 0; ; i++ {
+		message := Message{
+			Type:      "update",
+			SiteID:    siteID,
+			Count:     counts[i%len(counts)],
+			Message:   "dev-mode",
+			Timestamp: time.Now().Unix(),
+		}
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := conn.WriteJSON(message); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// 生成的脚本只在开发模式下采用 __testCount，生产模式下测试人数始终为 -1，挂件照常连接服务器
+func TestTestCountOnlyInDevMode(t *testing.T) {
+	for _, dev := range []bool{false, true} {
+		useTestSettings(t)
+		if dev {
+			setFlag(t, "dev-mode", "true")
+		}
+		w := httptest.NewRecorder()
+		handleJavaScript(w, httptest.NewRequest("GET", "/liveuser.js?siteId=a.example&__testCount=42", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("dev-mode=%v 时脚本返回 %d", dev, w.Code)
+		}
+		want := map[bool]string{false: "testCount: -1,", true: "testCount: 42,"}[dev]
+		if body := w.Body.String(); !strings.Contains(body, want) {
+			t.Fatalf("dev-mode=%v 时脚本中没有 %q", dev, want)
+		}
+	}
+}
+
+// /dev/ws 未开启开发模式时返回 404；开启后按脚本循环推送人数
+func TestDevWS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleDevWS))
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/dev/ws?siteId=a.example&script=5,10&interval=100ms"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("未开启开发模式时 /dev/ws 返回 %v（%v），应为 404", resp, err)
+	}
+	w := httptest.NewRecorder()
+	handleDevWS(w, httptest.NewRequest("GET", "/dev/ws", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("未开启开发模式时普通请求返回 %d，应为 404", w.Code)
+	}
+
+	setFlag(t, "dev-mode", "true")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	for _, want := range []int{5, 10, 5} {
+		var message Message
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Type != "update" || message.Count != want || message.Message != "dev-mode" {
+			t.Fatalf("收到 %+v，应为人数 %d 的 dev-mode 更新", message, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// 关闭帧原因的最大长度（WebSocket 控制帧负载不超过 125 字节，含 2 字节关闭码）
+const maxCloseReasonLen = 123
+
+// 断开站点的结果，站点不在线时 exists 为 false
+type disconnectResult struct {
+	dropped int
+	exists  bool
+}
+
+// 断开站点请求
+type disconnectRequest struct {
+	Reason string `json:"reason"`
+}
+
+// 断开站点响应
+type DisconnectInfo struct {
+	SiteID       string `json:"siteId"`
+	Disconnected int    `json:"disconnected"`
+	Removed      bool   `json:"removed"`
+	Reason       string `json:"reason"`
+}
+
+// 提交断开站点的命令，由 Hub 协程执行；Hub 已停止时返回 ErrHubClosed
+func (h *Hub) queueDisconnectSite(siteID, reason string, remove bool) (disconnectResult, error) {
+	reply := make(chan disconnectResult, 1)
+	h.enqueue(h.siteControl, hubCommand{siteID: siteID, reason: reason, remove: remove, reply: reply})
+	select {
+	case result := <-reply:
+		return result, nil
+	case <-h.stopped:
+		return disconnectResult{}, ErrHubClosed
+	}
+}
+
+// 断开站点的全部连接并将站点移出 Hub（在 Hub 协程中调用）
+//
+// 断开（remove 为 false）以 4503 关闭，挂件按正常间隔重连并重建站点，用于修复卡住的人数；
+// 移除以 4410 关闭，挂件不再重连。
+func (h *Hub) handleDisconnectSite(command hubCommand) {
+	err, messageType, action := ErrSiteDisconnected, "disconnected", "断开"
+	if command.remove {
+		err, messageType, action = ErrSiteRemoved, "removed", "移除"
+	}
+	now := time.Now()
+	dropped, exists := h.closeSite(command.siteID, Message{
+		Type:      messageType,
+		SiteID:    command.siteID,
+		Message:   command.reason,
+		Timestamp: now.Unix(),
+		closeCode: errorCloseCode(err),
+		closeText: command.reason,
+	})
+	if exists {
+		log.Printf("管理员%s站点 %s，断开 %d 个连接: %s", action, command.siteID, dropped, command.reason)
+		h.publishEvent(FeedEvent{Type: messageType, SiteID: command.siteID, Timestamp: now.Unix()})
+		h.refreshGroups(command.siteID)
+	}
+	command.reply <- disconnectResult{dropped: dropped, exists: exists}
+}
+
+// 处理断开站点请求：POST /admin/api/sites/{id}/disconnect 与 DELETE /admin/api/sites/{id}
+//
+// 请求体可选 {"reason":"..."}，原因随消息下发并作为关闭帧的原因，超过 123 字节时截断。
+func handleAdminSiteDisconnect(w http.ResponseWriter, r *http.Request, siteID string, remove bool) {
+	var req disconnectRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	reason := req.Reason
+	if reason == "" && remove {
+		reason = "removed by admin"
+	} else if reason == "" {
+		reason = "disconnected by admin"
+	}
+	if len(reason) > maxCloseReasonLen {
+		cut := maxCloseReasonLen
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
+	}
+
+	result, err := hub.queueDisconnectSite(siteID, reason, remove)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !result.exists {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, DisconnectInfo{SiteID: siteID, Disconnected: result.dropped, Removed: remove, Reason: reason})
+}
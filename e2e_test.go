@@ -0,0 +1,134 @@
+//go:build e2e
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// 浏览器控制台输出，测试失败时打印
+type consoleLog struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *consoleLog) add(page, line string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, page+": "+line)
+}
+
+// 记录页面的 console 调用与未捕获的异常
+func (l *consoleLog) listen(ctx context.Context, page string) {
+	chromedp.ListenTarget(ctx, func(ev any) {
+		switch ev := ev.(type) {
+		case *runtime.EventConsoleAPICalled:
+			args := make([]string, 0, len(ev.Args))
+			for _, arg := range ev.Args {
+				if len(arg.Value) > 0 {
+					args = append(args, string(arg.Value))
+				} else {
+					args = append(args, arg.Description)
+				}
+			}
+			l.add(page, fmt.Sprintf("[%s] %s", ev.Type, strings.Join(args, " ")))
+		case *runtime.EventExceptionThrown:
+			details := ev.ExceptionDetails
+			if details.Exception != nil && details.Exception.Description != "" {
+				l.add(page, "[exception] "+details.Exception.Description)
+			} else {
+				l.add(page, "[exception] "+details.Text)
+			}
+		}
+	})
+}
+
+// 启动无头浏览器，找不到浏览器时跳过测试
+func startTestBrowser(t *testing.T) context.Context {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Second)
+	t.Cleanup(cancel)
+	allocCtx, allocCancel := chromedp.NewExecAllocator(ctx, chromedp.DefaultExecAllocatorOptions[:]...)
+	t.Cleanup(allocCancel)
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx)
+	t.Cleanup(browserCancel)
+	if err := chromedp.Run(browserCtx); err != nil {
+		t.Skipf("无法启动浏览器: %v", err)
+	}
+	return browserCtx
+}
+
+// 在独立的浏览器上下文（不共享 localStorage，访客与 clientId 各不相同）中打开演示页面；
+// 外部 CDN 被屏蔽，页面只依赖本服务
+func openDemoPage(t *testing.T, browserCtx context.Context, console *consoleLog, name, url string) (context.Context, context.CancelFunc) {
+	t.Helper()
+
+	ctx, cancel := chromedp.NewContext(browserCtx, chromedp.WithNewBrowserContext())
+	t.Cleanup(cancel)
+	console.listen(ctx, name)
+	blocked := []*network.BlockPattern{{URLPattern: "https://cdn.jsdelivr.net/*", Block: true}, {URLPattern: "https://img.icons8.com/*", Block: true}}
+	err := chromedp.Run(ctx,
+		network.Enable(),
+		network.SetBlockedURLs().WithURLPatterns(blocked),
+		chromedp.Navigate(url),
+	)
+	if err != nil {
+		t.Fatalf("%s 打开演示页面失败: %v", name, err)
+	}
+	return ctx, cancel
+}
+
+// 等待页面中 #liveuser 显示的人数
+func expectWidgetCount(t *testing.T, ctx context.Context, name, want string) {
+	t.Helper()
+
+	var shown string
+	err := chromedp.Run(ctx,
+		chromedp.Poll(fmt.Sprintf(`document.getElementById('liveuser').textContent.trim() === %q`, want), nil,
+			chromedp.WithPollingTimeout(10*time.Second)),
+	)
+	if err != nil {
+		chromedp.Run(ctx, chromedp.Text("#liveuser", &shown, chromedp.ByID))
+		t.Fatalf("%s 显示 %q，应为 %q: %v", name, shown, want, err)
+	}
+}
+
+// 演示页面中的挂件：打开一个页面显示 1，再打开一个两边都显示 2，关闭其中一个后回到 1
+func TestE2EDemoWidget(t *testing.T) {
+	h := startTestHub(t)
+	server := startTestServer(t)
+	browserCtx := startTestBrowser(t)
+
+	console := &consoleLog{}
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		console.mutex.Lock()
+		defer console.mutex.Unlock()
+		t.Logf("浏览器控制台:\n%s", strings.Join(console.lines, "\n"))
+	})
+
+	first, _ := openDemoPage(t, browserCtx, console, "页面一", server.URL+"/")
+	expectWidgetCount(t, first, "页面一", "1")
+
+	second, closeSecond := openDemoPage(t, browserCtx, console, "页面二", server.URL+"/")
+	expectWidgetCount(t, second, "页面二", "2")
+	expectWidgetCount(t, first, "页面一", "2")
+
+	closeSecond()
+	expectWidgetCount(t, first, "页面一", "1")
+	if count := siteCount(h, "demo-site"); count != 1 {
+		t.Fatalf("关闭页面后站点人数为 %d", count)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	_ "embed"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+//go:embed embed.html
+var embedHTML string
+
+// 显示元素 ID 格式
+var elementIDPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+
+// 嵌入代码片段
+type EmbedSnippets struct {
+	SiteID        string `json:"siteId"`
+	ScriptURL     string `json:"scriptUrl"`
+	Script        string `json:"script"`
+	Element       string `json:"element"`
+	BadgeURL      string `json:"badgeUrl,omitempty"`
+	BadgeMarkdown string `json:"badgeMarkdown,omitempty"`
+	BadgeHTML     string `json:"badgeHtml,omitempty"`
+}
+
+// 嵌入代码页面数据
+type embedPage struct {
+	*Locale
+	Snippets EmbedSnippets
+	Element  string
+}
+
+// 服务对外的基础地址，如 https://example.com
+func publicBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.Header.Get("X-Forwarded-Proto") == "https" || r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// 按参数生成嵌入代码，只包含本实例已启用的接口
+func buildEmbedSnippets(r *http.Request, siteID, element, lang string) EmbedSnippets {
+	base := publicBaseURL(r)
+
+	query := url.Values{"siteId": {siteID}}
+	if element != "liveuser" {
+		query.Set("displayElementId", element)
+	}
+	if lang != "" {
+		query.Set("lang", lang)
+	}
+	scriptURL := base + "/liveuser.js?" + query.Encode()
+
+	escape := htmltemplate.HTMLEscapeString
+	snippets := EmbedSnippets{
+		SiteID:    siteID,
+		ScriptURL: scriptURL,
+		Script:    `<script src="` + escape(scriptURL) + `"></script>`,
+		Element:   `<span id="` + escape(element) + `"></span>`,
+	}
+
+	// 私有站点的徽章需要站点密钥，不提供公开的徽章代码
+	if !settings.Resolve(siteID).Private {
+		badgeURL := base + "/badge/" + url.PathEscape(siteID) + ".svg"
+		snippets.BadgeURL = badgeURL
+		snippets.BadgeMarkdown = "![online](" + badgeURL + ")"
+		snippets.BadgeHTML = `<img src="` + escape(badgeURL) + `" alt="online">`
+	}
+	return snippets
+}
+
+// 处理嵌入代码请求：GET /embed?siteId=example.com&element=liveuser&lang=en
+//
+// 返回可直接复制的代码片段页面，format=json 时返回 JSON。
+func handleEmbed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	siteID := strings.TrimSpace(params.Get("siteId"))
+	if siteID == "" {
+		writeError(w, &InvalidSiteIDError{Reason: "缺少 siteId"})
+		return
+	}
+	element := getParam(params, "element", "liveuser")
+	if !elementIDPattern.MatchString(element) {
+		http.Error(w, "Invalid element", http.StatusBadRequest)
+		return
+	}
+	lang := ""
+	if locale := matchLocale(params.Get("lang")); locale != nil {
+		lang = locale.Code
+	}
+
+	snippets := buildEmbedSnippets(r, siteID, element, lang)
+	if params.Get("format") == "json" {
+		writeJSON(w, http.StatusOK, snippets)
+		return
+	}
+
+	tmpl, err := htmltemplate.New("embed").Parse(embedHTML)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	locale := selectLocale(lang, r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", locale.Code)
+	w.Header().Add("Vary", "Accept-Language")
+	w.WriteHeader(http.StatusOK)
+
+	tmpl.Execute(w, embedPage{Locale: locale, Snippets: snippets, Element: element})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 以 Host: live.example.com 请求 /embed
+func requestEmbed(query, acceptLanguage string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "https://live.example.com/embed?"+query, nil)
+	if acceptLanguage != "" {
+		r.Header.Set("Accept-Language", acceptLanguage)
+	}
+	w := httptest.NewRecorder()
+	handleEmbed(w, r)
+	return w
+}
+
+// 嵌入代码页面与 JSON 与 golden 文件一致：go test -run TestEmbedGolden -update 重写
+func TestEmbedGolden(t *testing.T) {
+	useTestSettings(t)
+	settings = loadTestSettings(t, `{"sites":{"private.example":{"private":true}}}`)
+
+	tests := []struct {
+		golden         string
+		query          string
+		acceptLanguage string
+	}{
+		{"embed/page-en.html", "siteId=blog.example&lang=en", ""},
+		{"embed/page-zh.html", "siteId=blog.example&element=counter", "zh-CN,zh;q=0.9"},
+		{"embed/page-private.html", "siteId=private.example&lang=en", ""},
+		{"embed/snippets.json", "siteId=blog.example&element=counter&lang=zh&format=json", ""},
+		{"embed/snippets-private.json", "siteId=private.example&format=json", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			w := requestEmbed(tt.query, tt.acceptLanguage)
+			if w.Code != http.StatusOK {
+				t.Fatalf("返回 %d: %s", w.Code, w.Body.String())
+			}
+			checkGolden(t, tt.golden, w.Body.Bytes())
+		})
+	}
+}
+
+// 缺少 siteId 或元素 ID 格式不对时返回 400；元素 ID 不会原样进入页面
+func TestEmbedRejectsInvalidParams(t *testing.T) {
+	useTestSettings(t)
+
+	for _, query := range []string{
+		"",
+		"siteId=blog.example&element=1abc",
+		`siteId=blog.example&element=x"><script>alert(1)</script>`,
+	} {
+		if w := requestEmbed(query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%q 返回 %d，应为 400", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 可清除的数据类别
+const (
+	eraseStats    = "stats"
+	eraseSettings = "settings"
+	eraseHistory  = "history"
+	eraseEvents   = "events"
+)
+
+// 未指定 include 时清除全部类别
+var eraseCategories = []string{eraseStats, eraseSettings, eraseHistory, eraseEvents}
+
+// 清除数据后禁止重建的站点及截止时间，随状态文件保存
+var erasures = struct {
+	mutex   sync.Mutex
+	blocked map[string]time.Time
+}{blocked: make(map[string]time.Time)}
+
+// 禁止在 until 之前重建站点
+func blockSite(siteID string, until time.Time) {
+	erasures.mutex.Lock()
+	defer erasures.mutex.Unlock()
+	erasures.blocked[siteID] = until
+}
+
+// 站点是否处于禁止重建期
+func siteBlocked(siteID string, now time.Time) bool {
+	erasures.mutex.Lock()
+	defer erasures.mutex.Unlock()
+	until, exists := erasures.blocked[siteID]
+	return exists && now.Before(until)
+}
+
+// 禁止重建列表的副本
+func blockedSites() map[string]time.Time {
+	erasures.mutex.Lock()
+	defer erasures.mutex.Unlock()
+
+	if len(erasures.blocked) == 0 {
+		return nil
+	}
+	blocked := make(map[string]time.Time, len(erasures.blocked))
+	for siteID, until := range erasures.blocked {
+		blocked[siteID] = until
+	}
+	return blocked
+}
+
+// 从状态文件恢复禁止重建列表，已到期的条目丢弃
+func restoreBlocked(blocked map[string]time.Time, now time.Time) {
+	erasures.mutex.Lock()
+	defer erasures.mutex.Unlock()
+	for siteID, until := range blocked {
+		if now.Before(until) {
+			erasures.blocked[siteID] = until
+		}
+	}
+}
+
+// 清理已到期的禁止重建条目
+func sweepBlocked(now time.Time) {
+	erasures.mutex.Lock()
+	defer erasures.mutex.Unlock()
+	for siteID, until := range erasures.blocked {
+		if !now.Before(until) {
+			delete(erasures.blocked, siteID)
+		}
+	}
+}
+
+// 解析时长，除 time.ParseDuration 的格式外支持按天计的 "90d"
+func parseRetention(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("无效的时长 %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("无效的时长 %q", value)
+	}
+	return d, nil
+}
+
+// 解析 include 参数，为空时返回全部类别
+func parseEraseInclude(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return eraseCategories, nil
+	}
+	seen := make(map[string]bool)
+	var include []string
+	for _, part := range strings.Split(value, ",") {
+		category := strings.TrimSpace(part)
+		if category == "" || seen[category] {
+			continue
+		}
+		known := false
+		for _, c := range eraseCategories {
+			known = known || c == category
+		}
+		if !known {
+			return nil, fmt.Errorf("未知的数据类别 %q，可选 %s", category, strings.Join(eraseCategories, ","))
+		}
+		seen[category] = true
+		include = append(include, category)
+	}
+	return include, nil
+}
+
+// 审计日志中引用站点的匿名标识，不保留 siteId 本身
+func siteAuditRef(siteID string) string {
+	sum := sha256.Sum256([]byte(siteID))
+	return "site-" + hex.EncodeToString(sum[:8])
+}
+
+// 清除结果
+type ErasureReport struct {
+	SiteID  string   `json:"siteId"`
+	Include []string `json:"include"`
+
+	// 实际删除的条目，如 peak、uniques、history、settings、group:xxx、feeds
+	Removed      []string `json:"removed"`
+	Disconnected int      `json:"disconnected"`
+
+	// 审计日志中对应的匿名标识
+	AuditRef     string     `json:"auditRef"`
+	BlockedUntil *time.Time `json:"blockedUntil,omitempty"`
+}
+
+// 删除站点统计：序号与趋势、峰值、独立访客、按天的统计
+func (h *Hub) eraseStats(siteID string) []string {
+	var removed []string
+
+	h.mutex.Lock()
+	if stats, exists := h.stats[siteID]; exists {
+		// 唤醒等待中的条件请求，之后读到的是空数据
+		if stats.changed != nil {
+			close(stats.changed)
+		}
+		delete(h.stats, siteID)
+		removed = append(removed, "seq")
+	}
+	h.mutex.Unlock()
+
+	h.peakMutex.Lock()
+	if _, exists := h.peaks[siteID]; exists {
+		delete(h.peaks, siteID)
+		removed = append(removed, "peak")
+	}
+	h.peakMutex.Unlock()
+
+	h.uniquesMutex.Lock()
+	if _, exists := h.uniques[siteID]; exists {
+		delete(h.uniques, siteID)
+		removed = append(removed, "uniques")
+	}
+	h.uniquesMutex.Unlock()
+
+	h.dailyMutex.Lock()
+	if _, exists := h.daily[siteID]; exists {
+		delete(h.daily, siteID)
+		removed = append(removed, "daily")
+	}
+	h.dailyMutex.Unlock()
+
+	return removed
+}
+
+// 删除站点人数历史
+func (h *Hub) eraseHistory(siteID string) []string {
+	h.historyMutex.Lock()
+	defer h.historyMutex.Unlock()
+
+	if _, exists := h.history[siteID]; !exists {
+		return nil
+	}
+	delete(h.history, siteID)
+	return []string{"history"}
+}
+
+// 关闭订阅该站点的事件流，删除自定义事件的发布限额
+func (h *Hub) eraseEvents(siteID string) []string {
+	var removed []string
+
+	var feeds []*Feed
+	h.feedMutex.RLock()
+	for feed := range h.feeds {
+		if feed.siteID == siteID {
+			feeds = append(feeds, feed)
+		}
+	}
+	h.feedMutex.RUnlock()
+	for _, feed := range feeds {
+		h.unsubscribeFeed(feed)
+	}
+	if len(feeds) > 0 {
+		removed = append(removed, "feeds")
+	}
+
+	h.publishMutex.Lock()
+	if _, exists := h.publishLimits[siteID]; exists {
+		delete(h.publishLimits, siteID)
+		removed = append(removed, "publishLimits")
+	}
+	h.publishMutex.Unlock()
+
+	return removed
+}
+
+// 清除站点的数据：断开全部连接，删除选定类别并立即写回状态文件；
+// block 大于 0 时在此期间拒绝重新加入该站点
+func (h *Hub) eraseSite(siteID string, include []string, block time.Duration, now time.Time) (ErasureReport, error) {
+	report := ErasureReport{SiteID: siteID, Include: include, Removed: []string{}, AuditRef: siteAuditRef(siteID)}
+
+	// 先加入禁止列表，断开后挂件或其他客户端无法立即重建站点
+	if block > 0 {
+		until := now.Add(block)
+		blockSite(siteID, until)
+		report.BlockedUntil = &until
+	}
+
+	groups := settings.GroupsOf(siteID)
+	report.Disconnected, _ = h.closeSite(siteID, Message{
+		Type:      "erased",
+		SiteID:    siteID,
+		Timestamp: now.Unix(),
+		closeCode: errorCloseCode(ErrSiteErased),
+		closeText: "Gone",
+	})
+
+	persist := false
+	for _, category := range include {
+		switch category {
+		case eraseStats:
+			report.Removed = append(report.Removed, h.eraseStats(siteID)...)
+			persist = true
+		case eraseHistory:
+			report.Removed = append(report.Removed, h.eraseHistory(siteID)...)
+			persist = true
+		case eraseEvents:
+			report.Removed = append(report.Removed, h.eraseEvents(siteID)...)
+		case eraseSettings:
+			removed, err := settings.Erase(siteID)
+			if err != nil {
+				return report, err
+			}
+			report.Removed = append(report.Removed, removed...)
+		}
+	}
+	for _, name := range groups {
+		h.queueGroupChanged(name)
+	}
+
+	// 状态文件按间隔写入，清除后立即写一次，不等下个周期
+	if persist || block > 0 {
+		if err := h.saveState(*stateFile); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// 按站点配置的 retention 清理统计：按天的统计删除早于保留期的行；站点不在线且
+// 最近一次活动早于保留期时，删除其全部统计与人数历史（在 Hub 协程中调用）
+func (h *Hub) pruneRetention(now time.Time) {
+	for siteID, retention := range settings.Retentions() {
+		// 按天的统计按日期清理，在线的站点同样适用
+		if n := h.trimDaily(siteID, localDay(now.Add(-retention))); n > 0 {
+			log.Printf("审计: 站点 %s 清理 %d 天超过保留期 %s 的按天统计", siteAuditRef(siteID), n, retention)
+		}
+
+		h.mutex.RLock()
+		_, live := h.sites[siteID]
+		h.mutex.RUnlock()
+		if live {
+			continue
+		}
+
+		last := h.lastActivity(siteID)
+		if last.IsZero() || now.Sub(last) <= retention {
+			continue
+		}
+		removed := append(h.eraseStats(siteID), h.eraseHistory(siteID)...)
+		if len(removed) > 0 {
+			log.Printf("审计: 站点 %s 超过保留期 %s，清理 %s", siteAuditRef(siteID), retention, strings.Join(removed, "、"))
+		}
+	}
+}
+
+// 站点最近一次活动的时间，没有任何统计时为零值
+func (h *Hub) lastActivity(siteID string) time.Time {
+	var last time.Time
+	later := func(t time.Time) {
+		if t.After(last) {
+			last = t
+		}
+	}
+
+	h.mutex.RLock()
+	if stats, exists := h.stats[siteID]; exists {
+		later(stats.LastSeen)
+	}
+	h.mutex.RUnlock()
+
+	h.peakMutex.Lock()
+	if peak, exists := h.peaks[siteID]; exists && peak.LastSeen > 0 {
+		later(time.Unix(peak.LastSeen, 0))
+	}
+	h.peakMutex.Unlock()
+
+	h.historyMutex.Lock()
+	if ring, exists := h.history[siteID]; exists {
+		later(ring.lastActive)
+	}
+	h.historyMutex.Unlock()
+
+	return last
+}
+
+// 清除站点数据：DELETE /admin/api/sites/{id}/data?include=stats,settings,history,events&block=30d
+//
+// include 为空时清除全部类别；block 指定禁止重建的时长，期间加入返回 410。
+func handleAdminSiteData(w http.ResponseWriter, r *http.Request, siteID string) {
+	if siteID == "" || strings.Contains(siteID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "DELETE" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	include, err := parseEraseInclude(params.Get("include"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var block time.Duration
+	if value := params.Get("block"); value != "" {
+		if block, err = parseRetention(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := hub.eraseSite(siteID, include, block, time.Now())
+	sort.Strings(report.Removed)
+	if err != nil {
+		log.Printf("审计: 清除站点 %s 的数据失败（已删除 %s）: %v", report.AuditRef, strings.Join(report.Removed, "、"), err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("审计: 清除站点 %s 的数据（%s），删除 %s，断开 %d 个连接，来源 %s",
+		report.AuditRef, strings.Join(include, ","), strings.Join(report.Removed, "、"), report.Disconnected, getRealIP(r))
+	writeJSON(w, http.StatusOK, report)
+}
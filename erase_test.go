@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// 站点在 Hub 中还能查到的数据类别
+func siteRemnants(h *Hub, store *SettingsStore, siteID string) []string {
+	var found []string
+	if _, exists := h.peak(siteID, 0); exists {
+		found = append(found, "peak")
+	}
+	if h.uniqueCounts(siteID).Today > 0 {
+		found = append(found, "uniques")
+	}
+	if len(h.historyOf(siteID, time.Hour)) > 0 {
+		found = append(found, "history")
+	}
+	if len(h.dailyRange(siteID, "0000-00-00", "9999-99-99")) > 0 {
+		found = append(found, "daily")
+	}
+	store.mutex.RLock()
+	if _, exists := store.sites[siteID]; exists {
+		found = append(found, "settings")
+	}
+	for _, sites := range store.groups {
+		if slices.Contains(sites, siteID) {
+			found = append(found, "group")
+		}
+	}
+	store.mutex.RUnlock()
+	return found
+}
+
+// 清除站点后，内存、站点配置文件与状态文件中都查不到它的峰值、独立访客、历史、按天统计与配置，
+// 同一分组中的其他站点不受影响
+func TestEraseLeavesNothing(t *testing.T) {
+	h := startTestHub(t)
+	settings = loadTestSettings(t, `{"sites":{"a.example":{"meta":true},"b.example":{"meta":true}},`+
+		`"groups":[{"group":"net","sites":["a.example","b.example"]}]}`)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	setFlag(t, "state-file", statePath)
+	server := startTestServer(t)
+
+	erased := dialTestClient(t, server, "")
+	erased.join("a.example")
+	kept := dialTestClient(t, server, "")
+	kept.join("b.example")
+	expectCounts(t, h, "两个站点各一人", map[string]int{"a.example": 1, "b.example": 1})
+	h.sampleHistory(time.Now())
+	if err := h.saveState(statePath); err != nil {
+		t.Fatal(err)
+	}
+
+	all := []string{"peak", "uniques", "history", "daily", "settings", "group"}
+	for _, siteID := range []string{"a.example", "b.example"} {
+		if found := siteRemnants(h, settings, siteID); !slices.Equal(found, all) {
+			t.Fatalf("清除前 %s 只有 %v，无法验证清除", siteID, found)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleAdminSiteData(w, httptest.NewRequest("DELETE", "/admin/api/sites/a.example/data", nil), "a.example")
+	var report ErasureReport
+	decodeJSON(t, w, &report)
+	if report.Disconnected != 1 {
+		t.Fatalf("断开了 %d 个连接，应为 1", report.Disconnected)
+	}
+	for _, removed := range []string{"peak", "uniques", "history", "daily", "settings", "group:net"} {
+		if !slices.Contains(report.Removed, removed) {
+			t.Fatalf("删除的条目为 %v，缺少 %s", report.Removed, removed)
+		}
+	}
+
+	// 被断开的连接收到通知后关闭，注销时也不会重新写入统计
+	erased.expect("erased")
+	if _, err := erased.read(3 * time.Second); closeCode(err) != errorCloseCode(ErrSiteErased) {
+		t.Fatalf("断开原因为 %v，应为 %d", err, errorCloseCode(ErrSiteErased))
+	}
+	eventually(t, "被清除的连接退出", func() bool { return len(h.trackedClients()) == 1 })
+	// 注销命令按入队顺序处理，另一个连接注销完成时被清除的连接已先注销
+	kept.close()
+	eventually(t, "连接全部注销", func() bool { return h.counters.unregistered.Load() == 1 })
+
+	reloadedSettings, err := LoadSettings(settings.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewHub()
+	if err := restored.loadState(statePath); err != nil {
+		t.Fatal(err)
+	}
+	stores := []struct {
+		what     string
+		hub      *Hub
+		settings *SettingsStore
+	}{
+		{"内存", h, settings},
+		{"重新加载的配置文件与状态文件", restored, reloadedSettings},
+	}
+	for _, store := range stores {
+		if found := siteRemnants(store.hub, store.settings, "a.example"); len(found) != 0 {
+			t.Errorf("%s中仍能查到被清除站点的 %v", store.what, found)
+		}
+		if found := siteRemnants(store.hub, store.settings, "b.example"); !slices.Equal(found, all) {
+			t.Errorf("%s中未清除的站点只剩 %v", store.what, found)
+		}
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("a.example")) {
+		t.Fatalf("状态文件中仍有被清除的站点:\n%s", data)
+	}
+}
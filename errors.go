@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Hub 返回的错误，使用 errors.Is 判断
+var (
+	ErrSiteNotFound     = errors.New("站点不存在")
+	ErrSiteExpired      = errors.New("站点已过期")
+	ErrSiteAtCapacity   = errors.New("站点连接数已满")
+	ErrHubClosed        = errors.New("服务正在关闭")
+	ErrServerFull       = errors.New("实例连接数已满")
+	ErrTooManySites     = errors.New("站点数已达上限")
+	ErrSiteNotAllowed   = errors.New("站点不在允许的来源中")
+	ErrSiteErased       = errors.New("站点数据已清除")
+	ErrUpgradeRequired  = errors.New("挂件脚本版本过低，需要重新加载")
+	ErrOriginMismatch   = errors.New("siteId 与连接来源不符")
+	ErrSiteUnauthorized = errors.New("私有站点需要站点密钥")
+	ErrBotRejected      = errors.New("不接受爬虫连接")
+	ErrIPBlocked        = errors.New("IP 已被封禁")
+	ErrSiteDisconnected = errors.New("站点连接已被管理员断开")
+	ErrSiteRemoved      = errors.New("站点已被管理员移除")
+	// 加入令牌不匹配，消息固定为 unauthorized，便于挂件与自行对接的客户端识别
+	ErrJoinUnauthorized = errors.New("unauthorized")
+	// 多租户模式
+	ErrTenantUnauthorized = errors.New("无效的 API 密钥")
+	ErrTenantSites        = errors.New("租户站点数已达上限")
+	ErrTenantFull         = errors.New("租户连接数已达上限")
+)
+
+// 无效的 siteId
+type InvalidSiteIDError struct {
+	SiteID string
+	Reason string
+}
+
+func (e *InvalidSiteIDError) Error() string {
+	return fmt.Sprintf("无效的 siteId %q: %s", e.SiteID, e.Reason)
+}
+
+// 客户端消费过慢，发送队列已满
+type SlowClientError struct {
+	SiteID   string
+	IP       string
+	Buffered int
+	Dropped  int64
+}
+
+func (e *SlowClientError) Error() string {
+	return fmt.Sprintf("客户端 %s 消费过慢，站点 %s，队列 %d 条已满，累计丢弃 %d 条", e.IP, e.SiteID, e.Buffered, e.Dropped)
+}
+
+// 客户端消息超出限制，Code 为返回给客户端的错误码
+type LimitError struct {
+	Code  string
+	Limit string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("超出限制 %s=%d", e.Limit, e.Max)
+}
+
+// 错误对应的 HTTP 状态码
+func errorStatus(err error) int {
+	var invalid *InvalidSiteIDError
+	var slow *SlowClientError
+	var limit *LimitError
+	switch {
+	case errors.Is(err, ErrSiteNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrSiteUnauthorized), errors.Is(err, ErrJoinUnauthorized),
+		errors.Is(err, ErrTenantUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrSiteNotAllowed), errors.Is(err, ErrOriginMismatch), errors.Is(err, ErrBotRejected),
+		errors.Is(err, ErrIPBlocked):
+		return http.StatusForbidden
+	case errors.Is(err, ErrSiteExpired), errors.Is(err, ErrSiteErased), errors.Is(err, ErrSiteRemoved):
+		return http.StatusGone
+	case errors.Is(err, ErrSiteAtCapacity), errors.Is(err, ErrHubClosed), errors.Is(err, ErrServerFull),
+		errors.Is(err, ErrTooManySites), errors.Is(err, ErrTenantSites), errors.Is(err, ErrTenantFull),
+		errors.Is(err, ErrSiteDisconnected):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrUpgradeRequired):
+		return http.StatusUpgradeRequired
+	case errors.Is(err, ErrTooManyConnections), errors.Is(err, ErrConnectionRate):
+		return http.StatusTooManyRequests
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest
+	case errors.As(err, &slow):
+		return http.StatusTooManyRequests
+	case errors.As(err, &limit) && limit.Limit == "maxMessageBytes":
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &limit):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// 错误对应的 WebSocket 关闭码
+//
+// 客户端错误使用 4000 + HTTP 状态码（44xx 表示重连也无法恢复），
+// 服务关闭使用标准的 1001。
+func errorCloseCode(err error) int {
+	if errors.Is(err, ErrHubClosed) {
+		return websocket.CloseGoingAway
+	}
+	return 4000 + errorStatus(err)
+}
+
+// 检查客户端能否加入站点或分组
+func (h *Hub) checkJoin(siteID string) error {
+	if h.closed.Load() {
+		return ErrHubClosed
+	}
+	if err := validateSiteID(siteID); err != nil {
+		return err
+	}
+	if name, ok := groupName(siteID); ok {
+		if _, exists := settings.Group(name); !exists {
+			return fmt.Errorf("分组 %s: %w", name, ErrSiteNotFound)
+		}
+	}
+	if settings.Get(siteID).Expired(time.Now()) {
+		return fmt.Errorf("站点 %s: %w", siteID, ErrSiteExpired)
+	}
+	if siteBlocked(siteID, time.Now()) {
+		return fmt.Errorf("站点 %s: %w", siteID, ErrSiteErased)
+	}
+	if !siteAllowed(siteID) {
+		return fmt.Errorf("站点 %s: %w", siteID, ErrSiteNotAllowed)
+	}
+	return nil
+}
+
+// 以 HTTP 响应返回错误
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
+// 错误对应的协议错误码，只有超出限制的错误带错误码
+func errorCode(err error) string {
+	var limit *LimitError
+	if errors.As(err, &limit) {
+		return limit.Code
+	}
+	return ""
+}
+
+// 生成发送给客户端的错误消息，发送后按关闭码断开
+func errorMessage(siteID string, err error) Message {
+	// 无效的 siteId 只回显截短后的部分
+	var invalid *InvalidSiteIDError
+	if errors.As(err, &invalid) {
+		siteID = invalid.SiteID
+	}
+	return Message{
+		Type:      "error",
+		SiteID:    siteID,
+		Code:      errorCode(err),
+		Message:   err.Error(),
+		closeCode: errorCloseCode(err),
+		closeText: http.StatusText(errorStatus(err)),
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// 固定每个错误的 HTTP 状态码与 WebSocket 关闭码：挂件按关闭码决定是否重连，改动即是协议变更
+func TestErrorStatusAndCloseCode(t *testing.T) {
+	tests := []struct {
+		err       error
+		status    int
+		closeCode int
+	}{
+		{ErrSiteNotFound, http.StatusNotFound, 4404},
+		{ErrSiteExpired, http.StatusGone, 4410},
+		{ErrSiteErased, http.StatusGone, 4410},
+		{ErrSiteRemoved, http.StatusGone, 4410},
+		{ErrSiteUnauthorized, http.StatusUnauthorized, 4401},
+		{ErrJoinUnauthorized, http.StatusUnauthorized, 4401},
+		{ErrTenantUnauthorized, http.StatusUnauthorized, 4401},
+		{ErrSiteNotAllowed, http.StatusForbidden, 4403},
+		{ErrOriginMismatch, http.StatusForbidden, 4403},
+		{ErrBotRejected, http.StatusForbidden, 4403},
+		{ErrIPBlocked, http.StatusForbidden, 4403},
+		{ErrSiteAtCapacity, http.StatusServiceUnavailable, 4503},
+		{ErrServerFull, http.StatusServiceUnavailable, 4503},
+		{ErrTooManySites, http.StatusServiceUnavailable, 4503},
+		{ErrTenantSites, http.StatusServiceUnavailable, 4503},
+		{ErrTenantFull, http.StatusServiceUnavailable, 4503},
+		{ErrSiteDisconnected, http.StatusServiceUnavailable, 4503},
+		{ErrHubClosed, http.StatusServiceUnavailable, websocket.CloseGoingAway},
+		{ErrUpgradeRequired, http.StatusUpgradeRequired, 4426},
+		{ErrTooManyConnections, http.StatusTooManyRequests, 4429},
+		{ErrConnectionRate, http.StatusTooManyRequests, 4429},
+		{&InvalidSiteIDError{SiteID: "x", Reason: "test"}, http.StatusBadRequest, 4400},
+		{&SlowClientError{SiteID: "a.example"}, http.StatusTooManyRequests, 4429},
+		{&LimitError{Code: "message_too_large", Limit: "maxMessageBytes"}, http.StatusRequestEntityTooLarge, 4413},
+		{&LimitError{Code: "meta_too_long", Limit: "maxMetaLen"}, http.StatusBadRequest, 4400},
+		{errors.New("未知错误"), http.StatusInternalServerError, 4500},
+	}
+	for _, tt := range tests {
+		// 包装后的错误与原错误结果相同
+		for _, err := range []error{tt.err, fmt.Errorf("站点 a.example: %w", tt.err)} {
+			if status := errorStatus(err); status != tt.status {
+				t.Errorf("errorStatus(%v) = %d，应为 %d", err, status, tt.status)
+			}
+			if code := errorCloseCode(err); code != tt.closeCode {
+				t.Errorf("errorCloseCode(%v) = %d，应为 %d", err, code, tt.closeCode)
+			}
+		}
+	}
+}
+
+// 只有超出限制的错误带协议错误码
+func TestErrorCode(t *testing.T) {
+	limit := &LimitError{Code: "meta_too_many_keys", Limit: "maxMetaKeys", Max: 8}
+	if code := errorCode(fmt.Errorf("join: %w", limit)); code != "meta_too_many_keys" {
+		t.Errorf("LimitError 的错误码为 %q", code)
+	}
+	if code := errorCode(ErrSiteNotFound); code != "" {
+		t.Errorf("ErrSiteNotFound 的错误码为 %q，应为空", code)
+	}
+
+	message := errorMessage("a.example", &InvalidSiteIDError{SiteID: "trimmed", Reason: "test"})
+	if message.SiteID != "trimmed" || message.closeCode != 4400 || message.closeText != "Bad Request" {
+		t.Errorf("无效 siteId 的错误消息为 %+v", message)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 读接口的缓存策略：允许浏览器缓存 1 秒，共享缓存不缓存
+const readCacheControl = "private, max-age=1"
+
+// 挂件脚本与演示页面的缓存时长，0 表示每次使用前向服务器验证（带 ETag，未变化时返回 304）
+var scriptMaxAge = flag.Duration("script-max-age", 0, "挂件脚本与演示页面的缓存时长（Cache-Control max-age），0 表示每次使用前以 ETag 验证")
+
+// 挂件脚本与演示页面的缓存策略
+func scriptCacheControl() string {
+	if seconds := int64(scriptMaxAge.Seconds()); seconds > 0 {
+		return "public, max-age=" + strconv.FormatInt(seconds, 10)
+	}
+	return "no-cache"
+}
+
+// 内容摘要（SHA-256 的十六进制），用于生成 ETag
+func contentHash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		// 以长度分隔各部分，避免不同的切分得到相同的摘要
+		h.Write([]byte(strconv.Itoa(len(part)) + ":" + part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 按内容生成强 ETag：模板摘要加上渲染所用的数据，相同输入总是得到相同的输出
+func contentETag(parts ...string) string {
+	return `"` + contentHash(parts...)[:32] + `"`
+}
+
+// 设置缓存头与 ETag，If-None-Match 命中时返回 304；返回 false 表示已写入响应
+func checkContentNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("Cache-Control", scriptCacheControl())
+	w.Header().Set("ETag", etag)
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+	return true
+}
+
+// 按站点序号生成强 ETag
+//
+// 序号不持久化，重启后从 0 开始，因此带上进程启动时间避免误判为未变化。
+// variant 区分同一 URL 的不同表示（如按 Accept-Language 选择的语言）。
+func seqETag(seq int64, variant string) string {
+	tag := strconv.FormatInt(startTime.Unix(), 36) + "-" + strconv.FormatInt(seq, 10)
+	if variant != "" {
+		tag += "-" + variant
+	}
+	return `"` + tag + `"`
+}
+
+// If-None-Match 是否包含 etag（按弱比较，忽略 W/ 前缀）
+func etagMatches(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析 wait 参数，最长不超过长轮询的单次等待时间
+func parseWait(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, true
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, false
+	}
+	return min(wait, pollWaitTimeout), true
+}
+
+// 处理条件请求，返回 false 表示已写入响应（304 或错误）或客户端已断开
+//
+// If-None-Match 命中当前 ETag 时返回 304；带 wait 参数时先等待序号变化，
+// 期间有变化则继续输出最新内容，超时或服务关闭时返回 304。
+func checkNotModified(w http.ResponseWriter, r *http.Request, siteID, variant string) bool {
+	wait, ok := parseWait(r.URL.Query().Get("wait"))
+	if !ok {
+		http.Error(w, "Invalid wait", http.StatusBadRequest)
+		return false
+	}
+
+	seq, changed := hub.watchSeq(siteID)
+	etag := seqETag(seq, variant)
+	w.Header().Set("Cache-Control", readCacheControl)
+	if !etagMatches(r, etag) {
+		w.Header().Set("ETag", etag)
+		return true
+	}
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-changed:
+			seq, _ = hub.watchSeq(siteID)
+			w.Header().Set("ETag", seqETag(seq, variant))
+			return true
+		case <-r.Context().Done():
+			return false
+		case <-hub.closing:
+		case <-timer.C:
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+	return false
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 以 If-None-Match 请求 /api/count；etag 为空时不带该头
+func requestCount(etag, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/api/count?siteId=a.example"+query, nil)
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	handleCount(w, r)
+	return w
+}
+
+// 人数未变化时 ETag 不变，带上它的条件请求返回 304；弱比较与 * 同样命中
+func TestETagStable(t *testing.T) {
+	startTestHub(t)
+
+	first := requestCount("", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("返回 %d，ETag %q", first.Code, etag)
+	}
+	if second := requestCount("", "").Header().Get("ETag"); second != etag {
+		t.Fatalf("未变化时 ETag 从 %s 变为 %s", etag, second)
+	}
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		w := requestCount(header, "")
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("If-None-Match: %s 返回 %d", header, w.Code)
+		}
+		if got := w.Header().Get("ETag"); got != etag {
+			t.Fatalf("304 的 ETag 为 %s，应为 %s", got, etag)
+		}
+	}
+
+	// 同一序号的不同表示使用不同的 ETag
+	if seqETag(0, "") == seqETag(0, "en") {
+		t.Fatal("不同表示的 ETag 相同")
+	}
+}
+
+// 人数变化后 ETag 随之改变，旧的 ETag 不再命中
+func TestETagInvalidatedOnChange(t *testing.T) {
+	h := startTestHub(t)
+
+	etag := requestCount("", "").Header().Get("ETag")
+	h.bumpSeq("a.example")
+	w := requestCount(etag, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("变化后返回 %d，应为 200", w.Code)
+	}
+	if got := w.Header().Get("ETag"); got == etag || got != seqETag(1, "") {
+		t.Fatalf("变化后 ETag 为 %s，应为 %s", got, seqETag(1, ""))
+	}
+}
+
+// 带 wait 时等待序号变化：期间变化返回新内容，超时返回 304，格式错误返回 400
+func TestETagWait(t *testing.T) {
+	h := startTestHub(t)
+	etag := requestCount("", "").Header().Get("ETag")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		h.bumpSeq("a.example")
+	}()
+	start := time.Now()
+	w := requestCount(etag, "&wait=5s")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("等待期间变化后返回 %d，ETag %s", w.Code, w.Header().Get("ETag"))
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("变化后 %v 才返回", elapsed)
+	}
+
+	etag = w.Header().Get("ETag")
+	start = time.Now()
+	if w := requestCount(etag, "&wait=50ms"); w.Code != http.StatusNotModified {
+		t.Fatalf("等待超时返回 %d，应为 304", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("只等待了 %v", elapsed)
+	}
+
+	for _, wait := range []string{"abc", "-1s"} {
+		if w := requestCount(etag, "&wait="+wait); w.Code != http.StatusBadRequest {
+			t.Fatalf("wait=%s 返回 %d，应为 400", wait, w.Code)
+		}
+	}
+}
+
+// 等待期间客户端断开时直接返回，不写入响应；进入关闭流程时返回 304
+func TestETagWaitCancelled(t *testing.T) {
+	h := startTestHub(t)
+	etag := requestCount("", "").Header().Get("ETag")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/api/count?siteId=a.example&wait=5s", nil).WithContext(ctx)
+	r.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	done := make(chan bool, 1)
+	go func() { done <- checkNotModified(w, r, "a.example", "") }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("客户端断开后仍继续输出内容")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("客户端断开后仍在等待")
+	}
+	if w.Header().Get("ETag") != "" || w.Body.Len() != 0 {
+		t.Fatal("客户端断开后写入了响应")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		h.markClosing()
+	}()
+	start := time.Now()
+	if w := requestCount(etag, "&wait=5s"); w.Code != http.StatusNotModified {
+		t.Fatalf("关闭时返回 %d，应为 304", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("关闭后 %v 才返回", elapsed)
+	}
+}
+
+// 内容 ETag 只取决于输入：相同输入相同，任一部分或切分方式变化都不同
+func TestContentETag(t *testing.T) {
+	if contentETag("template", "data") != contentETag("template", "data") {
+		t.Fatal("相同输入的 ETag 不同")
+	}
+	for _, parts := range [][]string{{"template", "Data"}, {"templated", "ata"}, {"template", "data", ""}} {
+		if contentETag(parts...) == contentETag("template", "data") {
+			t.Fatalf("%q 与原输入的 ETag 相同", parts)
+		}
+	}
+
+	etag := contentETag("template", "data")
+	r := httptest.NewRequest("GET", "/main.js", nil)
+	r.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	if checkContentNotModified(w, r, etag) || w.Code != http.StatusNotModified {
+		t.Fatalf("命中时返回 %d，应为 304", w.Code)
+	}
+	if w.Header().Get("Cache-Control") != scriptCacheControl() {
+		t.Fatalf("Cache-Control 为 %q", w.Header().Get("Cache-Control"))
+	}
+}
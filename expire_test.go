@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 临时站点到期时，在线连接收到 expired 并以 4410 断开，站点的峰值与仍在线的会话写入状态文件
+func TestExpireSiteSavesState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	setFlag(t, "state-file", path)
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	client := dialTestClient(t, server, "")
+	client.join("webinar.example")
+
+	expiresAt := time.Now().Add(-time.Second)
+	if err := settings.Update("webinar.example", func(site *SiteSettings) { site.ExpiresAt = &expiresAt }); err != nil {
+		t.Fatal(err)
+	}
+
+	client.expect("expired")
+	_, err := client.read(3 * time.Second)
+	if code := closeCode(err); code != errorCloseCode(ErrSiteExpired) {
+		t.Fatalf("关闭码为 %d，应为 %d（%v）", code, errorCloseCode(ErrSiteExpired), err)
+	}
+	eventually(t, "过期站点移出 Hub", func() bool { return siteCount(h, "webinar.example") == -1 })
+
+	var snapshot stateSnapshot
+	eventually(t, "写入状态文件", func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && json.Unmarshal(data, &snapshot) == nil
+	})
+	state := snapshot.Sites["webinar.example"]
+	if state == nil || state.Peak == nil || state.Peak.Peak != 1 {
+		t.Fatalf("状态文件中缺少站点峰值: %+v", state)
+	}
+	if len(state.Daily) != 1 || state.Daily[0].Joins != 1 || state.Daily[0].Sessions != 1 {
+		t.Fatalf("状态文件中的按天统计为 %+v，应有 1 次加入与 1 个会话", state.Daily)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 事件流推送的事件
+type FeedEvent struct {
+	Type        string            `json:"type"`
+	SiteID      string            `json:"siteId"`
+	Count       int               `json:"count"`
+	IP          string            `json:"ip,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	Excluded    bool              `json:"excluded,omitempty"`
+	Connections []ConnectionInfo  `json:"connections,omitempty"`
+	Dropped     int64             `json:"dropped,omitempty"`
+	Correction  *CountCorrection  `json:"correction,omitempty"`
+	LastSeen    int64             `json:"lastSeen,omitempty"`
+	Timestamp   int64             `json:"timestamp"`
+}
+
+// 事件流订阅者
+type Feed struct {
+	conn    *websocket.Conn
+	hub     *Hub
+	siteID  string
+	send    chan FeedEvent
+	dropped atomic.Int64
+}
+
+// 快照推送的最小间隔
+const feedSnapshotInterval = 5 * time.Second
+
+// 订阅事件流，siteID 为空表示订阅全部站点；同一站点订阅数达到上限时返回 false
+func (h *Hub) subscribeFeed(feed *Feed, limit int) bool {
+	h.feedMutex.Lock()
+	defer h.feedMutex.Unlock()
+
+	if limit > 0 {
+		count := 0
+		for other := range h.feeds {
+			if other.siteID == feed.siteID {
+				count++
+			}
+		}
+		if count >= limit {
+			return false
+		}
+	}
+
+	h.feeds[feed] = true
+	return true
+}
+
+// 取消订阅并关闭发送通道
+func (h *Hub) unsubscribeFeed(feed *Feed) {
+	h.feedMutex.Lock()
+	if _, exists := h.feeds[feed]; exists {
+		delete(h.feeds, feed)
+		close(feed.send)
+	}
+	h.feedMutex.Unlock()
+}
+
+// 向订阅者推送事件，在入队时按站点过滤，队列满时丢弃
+func (h *Hub) publishEvent(event FeedEvent) {
+	h.feedMutex.RLock()
+	defer h.feedMutex.RUnlock()
+
+	for feed := range h.feeds {
+		if feed.siteID != "" && feed.siteID != event.SiteID {
+			continue
+		}
+		select {
+		case feed.send <- event:
+		default:
+			feed.dropped.Add(1)
+		}
+	}
+}
+
+// 生成站点快照
+func (h *Hub) siteSnapshot(siteID string) FeedEvent {
+	connections := h.listConnections(func(site *Site, client *Client) bool {
+		return site.ID == siteID
+	})
+	count := 0
+	for i := range connections {
+		connections[i].IP = anonymizeIP(connections[i].IP)
+		if !connections[i].Excluded {
+			count++
+		}
+	}
+
+	return FeedEvent{
+		Type:        "snapshot",
+		SiteID:      siteID,
+		Count:       count,
+		Connections: connections,
+		Timestamp:   time.Now().Unix(),
+	}
+}
+
+// 读取订阅者连接，仅用于感知断开
+func (f *Feed) readPump() {
+	defer func() {
+		f.hub.unsubscribeFeed(f)
+		f.conn.Close()
+		f.hub.pumps.Done()
+	}()
+
+	f.conn.SetReadLimit(256)
+	f.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	f.conn.SetPongHandler(func(string) error {
+		f.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	for {
+		if _, _, err := f.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+// 向订阅者推送事件，站点有变化时按间隔补发快照
+func (f *Feed) writePump() {
+	ping := time.NewTicker(54 * time.Second)
+	snapshot := time.NewTicker(feedSnapshotInterval)
+	defer func() {
+		ping.Stop()
+		snapshot.Stop()
+		f.conn.Close()
+		f.hub.pumps.Done()
+	}()
+
+	dirty := false
+	for {
+		select {
+		case event, ok := <-f.send:
+			f.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if !ok {
+				f.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := f.conn.WriteJSON(event); err != nil {
+				return
+			}
+			dirty = true
+
+		case <-snapshot.C:
+			if !dirty || f.siteID == "" {
+				continue
+			}
+			dirty = false
+			event := f.hub.siteSnapshot(f.siteID)
+			event.Dropped = f.dropped.Load()
+			f.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := f.conn.WriteJSON(event); err != nil {
+				return
+			}
+
+		case <-ping.C:
+			f.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := f.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// 匿名化 IP：IPv4 去掉最后一段，IPv6 保留前 48 位
+func anonymizeIP(addr string) string {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// 每个站点保留的最近操作数
+const siteOpRingSize = 32
+
+// 连接 ID 生成器
+var nextClientID atomic.Uint64
+
+// 站点上的一次注册/注销操作
+type SiteOp struct {
+	ConnID    uint64 `json:"connId"`
+	VisitorID string `json:"visitorId,omitempty"`
+	Op        string `json:"op"`
+	IP        string `json:"ip,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// 固定大小的操作环，只由 Hub 协程在持有站点锁时写入
+type opRing struct {
+	ops  [siteOpRingSize]SiteOp
+	next int
+	full bool
+}
+
+// 追加一条操作，覆盖最旧的记录
+func (r *opRing) add(op SiteOp) {
+	r.ops[r.next] = op
+	r.next = (r.next + 1) % siteOpRingSize
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// 按时间顺序复制全部操作
+func (r *opRing) snapshot() []SiteOp {
+	if !r.full {
+		return append([]SiteOp{}, r.ops[:r.next]...)
+	}
+	return append(append([]SiteOp{}, r.ops[r.next:]...), r.ops[:r.next]...)
+}
+
+// 人数校正记录
+type CountCorrection struct {
+	SiteID    string   `json:"siteId"`
+	Structure string   `json:"structure"`
+	Expected  int      `json:"expected"`
+	Actual    int      `json:"actual"`
+	Ops       []SiteOp `json:"ops"`
+}
+
+// 记录站点操作（调用方需持有站点写锁，或在 Hub 协程中持有读锁）
+func (s *Site) recordOp(op string, client *Client) {
+	ip := ""
+	if !settings.Resolve(s.ID).Private {
+		ip = anonymizeIP(client.ip)
+	}
+	s.ops.add(SiteOp{
+		ConnID:    client.id,
+		VisitorID: client.visitorID,
+		Op:        op,
+		IP:        ip,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// 核对人数与连接表，不一致时以连接表为准校正（调用方需持有站点写锁）
+func (s *Site) auditLocked() *CountCorrection {
+	expected := s.expectedCountLocked()
+	if s.Count == expected {
+		return nil
+	}
+
+	structure := "Count != len(sessions)"
+	if s.dedupIP {
+		structure = "Count != len(ips)"
+	}
+	if len(s.beacons) > 0 {
+		structure += "+len(beacons)"
+	}
+	correction := &CountCorrection{
+		SiteID:    s.ID,
+		Structure: structure,
+		Expected:  expected,
+		Actual:    s.Count,
+		Ops:       s.ops.snapshot(),
+	}
+	s.Count = expected
+	return correction
+}
+
+// 输出校正记录到日志与站长事件流
+func (h *Hub) reportCorrection(correction *CountCorrection) {
+	if correction == nil {
+		return
+	}
+
+	dump, _ := json.Marshal(correction)
+	log.Printf("站点 %s 人数校正: %d -> %d，最近操作: %s",
+		correction.SiteID, correction.Actual, correction.Expected, dump)
+	h.publishEvent(FeedEvent{
+		Type:       "correction",
+		SiteID:     correction.SiteID,
+		Count:      correction.Expected,
+		Correction: correction,
+		Timestamp:  time.Now().Unix(),
+	})
+}
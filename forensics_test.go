@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 测试期间把日志写入缓冲区，结束时恢复
+type logCapture struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (c *logCapture) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.buf.Write(p)
+}
+
+func (c *logCapture) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.buf.String()
+}
+
+func captureLog(t *testing.T) *logCapture {
+	t.Helper()
+	capture := &logCapture{}
+	previous := log.Writer()
+	log.SetOutput(capture)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return capture
+}
+
+// 日志中站点的人数校正记录
+func correctionsIn(t *testing.T, logs, siteID string) []CountCorrection {
+	t.Helper()
+	var corrections []CountCorrection
+	for _, line := range strings.Split(logs, "\n") {
+		_, dump, found := strings.Cut(line, "站点 "+siteID+" 人数校正: ")
+		if !found {
+			continue
+		}
+		_, dump, _ = strings.Cut(dump, "最近操作: ")
+		var correction CountCorrection
+		if err := json.Unmarshal([]byte(dump), &correction); err != nil {
+			t.Fatalf("校正记录无法解析: %v\n%s", err, line)
+		}
+		corrections = append(corrections, correction)
+	}
+	return corrections
+}
+
+// 操作环写满后覆盖最旧的记录，快照按时间顺序
+func TestOpRingWraps(t *testing.T) {
+	var ring opRing
+	if len(ring.snapshot()) != 0 {
+		t.Fatal("空的操作环有记录")
+	}
+	for i := 1; i <= siteOpRingSize+8; i++ {
+		ring.add(SiteOp{ConnID: uint64(i)})
+	}
+	ops := ring.snapshot()
+	if len(ops) != siteOpRingSize {
+		t.Fatalf("快照有 %d 条，应为 %d", len(ops), siteOpRingSize)
+	}
+	for i, op := range ops {
+		if want := uint64(i + 9); op.ConnID != want {
+			t.Fatalf("第 %d 条为连接 %d，应为 %d", i, op.ConnID, want)
+		}
+	}
+}
+
+// 人数被人为改错后，下一次加入时校正，日志中的记录包含最近的加入与离开操作，IP 已匿名化；
+// 私有站点的记录不含 IP
+func TestCorrectionDumpsOps(t *testing.T) {
+	logs := captureLog(t)
+	h := startTestHub(t)
+	settings = loadTestSettings(t, `{"sites":{"private.example":{"private":true}}}`)
+	server := startTestServer(t)
+
+	for _, siteID := range []string{"a.example", "private.example"} {
+		first := dialTestClient(t, server, "")
+		first.joinAs(siteID, "visitor-1", "browser-1")
+		second := dialTestClient(t, server, "")
+		second.joinAs(siteID, "visitor-2", "browser-2")
+		second.close()
+		expectCounts(t, h, "第二个连接离开", map[string]int{siteID: 1})
+
+		// 故意制造偏差
+		h.mutex.RLock()
+		site := h.sites[siteID]
+		h.mutex.RUnlock()
+		site.mutex.Lock()
+		site.Count += 5
+		site.mutex.Unlock()
+
+		dialTestClient(t, server, "").joinAs(siteID, "visitor-3", "browser-3")
+		if count := siteCount(h, siteID); count != 2 {
+			t.Fatalf("%s 校正后人数为 %d，应为 2", siteID, count)
+		}
+
+		corrections := correctionsIn(t, logs.String(), siteID)
+		if len(corrections) != 1 {
+			t.Fatalf("%s 有 %d 条校正记录，应为 1:\n%s", siteID, len(corrections), logs.String())
+		}
+		correction := corrections[0]
+		if correction.Structure != "Count != len(sessions)" || correction.Expected != 2 || correction.Actual != 7 {
+			t.Fatalf("%s 的校正记录为 %+v", siteID, correction)
+		}
+
+		wantOps := []struct{ op, visitorID string }{
+			{"join", "visitor-1"}, {"join", "visitor-2"}, {"leave", "visitor-2"}, {"join", "visitor-3"},
+		}
+		if len(correction.Ops) != len(wantOps) {
+			t.Fatalf("%s 记录了 %d 条操作，应为 %d: %+v", siteID, len(correction.Ops), len(wantOps), correction.Ops)
+		}
+		wantIP := "127.0.0.0"
+		if siteID == "private.example" {
+			wantIP = ""
+		}
+		for i, op := range correction.Ops {
+			if op.Op != wantOps[i].op || op.VisitorID != wantOps[i].visitorID || op.IP != wantIP || op.ConnID == 0 {
+				t.Fatalf("%s 第 %d 条操作为 %+v，应为 %s %s，IP %q", siteID, i, op, wantOps[i].op, wantOps[i].visitorID, wantIP)
+			}
+		}
+		if correction.Ops[1].ConnID != correction.Ops[2].ConnID {
+			t.Fatalf("%s 离开操作的连接与加入时不同: %+v", siteID, correction.Ops)
+		}
+	}
+	if strings.Contains(logs.String(), "127.0.0.1\"") {
+		t.Fatalf("校正记录中有未匿名化的 IP:\n%s", logs.String())
+	}
+}
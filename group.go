@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 分组在 siteId 中的前缀，例如 group:mynetwork
+const groupPrefix = "group:"
+
+// 单个分组的成员上限
+const maxGroupSites = 100
+
+// 从 siteId 中解析分组名
+func groupName(siteID string) (string, bool) {
+	name, ok := strings.CutPrefix(siteID, groupPrefix)
+	return name, ok && name != ""
+}
+
+// 整理分组成员：去空白、去重，不允许嵌套分组
+func cleanGroupSites(sites []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var cleaned []string
+	for _, id := range sites {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if strings.HasPrefix(id, groupPrefix) {
+			return nil, fmt.Errorf("成员 %q 不能是分组", id)
+		}
+		seen[id] = true
+		cleaned = append(cleaned, id)
+	}
+	if len(cleaned) == 0 {
+		return nil, fmt.Errorf("分组至少需要一个站点")
+	}
+	if len(cleaned) > maxGroupSites {
+		return nil, fmt.Errorf("分组成员不能超过 %d 个", maxGroupSites)
+	}
+	return cleaned, nil
+}
+
+// 计算分组人数与成员明细
+//
+// 同一访客在多个成员站点同时在线只计一次（取其在单个站点的最大会话数，
+// 同一 clientId 的多个标签页为一个会话，与站点人数的去重一致），
+// 没有访客 ID 的连接无法去重，按连接计数。设置为 private 的成员
+// 计入总数但不出现在明细中。总数使用真实人数；明细中的成员人数与
+// /api/count 查询该站点的结果相同（含其他实例与 addBase）。
+func (h *Hub) groupCount(name string) (int, map[string]int) {
+	sites, _ := settings.Group(name)
+	members := make(map[string]int)
+	visitors := make(map[string]int)
+	anonymous := 0
+
+	for _, siteID := range sites {
+		h.mutex.RLock()
+		site, live := h.sites[siteID]
+		h.mutex.RUnlock()
+
+		count := 0
+		if live {
+			local := make(map[string]int)
+			sessions := make(map[string]bool)
+			site.mutex.RLock()
+			count = site.Count
+			for client := range site.Connections {
+				if client.visitorID == "" {
+					anonymous++
+					continue
+				}
+				if key := sessionKey(client); !sessions[key] {
+					sessions[key] = true
+					local[client.visitorID]++
+				}
+			}
+			site.mutex.RUnlock()
+
+			for visitorID, n := range local {
+				if n > visitors[visitorID] {
+					visitors[visitorID] = n
+				}
+			}
+		}
+
+		if !settings.Resolve(siteID).Private {
+			members[siteID] = globalCount(siteID, count)
+		}
+	}
+
+	total := anonymous
+	for _, n := range visitors {
+		total += n
+	}
+	return total, members
+}
+
+// 重新计算分组人数，有变化时递增序号并推送给分组观察者（仅在 Hub 协程中调用）
+func (h *Hub) refreshGroup(name string) (count int, pushed bool) {
+	count, _ = h.groupCount(name)
+
+	h.mutex.Lock()
+	last, known := h.groups[name]
+	if _, defined := settings.Group(name); defined {
+		h.groups[name] = count
+	} else {
+		delete(h.groups, name)
+	}
+	h.mutex.Unlock()
+
+	if known && last == count {
+		return count, false
+	}
+
+	siteID := groupPrefix + name
+	seq := h.bumpSeq(siteID)
+	h.broadcastToSite(siteID, count, seq)
+	return count, true
+}
+
+// 成员站点人数变化后刷新所在分组
+func (h *Hub) refreshGroups(siteID string) {
+	for _, name := range settings.GroupsOf(siteID) {
+		h.refreshGroup(name)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// 进程启动时间
+var startTime = time.Now()
+
+// 健康检查响应
+type HealthInfo struct {
+	Status      string `json:"status"`
+	Uptime      int64  `json:"uptime"`
+	Connections int    `json:"connections"`
+	Sites       int    `json:"sites"`
+
+	// 深度检查时附带 Hub 命令队列状态
+	Queue *QueueInfo `json:"queue,omitempty"`
+}
+
+// 当前健康状态
+func healthInfo(status string) HealthInfo {
+	connections, sites := hub.totals()
+	return HealthInfo{
+		Status:      status,
+		Uptime:      int64(time.Since(startTime).Seconds()),
+		Connections: connections,
+		Sites:       sites,
+	}
+}
+
+// 处理存活检查：GET /healthz，只读取内存中的 Hub 状态
+//
+// deep=1 时同时检查加入与人数推送所经过的 Hub 命令队列，排队延迟超过
+// -queue-latency-threshold 时返回 503 与 degraded。
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("deep") != "1" {
+		writeJSON(w, http.StatusOK, healthInfo("ok"))
+		return
+	}
+
+	queue := hub.queueInfo()
+	if queue.Degraded {
+		info := healthInfo("degraded")
+		info.Queue = &queue
+		writeJSON(w, http.StatusServiceUnavailable, info)
+		return
+	}
+	info := healthInfo("ok")
+	info.Queue = &queue
+	writeJSON(w, http.StatusOK, info)
+}
+
+// 处理就绪检查：GET /readyz，关闭排空与计划重启排空期间返回 503
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if hub.closed.Load() || restarts.Draining() {
+		writeJSON(w, http.StatusServiceUnavailable, healthInfo("draining"))
+		return
+	}
+	writeJSON(w, http.StatusOK, healthInfo("ready"))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 以当前输出重写 testdata 下的 golden 文件：go test -run ... -update
+var updateGolden = flag.Bool("update", false, "重写 testdata 下的 golden 文件")
+
+// 测试共用的初始化：加载内置模板、语言包与爬虫规则；未加 -v 时不输出日志
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+
+	loadAssets()
+	loaded, err := loadLocales("")
+	if err != nil {
+		log.Fatalf("加载语言包失败: %v", err)
+	}
+	locales = loaded
+	patterns, err := loadBotPatterns()
+	if err != nil {
+		log.Fatalf("加载爬虫规则失败: %v", err)
+	}
+	setBotPatterns(patterns)
+
+	os.Exit(m.Run())
+}
+
+// 在测试期间使用空的站点配置与不同步的消息代理，结束时恢复
+func useTestSettings(t *testing.T) {
+	t.Helper()
+
+	store, err := LoadSettings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousSettings, previousBroker := settings, broker
+	settings, broker = store, noopBroker{}
+	t.Cleanup(func() {
+		settings, broker = previousSettings, previousBroker
+	})
+}
+
+// 创建并启动一个 Hub，替换全局的 hub、站点配置与消息代理；测试结束时停止
+func startTestHub(t *testing.T) *Hub {
+	t.Helper()
+
+	useTestSettings(t)
+	h := NewHub()
+	hub = h
+	go h.Run()
+	t.Cleanup(func() {
+		// 先等连接协程随客户端关闭退出（注销需要 Hub 处理），再停止 Hub
+		waitPumps(t, h, 5*time.Second)
+		h.Stop()
+	})
+	return h
+}
+
+// 等待 Hub 登记的读写协程全部退出
+func waitPumps(t *testing.T, h *Hub, timeout time.Duration) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		h.pumps.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Errorf("%v 后仍有 %d 个 WebSocket 连接未退出", timeout, len(h.trackedClients()))
+	}
+}
+
+// 启动处理 WebSocket 与脚本请求的测试服务器，不限制同一 IP 的连接数与频率
+func startTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	setFlag(t, "ip-max-conns", "0")
+	setFlag(t, "ip-max-rate", "0")
+	server := httptest.NewServer(http.HandlerFunc(handleRequest))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// 测试用的 WebSocket 客户端
+type testClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+// 以浏览器的 User-Agent 连接测试服务器，query 为附加的查询参数；测试结束时关闭
+func dialTestClient(t *testing.T, server *httptest.Server, query string) *testClient {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+	if query != "" {
+		url += "?" + query
+	}
+	header := http.Header{"User-Agent": {"Mozilla/5.0 (X11; Linux x86_64) LiveUserTest"}}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("连接 %s 失败: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+// 发送一条消息
+func (c *testClient) send(message Message) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(message); err != nil {
+		c.t.Fatalf("发送 %s 失败: %v", message.Type, err)
+	}
+}
+
+// 读取下一条消息
+func (c *testClient) read(timeout time.Duration) (Message, error) {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return Message{}, err
+	}
+	var message Message
+	err = json.Unmarshal(data, &message)
+	return message, err
+}
+
+// 读取消息直到类型为 messageType 的一条，跳过其他类型
+func (c *testClient) expect(messageType string) Message {
+	c.t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		message, err := c.read(time.Until(deadline))
+		if err != nil {
+			c.t.Fatalf("等待 %s 消息失败: %v", messageType, err)
+		}
+		if message.Type == messageType {
+			return message
+		}
+	}
+}
+
+// 加入站点并等待 joined 确认
+func (c *testClient) join(siteID string) {
+	c.t.Helper()
+	c.send(Message{Type: "join", SiteID: siteID, Version: scriptVersion})
+	c.expect("joined")
+}
+
+// 关闭连接
+func (c *testClient) close() {
+	c.conn.Close()
+}
+
+// 轮询直到 cond 成立，超时后测试失败
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("超时: %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// 站点的本实例人数，站点不存在时为 -1
+func siteCount(h *Hub, siteID string) int {
+	h.mutex.RLock()
+	site, exists := h.sites[siteID]
+	h.mutex.RUnlock()
+	if !exists {
+		return -1
+	}
+	site.mutex.RLock()
+	defer site.mutex.RUnlock()
+	return site.Count
+}
+
+// 在测试期间修改命令行参数，结束时恢复
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("未知参数 -%s", name)
+	}
+	previous := f.Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("设置 -%s=%s 失败: %v", name, value, err)
+	}
+	t.Cleanup(func() {
+		flag.Set(name, previous)
+	})
+}
+
+// 读取错误中的 WebSocket 关闭码，不是关闭帧时为 0
+func closeCode(err error) int {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code
+	}
+	return 0
+}
+
+// 与 testdata/name 的内容比较，-update 时改为写入
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取 %s 失败（首次运行加 -update 生成）: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s 不一致\n得到:\n%s\n期望:\n%s", path, got, want)
+	}
+}
+
+// 编码为带缩进的 JSON，便于 golden 文件比较
+func goldenJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, '\n')
+}
+
+// 检查响应为 200 并解码 JSON 响应体
+func decodeJSON(t *testing.T, recorder *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("返回 %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), v); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 历史数据点
+type HistoryPoint struct {
+	T     int64 `json:"t"`
+	Count int   `json:"count"`
+}
+
+// 差分编码的历史数据
+//
+// Deltas 中非零值为相邻两点的差值；0 后面紧跟一个重复次数，
+// 表示连续若干个点没有变化。例如 [2, 0, 40, -1] 表示先加 2，
+// 随后 40 个点不变，最后减 1。
+type DeltaHistory struct {
+	Encoding string `json:"encoding"`
+	Start    int64  `json:"start"`
+	Interval int64  `json:"interval"`
+	First    int    `json:"first"`
+	Deltas   []int  `json:"deltas"`
+}
+
+// 按固定间隔对历史数据做差分与游程编码，points 需按时间升序且间隔固定
+func encodeDeltas(points []HistoryPoint, interval int64) DeltaHistory {
+	encoded := DeltaHistory{
+		Encoding: "delta",
+		Interval: interval,
+		Deltas:   []int{},
+	}
+	if len(points) == 0 {
+		return encoded
+	}
+
+	encoded.Start = points[0].T
+	encoded.First = points[0].Count
+
+	zeros := 0
+	flush := func() {
+		if zeros > 0 {
+			encoded.Deltas = append(encoded.Deltas, 0, zeros)
+			zeros = 0
+		}
+	}
+
+	for i := 1; i < len(points); i++ {
+		delta := points[i].Count - points[i-1].Count
+		if delta == 0 {
+			zeros++
+			continue
+		}
+		flush()
+		encoded.Deltas = append(encoded.Deltas, delta)
+	}
+	flush()
+
+	return encoded
+}
+
+// 将历史数据按 bucket 秒聚合，agg 为 max 或 mean
+func downsampleHistory(points []HistoryPoint, bucket int64, agg string) ([]HistoryPoint, error) {
+	if agg != "max" && agg != "mean" {
+		return nil, fmt.Errorf("不支持的聚合方式 %q", agg)
+	}
+	if bucket <= 0 {
+		return nil, fmt.Errorf("无效的聚合间隔 %d", bucket)
+	}
+
+	result := []HistoryPoint{}
+	sum, n := 0, 0
+	flush := func() {
+		if n == 0 {
+			return
+		}
+		last := &result[len(result)-1]
+		if agg == "mean" {
+			last.Count = int(math.Round(float64(sum) / float64(n)))
+		}
+		sum, n = 0, 0
+	}
+
+	for _, point := range points {
+		start := point.T - point.T%bucket
+		if len(result) == 0 || result[len(result)-1].T != start {
+			flush()
+			result = append(result, HistoryPoint{T: start, Count: point.Count})
+		}
+		last := &result[len(result)-1]
+		if point.Count > last.Count && agg == "max" {
+			last.Count = point.Count
+		}
+		sum += point.Count
+		n++
+	}
+	flush()
+
+	return result, nil
+}
+
+// 历史采样间隔与每个站点保留的采样数
+var historyInterval = flag.Duration("history-interval", 10*time.Second, "站点人数历史的采样间隔")
+var historySamples = flag.Int("history-samples", 360, "每个站点保留的历史采样数")
+
+// 固定容量的历史环形缓冲
+type historyRing struct {
+	points []HistoryPoint
+	next   int
+	full   bool
+
+	// 最近一次采样到非零人数的时间，站点清空超过一个窗口后丢弃
+	lastActive time.Time
+}
+
+// 追加一个采样点，覆盖最旧的点
+func (r *historyRing) add(point HistoryPoint) {
+	if r.points == nil {
+		r.points = make([]HistoryPoint, *historySamples)
+	}
+	r.points[r.next] = point
+	r.next = (r.next + 1) % len(r.points)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// 按时间升序返回不早于 since 的采样点
+func (r *historyRing) since(since int64) []HistoryPoint {
+	ordered := r.points[:r.next]
+	if r.full {
+		ordered = append(append([]HistoryPoint{}, r.points[r.next:]...), r.points[:r.next]...)
+	}
+	points := []HistoryPoint{}
+	for _, point := range ordered {
+		if point.T >= since {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// 对所有站点采样一次人数（在 Hub 协程中调用）
+//
+// 无人在线的站点记录 0 而不是留空，清空后超过一个完整窗口才丢弃缓冲。
+func (h *Hub) sampleHistory(now time.Time) {
+	counts := make(map[string]int)
+	h.mutex.RLock()
+	for id, site := range h.sites {
+		if _, ok := groupName(id); ok {
+			continue
+		}
+		site.mutex.RLock()
+		counts[id] = site.Count
+		site.mutex.RUnlock()
+	}
+	h.mutex.RUnlock()
+
+	window := time.Duration(*historySamples) * *historyInterval
+	point := HistoryPoint{T: now.Unix()}
+
+	h.historyMutex.Lock()
+	defer h.historyMutex.Unlock()
+
+	for id := range counts {
+		if _, exists := h.history[id]; !exists {
+			h.history[id] = &historyRing{}
+		}
+	}
+	for id, ring := range h.history {
+		point.Count = counts[id]
+		if point.Count > 0 {
+			ring.lastActive = now
+		} else if now.Sub(ring.lastActive) > window {
+			delete(h.history, id)
+			continue
+		}
+		ring.add(point)
+	}
+}
+
+// 读取站点最近 d 时长内的历史
+func (h *Hub) historyOf(siteID string, d time.Duration) []HistoryPoint {
+	h.historyMutex.Lock()
+	defer h.historyMutex.Unlock()
+
+	ring, exists := h.history[siteID]
+	if !exists {
+		return []HistoryPoint{}
+	}
+	return ring.since(time.Now().Add(-d).Unix())
+}
+
+// 服务端降采样可选的分辨率（resolution 参数），值为聚合间隔的秒数
+var historyResolutions = map[string]int64{
+	"1m": 60,
+	"5m": 300,
+}
+
+// 处理历史请求：GET /api/history?siteId=example.com&minutes=60
+//
+// 可选 resolution=1m|5m 与 agg=max|mean 降采样，encoding=delta 返回差分编码。
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	siteID := resolveSiteID(strings.TrimSpace(params.Get("siteId")))
+	if siteID == "" {
+		writeError(w, &InvalidSiteIDError{Reason: "缺少 siteId"})
+		return
+	}
+
+	// 私有站点需要站点密钥
+	if settings.Resolve(siteID).Private && !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	minutes := 60
+	if value := params.Get("minutes"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		minutes = n
+	}
+
+	points := hub.historyOf(siteID, time.Duration(minutes)*time.Minute)
+	interval := int64(historyInterval.Seconds())
+	if value := params.Get("resolution"); value != "" {
+		bucket, ok := historyResolutions[value]
+		if !ok {
+			http.Error(w, fmt.Sprintf("不支持的分辨率 %q，可用 1m 或 5m", value), http.StatusBadRequest)
+			return
+		}
+		var err error
+		points, err = downsampleHistory(points, bucket, getParam(params, "agg", "max"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		interval = bucket
+	}
+
+	if params.Get("encoding") == "delta" {
+		writeJSON(w, http.StatusOK, encodeDeltas(points, interval))
+		return
+	}
+	writeJSON(w, http.StatusOK, points)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 每 10 秒一个采样点，从一分钟的第 40 秒开始，覆盖不满一段的开头、平稳段与清空
+func historyFixture() []HistoryPoint {
+	counts := []int{3, 3, 3, 5, 5, 4, 4, 4, 4, 4, 4, 0, 0, 2, 7, 7, 7, 7}
+	points := make([]HistoryPoint, len(counts))
+	for i, count := range counts {
+		points[i] = HistoryPoint{T: 1720000000 + int64(i)*10, Count: count}
+	}
+	return points
+}
+
+func TestDownsampleHistoryGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []HistoryPoint
+		bucket int64
+		agg    string
+	}{
+		{"1m-max", historyFixture(), 60, "max"},
+		{"1m-mean", historyFixture(), 60, "mean"},
+		{"5m-max", historyFixture(), 300, "max"},
+		{"empty", nil, 60, "max"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := downsampleHistory(tt.points, tt.bucket, tt.agg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "history/downsample-"+tt.name+".json", goldenJSON(t, points))
+		})
+	}
+}
+
+func TestDownsampleHistoryInvalid(t *testing.T) {
+	if _, err := downsampleHistory(historyFixture(), 60, "min"); err == nil {
+		t.Error("不支持的聚合方式应返回错误")
+	}
+	if _, err := downsampleHistory(historyFixture(), 0, "max"); err == nil {
+		t.Error("聚合间隔为 0 应返回错误")
+	}
+}
+
+func TestEncodeDeltasGolden(t *testing.T) {
+	downsampled, err := downsampleHistory(historyFixture(), 60, "max")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat := []HistoryPoint{{T: 100, Count: 2}, {T: 110, Count: 2}, {T: 120, Count: 2}}
+
+	tests := []struct {
+		name     string
+		points   []HistoryPoint
+		interval int64
+	}{
+		{"raw", historyFixture(), 10},
+		{"1m-max", downsampled, 60},
+		{"flat", flat, 10},
+		{"single", flat[:1], 10},
+		{"empty", nil, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeDeltas(tt.points, tt.interval)
+			checkGolden(t, "history/delta-"+tt.name+".json", goldenJSON(t, encoded))
+
+			// 解码后与原数据一致
+			if decoded := decodeDeltas(encoded); !equalHistory(decoded, tt.points) {
+				t.Fatalf("解码结果 %v 与原数据 %v 不一致", decoded, tt.points)
+			}
+		})
+	}
+}
+
+// 按文档描述的格式解码差分编码，用于校验编码可逆
+func decodeDeltas(encoded DeltaHistory) []HistoryPoint {
+	var points []HistoryPoint
+	if encoded.Start == 0 && len(encoded.Deltas) == 0 && encoded.First == 0 {
+		return points
+	}
+	t, count := encoded.Start, encoded.First
+	points = append(points, HistoryPoint{T: t, Count: count})
+	for i := 0; i < len(encoded.Deltas); i++ {
+		repeat := 1
+		if encoded.Deltas[i] == 0 {
+			i++
+			repeat = encoded.Deltas[i]
+		} else {
+			count += encoded.Deltas[i]
+		}
+		for ; repeat > 0; repeat-- {
+			t += encoded.Interval
+			points = append(points, HistoryPoint{T: t, Count: count})
+		}
+	}
+	return points
+}
+
+func equalHistory(a, b []HistoryPoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolution 只接受 1m 与 5m，降采样后的差分编码以分辨率为间隔
+func TestHistoryResolution(t *testing.T) {
+	h := startTestHub(t)
+	h.historyMutex.Lock()
+	ring := &historyRing{}
+	now := time.Now().Unix()
+	for i := int64(0); i < 12; i++ {
+		ring.add(HistoryPoint{T: now - 110 + i*10, Count: int(i)})
+	}
+	h.history["a.example"] = ring
+	h.historyMutex.Unlock()
+
+	get := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handleHistory(recorder, httptest.NewRequest("GET", "/api/history?siteId=a.example&"+query, nil))
+		return recorder
+	}
+
+	for _, value := range []string{"10m", "60", "1h"} {
+		if code := get("resolution=" + value).Code; code != http.StatusBadRequest {
+			t.Errorf("resolution=%s 返回 %d，应为 400", value, code)
+		}
+	}
+
+	var encoded DeltaHistory
+	recorder := get("resolution=1m&encoding=delta")
+	decodeJSON(t, recorder, &encoded)
+	if encoded.Interval != 60 {
+		t.Fatalf("降采样后的间隔为 %d，应为 60", encoded.Interval)
+	}
+	if encoded.Start%60 != 0 {
+		t.Fatalf("第一段的起点 %d 未对齐到分钟", encoded.Start)
+	}
+
+	var points []HistoryPoint
+	decodeJSON(t, get("resolution=5m&agg=max"), &points)
+	if len(points) == 0 || len(points) > 2 || points[len(points)-1].Count != 11 {
+		t.Fatalf("5m 降采样结果为 %v", points)
+	}
+}
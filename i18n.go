@@ -0,0 +1,194 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 额外语言包目录，其中的 <语言>.json 会覆盖或补充内置语言包
+var localesDir = flag.String("locales-dir", "", "额外语言包目录")
+
+// 内置语言包
+//
+//go:embed locales/*.json
+var bundledLocales embed.FS
+
+// 未匹配到语言时使用的默认语言
+const defaultLocale = "en"
+
+// 语言代码格式，例如 en、zh、pt-br
+var localeCodePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// 已加载的语言包
+var locales map[string]*Locale
+
+// 语言包
+type Locale struct {
+	Code     string
+	messages map[string]string
+	fallback *Locale
+}
+
+// 获取文案，缺失时依次回退到英文与键名
+func (l *Locale) T(key string) string {
+	for locale := l; locale != nil; locale = locale.fallback {
+		if message, exists := locale.messages[key]; exists {
+			return message
+		}
+	}
+	return key
+}
+
+// 获取一组文案，用于传给页面脚本
+func (l *Locale) Messages(prefix string) map[string]string {
+	result := make(map[string]string)
+	for locale := l; locale != nil; locale = locale.fallback {
+		for key, message := range locale.messages {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			name := strings.TrimPrefix(key, prefix)
+			if _, exists := result[name]; !exists {
+				result[name] = message
+			}
+		}
+	}
+	return result
+}
+
+// 加载内置语言包与 dir 中的语言包
+func loadLocales(dir string) (map[string]*Locale, error) {
+	catalogs := make(map[string]map[string]string)
+
+	bundled, err := fs.Glob(bundledLocales, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range bundled {
+		data, err := bundledLocales.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := mergeCatalog(catalogs, path, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("语言包目录 %s 中没有 .json 文件", dir)
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := mergeCatalog(catalogs, path, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if _, exists := catalogs[defaultLocale]; !exists {
+		return nil, fmt.Errorf("缺少默认语言包 %s", defaultLocale)
+	}
+
+	result := make(map[string]*Locale)
+	for code, messages := range catalogs {
+		result[code] = &Locale{Code: code, messages: messages}
+	}
+	for code, locale := range result {
+		if code != defaultLocale {
+			locale.fallback = result[defaultLocale]
+		}
+	}
+	return result, nil
+}
+
+// 解析单个语言包文件并合并到 catalogs
+func mergeCatalog(catalogs map[string]map[string]string, path string, data []byte) error {
+	code := strings.ToLower(strings.TrimSuffix(filepath.Base(path), ".json"))
+	if !localeCodePattern.MatchString(code) {
+		return fmt.Errorf("语言包 %s: 无效的语言代码 %q", path, code)
+	}
+
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return fmt.Errorf("语言包 %s: %v", path, err)
+	}
+	if format, exists := messages["widget.format"]; exists && !strings.Contains(format, "{count}") {
+		return fmt.Errorf("语言包 %s: widget.format 必须包含 {count}", path)
+	}
+
+	if catalogs[code] == nil {
+		catalogs[code] = make(map[string]string)
+	}
+	for key, message := range messages {
+		catalogs[code][key] = message
+	}
+	return nil
+}
+
+// 按 ?lang= 参数或 Accept-Language 选择语言，未匹配时使用英文
+func selectLocale(lang, acceptLanguage string) *Locale {
+	if locale := matchLocale(lang); locale != nil {
+		return locale
+	}
+
+	type candidate struct {
+		tag string
+		q   float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{tag, q})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	for _, c := range candidates {
+		if locale := matchLocale(c.tag); locale != nil {
+			return locale
+		}
+	}
+	return locales[defaultLocale]
+}
+
+// 匹配语言代码，先完整匹配再按主语言匹配
+func matchLocale(tag string) *Locale {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if tag == "" {
+		return nil
+	}
+	if locale, exists := locales[tag]; exists {
+		return locale
+	}
+	primary, _, _ := strings.Cut(tag, "-")
+	return locales[primary]
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 以查询参数与 Accept-Language 请求 handler
+func requestLocalized(handler http.HandlerFunc, target, acceptLanguage string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	if acceptLanguage != "" {
+		r.Header.Set("Accept-Language", acceptLanguage)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// 演示页面按 lang 参数或 Accept-Language 选择语言包，页面中只有该语言的文案
+func TestDemoPageLocales(t *testing.T) {
+	useTestSettings(t)
+	keys := []string{"demo.online.legend", "demo.intro.text", "demo.basic", "demo.advanced"}
+
+	tests := []struct {
+		target, acceptLanguage, code, other string
+	}{
+		{"/", "en-US,en;q=0.9", "en", "zh"},
+		{"/", "zh-CN,zh;q=0.9", "zh", "en"},
+		{"/?lang=zh", "en-US", "zh", "en"},
+		{"/?lang=en", "zh-CN", "en", "zh"},
+	}
+	for _, tt := range tests {
+		w := requestLocalized(handleDemoPage, tt.target, tt.acceptLanguage)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Language") != tt.code {
+			t.Fatalf("%s（%s）返回 %d，语言 %q，应为 %s", tt.target, tt.acceptLanguage, w.Code, w.Header().Get("Content-Language"), tt.code)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, `<html lang="`+tt.code+`">`) {
+			t.Fatalf("%s（%s）的页面语言不是 %s", tt.target, tt.acceptLanguage, tt.code)
+		}
+		for _, key := range keys {
+			if !strings.Contains(body, html.EscapeString(locales[tt.code].T(key))) {
+				t.Errorf("%s（%s）的页面中没有 %s 的 %s 文案", tt.target, tt.acceptLanguage, tt.code, key)
+			}
+			if strings.Contains(body, html.EscapeString(locales[tt.other].T(key))) {
+				t.Errorf("%s（%s）的页面中有 %s 的 %s 文案", tt.target, tt.acceptLanguage, tt.other, key)
+			}
+		}
+	}
+}
+
+// 挂件脚本中的标签与格式按语言生成，lang 参数优先于 Accept-Language；显式参数覆盖语言包
+func TestWidgetLocales(t *testing.T) {
+	useTestSettings(t)
+
+	tests := []struct {
+		query, acceptLanguage, lang, label string
+	}{
+		{"siteId=a.example", "en-US,en;q=0.9", "en", "Visitors online"},
+		{"siteId=a.example", "zh-CN,zh;q=0.9", "zh", "当前在线人数"},
+		{"siteId=a.example&lang=zh", "en-US", "zh", "当前在线人数"},
+		{"siteId=a.example&lang=zh&label=Now", "", "zh", "Now"},
+	}
+	for _, tt := range tests {
+		w := requestLocalized(handleJavaScript, "/liveuser.js?"+tt.query, tt.acceptLanguage)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s 返回 %d", tt.query, w.Code)
+		}
+		body := w.Body.String()
+		for _, want := range []string{"lang: '" + tt.lang + "'", "label: '" + tt.label + "'", "format: '{count}'"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s（%s）生成的脚本中没有 %q", tt.query, tt.acceptLanguage, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"time"
+)
+
+// 单个 IP 的连接限制，0 表示不限制
+var (
+	ipMaxConns = flag.Int("ip-max-conns", 10, "单个 IP 同时保持的 WebSocket 连接数上限（0 不限制）")
+	ipMaxRate  = flag.Int("ip-max-rate", 60, "单个 IP 每分钟新建的 WebSocket 连接数上限（0 不限制）")
+)
+
+// 实例的 WebSocket 连接总数上限，0 表示不限制
+var maxConnections = flag.Int("max-connections", 0, "实例同时保持的 WebSocket 连接总数上限（0 不限制），达到上限时握手返回 503")
+
+// 新建连接数的统计窗口
+const ipRateWindow = time.Minute
+
+// 实例连接数已满时建议客户端等待的秒数
+const serverFullRetryAfter = 30
+
+// 超过单 IP 连接限制
+var (
+	ErrTooManyConnections = errors.New("该 IP 的连接数已达上限")
+	ErrConnectionRate     = errors.New("该 IP 新建连接过于频繁")
+)
+
+// 单个 IP 的连接记录
+type ipLimit struct {
+	active int
+
+	// 当前窗口内新建的连接数
+	windowStart time.Time
+	opened      int
+
+	// 当前窗口内被拒绝的次数，只在第一次拒绝时打印日志
+	rejected int
+}
+
+// 为 IP 占用一个连接名额，超过限制时返回错误
+//
+// addr 为 getRealIP 的结果（不带端口）；
+// 名额在连接关闭时通过 releaseIP 归还。
+func (h *Hub) acquireIP(addr string, now time.Time) error {
+	ip := dedupKey(addr)
+	h.ipMutex.Lock()
+	defer h.ipMutex.Unlock()
+
+	limit, exists := h.ipLimits[ip]
+	if !exists {
+		limit = &ipLimit{windowStart: now}
+		h.ipLimits[ip] = limit
+	}
+	if now.Sub(limit.windowStart) >= ipRateWindow {
+		limit.windowStart, limit.opened, limit.rejected = now, 0, 0
+	}
+
+	var err error
+	switch {
+	case *ipMaxConns > 0 && limit.active >= *ipMaxConns:
+		err = ErrTooManyConnections
+	case *ipMaxRate > 0 && limit.opened >= *ipMaxRate:
+		err = ErrConnectionRate
+	}
+	if err != nil {
+		h.ipRejected.Add(1)
+		limit.rejected++
+		if limit.rejected == 1 {
+			log.Printf("警告: 拒绝来自 %s 的连接: %v（在线 %d，本分钟新建 %d）", ip, err, limit.active, limit.opened)
+		}
+		return err
+	}
+
+	limit.active++
+	limit.opened++
+	return nil
+}
+
+// 占用一个实例连接名额，达到 -max-connections 时返回 ErrServerFull
+//
+// 在升级前占用，并发握手不会越过上限；升级失败或连接关闭时通过 releaseConn 归还。
+func (h *Hub) acquireConn() error {
+	count := h.wsConns.Add(1)
+	if *maxConnections > 0 && count > int64(*maxConnections) {
+		h.wsConns.Add(-1)
+		h.connsRejected.Add(1)
+		return ErrServerFull
+	}
+	return nil
+}
+
+// 归还实例连接名额
+func (h *Hub) releaseConn() {
+	h.wsConns.Add(-1)
+}
+
+// 连接关闭后归还名额
+func (h *Hub) releaseIP(addr string) {
+	h.ipMutex.Lock()
+	defer h.ipMutex.Unlock()
+
+	if limit, exists := h.ipLimits[dedupKey(addr)]; exists && limit.active > 0 {
+		limit.active--
+	}
+}
+
+// 清理没有连接且窗口已过期的 IP
+func (h *Hub) sweepIPLimits(now time.Time) {
+	h.ipMutex.Lock()
+	defer h.ipMutex.Unlock()
+
+	for ip, limit := range h.ipLimits {
+		if limit.active == 0 && now.Sub(limit.windowStart) >= ipRateWindow {
+			delete(h.ipLimits, ip)
+		}
+	}
+}
+
+// 正在跟踪的 IP 数
+func (h *Hub) trackedIPs() int {
+	h.ipMutex.Lock()
+	defer h.ipMutex.Unlock()
+	return len(h.ipLimits)
+}
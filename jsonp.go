@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+)
+
+// JSONP 回调名的最大长度
+const maxJSONPCallbackLen = 64
+
+// JSONP 回调名：JavaScript 标识符，可用点号访问属性（如 LiveUser.onCount），不允许括号、引号等字符
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// 请求的 JSONP 回调名，未携带 callback 参数时返回空字符串；回调名无效时 ok 为 false
+func jsonpCallback(r *http.Request) (callback string, ok bool) {
+	callback = r.URL.Query().Get("callback")
+	if callback == "" {
+		return "", true
+	}
+	if len(callback) > maxJSONPCallbackLen || !jsonpCallbackPattern.MatchString(callback) {
+		return "", false
+	}
+	return callback, true
+}
+
+// 以 JSONP 写入响应：callback({...});，callback 为空时与 writeJSON 相同
+//
+// JSON 编码会转义 <、>、& 与 U+2028/U+2029，内容无法提前结束 script 标签或语句。
+func writeJSONP(w http.ResponseWriter, status int, callback string, v interface{}) {
+	if callback == "" {
+		writeJSON(w, status, v)
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write([]byte(callback + "(" + string(data) + ");\n"))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// 挂件脚本模板可以读取的数据，只包含可以下发给浏览器的字段
+//
+// 模板只能引用这里的字段；不要把服务端配置、密钥或内部地址加进来。
+type TemplateData struct {
+	ServerURL        string
+	SiteID           string
+	DisplayElementID string
+	ReconnectDelay   int
+	Debug            bool
+	TestCount        int
+	Lang             string
+	Format           string
+	FormatOne        string
+	HideBelow        int
+	Label            string
+	SSEFallback      bool
+	Dedup            string
+	IdleTimeout      int
+	Observe          bool
+	Path             string
+	Token            string
+	APIKey           string
+
+	// 重连退避的上限（毫秒）、倍数与抖动比例
+	ReconnectMaxDelay   int
+	ReconnectMultiplier float64
+	ReconnectJitter     float64
+
+	// 生成脚本的服务器版本
+	ServerVersion string
+
+	// 站点挂件配置的 JSON，由服务器编码
+	Widget string
+
+	// 内置脚本的版本，挂件在 join 中上报
+	ScriptVersion string
+}
+
+// 挂件脚本模板允许调用的函数，均为 text/template 的转义函数
+var templateFuncs = []string{"html", "js", "urlquery"}
+
+// 模板使用的数据
+func (c JSConfig) templateData() TemplateData {
+	return TemplateData{
+		ServerURL:        c.ServerURL,
+		SiteID:           c.SiteID,
+		DisplayElementID: c.DisplayElementID,
+		ReconnectDelay:   c.ReconnectDelay,
+		Debug:            c.Debug,
+		TestCount:        c.TestCount,
+		Lang:             c.Lang,
+		Format:           c.Format,
+		FormatOne:        c.FormatOne,
+		HideBelow:        c.HideBelow,
+		Label:            c.Label,
+		SSEFallback:      c.SSEFallback,
+		Dedup:            c.Dedup,
+		IdleTimeout:      c.IdleTimeout,
+		Observe:          c.Observe,
+		Path:             c.Path,
+		Token:            c.Token,
+		APIKey:           c.APIKey,
+		Widget:           c.Widget,
+		ScriptVersion:    scriptVersion,
+
+		ReconnectMaxDelay:   c.ReconnectMaxDelay,
+		ReconnectMultiplier: c.ReconnectMultiplier,
+		ReconnectJitter:     c.ReconnectJitter,
+
+		ServerVersion: c.ServerVersion,
+	}
+}
+
+// 解析挂件脚本模板，并校验只引用 TemplateData 的字段与允许的函数
+func parseJSTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool)
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(TemplateData{})) {
+		fields[field.Name] = true
+	}
+	funcs := make(map[string]bool)
+	for _, fn := range templateFuncs {
+		funcs[fn] = true
+	}
+
+	checker := &templateChecker{fields: fields, funcs: funcs}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+		checker.tree = t.Tree
+		if err := checker.check(t.Tree.Root); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// 模板语法树校验
+type templateChecker struct {
+	tree   *parse.Tree
+	fields map[string]bool
+	funcs  map[string]bool
+}
+
+// 带模板位置的错误
+func (c *templateChecker) errorf(node parse.Node, format string, args ...interface{}) error {
+	location, _ := c.tree.ErrorContext(node)
+	return fmt.Errorf("模板 %s: %s", location, fmt.Sprintf(format, args...))
+}
+
+// 允许的字段列表，用于错误提示
+func (c *templateChecker) allowedFields() string {
+	names := make([]string, 0, len(c.fields))
+	for name := range c.fields {
+		names = append(names, "."+name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// 字段引用只能是 TemplateData 的一级字段
+func (c *templateChecker) checkField(node parse.Node, idents []string) error {
+	if len(idents) != 1 || !c.fields[idents[0]] {
+		return c.errorf(node, "不允许的字段 .%s，可用字段: %s", strings.Join(idents, "."), c.allowedFields())
+	}
+	return nil
+}
+
+func (c *templateChecker) check(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := c.check(child); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return c.check(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		if len(n.Decl) > 0 {
+			return c.errorf(n, "模板中不允许声明变量")
+		}
+		for _, cmd := range n.Cmds {
+			if err := c.check(cmd); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := c.check(arg); err != nil {
+				return err
+			}
+		}
+	case *parse.IdentifierNode:
+		if !c.funcs[n.Ident] {
+			return c.errorf(n, "不允许的函数 %s，可用函数: %s", n.Ident, strings.Join(templateFuncs, ", "))
+		}
+	case *parse.FieldNode:
+		return c.checkField(n, n.Ident)
+	case *parse.VariableNode:
+		if n.Ident[0] != "$" || len(n.Ident) == 1 {
+			return c.errorf(n, "模板中不允许使用变量 %s", strings.Join(n.Ident, "."))
+		}
+		return c.checkField(n, n.Ident[1:])
+	case *parse.ChainNode:
+		return c.errorf(n, "不允许的字段访问 %s", n)
+	case *parse.DotNode:
+		return c.errorf(n, "不允许直接输出 .，请引用具体字段，可用字段: %s", c.allowedFields())
+	case *parse.IfNode:
+		return c.checkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return c.errorf(n, "模板中不允许使用 range")
+	case *parse.WithNode:
+		return c.errorf(n, "模板中不允许使用 with")
+	case *parse.TemplateNode:
+		return c.errorf(n, "模板中不允许使用 template 引用其他模板")
+	case *parse.TextNode, *parse.CommentNode, *parse.StringNode, *parse.NumberNode, *parse.BoolNode, *parse.NilNode:
+	default:
+		return c.errorf(node, "模板中不允许使用 %s", node)
+	}
+	return nil
+}
+
+func (c *templateChecker) checkBranch(n *parse.BranchNode) error {
+	if err := c.check(n.Pipe); err != nil {
+		return err
+	}
+	if err := c.check(n.List); err != nil {
+		return err
+	}
+	return c.check(n.ElseList)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 来自查询参数的字符串都经过 JavaScript 转义，构造的参数无法提前结束字符串字面量注入脚本
+func TestScriptEscapesQueryParams(t *testing.T) {
+	startTestHub(t)
+
+	payload := `x');alert(1);//</script><script>alert(2)//`
+	params := url.Values{"siteId": {"a.example"}}
+	for _, name := range []string{"serverUrl", "displayElementId", "displayFormat", "displayFormatOne", "label", "path", "token"} {
+		params.Set(name, payload)
+	}
+	req := httptest.NewRequest("GET", "/liveuser.js?"+params.Encode(), nil)
+	rec := httptest.NewRecorder()
+	handleJavaScript(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("状态码 %d: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	for _, raw := range []string{"x');", "</script><script>"} {
+		if strings.Contains(body, raw) {
+			t.Errorf("生成的脚本包含未转义的 %q", raw)
+		}
+	}
+	for _, field := range []string{"serverUrl", "displayElementId", "format"} {
+		if !strings.Contains(body, field+`: 'x\'`) {
+			t.Errorf("%s 未按转义后的字符串写入", field)
+		}
+	}
+}
+
+// 内置模板与只引用 TemplateData 一级字段、允许函数的模板可以解析
+func TestParseJSTemplateAccepts(t *testing.T) {
+	for _, text := range []string{
+		mainJS,
+		`var id = '{{js .SiteID}}';`,
+		`{{.SiteID | js}}{{urlquery .Path}}{{html .Label}}`,
+		`{{if .Debug}}debug{{else if .Observe}}observe{{else}}{{$.Lang}}{{end}}`,
+		`{{/* 注释 */}}{{.ReconnectDelay}} {{"text"}} {{1.5}} {{true}}`,
+	} {
+		if _, err := parseJSTemplate("test", text); err != nil {
+			t.Errorf("%.60q 解析失败: %v", text, err)
+		}
+	}
+}
+
+// 恶意或过期的模板在解析时拒绝：未知字段、嵌套字段、变量、不允许或已删除的函数与控制结构，
+// 错误带有模板中的位置
+func TestParseJSTemplateRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"未知字段", `x = {{.AdminToken}}`, "不允许的字段 .AdminToken"},
+		{"分支中的未知字段", `{{if .Debug}}{{.Secret}}{{end}}`, "不允许的字段 .Secret"},
+		{"else 中的未知字段", `{{if .Debug}}a{{else}}{{.Secret}}{{end}}`, "不允许的字段 .Secret"},
+		{"嵌套字段", `{{.SiteID.Len}}`, "不允许的字段 .SiteID.Len"},
+		{"经 $ 的嵌套字段", `{{$.Widget.Key}}`, "不允许的字段 .Widget.Key"},
+		{"链式访问", `{{(.SiteID).Len}}`, "不允许的字段访问"},
+		{"声明变量", `{{$x := .SiteID}}{{$x}}`, "不允许声明变量"},
+		{"直接输出 .", `{{.}}`, "不允许直接输出 ."},
+		{"printf", `{{printf "%s" .SiteID}}`, "不允许的函数 printf"},
+		{"call", `{{call .SiteID}}`, "不允许的函数 call"},
+		{"管道中的 index", `{{.Widget | index}}`, "不允许的函数 index"},
+		{"range", `{{range .Path}}x{{end}}`, "不允许使用 range"},
+		{"with", `{{with .Token}}x{{end}}`, "不允许使用 with"},
+		{"template", `{{define "inner"}}{{.SiteID}}{{end}}{{template "inner" .}}`, "不允许使用 template"},
+		{"已删除的函数", `{{toJSON .Widget}}`, `function "toJSON" not defined`},
+		{"语法错误", `{{.SiteID`, "unclosed action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseJSTemplate("hostile.js", tt.text)
+			if err == nil {
+				t.Fatalf("%q 应解析失败", tt.text)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("错误为 %q，应包含 %q", err, tt.want)
+			}
+			if !strings.Contains(err.Error(), "hostile.js:1:") {
+				t.Fatalf("错误 %q 没有模板位置", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 换发站点密钥后旧密钥继续有效的时长
+var keyGrace = flag.Duration("key-grace", 7*24*time.Hour, "换发站点密钥后旧密钥继续有效的时长，0 表示直到手动移除")
+
+// 密钥校验结果
+const (
+	keyCurrent  = "current"
+	keyPrevious = "previous"
+	keyInvalid  = "invalid"
+)
+
+// 站点密钥的校验次数
+type siteKeyUsage struct {
+	current          int64
+	previous         int64
+	previousLastUsed time.Time
+}
+
+// 站点密钥校验统计，按站点索引，只记录校验通过的请求
+var keyVerifications = struct {
+	mutex  sync.Mutex
+	totals map[string]int64
+	sites  map[string]*siteKeyUsage
+}{totals: make(map[string]int64), sites: make(map[string]*siteKeyUsage)}
+
+// 记录一次校验
+func recordKeyVerification(siteID, result string) {
+	keyVerifications.mutex.Lock()
+	defer keyVerifications.mutex.Unlock()
+
+	keyVerifications.totals[result]++
+	if result == keyInvalid {
+		return
+	}
+	usage, exists := keyVerifications.sites[siteID]
+	if !exists {
+		usage = &siteKeyUsage{}
+		keyVerifications.sites[siteID] = usage
+	}
+	if result == keyPrevious {
+		usage.previous++
+		usage.previousLastUsed = time.Now()
+	} else {
+		usage.current++
+	}
+}
+
+// 各校验结果的次数
+func keyVerificationTotals() map[string]int64 {
+	keyVerifications.mutex.Lock()
+	defer keyVerifications.mutex.Unlock()
+
+	totals := make(map[string]int64, 3)
+	for _, result := range []string{keyCurrent, keyPrevious, keyInvalid} {
+		totals[result] = keyVerifications.totals[result]
+	}
+	return totals
+}
+
+// 密钥轮换中的站点
+type KeyRotationInfo struct {
+	SiteID   string `json:"siteId"`
+	Current  int64  `json:"current"`
+	Previous int64  `json:"previous"`
+
+	// 最近一次使用旧密钥的时间（Unix 秒），未使用过为 0
+	PreviousLastUsed int64 `json:"previousLastUsed,omitempty"`
+
+	// 旧密钥的迁移期限（Unix 秒），没有期限时为 0
+	PreviousExpiresAt int64 `json:"previousExpiresAt,omitempty"`
+}
+
+// 列出配置了旧密钥的站点及本次启动以来两个密钥的校验次数
+func keyRotations() []KeyRotationInfo {
+	var rotations []KeyRotationInfo
+	for _, siteID := range settings.Rotating() {
+		rotation := KeyRotationInfo{SiteID: siteID}
+		if expiresAt := settings.Get(siteID).PreviousKeyExpiresAt; expiresAt != nil {
+			rotation.PreviousExpiresAt = expiresAt.Unix()
+		}
+		rotations = append(rotations, rotation)
+	}
+
+	keyVerifications.mutex.Lock()
+	for i := range rotations {
+		if usage, exists := keyVerifications.sites[rotations[i].SiteID]; exists {
+			rotations[i].Current = usage.current
+			rotations[i].Previous = usage.previous
+			if !usage.previousLastUsed.IsZero() {
+				rotations[i].PreviousLastUsed = usage.previousLastUsed.Unix()
+			}
+		}
+	}
+	keyVerifications.mutex.Unlock()
+
+	sort.Slice(rotations, func(i, j int) bool { return rotations[i].SiteID < rotations[j].SiteID })
+	return rotations
+}
+
+// 处理密钥轮换查询：GET /admin/api/keys
+//
+// 列出仍保留旧密钥的站点；旧密钥的校验次数不再增长后即可移除 previousKey。
+// DELETE /admin/api/keys?siteId=xxx 移除该站点的旧密钥。
+func handleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		rotations := keyRotations()
+		if rotations == nil {
+			rotations = []KeyRotationInfo{}
+		}
+		writeJSON(w, http.StatusOK, rotations)
+	case "DELETE":
+		siteID := strings.TrimSpace(r.URL.Query().Get("siteId"))
+		if siteID == "" || settings.Get(siteID).PreviousKey == "" {
+			http.NotFound(w, r)
+			return
+		}
+		err := settings.Update(siteID, func(site *SiteSettings) {
+			site.PreviousKey, site.PreviousKeyExpiresAt = "", nil
+		})
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 以站点密钥查询私有站点人数的状态码
+func countWithKey(siteID, key string) int {
+	w := httptest.NewRecorder()
+	handleCount(w, httptest.NewRequest("GET", "/api/count?siteId="+siteID+"&key="+key, nil))
+	return w.Code
+}
+
+// 凭当前密钥换发新密钥
+func reissueKey(t *testing.T, siteID, key string) string {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/claim", strings.NewReader(`{"siteId":"`+siteID+`"}`))
+	r.Header.Set("X-Site-Key", key)
+	w := httptest.NewRecorder()
+	handleClaim(w, r)
+	var info ClaimInfo
+	decodeJSON(t, w, &info)
+	return info.Key
+}
+
+// 轮换前签发的密钥在 -key-grace 内仍可通过校验，期限过后被拒绝；新密钥始终有效
+func TestPreviousKeyGrace(t *testing.T) {
+	startTestHub(t)
+	settings = loadTestSettings(t, `{"sites":{"a.example":{"key":"old-key","private":true}}}`)
+	setFlag(t, "key-grace", "1h")
+
+	newKey := reissueKey(t, "a.example", "old-key")
+	if newKey == "" || newKey == "old-key" {
+		t.Fatalf("换发的密钥为 %q", newKey)
+	}
+	site := settings.Get("a.example")
+	if site.PreviousKey != "old-key" || site.PreviousKeyExpiresAt == nil ||
+		site.PreviousKeyExpiresAt.Sub(time.Now().Add(time.Hour)).Abs() > time.Minute {
+		t.Fatalf("换发后旧密钥为 %q，期限 %v，应保留 1 小时", site.PreviousKey, site.PreviousKeyExpiresAt)
+	}
+	for _, key := range []string{"old-key", newKey} {
+		if code := countWithKey("a.example", key); code != http.StatusOK {
+			t.Fatalf("迁移期内以 %s 查询返回 %d", key, code)
+		}
+	}
+	if matchSiteKey("a.example", "old-key") != keyPrevious {
+		t.Fatal("迁移期内旧密钥未计为 previous")
+	}
+	rotations := keyRotations()
+	if len(rotations) != 1 || rotations[0].PreviousExpiresAt != site.PreviousKeyExpiresAt.Unix() {
+		t.Fatalf("轮换列表为 %+v", rotations)
+	}
+
+	// 迁移期限已过
+	err := settings.Update("a.example", func(site *SiteSettings) {
+		expired := time.Now().Add(-time.Second)
+		site.PreviousKeyExpiresAt = &expired
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := countWithKey("a.example", "old-key"); code != http.StatusUnauthorized {
+		t.Fatalf("期限过后以旧密钥查询返回 %d，应为 401", code)
+	}
+	if code := countWithKey("a.example", newKey); code != http.StatusOK {
+		t.Fatalf("期限过后以新密钥查询返回 %d", code)
+	}
+
+	// -key-grace 0：旧密钥直到移除前都有效
+	setFlag(t, "key-grace", "0")
+	latest := reissueKey(t, "a.example", newKey)
+	if site := settings.Get("a.example"); site.PreviousKey != newKey || site.PreviousKeyExpiresAt != nil {
+		t.Fatalf("-key-grace 0 时旧密钥为 %q，期限 %v", site.PreviousKey, site.PreviousKeyExpiresAt)
+	}
+	for _, key := range []string{newKey, latest} {
+		if code := countWithKey("a.example", key); code != http.StatusOK {
+			t.Fatalf("以 %s 查询返回 %d", key, code)
+		}
+	}
+}
+
+// Webhook 以站点的当前密钥签名，重新加载配置换成新密钥后，下一条通知立即使用新密钥
+func TestWebhookSignedWithCurrentKey(t *testing.T) {
+	useTestSettings(t)
+	settings = loadTestSettings(t, `{"sites":{"a.example":{"key":"old-key"}}}`)
+
+	type delivery struct {
+		body      []byte
+		signature string
+	}
+	deliveries := make(chan delivery, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{body, r.Header.Get(webhookSignatureHeader)}
+	}))
+	t.Cleanup(server.Close)
+	setFlag(t, "webhook-url", server.URL)
+	setFlag(t, "webhook-threshold", "1")
+	n, err := newWebhookNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go n.run()
+	t.Cleanup(func() { n.Close(context.Background()) })
+
+	receive := func(what, key string) {
+		t.Helper()
+		select {
+		case d := <-deliveries:
+			var payload WebhookPayload
+			if err := json.Unmarshal(d.body, &payload); err != nil || payload.SiteID != "a.example" {
+				t.Fatalf("%s: 请求体为 %s", what, d.body)
+			}
+			if want := webhookSignature(key, d.body); d.signature != want {
+				t.Fatalf("%s: 签名为 %q，应为以 %s 计算的 %q", what, d.signature, key, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s: 没有收到通知", what)
+		}
+	}
+
+	now := time.Now()
+	n.observe("a.example", 1, now)
+	receive("轮换前", "old-key")
+
+	// 与 SIGHUP 相同，重新读取配置文件
+	if err := os.WriteFile(settings.path, []byte(`{"sites":{"a.example":{"key":"new-key","previousKey":"old-key"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := settings.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	n.observe("a.example", 0, now.Add(n.cooldown))
+	receive("重新加载后", "new-key")
+
+	// 没有密钥的站点不签名
+	n.observe("b.example", 1, now)
+	select {
+	case d := <-deliveries:
+		if d.signature != "" {
+			t.Fatalf("没有密钥的站点带有签名 %q", d.signature)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("没有收到 b.example 的通知")
+	}
+}
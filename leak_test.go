@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/goleak"
+)
+
+// 测试结束、其余清理都完成后检查没有遗留的协程，只比较本测试开始后新建的；须在 startTestHub 之前调用
+func verifyNoLeaks(t *testing.T) {
+	t.Helper()
+	ignore := goleak.IgnoreCurrent()
+	t.Cleanup(func() { goleak.VerifyNone(t, ignore) })
+}
+
+// 等连接协程全部退出后停止 Hub，检查站点、连接名额、按 IP 的连接记录、信标、长轮询与事件流都已释放
+func expectReleased(t *testing.T, h *Hub) {
+	t.Helper()
+
+	waitPumps(t, h, 5*time.Second)
+	h.Stop()
+
+	h.ipMutex.Lock()
+	active := 0
+	for _, limit := range h.ipLimits {
+		active += limit.active
+	}
+	h.ipMutex.Unlock()
+	// 新建连接数的窗口过后 IP 记录随清理删除
+	h.sweepIPLimits(time.Now().Add(ipRateWindow))
+
+	leftovers := []struct {
+		what string
+		n    int
+	}{
+		{"站点", len(h.sites)},
+		{"计入人数的连接", int(h.total.Load())},
+		{"实例连接名额", int(h.wsConns.Load())},
+		{"登记的 WebSocket 连接", len(h.wsClients)},
+		{"IP 占用的连接名额", active},
+		{"IP 记录", h.trackedIPs()},
+		{"信标站点", len(h.beaconSites)},
+		{"信标在线记录", int(h.beaconPresences.Load())},
+		{"长轮询会话", len(h.polls)},
+		{"事件流订阅", len(h.feeds)},
+		{"全站人数流订阅", len(h.adminFeeds)},
+		{"全站总数订阅", len(h.totalSubscribers)},
+		{"排队中的命令", len(h.queues.pending)},
+	}
+	for _, leftover := range leftovers {
+		if leftover.n != 0 {
+			t.Errorf("仍有 %d 个%s未释放", leftover.n, leftover.what)
+		}
+	}
+}
+
+// 读取直到连接被服务端关闭，返回关闭码
+func (c *testClient) expectClosed() int {
+	c.t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_, err := c.read(time.Until(deadline))
+		if err == nil {
+			continue
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("连接没有被关闭: %v", err)
+		}
+		return closeCode(err)
+	}
+}
+
+// 建立连接但从不加入站点
+func TestLeakNeverJoin(t *testing.T) {
+	verifyNoLeaks(t)
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	for i := 0; i < 5; i++ {
+		client := dialTestClient(t, server, "")
+		// 握手返回时服务端可能还没登记读写协程
+		eventually(t, "连接登记", func() bool { return len(h.trackedClients()) == 1 })
+		client.close()
+		eventually(t, "连接退出", func() bool { return len(h.trackedClients()) == 0 })
+	}
+	expectReleased(t, h)
+}
+
+// 反复加入、切换站点与离开，连接与会话都逐一释放
+func TestLeakJoinLeaveLoop(t *testing.T) {
+	verifyNoLeaks(t)
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	for i := 0; i < 20; i++ {
+		client := dialTestClient(t, server, "")
+		client.joinAs("a.example", "visitor-1", "browser-1")
+		client.joinAs("b.example", "visitor-1", "browser-1")
+		if i%2 == 0 {
+			client.send(Message{Type: "unjoin", SiteID: "b.example"})
+			client.expect("unjoined")
+		}
+		client.close()
+	}
+	expectReleased(t, h)
+}
+
+// 写入很慢的连接队列积压后被广播丢弃，读写协程随之退出
+func TestLeakSlowClientEviction(t *testing.T) {
+	verifyNoLeaks(t)
+	setFlag(t, "ip-max-conns", "0")
+	setFlag(t, "ip-max-rate", "0")
+	setFlag(t, "slow-write-strikes", "0")
+	setFlag(t, "write-timeout", "10s")
+	h := startTestHub(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(handleRequest))
+	server.Listener = &throttledListener{Listener: server.Listener, delay: 20 * time.Millisecond}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client := dialTestClient(t, server, "")
+	client.join("a.example")
+	for i := 0; h.counters.droppedFrames.Load() == 0; i++ {
+		if i == 1000 {
+			t.Fatal("队列一直没有积压")
+		}
+		h.broadcastToSite("a.example", 1, 0)
+	}
+	client.expectClosed()
+	expectReleased(t, h)
+}
+
+// 临时站点到期：连接以 4410 断开；保留期过后过期标记也被清除
+func TestLeakSiteExpiry(t *testing.T) {
+	verifyNoLeaks(t)
+	setFlag(t, "expired-retention", "1s")
+	h := startTestHub(t)
+	expiresAt := time.Now().Add(time.Second).Format(time.RFC3339Nano)
+	settings = loadTestSettings(t, `{"sites":{"a.example":{"expiresAt":"`+expiresAt+`"}}}`)
+	server := startTestServer(t)
+
+	client := dialTestClient(t, server, "")
+	client.join("a.example")
+	client.expect("expired")
+	if code := client.expectClosed(); code != errorCloseCode(ErrSiteExpired) {
+		t.Fatalf("关闭码为 %d，应为 %d", code, errorCloseCode(ErrSiteExpired))
+	}
+	eventually(t, "过期标记被清除", func() bool { return len(settings.Expiring()) == 0 })
+	expectReleased(t, h)
+}
+
+// 管理员断开与移除站点
+func TestLeakAdminKick(t *testing.T) {
+	verifyNoLeaks(t)
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	for _, remove := range []bool{false, true} {
+		clients := []*testClient{dialTestClient(t, server, ""), dialTestClient(t, server, "")}
+		for _, client := range clients {
+			client.join("a.example")
+		}
+		w := httptest.NewRecorder()
+		handleAdminSiteDisconnect(w, httptest.NewRequest("POST", "/admin/api/sites/a.example/disconnect", nil), "a.example", remove)
+		if w.Code != http.StatusOK {
+			t.Fatalf("断开站点返回 %d: %s", w.Code, w.Body.String())
+		}
+		for _, client := range clients {
+			client.expectClosed()
+		}
+	}
+	expectReleased(t, h)
+}
+
+// 长轮询离开与超时、信标到期、站长事件流断开
+func TestLeakPollBeaconFeed(t *testing.T) {
+	verifyNoLeaks(t)
+	setFlag(t, "poll-timeout", "1s")
+	setFlag(t, "beacon-ttl", "1s")
+	h := startTestHub(t)
+	settings = loadTestSettings(t, `{"sites":{"a.example":{"key":"secret"}}}`)
+
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handlePollJoin(w, httptest.NewRequest("POST", "/poll/join", strings.NewReader(`{"siteId":"a.example"}`)))
+		var info PollJoinInfo
+		decodeJSON(t, w, &info)
+		tokens = append(tokens, info.Token)
+	}
+	// 第一个会话主动离开，第二个等待超时
+	w := httptest.NewRecorder()
+	handlePollLeave(w, httptest.NewRequest("POST", "/poll/leave?token="+tokens[0], nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("长轮询离开返回 %d", w.Code)
+	}
+
+	hitBeacon(t, "a.example", "192.0.2.1")
+
+	feedServer := httptest.NewServer(http.HandlerFunc(handleOwnerWS))
+	t.Cleanup(feedServer.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(feedServer.URL, "http")+"/?siteId=a.example&key=secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot FeedEvent
+	if err := conn.ReadJSON(&snapshot); err != nil || snapshot.Type != "snapshot" {
+		t.Fatalf("事件流的第一条为 %+v（%v）", snapshot, err)
+	}
+	conn.Close()
+
+	eventually(t, "长轮询与信标都已离开", func() bool { return siteCount(h, "a.example") == -1 })
+	expectReleased(t, h)
+}
+
+// 写入响应头即失败的 ResponseWriter，模拟 SSE 订阅者在站点创建后立即断开
+type failingFlushWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingFlushWriter) FlushError() error {
+	return errors.New("连接已断开")
+}
+
+// SSE 首次刷新失败时，为这次订阅创建的空站点被移出，不占用 -max-sites 名额
+func TestLeakSSEFlushFailure(t *testing.T) {
+	verifyNoLeaks(t)
+	setFlag(t, "max-sites", "1")
+	h := startTestHub(t)
+
+	for _, siteID := range []string{"a.example", "b.example"} {
+		r := httptest.NewRequest("GET", "/sse?siteId="+siteID, nil)
+		r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) LiveUserTest")
+		handleSSE(failingFlushWriter{httptest.NewRecorder()}, r)
+		eventually(t, siteID+" 被移出", func() bool { return siteCount(h, siteID) == -1 })
+	}
+	expectReleased(t, h)
+}
+
+// 完整的关闭流程：已加入与未加入的连接、长轮询、信标与事件流都在关闭后释放
+func TestLeakShutdown(t *testing.T) {
+	verifyNoLeaks(t)
+	h := startTestHub(t)
+	settings = loadTestSettings(t, `{"sites":{"a.example":{"key":"secret"}}}`)
+	server := startTestServer(t)
+
+	dialTestClient(t, server, "").join("a.example")
+	dialTestClient(t, server, "").join("b.example")
+	dialTestClient(t, server, "")
+	eventually(t, "未加入的连接登记", func() bool { return len(h.trackedClients()) == 3 })
+
+	w := httptest.NewRecorder()
+	handlePollJoin(w, httptest.NewRequest("POST", "/poll/join", strings.NewReader(`{"siteId":"a.example"}`)))
+	decodeJSON(t, w, &PollJoinInfo{})
+	hitBeacon(t, "a.example", "192.0.2.1")
+
+	feedServer := httptest.NewServer(http.HandlerFunc(handleOwnerWS))
+	t.Cleanup(feedServer.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(feedServer.URL, "http")+"/?siteId=a.example&key=secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// 收到快照说明事件流的读写协程已经登记
+	if err := conn.ReadJSON(&FeedEvent{}); err != nil {
+		t.Fatal(err)
+	}
+
+	runShutdown([]shutdownStage{
+		{"排空 WebSocket 连接", h.drain},
+		{"等待连接协程退出", h.waitPumps},
+		{"停止 Hub", func(ctx context.Context) error {
+			h.Stop()
+			return nil
+		}},
+	}, 5*time.Second)
+	expectReleased(t, h)
+}
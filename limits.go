@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+// 客户端消息的大小与字段限制
+var (
+	maxMessageBytes = flag.Int("max-message-bytes", 256, "客户端单条 WebSocket 消息的最大字节数，可被站点配置覆盖")
+	maxMetaKeys     = flag.Int("max-meta-keys", 16, "加入消息中 meta 的最大键数，可被站点配置覆盖")
+)
+
+// 站点配置可以设置的上限，WebSocket 连接的读取上限也取该值
+const messageBytesCap = 64 << 10
+
+// 客户端消息的生效限制
+//
+// 加入确认、发现文档与服务端校验都使用同一个 Limits，保证三者一致。
+type Limits struct {
+	MaxMessageBytes int `json:"maxMessageBytes"`
+	MaxMetaKeys     int `json:"maxMetaKeys"`
+	MaxMetaLen      int `json:"maxMetaLen"`
+	MaxVisitorIDLen int `json:"maxVisitorIdLen"`
+}
+
+// 站点配置中的限制，0 表示使用命令行参数
+type SiteLimits struct {
+	MaxMessageBytes int `json:"maxMessageBytes,omitempty"`
+	MaxMetaKeys     int `json:"maxMetaKeys,omitempty"`
+	MaxMetaLen      int `json:"maxMetaLen,omitempty"`
+}
+
+// 校验站点配置中的限制
+func (l *SiteLimits) check() error {
+	if l == nil {
+		return nil
+	}
+	if l.MaxMessageBytes < 0 || l.MaxMessageBytes > messageBytesCap {
+		return fmt.Errorf("limits.maxMessageBytes 须在 1 到 %d 之间", messageBytesCap)
+	}
+	if l.MaxMetaKeys < 0 || l.MaxMetaLen < 0 {
+		return fmt.Errorf("limits 不能为负数")
+	}
+	return nil
+}
+
+// 实例的默认限制，来自命令行参数
+func instanceLimits() Limits {
+	return Limits{
+		MaxMessageBytes: *maxMessageBytes,
+		MaxMetaKeys:     *maxMetaKeys,
+		MaxMetaLen:      *metaMaxLen,
+		MaxVisitorIDLen: maxVisitorIDLen,
+	}
+}
+
+// 站点的生效限制，siteId 为空时为实例默认值
+func siteLimits(siteID string) Limits {
+	limits := instanceLimits()
+	if siteID == "" {
+		return limits
+	}
+	if site := settings.Get(siteID).Limits; site != nil {
+		if site.MaxMessageBytes > 0 {
+			limits.MaxMessageBytes = site.MaxMessageBytes
+		}
+		if site.MaxMetaKeys > 0 {
+			limits.MaxMetaKeys = site.MaxMetaKeys
+		}
+		if site.MaxMetaLen > 0 {
+			limits.MaxMetaLen = site.MaxMetaLen
+		}
+	}
+	return limits
+}
+
+// 校验命令行参数
+func checkLimits() error {
+	if *maxMessageBytes <= 0 || *maxMessageBytes > messageBytesCap {
+		return fmt.Errorf("-max-message-bytes 须在 1 到 %d 之间", messageBytesCap)
+	}
+	if *maxMetaKeys <= 0 {
+		return fmt.Errorf("-max-meta-keys 须大于 0")
+	}
+	if *metaMaxLen <= 0 {
+		return fmt.Errorf("-meta-max-len 须大于 0")
+	}
+	if *ipMaxConns < 0 || *ipMaxRate < 0 {
+		return fmt.Errorf("-ip-max-conns 与 -ip-max-rate 不能为负数")
+	}
+	if *maxConnections < 0 || *maxSites < 0 {
+		return fmt.Errorf("-max-connections 与 -max-sites 不能为负数")
+	}
+	if *dailyDays < 0 {
+		return fmt.Errorf("-daily-days 不能为负数")
+	}
+	if *writeTimeout <= 0 {
+		return fmt.Errorf("-write-timeout 须大于 0")
+	}
+	if *slowWriteStrikes < 0 {
+		return fmt.Errorf("-slow-write-strikes 不能为负数")
+	}
+	if err := checkMinVersion(*minClientVersion); err != nil {
+		return fmt.Errorf("-min-client-version: %v", err)
+	}
+	if *alertInterval <= 0 {
+		return fmt.Errorf("-alert-interval 须大于 0")
+	}
+	return nil
+}
+
+// 校验消息大小
+func (l Limits) checkMessage(size int) error {
+	if size > l.MaxMessageBytes {
+		return &LimitError{Code: "message_too_large", Limit: "maxMessageBytes", Max: l.MaxMessageBytes}
+	}
+	return nil
+}
+
+// 校验访客 ID 长度（首尾空白不计入）
+func (l Limits) checkVisitorID(visitorID string) error {
+	if len(visitorID) > l.MaxVisitorIDLen {
+		return &LimitError{Code: "visitor_id_too_long", Limit: "maxVisitorIdLen", Max: l.MaxVisitorIDLen}
+	}
+	return nil
+}
+
+// 服务发现文档
+type WellKnownInfo struct {
+	Version string `json:"version"`
+	Limits  Limits `json:"limits"`
+}
+
+// 处理服务发现请求：GET /.well-known/liveuser.json[?siteId=xxx]，/config.json 为同一文档
+//
+// 客户端库可以据此预先校验加入消息；指定 siteId 时返回该站点的生效限制。
+func handleWellKnown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	siteID := r.URL.Query().Get("siteId")
+	writeJSON(w, http.StatusOK, WellKnownInfo{Version: Version, Limits: siteLimits(siteID)})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 检查 err 为指定错误码的 LimitError，code 为空时 err 应为 nil
+func expectLimitError(t *testing.T, what string, err error, code string) {
+	t.Helper()
+
+	if code == "" {
+		if err != nil {
+			t.Fatalf("%s: 不应超出限制: %v", what, err)
+		}
+		return
+	}
+	var limit *LimitError
+	if !errors.As(err, &limit) || limit.Code != code {
+		t.Fatalf("%s: 错误为 %v，应为 %s", what, err, code)
+	}
+}
+
+// 消息大小与访客 ID 长度：等于上限通过，超出 1 即拒绝
+func TestLimitMessageAndVisitorID(t *testing.T) {
+	limits := Limits{MaxMessageBytes: 256, MaxVisitorIDLen: maxVisitorIDLen}
+
+	expectLimitError(t, "消息等于上限", limits.checkMessage(256), "")
+	expectLimitError(t, "消息超出上限", limits.checkMessage(257), "message_too_large")
+	expectLimitError(t, "访客 ID 等于上限", limits.checkVisitorID(strings.Repeat("v", maxVisitorIDLen)), "")
+	expectLimitError(t, "访客 ID 超出上限", limits.checkVisitorID(strings.Repeat("v", maxVisitorIDLen+1)), "visitor_id_too_long")
+}
+
+// meta 的键数与键、值长度：等于上限通过，超出 1 即拒绝
+func TestLimitMeta(t *testing.T) {
+	useTestSettings(t)
+	settings = loadTestSettings(t, `{"sites":{"a.example":{"meta":true}}}`)
+	limits := Limits{MaxMetaKeys: 4, MaxMetaLen: 8}
+
+	keys := make([]string, limits.MaxMetaKeys+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%d", i)
+	}
+	long := strings.Repeat("x", limits.MaxMetaLen)
+	setFlag(t, "meta-keys", strings.Join(append(keys, long, long+"x"), ","))
+
+	meta := func(n int) map[string]string {
+		m := make(map[string]string)
+		for _, key := range keys[:n] {
+			m[key] = "v"
+		}
+		return m
+	}
+	tests := []struct {
+		what string
+		meta map[string]string
+		code string
+	}{
+		{"键数等于上限", meta(limits.MaxMetaKeys), ""},
+		{"键数超出上限", meta(limits.MaxMetaKeys + 1), "meta_too_many_keys"},
+		{"键长等于上限", map[string]string{long: "v"}, ""},
+		{"键长超出上限", map[string]string{long + "x": "v"}, "meta_too_long"},
+		{"值长等于上限", map[string]string{"k0": long}, ""},
+		{"值长超出上限", map[string]string{"k0": long + "x"}, "meta_too_long"},
+	}
+	for _, tt := range tests {
+		_, err := validateMeta("a.example", tt.meta, limits)
+		expectLimitError(t, tt.what, err, tt.code)
+	}
+}
+
+// 站点配置中的限制：0 到上限之间有效，超出上限或为负数时拒绝
+func TestSiteLimitsCheck(t *testing.T) {
+	tests := []struct {
+		limits SiteLimits
+		valid  bool
+	}{
+		{SiteLimits{}, true},
+		{SiteLimits{MaxMessageBytes: messageBytesCap, MaxMetaKeys: 1, MaxMetaLen: 1}, true},
+		{SiteLimits{MaxMessageBytes: messageBytesCap + 1}, false},
+		{SiteLimits{MaxMessageBytes: -1}, false},
+		{SiteLimits{MaxMetaKeys: -1}, false},
+		{SiteLimits{MaxMetaLen: -1}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.limits.check(); (err == nil) != tt.valid {
+			t.Errorf("%+v 的校验结果为 %v", tt.limits, err)
+		}
+	}
+}
+
+// 命令行参数中的限制：边界值有效，超出范围时拒绝启动
+func TestCheckLimitsFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"max-message-bytes", "1", true},
+		{"max-message-bytes", fmt.Sprint(messageBytesCap), true},
+		{"max-message-bytes", "0", false},
+		{"max-message-bytes", fmt.Sprint(messageBytesCap + 1), false},
+		{"max-meta-keys", "1", true},
+		{"max-meta-keys", "0", false},
+		{"meta-max-len", "1", true},
+		{"meta-max-len", "0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			setFlag(t, tt.name, tt.value)
+			if err := checkLimits(); (err == nil) != tt.valid {
+				t.Fatalf("校验结果为 %v", err)
+			}
+		})
+	}
+}
+
+// 加入确认、/.well-known/liveuser.json 与 /config.json 返回同一份站点限制，超出的消息被拒绝并断开
+func TestLimitsAdvertisedAndEnforced(t *testing.T) {
+	startTestHub(t)
+	settings = loadTestSettings(t, `{"sites":{"a.example":{"limits":{"maxMessageBytes":300,"maxMetaKeys":2}}}}`)
+	server := startTestServer(t)
+
+	want := instanceLimits()
+	want.MaxMessageBytes, want.MaxMetaKeys = 300, 2
+	if got := siteLimits("a.example"); got != want {
+		t.Fatalf("站点限制为 %+v，应为 %+v", got, want)
+	}
+
+	client := dialTestClient(t, server, "")
+	client.send(Message{Type: "join", SiteID: "a.example", Version: scriptVersion})
+	joined := client.expect("joined")
+	if joined.Limits == nil || *joined.Limits != want {
+		t.Fatalf("加入确认中的限制为 %+v，应为 %+v", joined.Limits, want)
+	}
+	for _, path := range []string{"/.well-known/liveuser.json", "/config.json"} {
+		w := httptest.NewRecorder()
+		handleWellKnown(w, httptest.NewRequest("GET", path+"?siteId=a.example", nil))
+		var info WellKnownInfo
+		decodeJSON(t, w, &info)
+		if info.Limits != want || info.Version != Version {
+			t.Fatalf("%s 返回 %+v，应与加入确认一致", path, info)
+		}
+	}
+
+	// 超出站点上限 1 字节的消息
+	prefix, suffix := `{"type":"ping","message":"`, `"}`
+	message := prefix + strings.Repeat("x", want.MaxMessageBytes+1-len(prefix)-len(suffix)) + suffix
+	if err := client.conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+	reply := client.expect("error")
+	if reply.Code != "message_too_large" {
+		t.Fatalf("错误为 %+v，应为 message_too_large", reply)
+	}
+	if _, err := client.read(3 * time.Second); closeCode(err) != 4000+http.StatusRequestEntityTooLarge {
+		t.Fatalf("断开原因为 %v，应为 4413", err)
+	}
+}
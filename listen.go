@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -addr 中表示 Unix 域套接字的前缀，如 unix:/run/liveuser.sock
+const unixAddrPrefix = "unix:"
+
+// 继承的监听文件描述符，0 表示不使用
+var listenFD = flag.Int("fd", 0, "在继承的文件描述符（如 3）上监听，不再绑定 -addr；systemd 套接字激活时按 LISTEN_FDS 自动检测，无需设置")
+
+// systemd 传入的第一个文件描述符
+const systemdListenFDStart = 3
+
+// Unix 域套接字文件的权限
+var unixSocketMode = flag.String("unix-socket-mode", "0660", "-addr 为 unix:路径 时套接字文件的权限（八进制），反向代理的用户需要有写权限")
+
+// -addr 是否为 Unix 域套接字，返回套接字路径
+func unixSocketPath(address string) (string, bool) {
+	return strings.CutPrefix(address, unixAddrPrefix)
+}
+
+// 解析 -unix-socket-mode
+func parseSocketMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("无效的 -unix-socket-mode %q，需为八进制权限，如 0660", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// 校验监听地址
+func checkListenAddr() error {
+	if *listenFD < 0 || (*listenFD > 0 && *listenFD < systemdListenFDStart) {
+		return fmt.Errorf("无效的 -fd %d，需为 3 及以上的文件描述符", *listenFD)
+	}
+	path, ok := unixSocketPath(*addr)
+	if !ok {
+		return nil
+	}
+	if path == "" {
+		return fmt.Errorf("-addr 缺少套接字路径，格式为 unix:/run/liveuser.sock")
+	}
+	_, err := parseSocketMode(*unixSocketMode)
+	return err
+}
+
+// 删除上次异常退出遗留的套接字文件；路径不是套接字或仍有进程在监听时返回错误
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s 已存在且不是套接字，拒绝删除", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s 已有进程在监听", path)
+	}
+	return os.Remove(path)
+}
+
+// 按 -addr 创建监听：unix: 前缀时监听 Unix 域套接字，否则监听 TCP
+//
+// 套接字文件在监听关闭（server.Shutdown）时自动删除。
+func listen(address string) (net.Listener, error) {
+	path, ok := unixSocketPath(address)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	mode, err := parseSocketMode(*unixSocketMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("设置套接字权限失败: %v", err)
+	}
+	return listener, nil
+}
+
+// systemd 套接字激活时传入的监听文件描述符，未激活时返回 0
+//
+// 只有 LISTEN_PID 为本进程时才采用；无论是否采用，读取后都清除这些环境变量，子进程不会误用。
+// 传入多个套接字时只使用第一个。
+func systemdListenFD() (int, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	value := os.Getenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil || pid != os.Getpid() {
+		return 0, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("systemd 套接字激活未传入可用的文件描述符（LISTEN_FDS=%q）", value)
+	}
+	if count > 1 {
+		log.Printf("警告: systemd 传入了 %d 个套接字，只使用第一个（fd %d）", count, systemdListenFDStart)
+	}
+	return systemdListenFDStart, nil
+}
+
+// 由继承的文件描述符创建监听；该描述符须是已在监听的流套接字
+func inheritedListener(fd int) (net.Listener, error) {
+	file := os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd))
+	if file == nil {
+		return nil, fmt.Errorf("无效的文件描述符 %d", fd)
+	}
+	// FileListener 复制了描述符，原文件可以关闭
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("文件描述符 %d 不是可用的监听套接字: %v", fd, err)
+	}
+	return listener, nil
+}
+
+// 创建服务使用的监听，并返回日志中的描述
+//
+// 优先级：-fd 指定的描述符 > systemd 套接字激活 > 绑定 -addr。
+func openListener() (net.Listener, string, error) {
+	if *listenFD > 0 {
+		listener, err := inheritedListener(*listenFD)
+		if err != nil {
+			return nil, "", err
+		}
+		return listener, fmt.Sprintf("%s（继承的 fd %d）", listener.Addr(), *listenFD), nil
+	}
+
+	fd, err := systemdListenFD()
+	if err != nil {
+		return nil, "", err
+	}
+	if fd > 0 {
+		listener, err := inheritedListener(fd)
+		if err != nil {
+			return nil, "", err
+		}
+		return listener, fmt.Sprintf("%s（systemd 套接字激活，忽略 -addr）", listener.Addr()), nil
+	}
+
+	listener, err := listen(*addr)
+	if err != nil {
+		return nil, "", err
+	}
+	return listener, *addr, nil
+}
+
+// 请求是否经由 Unix 域套接字到达，此时对端是同一主机上的反向代理
+func viaUnixSocket(r *http.Request) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && local.Network() == "unix"
+}
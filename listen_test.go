@@ -0,0 +1,131 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// 复制监听套接字的描述符，模拟进程管理器传入的 fd；原监听随即关闭，只剩复制的描述符在监听
+func inheritableFD(t *testing.T) (int, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// inheritedListener 会关闭传入的描述符，复制一份，避免 file 回收时重复关闭
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	listener.Close()
+	return fd, address
+}
+
+// 由继承的监听描述符创建的监听沿用原地址，可以直接提供服务
+func TestInheritedListener(t *testing.T) {
+	fd, address := inheritableFD(t)
+	listener, err := inheritedListener(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listener.Addr().String() != address {
+		t.Fatalf("监听地址为 %s，应为 %s", listener.Addr(), address)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "inherited")
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	resp, err := http.Get("http://" + address + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "inherited" {
+		t.Fatalf("响应为 %q", body)
+	}
+}
+
+// 不是监听套接字的描述符返回错误
+func TestInheritedListenerNotSocket(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "not-a-socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listener, err := inheritedListener(fd); err == nil {
+		listener.Close()
+		t.Fatal("普通文件的描述符创建监听成功")
+	}
+}
+
+// -fd 优先于 -addr
+func TestOpenListenerFD(t *testing.T) {
+	fd, address := inheritableFD(t)
+	setFlag(t, "fd", strconv.Itoa(fd))
+	setFlag(t, "addr", "127.0.0.1:1")
+	listener, description, err := openListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if listener.Addr().String() != address || !strings.Contains(description, "继承的 fd "+strconv.Itoa(fd)) {
+		t.Fatalf("监听 %s，描述为 %q", listener.Addr(), description)
+	}
+}
+
+// LISTEN_PID 不是本进程时不采用传入的描述符；无论是否采用，读取后都清除环境变量
+func TestSystemdListenFD(t *testing.T) {
+	tests := []struct {
+		pid, fds string
+		fd       int
+		wantErr  bool
+	}{
+		{strconv.Itoa(os.Getpid() + 1), "1", 0, false},
+		{"not-a-pid", "1", 0, false},
+		{strconv.Itoa(os.Getpid()), "1", systemdListenFDStart, false},
+		{strconv.Itoa(os.Getpid()), "2", systemdListenFDStart, false},
+		{strconv.Itoa(os.Getpid()), "0", 0, true},
+		{strconv.Itoa(os.Getpid()), "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("LISTEN_PID", tt.pid)
+		t.Setenv("LISTEN_FDS", tt.fds)
+		t.Setenv("LISTEN_FDNAMES", "liveuser.socket")
+
+		fd, err := systemdListenFD()
+		if fd != tt.fd || (err != nil) != tt.wantErr {
+			t.Errorf("LISTEN_PID=%s LISTEN_FDS=%s 时返回 %d, %v", tt.pid, tt.fds, fd, err)
+		}
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			if value, set := os.LookupEnv(name); set {
+				t.Errorf("LISTEN_PID=%s LISTEN_FDS=%s 时 %s=%q 没有清除", tt.pid, tt.fds, name, value)
+			}
+		}
+	}
+
+	// 未激活时不做任何事
+	if fd, err := systemdListenFD(); fd != 0 || err != nil {
+		t.Fatalf("未设置环境变量时返回 %d, %v", fd, err)
+	}
+}
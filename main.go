@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -12,17 +15,21 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
-	"text/template"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-// 版本信息
-var Version = "dev"
+// 版本信息，构建时通过 -ldflags "-X main.Version=... -X main.BuiltAt=..." 注入
+var (
+	Version = "dev"
+	BuiltAt = ""
+)
 
-// 内置静态文件
+// 内置静态文件，-assets-dir（或可执行文件所在目录）中的同名文件优先
+//
 //go:embed demo.html
 var demoHTML string
 
@@ -34,33 +41,309 @@ type Site struct {
 	ID          string           `json:"id"`
 	Count       int              `json:"count"`
 	Connections map[*Client]bool `json:"-"`
+	Observers   map[*Client]bool `json:"-"`
 	mutex       sync.RWMutex     `json:"-"`
+
+	burst burstState
+	ops   opRing
+
+	// 当前周期（按 -peak-reset）的最高人数
+	Peak   int   `json:"peak"`
+	PeakAt int64 `json:"peakAt,omitempty"`
+
+	// 计入人数的连接按 IP 与会话（clientId）统计的数量；
+	// dedupIP 时人数为不同 IP 数，否则为不同会话数；dedupDecided 表示去重方式已由第一个连接确定
+	ips          map[string]int
+	sessions     map[string]int
+	dedupIP      bool
+	dedupDecided bool
+
+	// 按页面路径统计的连接，没有连接上报路径时为 nil
+	pages map[string]*pageState
+
+	// GIF 信标的在线记录（IP 到期时间），与连接分开计入人数，没有信标时为 nil
+	beacons map[string]time.Time
 }
 
 // 客户端连接
 type Client struct {
-	conn *websocket.Conn
-	site *Site
-	hub  *Hub
-	send chan Message
-	ip   string
+	conn   *websocket.Conn
+	site   *Site
+	hub    *Hub
+	send   chan Message
+	id     uint64
+	ip     string
+	origin string
+
+	// 升级时记录的来源主机名（Origin，没有时取 Referer），严格来源模式下用于校验 siteId
+	originHost string
+
+	meta        map[string]string
+	connectedAt time.Time
+	visitorID   string
+	clientID    string
+	observer    bool
+	dedup       string
+	kind        connKind
+
+	// 上报的页面路径（已规范化），为空时不按页面统计；WebSocket 连接只在 Hub 协程中修改
+	path string
+
+	// 按 User-Agent 划分的设备类别与来源页面的主机名，用于访客构成统计；
+	// 来源在建立连接时取自 Referer，挂件在 join 中上报 document.referrer 时以其为准
+	device   string
+	referrer string
+
+	// 被识别为爬虫：连接照常接收人数，但总是作为观察者加入
+	bot bool
+
+	// 多租户模式下连接所属的租户，上报的 siteId 都放在该租户下（只在读协程中使用）
+	tenant string
+
+	// 待发送的加入确认与是否因站点已满被拒绝，只在 Hub 协程中读写
+	joinAck  *Limits
+	rejected bool
+
+	// 计入人数的加入时间，用于统计会话时长，只在 Hub 协程中读写
+	joinedAt time.Time
+
+	// 挂件上报的脚本版本，以及版本过低时的断开计时器（只在 Hub 协程中读写）
+	version      string
+	upgradeTimer *time.Timer
+
+	// 通过 siteIds 订阅的站点，按 siteId 索引，连接作为观察者登记在每个站点中（只在 Hub 协程中读写）
+	subscriptions map[string]*Site
+
+	// 上一次 query 的时间，只在 readPump 中使用
+	lastQuery time.Time
+
+	// 发送队列的写入与关闭互斥进行；v2 连接按入队顺序为每条消息分配序号
+	sendMutex  sync.Mutex
+	sendClosed bool
+	sendSeq    int64
+
+	// 严格模式关闭 v1-timestamp 时，v1 连接的消息不再带 timestamp
+	omitTimestamp bool
+
+	// SSE 订阅者没有 conn，通过 cancel 结束请求
+	cancel context.CancelFunc
+}
+
+// WebSocket 连接加入或切换站点的请求，由 Hub 协程统一修改客户端字段
+type siteJoin struct {
+	client    *Client
+	site      *Site
+	meta      map[string]string
+	visitorID string
+	clientID  string
+	dedup     string
+	observer  bool
+	version   string
+	path      string
+	referrer  string
+
+	// 重复加入所在的站点，只补发当前人数
+	rejoin bool
+
+	// 连接在登记前就已放弃，只移出为其创建、仍然为空的站点
+	release bool
+
+	// join 中的 siteIds（为 nil 时不修改订阅）与 unjoin 取消的站点，由 Hub 协程处理
+	subscribe []string
+	unjoin    string
+
+	// 加入成功后回复的生效限制
+	limits Limits
 }
 
 // 连接管理器
 type Hub struct {
 	sites      map[string]*Site
-	register   chan *Client
-	unregister chan *Client
+	register   chan hubCommand
+	unregister chan hubCommand
+	join       chan hubCommand
+	stats      map[string]*SiteStats
 	mutex      sync.RWMutex
+
+	// 分组最近一次计算的人数，成员变更通过 groupChanged 通知 Hub 协程
+	groups       map[string]int
+	groupChanged chan hubCommand
+
+	// 管理员断开或移除站点的命令
+	siteControl chan hubCommand
+
+	// GIF 信标请求；有信标在线记录的站点（只在 Hub 协程中读写）、请求数与当前的在线记录数
+	beacon          chan hubCommand
+	beaconSites     map[string]bool
+	beaconHits      atomic.Int64
+	beaconPresences atomic.Int64
+
+	// 命令排队延迟
+	queues queueStats
+
+	feeds     map[*Feed]bool
+	feedMutex sync.RWMutex
+
+	// 计入人数的连接总数；订阅全站总数的连接与最近一次推送的总数（totalSent 只在 Hub 协程中读写）
+	total            atomic.Int64
+	totalSent        int64
+	totalSubscribers map[*Client]bool
+	totalMutex       sync.Mutex
+
+	// 全站人数流的订阅者，以及因其队列已满丢弃的消息数
+	adminFeeds       map[*AdminFeed]bool
+	adminFeedMutex   sync.RWMutex
+	adminFeedDropped atomic.Int64
+
+	// 长轮询会话，按令牌索引
+	polls     map[string]*pollSession
+	pollMutex sync.Mutex
+
+	// 自定义事件的发布限额，按站点索引
+	publishLimits map[string]*publishLimit
+	publishMutex  sync.Mutex
+
+	// 按天的独立访客估计，按站点索引，不随站点清理
+	uniques      map[string]*uniqueStats
+	uniquesMutex sync.Mutex
+
+	// 站点峰值，按站点索引，不随站点清理
+	peaks     map[string]PeakStats
+	peakMutex sync.Mutex
+
+	// 站点人数历史，按站点索引，站点清空一个窗口后丢弃
+	history      map[string]*historyRing
+	historyMutex sync.Mutex
+
+	// 按天的站点统计，按站点索引，保留 -daily-days 天
+	daily      map[string][]*DailyStats
+	dailyMutex sync.Mutex
+
+	// 人数越过阈值时的 Webhook 通知，未启用时为 nil
+	webhooks *webhookNotifier
+
+	// 按 IP 统计的 WebSocket 连接数，连接关闭时归还名额
+	ipLimits   map[string]*ipLimit
+	ipMutex    sync.Mutex
+	ipRejected atomic.Int64
+
+	// 已占用名额的 WebSocket 连接数与因 -max-connections 拒绝的握手数
+	wsConns       atomic.Int64
+	connsRejected atomic.Int64
+
+	// 慢写次数与因写入过慢或超时断开的连接数
+	slowWrites      atomic.Int64
+	slowWriteClosed atomic.Int64
+
+	// 通知升级的连接数与升级期限已过而断开的连接数
+	upgradeRequired atomic.Int64
+	upgradeClosed   atomic.Int64
+
+	// 严格来源模式下因来源不符拒绝的加入数
+	originRejected atomic.Int64
+
+	// 因 -max-sites 拒绝的加入数；最近一次告警的时间与之后的拒绝数受 mutex 保护
+	sitesRejected       atomic.Int64
+	sitesWarnedAt       time.Time
+	sitesRejectedRecent int
+
+	clock    clockMonitor
+	counters hubCounters
+
+	// 进入关闭流程后 closed 置位，closing 关闭
+	closed  atomic.Bool
+	closing chan struct{}
+
+	// WebSocket 连接与站长事件流的读写协程，关闭时等待全部退出；
+	// 尚未加入站点的 WebSocket 连接不在任何站点中，另行登记以便关闭时断开
+	pumps     sync.WaitGroup
+	wsClients map[*Client]bool
+	wsMutex   sync.Mutex
+
+	// Stop 关闭 done 通知 Run 退出，Run 返回时关闭 stopped
+	done     chan struct{}
+	stopped  chan struct{}
+	running  atomic.Bool
+	stopOnce sync.Once
 }
 
 // 消息结构
 type Message struct {
-	Type      string `json:"type"`
-	SiteID    string `json:"siteId,omitempty"`
-	Count     int    `json:"count,omitempty"`
-	Message   string `json:"message,omitempty"`
-	Timestamp int64  `json:"timestamp,omitempty"`
+	Type   string `json:"type"`
+	SiteID string `json:"siteId,omitempty"`
+
+	// join 时同时订阅的多个站点，query 查询的站点；subscribed 中为当前订阅的全部站点
+	SiteIDs   []string `json:"siteIds,omitempty"`
+	Count     int      `json:"count,omitempty"`
+	Code      string   `json:"code,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Timestamp int64    `json:"timestamp,omitempty"`
+	Seq       int64    `json:"seq,omitempty"`
+	Peak      int      `json:"peak,omitempty"`
+
+	// v2 连接中 seq 为连接内序号，人数序号移到 countSeq
+	CountSeq int64 `json:"countSeq,omitempty"`
+
+	// 突发模式下的定时推送带有 reason:"sync" 与人数趋势
+	Reason string `json:"reason,omitempty"`
+	Trend  string `json:"trend,omitempty"`
+
+	VisitorID string            `json:"visitorId,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	Dedup     string            `json:"dedup,omitempty"`
+
+	// join 时上报的页面路径；update_page 中为人数所属的页面
+	Path string `json:"path,omitempty"`
+
+	// join 时上报的来源页面（document.referrer）
+	Referrer string `json:"referrer,omitempty"`
+
+	// join 时订阅全站在线总数，与 siteId 为 *total* 相同
+	Global bool `json:"global,omitempty"`
+
+	// join 时以观察者身份加入：接收人数但不计入在线，用于看板等内部页面
+	Observe bool `json:"observe,omitempty"`
+
+	// 同一浏览器多个标签页共用的会话 ID，用于合并计数
+	ClientID string `json:"clientId,omitempty"`
+
+	// 站长发布的自定义事件
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+
+	// 计划重启的时间（Unix 秒）
+	RestartAt int64 `json:"restartAt,omitempty"`
+
+	// shutdown 中建议客户端首次重连前等待的秒数
+	RetryAfter int `json:"retryAfter,omitempty"`
+
+	// configUpdate 下发的挂件配置
+	Config *WidgetSettings `json:"config,omitempty"`
+
+	// join 中上报的挂件脚本版本；upgrade_required 中要求的最低版本与断开期限（Unix 秒）
+	Version    string `json:"version,omitempty"`
+	MinVersion string `json:"minVersion,omitempty"`
+	Deadline   int64  `json:"deadline,omitempty"`
+
+	// join 中携带的管理令牌，严格来源模式下豁免来源检查，不会下发
+	AdminToken string `json:"adminToken,omitempty"`
+
+	// join 中携带的站点加入令牌（-site-tokens-file），不会下发
+	Token string `json:"token,omitempty"`
+
+	// 多租户模式下 join 与 query 中携带的 API 密钥，不会下发
+	APIKey string `json:"apiKey,omitempty"`
+
+	// 加入确认中的生效限制，以及协商后的协议版本（仅 v2 及以上）
+	Limits   *Limits `json:"limits,omitempty"`
+	Protocol int     `json:"protocol,omitempty"`
+
+	// 发送后以该关闭码断开连接
+	closeCode int
+	closeText string
+
+	// 预先编码的 WebSocket 帧，广播相同内容时避免重复编码
+	prepared *websocket.PreparedMessage
 }
 
 // JavaScript 配置结构
@@ -70,15 +353,47 @@ type JSConfig struct {
 	DisplayElementID string `json:"displayElementId"`
 	ReconnectDelay   int    `json:"reconnectDelay"`
 	Debug            bool   `json:"debug"`
+	TestCount        int    `json:"testCount"`
+	Lang             string `json:"lang"`
+	Format           string `json:"format"`
+	FormatOne        string `json:"formatOne"`
+	HideBelow        int    `json:"hideBelow"`
+	Label            string `json:"label"`
+	SSEFallback      bool   `json:"sseFallback"`
+	Dedup            string `json:"dedup"`
+	IdleTimeout      int    `json:"idleTimeout"`
+	Widget           string `json:"widget"`
+
+	// 按可注册域名计数，脚本中的 siteId 已收拢
+	GroupByRegistrableDomain bool `json:"groupByRegistrableDomain"`
+
+	// 以观察者身份加入，挂件显示人数但自身不计入
+	Observe bool `json:"observe"`
+
+	// 上报的页面路径，为空时挂件使用 location.pathname
+	Path string `json:"path"`
+
+	// 站点加入令牌，在 join 中携带
+	Token string `json:"token"`
+
+	// 重连退避：间隔上限（毫秒）、每次重连的倍数与随机抖动的比例（0~1）
+	ReconnectMaxDelay   int     `json:"reconnectMaxDelay"`
+	ReconnectMultiplier float64 `json:"reconnectMultiplier"`
+	ReconnectJitter     float64 `json:"reconnectJitter"`
+
+	// 多租户模式下的 API 密钥，在 join 中携带
+	APIKey string `json:"apiKey"`
+
+	// 生成脚本的服务器版本，调试模式下由挂件打印
+	ServerVersion string `json:"serverVersion"`
 }
 
 // WebSocket 升级器
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  512,
 	WriteBufferSize: 512,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	// 只接受 -allowed-origins 中的来源，未设置时允许所有来源
+	CheckOrigin: originAllowed,
 }
 
 // 全局变量
@@ -86,88 +401,565 @@ var hub *Hub
 
 // 命令行参数
 var addr = flag.String("addr", "0.0.0.0:10086", "监听地址")
+var sitesFile = flag.String("sites-file", "", "站点配置文件路径（JSON）")
+var metaKeys = flag.String("meta-keys", "", "允许客户端携带的 meta 键，逗号分隔")
+var metaMaxLen = flag.Int("meta-max-len", 64, "meta 键和值的最大长度")
+var maxPerSite = flag.Int("max-per-site", 0, "每个站点允许的连接数上限（含观察者，0 不限制）")
+
+// Hub 同时保存的站点数上限，防止大量随意的 siteId 耗尽内存
+var maxSites = flag.Int("max-sites", 0, "Hub 同时保存的站点数上限（0 不限制），达到上限后拒绝加入新站点")
+
+// 站点数达到上限时，每分钟最多打印一次告警
+const sitesWarnInterval = time.Minute
+
+var expiredRetention = flag.Duration("expired-retention", 24*time.Hour, "临时站点过期后拒绝加入的保留时长")
 
 // 创建新的Hub
 func NewHub() *Hub {
 	return &Hub{
 		sites:      make(map[string]*Site),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		register:   make(chan hubCommand),
+		unregister: make(chan hubCommand),
+		join:       make(chan hubCommand),
+		stats:      make(map[string]*SiteStats),
+		feeds:      make(map[*Feed]bool),
+		adminFeeds: make(map[*AdminFeed]bool),
+
+		totalSubscribers: make(map[*Client]bool),
+		polls:            make(map[string]*pollSession),
+
+		publishLimits: make(map[string]*publishLimit),
+		uniques:       make(map[string]*uniqueStats),
+		peaks:         make(map[string]PeakStats),
+		history:       make(map[string]*historyRing),
+		daily:         make(map[string][]*DailyStats),
+		ipLimits:      make(map[string]*ipLimit),
+		wsClients:     make(map[*Client]bool),
+
+		groups:       make(map[string]int),
+		groupChanged: make(chan hubCommand),
+		siteControl:  make(chan hubCommand),
+		beacon:       make(chan hubCommand),
+		beaconSites:  make(map[string]bool),
+		closing:      make(chan struct{}),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+
+		queues: queueStats{
+			pending: make(map[uint64]pendingCommand),
+			sites:   make(map[string]*siteQueueStats),
+		},
 	}
 }
 
-// Hub 主循环
+// 访客 ID 最大长度
+const maxVisitorIDLen = 64
+
+// Hub 主循环，调用 Stop 后返回
 func (h *Hub) Run() {
+	h.running.Store(true)
+	defer close(h.stopped)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	sweep := time.NewTicker(time.Minute)
+	defer sweep.Stop()
+	sample := time.NewTicker(*historyInterval)
+	defer sample.Stop()
+
 	for {
 		select {
-		case client := <-h.register:
-			h.handleRegister(client)
-		case client := <-h.unregister:
-			h.handleUnregister(client)
+		case command := <-h.register:
+			h.dequeued(command)
+			h.handleRegister(command.client)
+		case command := <-h.unregister:
+			h.dequeued(command)
+			h.handleUnregister(command.client)
+		case command := <-h.join:
+			h.dequeued(command)
+			h.handleJoin(command.join)
+		case command := <-h.groupChanged:
+			h.dequeued(command)
+			h.refreshGroup(command.group)
+		case command := <-h.siteControl:
+			h.dequeued(command)
+			h.handleDisconnectSite(command)
+		case command := <-h.beacon:
+			h.dequeued(command)
+			h.handleBeaconCommand(command)
+		case now := <-ticker.C:
+			h.clock.observe(now)
+			h.expireSites(now)
+			h.checkBursts(now)
+			h.expirePolls(now)
+			h.expireBeacons(now)
+			h.pushConfigUpdates(now)
+			h.pushTotal(now)
+		case now := <-sample.C:
+			h.sampleHistory(now)
+		case now := <-sweep.C:
+			h.sweepStats(now)
+			h.sweepPublishLimits(now)
+			h.sweepUniques(now)
+			h.sweepQueues(now)
+			h.webhooks.sweep(now)
+			h.sweepIPLimits(now)
+			h.syncDailyUniques(now)
+			h.pruneRetention(now)
+			sweepBlocked(now)
+		case <-h.done:
+			h.drainCommands()
+			h.closeAllSends()
+			return
 		}
 	}
 }
 
+// 清理已到期的临时站点
+//
+// 移出站点前记录仍在线连接的会话，移出后立即写入状态文件，不等下个写入周期。
+func (h *Hub) expireSites(now time.Time) {
+	expired := 0
+	defer func() {
+		if expired == 0 {
+			return
+		}
+		if err := h.saveState(*stateFile); err != nil {
+			log.Printf("写入状态文件失败: %v", err)
+		}
+	}()
+
+	for siteID, expiresAt := range settings.Expiring() {
+		if now.Before(expiresAt) {
+			continue
+		}
+
+		// 超过保留期后移除过期标记，站点可重新使用
+		if now.After(expiresAt.Add(*expiredRetention)) {
+			err := settings.Update(siteID, func(site *SiteSettings) {
+				site.ExpiresAt = nil
+			})
+			if err != nil {
+				log.Printf("清理站点 %s 过期标记失败: %v", siteID, err)
+			}
+			continue
+		}
+
+		h.endSessions(siteID, now)
+		dropped, exists := h.closeSite(siteID, Message{
+			Type:      "expired",
+			SiteID:    siteID,
+			Timestamp: now.Unix(),
+			closeCode: errorCloseCode(ErrSiteExpired),
+			closeText: "Gone",
+		})
+		if !exists {
+			continue
+		}
+
+		expired++
+		log.Printf("站点 %s 已过期，断开 %d 个连接", siteID, dropped)
+		h.publishEvent(FeedEvent{Type: "expired", SiteID: siteID, Timestamp: now.Unix()})
+		h.refreshGroups(siteID)
+	}
+}
+
+// 记录站点中计入人数的连接到 now 为止的会话（在 Hub 协程中调用）
+//
+// closeSite 断开的连接注销时已不在站点中，不会再记录会话。
+func (h *Hub) endSessions(siteID string, now time.Time) {
+	h.mutex.RLock()
+	site, exists := h.sites[siteID]
+	h.mutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	site.mutex.RLock()
+	durations := make([]time.Duration, 0, len(site.Connections))
+	for client := range site.Connections {
+		durations = append(durations, now.Sub(client.joinedAt))
+	}
+	site.mutex.RUnlock()
+
+	for _, duration := range durations {
+		h.recordDailySession(siteID, duration, now)
+	}
+}
+
+// 将站点移出 Hub，向全部连接发送 message 后断开；返回断开的连接数，站点不在线时 exists 为 false
+func (h *Hub) closeSite(siteID string, message Message) (dropped int, exists bool) {
+	h.mutex.Lock()
+	site, exists := h.sites[siteID]
+	delete(h.sites, siteID)
+	h.mutex.Unlock()
+
+	if !exists {
+		return 0, false
+	}
+
+	site.mutex.Lock()
+	dropped = len(site.Connections) + len(site.Observers)
+	h.total.Add(-int64(len(site.Connections)))
+	for _, clients := range []map[*Client]bool{site.Connections, site.Observers} {
+		for client := range clients {
+			delete(clients, client)
+			if !client.enqueue(message) {
+				h.counters.droppedFrames.Add(1)
+			}
+			client.closeSend()
+		}
+	}
+	site.Count = 0
+	site.ips = make(map[string]int)
+	site.sessions = make(map[string]int)
+	site.pages = nil
+	h.beaconPresences.Add(-int64(len(site.beacons)))
+	site.beacons = nil
+	site.mutex.Unlock()
+	broker.Publish(siteID, 0)
+	return dropped, true
+}
+
 // 处理客户端注册
 func (h *Hub) handleRegister(client *Client) {
 	if client.site == nil {
 		return
 	}
+	client.site = h.liveSite(client.site)
+	// 租户连接数遍历该租户的站点，须在持有站点锁之前检查
+	if h.tenantFull(client) {
+		h.releaseSite(client.site)
+		return
+	}
 
 	site := client.site
 	site.mutex.Lock()
+
+	// 连接数上限在站点锁内检查，并发加入不会同时越过上限
+	if *maxPerSite > 0 && len(site.Connections)+len(site.Observers) >= *maxPerSite {
+		site.mutex.Unlock()
+		h.rejectFull(client)
+		return
+	}
+	h.counters.joins.Add(1)
+	h.sendJoinAck(client)
+	h.checkClientVersion(client, site.ID)
+
+	// 观察者只接收人数，不计入在线
+	if client.observer {
+		site.Observers[client] = true
+		count := site.Count
+		pageCount, onPage := site.joinPageLocked(client, false)
+		site.mutex.Unlock()
+		if onPage {
+			client.enqueue(Message{Type: "update_page", SiteID: site.ID, Path: client.path, Count: pageCount, Timestamp: time.Now().Unix()})
+		}
+
+		// 分组人数由成员站点汇总，有变化时已随广播推送
+		pushed := false
+		if name, ok := groupName(site.ID); ok {
+			count, pushed = h.refreshGroup(name)
+		}
+
+		log.Printf("观察者 %s 加入站点 %s", client.ip, site.ID)
+		if !pushed {
+			h.sendWelcome(client, site.ID, count, 0, 0)
+		}
+		h.publishClientEvent("join", site.ID, count, client)
+		return
+	}
+
+	switched := site.setDedupLocked(site.resolveDedupLocked(client.dedup))
 	site.Connections[client] = true
-	site.Count++
+	h.total.Add(1)
+	if pageCount, onPage := site.joinPageLocked(client, true); onPage {
+		h.broadcastPageLocked(site, client.path, pageCount)
+	}
+	changed := site.trackJoinLocked(client) || switched
+	site.burst.joins++
+	site.recordOp("join", client)
+	correction := site.auditLocked()
 	count := site.Count
+	bursting := site.burst.active
+	peak := h.observePeak(site.ID, count)
+	site.Peak, site.PeakAt = peak.Peak, peak.PeakAt
 	site.mutex.Unlock()
 
+	client.joinedAt = time.Now()
+	h.recordDailyJoin(site.ID, count, client.joinedAt)
+
+	h.reportCorrection(correction)
+	h.recordUnique(site.ID, client)
 	log.Printf("客户端 %s 加入站点 %s，在线: %d", client.ip, site.ID, count)
-	h.broadcastToSite(site.ID, count)
+
+	// 同一 IP（按 IP 去重时）或同一会话的新连接不改变人数，只需把当前人数发给它
+	if !changed {
+		_, seq := h.countAndSeq(site.ID)
+		h.sendWelcome(client, site.ID, count, seq, peak.Peak)
+		h.publishClientEvent("join", site.ID, count, client)
+		return
+	}
+
+	// 先把人数直接发给新连接，突发模式下不广播时挂件也能立即显示
+	seq := h.countChanged(site.ID, 1)
+	h.sendWelcome(client, site.ID, count, seq, peak.Peak)
+	broker.Publish(site.ID, count)
+	h.webhooks.observe(site.ID, count, time.Now())
+	if !bursting {
+		h.broadcastToSite(site.ID, count, seq)
+	}
+	h.publishClientEvent("join", site.ID, count, client)
+	h.refreshGroups(site.ID)
+}
+
+// 支持 welcome 消息的最低挂件版本
+const welcomeVersion = "2.2.0"
+
+// 直接向新加入的客户端发送站点的当前人数，count 为本实例人数
+//
+// 挂件 2.2.0 起收到 welcome，与 update 同样处理；更早的挂件与未上报版本的客户端收到同样内容的 update。
+func (h *Hub) sendWelcome(client *Client, siteID string, count int, seq int64, peak int) {
+	message := Message{
+		Type:      "welcome",
+		SiteID:    siteID,
+		Count:     globalCount(siteID, count),
+		Timestamp: time.Now().Unix(),
+		Seq:       seq,
+		Peak:      withBase(siteID, peak),
+		Version:   Version,
+	}
+	if compareVersions(client.version, welcomeVersion) < 0 {
+		message.Type = "update"
+	}
+	if !client.enqueue(message) {
+		h.counters.droppedFrames.Add(1)
+	}
+}
+
+// 客户端重复加入所在的站点（如重连后的挂件再次发送 join）时补发当前人数（在 Hub 协程中调用）
+func (h *Hub) rewelcome(client *Client) {
+	if client.site == nil || client.rejected {
+		return
+	}
+	h.welcomeSite(client, client.site)
+}
+
+// 向客户端发送站点的当前人数，分组取成员站点的合计（在 Hub 协程中调用）
+func (h *Hub) welcomeSite(client *Client, site *Site) {
+	if name, ok := groupName(site.ID); ok {
+		count, _ := h.groupCount(name)
+		_, seq := h.countAndSeq(site.ID)
+		h.sendWelcome(client, site.ID, count, seq, 0)
+		return
+	}
+
+	site.mutex.RLock()
+	count, peak := site.Count, site.Peak
+	site.mutex.RUnlock()
+	_, seq := h.countAndSeq(site.ID)
+	h.sendWelcome(client, site.ID, count, seq, peak)
+}
+
+// 回复加入确认，只在 WebSocket 加入或切换站点时发送一次
+func (h *Hub) sendJoinAck(client *Client) {
+	if client.joinAck == nil {
+		return
+	}
+	ack := Message{Type: "joined", SiteID: client.site.ID, Limits: client.joinAck}
+	if client.kind.Protocol > protocolVersion {
+		ack.Protocol = client.kind.Protocol
+	}
+	if !client.enqueue(ack) {
+		h.counters.droppedFrames.Add(1)
+	}
+	client.joinAck = nil
+}
+
+// 站点连接数已满：回复 rejected 后按关闭码断开，不计入人数
+func (h *Hub) rejectFull(client *Client) {
+	client.rejected = true
+	client.joinAck = nil
+	log.Printf("站点 %s 连接数已达上限 %d，拒绝客户端 %s", client.site.ID, *maxPerSite, client.ip)
+
+	rejected := client.enqueue(Message{
+		Type:      "rejected",
+		SiteID:    client.site.ID,
+		Message:   "site full",
+		closeCode: errorCloseCode(ErrSiteAtCapacity),
+		closeText: http.StatusText(errorStatus(ErrSiteAtCapacity)),
+	})
+	if !rejected {
+		h.counters.droppedFrames.Add(1)
+		client.disconnect()
+	}
 }
 
 // 处理客户端注销
 func (h *Hub) handleUnregister(client *Client) {
-	if client.site == nil {
+	// 从未加入站点或加入被拒绝的连接，直接结束其 writePump
+	if client.upgradeTimer != nil {
+		client.upgradeTimer.Stop()
+	}
+	h.unsubscribeAll(client)
+	if client.site == nil || client.rejected {
+		client.closeSend()
 		return
 	}
+	h.leaveSite(client, true)
+}
 
+// 处理 WebSocket 连接的 join：先离开当前站点（保留连接），再加入新站点
+//
+// 客户端字段只在 Hub 协程中修改，readPump 不读写 client.site，
+// 避免切换站点时注销与加入交错导致旧站点人数残留。
+func (h *Hub) handleJoin(join siteJoin) {
+	client := join.client
+	if join.release {
+		h.releaseSite(join.site)
+		return
+	}
+	if join.rejoin {
+		h.rewelcome(client)
+		return
+	}
+	if client.rejected {
+		// 已因站点已满被拒绝，连接即将断开
+		h.releaseSite(join.site)
+		return
+	}
+	if client.sendIsClosed() {
+		// 已因消费过慢被丢弃，连接即将断开，由其注销移出当前站点
+		h.releaseSite(join.site)
+		return
+	}
+	if join.unjoin != "" {
+		h.handleUnjoin(client, join.unjoin)
+		return
+	}
+	if join.site == nil {
+		h.handleSubscribe(client, join.subscribe, join.version)
+		return
+	}
+	if client.site != nil && !h.leaveSite(client, false) {
+		h.releaseSite(join.site)
+		return
+	}
+	// 新站点已在订阅中时改为计入人数，连接不会同时在一个站点中登记两次
+	if site, subscribed := client.subscriptions[join.site.ID]; subscribed {
+		h.unsubscribe(client, site)
+	}
+
+	client.site = join.site
+	client.meta = join.meta
+	client.visitorID = join.visitorID
+	client.clientID = join.clientID
+	client.dedup = join.dedup
+	client.observer = join.observer
+	client.version = join.version
+	client.path = join.path
+	if join.referrer != "" {
+		client.referrer = join.referrer
+	}
+	client.joinAck = &join.limits
+	h.handleRegister(client)
+}
+
+// 将客户端移出所在站点，closeSend 为 true 时同时关闭发送队列结束 writePump；
+// 客户端已不在站点中时返回 false
+func (h *Hub) leaveSite(client *Client, closeSend bool) bool {
 	site := client.site
 	site.mutex.Lock()
 
-	if _, exists := site.Connections[client]; exists {
-		delete(site.Connections, client)
-		close(client.send)
-		site.Count--
-		if site.Count < 0 {
-			site.Count = 0
-		}
-		count := site.Count
-		connectionsLeft := len(site.Connections)
+	_, counted := site.Connections[client]
+	_, observing := site.Observers[client]
+	if !counted && !observing {
+		// 不应出现：连接表只在 Hub 协程中修改，核对人数是否一致
+		site.recordOp("leave-missing", client)
+		correction := site.auditLocked()
 		site.mutex.Unlock()
+		h.reportCorrection(correction)
+		return false
+	}
 
-		log.Printf("客户端 %s 离开站点 %s，在线: %d", client.ip, site.ID, count)
+	delete(site.Connections, client)
+	delete(site.Observers, client)
+	if counted {
+		h.total.Add(-1)
+	}
+	if pageCount, onPage := site.leavePageLocked(client, counted); onPage && counted {
+		h.broadcastPageLocked(site, client.path, pageCount)
+	}
+	if closeSend {
+		client.closeSend()
+	} else {
+		client.site = nil
+	}
+	h.counters.unregistered.Add(1)
+	changed := false
+	if counted {
+		changed = site.trackLeaveLocked(client)
+		site.recordOp("leave", client)
+	}
+	correction := site.auditLocked()
+	count := site.Count
+	bursting := site.burst.active
+	empty := len(site.Connections) == 0 && len(site.Observers) == 0 && len(site.beacons) == 0
+	site.mutex.Unlock()
 
-		if connectionsLeft == 0 {
-			h.mutex.Lock()
-			delete(h.sites, site.ID)
-			h.mutex.Unlock()
-		} else {
-			h.broadcastToSite(site.ID, count)
-		}
+	h.reportCorrection(correction)
+
+	var seq int64
+	if changed {
+		seq = h.countChanged(site.ID, -1)
+		broker.Publish(site.ID, count)
+		h.webhooks.observe(site.ID, count, time.Now())
+	}
+	if counted {
+		h.observePeak(site.ID, count)
+		h.recordDailySession(site.ID, time.Since(client.joinedAt), time.Now())
+	}
+	if counted {
+		log.Printf("客户端 %s 离开站点 %s，在线: %d", client.ip, site.ID, count)
 	} else {
-		site.mutex.Unlock()
+		log.Printf("观察者 %s 离开站点 %s", client.ip, site.ID)
+	}
+	h.publishClientEvent("leave", site.ID, count, client)
+
+	if empty {
+		h.mutex.Lock()
+		delete(h.sites, site.ID)
+		h.mutex.Unlock()
+		h.publishOffline(site.ID, time.Now())
 	}
+	// 站点清空后已没有连接，这次广播只送达全站人数流
+	if changed && (!bursting || empty) {
+		h.broadcastToSite(site.ID, count, seq)
+	}
+	if changed {
+		h.refreshGroups(site.ID)
+	}
+	return true
 }
 
-// 向指定站点广播消息
-func (h *Hub) broadcastToSite(siteID string, count int) {
+// 向指定站点广播人数，count 为本实例人数，启用 -redis 时加上其他实例的人数；
+// 同时转发给全站人数流
+func (h *Hub) broadcastToSite(siteID string, count int, seq int64) {
+	peak, _ := h.peak(siteID, count)
 	message := Message{
 		Type:      "update",
 		SiteID:    siteID,
-		Count:     count,
+		Count:     globalCount(siteID, count),
 		Timestamp: time.Now().Unix(),
+		Seq:       seq,
+		Peak:      withBase(siteID, peak.Peak),
 	}
+	h.broadcastMessage(siteID, message)
+	h.publishAdminFeed(message)
+}
 
+// 向指定站点广播消息
+func (h *Hub) broadcastMessage(siteID string, message Message) {
 	h.mutex.RLock()
 	site, exists := h.sites[siteID]
 	h.mutex.RUnlock()
@@ -176,49 +968,152 @@ func (h *Hub) broadcastToSite(siteID string, count int) {
 		return
 	}
 
-	site.mutex.RLock()
-	defer site.mutex.RUnlock()
-
-	for client := range site.Connections {
-		select {
-		case client.send <- message:
-		default:
-			delete(site.Connections, client)
-			close(client.send)
+	// 持有写锁使同一站点的广播依次进行，每个客户端收到的顺序与广播顺序一致
+	site.mutex.Lock()
+	defer site.mutex.Unlock()
+
+	// 广播不修改连接表：队列已满的客户端只关闭发送队列，writePump 随之断开连接，
+	// 由连接自身的注销在 Hub 协程中移出站点并更新人数
+	h.counters.broadcasts.Add(1)
+	for _, clients := range []map[*Client]bool{site.Connections, site.Observers} {
+		for client := range clients {
+			if client.enqueue(message) || !client.closeSend() {
+				// 发送成功，或发送队列已关闭（正在离开或已被丢弃）
+				continue
+			}
+			dropped := h.counters.droppedFrames.Add(1)
+			log.Printf("%v", &SlowClientError{
+				SiteID:   siteID,
+				IP:       client.ip,
+				Buffered: cap(client.send),
+				Dropped:  dropped,
+			})
+			site.recordOp("drop", client)
 		}
 	}
 }
 
-// 获取或创建站点
-func (h *Hub) getSite(siteID string) *Site {
+// 推送客户端加入/离开事件
+func (h *Hub) publishClientEvent(eventType, siteID string, count int, client *Client) {
+	h.publishEvent(FeedEvent{
+		Type:      eventType,
+		SiteID:    siteID,
+		Count:     count,
+		IP:        anonymizeIP(client.ip),
+		Meta:      client.meta,
+		Excluded:  client.observer,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// 获取或创建站点，站点数达到 -max-sites 时不再创建新站点
+//
+// 上限在 Hub 锁内检查，同时到达的多个新站点不会一起越过上限。
+func (h *Hub) getSite(siteID string) (*Site, error) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
 	site, exists := h.sites[siteID]
 	if !exists {
+		if *maxSites > 0 && len(h.sites) >= *maxSites {
+			h.rejectSite(siteID)
+			return nil, ErrTooManySites
+		}
+		if err := h.checkTenantSitesLocked(siteID); err != nil {
+			return nil, err
+		}
 		site = &Site{
 			ID:          siteID,
 			Count:       0,
 			Connections: make(map[*Client]bool),
+			Observers:   make(map[*Client]bool),
+			ips:         make(map[string]int),
+			sessions:    make(map[string]int),
 		}
 		h.sites[siteID] = site
 	}
 
-	return site
+	return site, nil
 }
 
-// 获取客户端真实IP
-func getRealIP(r *http.Request) string {
-	if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
-		return strings.Split(ip, ",")[0]
+// 返回 Hub 中登记的同名站点（在 Hub 协程中调用）
+//
+// readPump 在排队加入前取得站点，排队期间站点可能因清空被移出 Hub：
+// 已有同名新站点时改用它，否则重新登记，加入的连接不会留在 Hub 之外的站点中。
+func (h *Hub) liveSite(site *Site) *Site {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	current, exists := h.sites[site.ID]
+	if !exists {
+		h.sites[site.ID] = site
+		return site
+	}
+	return current
+}
+
+// 加入未被接受时，移出为这次加入创建、仍然为空的站点（在 Hub 协程中调用）
+//
+// 站点在 Hub 接受加入前就已创建，不移出时空站点会一直占用 -max-sites 与租户的站点配额，排空也会等它到超时。
+func (h *Hub) releaseSite(site *Site) {
+	if site == nil {
+		return
+	}
+	site.mutex.RLock()
+	empty := len(site.Connections) == 0 && len(site.Observers) == 0 && len(site.beacons) == 0
+	site.mutex.RUnlock()
+	if empty {
+		h.removeSite(site)
+	}
+}
+
+// 记录一次因站点数已满而拒绝的加入，按间隔打印告警（调用方需持有 Hub 锁）
+func (h *Hub) rejectSite(siteID string) {
+	h.sitesRejected.Add(1)
+	h.sitesRejectedRecent++
+	now := time.Now()
+	if now.Sub(h.sitesWarnedAt) < sitesWarnInterval {
+		return
+	}
+	log.Printf("警告: 站点数已达上限 %d，拒绝新站点 %q（上次告警以来共拒绝 %d 次）", *maxSites, siteID, h.sitesRejectedRecent)
+	h.sitesWarnedAt = now
+	h.sitesRejectedRecent = 0
+}
+
+// 校验客户端 meta，站点未开启时直接丢弃
+func validateMeta(siteID string, meta map[string]string, limits Limits) (map[string]string, error) {
+	if len(meta) == 0 || !settings.Get(siteID).Meta {
+		return nil, nil
+	}
+	if len(meta) > limits.MaxMetaKeys {
+		return nil, &LimitError{Code: "meta_too_many_keys", Limit: "maxMetaKeys", Max: limits.MaxMetaKeys}
+	}
+
+	allowed := make(map[string]bool)
+	for _, key := range strings.Split(*metaKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			allowed[key] = true
+		}
 	}
-	if ip := r.Header.Get("X-Real-IP"); ip != "" {
-		return ip
+
+	for key, value := range meta {
+		if !allowed[key] {
+			return nil, fmt.Errorf("meta 键 %q 不在允许列表中", key)
+		}
+		if len(key) > limits.MaxMetaLen || len(value) > limits.MaxMetaLen {
+			return nil, &LimitError{Code: "meta_too_long", Limit: "maxMetaLen", Max: limits.MaxMetaLen}
+		}
 	}
-	if ip := r.Header.Get("CF-Connecting-IP"); ip != "" {
-		return ip
+
+	return meta, nil
+}
+
+// 校验访客 ID，不合法时忽略
+func validVisitorID(visitorID string) string {
+	visitorID = strings.TrimSpace(visitorID)
+	if len(visitorID) > maxVisitorIDLen {
+		return ""
 	}
-	return r.RemoteAddr
+	return visitorID
 }
 
 // 检查是否为WebSocket请求
@@ -228,6 +1123,15 @@ func isWebSocketRequest(r *http.Request) bool {
 
 // 处理所有请求
 func handleRequest(w http.ResponseWriter, r *http.Request) {
+	// 除 / 与 /liveuser.js 外的路径依赖兜底路由，已废弃
+	if r.URL.Path != "/" && r.URL.Path != "/liveuser.js" {
+		deprecations.recordRequest(deprecatedCatchAll, r, "")
+		if strictMode(deprecatedCatchAll) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
 	if isWebSocketRequest(r) {
 		handleWebSocket(w, r)
 		return
@@ -247,37 +1151,85 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 
 // 处理JavaScript文件请求
 func handleJavaScript(w http.ResponseWriter, r *http.Request) {
+	if multiTenant() {
+		if _, err := tenantFromRequest(r); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
 	config := parseJSConfig(r)
+	if config.SiteID == "" {
+		writeError(w, &InvalidSiteIDError{Reason: "缺少 siteId 且无法从 Referer 推断"})
+		return
+	}
+	if err := validateSiteID(config.SiteID); err != nil {
+		writeError(w, err)
+		return
+	}
 
-	tmpl, err := template.New("liveuser").Parse(mainJS)
+	// ETag 取模板摘要与完整的模板数据，siteId、语言与站点挂件配置不同的脚本互不相同
+	cached := currentJSTemplate()
+	data := config.templateData()
+	encoded, err := json.Marshal(data)
 	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w, gzipped, done := compressResponse(w, r)
+	defer done()
+	if !checkContentNotModified(w, r, encodedETag(contentETag(cached.hash, string(encoded)), gzipped)) {
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
-	w.Header().Set("Cache-Control", "no-cache")
 	w.WriteHeader(http.StatusOK)
 
-	tmpl.Execute(w, config)
+	cached.tmpl.Execute(w, data)
 }
 
 // 解析JavaScript配置
 func parseJSConfig(r *http.Request) JSConfig {
 	params := r.URL.Query()
 
-	protocol := "ws"
-	if r.Header.Get("X-Forwarded-Proto") == "https" || r.TLS != nil {
-		protocol = "wss"
-	}
-	defaultServerURL := protocol + "://" + r.Host + "/"
+	defaultServerURL := "ws" + strings.TrimPrefix(publicBaseURL(r), "http") + "/"
+	locale := selectLocale(params.Get("lang"), r.Header.Get("Accept-Language"))
 
 	config := JSConfig{
 		ServerURL:        getParam(params, "serverUrl", defaultServerURL),
-		SiteID:           getParam(params, "siteId", ""),
+		SiteID:           strings.TrimSpace(getParam(params, "siteId", "")),
 		DisplayElementID: getParam(params, "displayElementId", "liveuser"),
 		ReconnectDelay:   getIntParam(params, "reconnectDelay", 3000),
 		Debug:            getBoolParam(params, "debug", true),
+		TestCount:        -1,
+		Lang:             locale.Code,
+		Format:           getParam(params, "displayFormat", getParam(params, "format", locale.T("widget.format"))),
+		FormatOne:        getParam(params, "displayFormatOne", getParam(params, "formatOne", "")),
+		HideBelow:        max(getIntParam(params, "hideBelow", 0), 0),
+		Label:            getParam(params, "label", locale.T("widget.label")),
+		SSEFallback:      getBoolParam(params, "sseFallback", true),
+		Dedup:            getParam(params, "dedup", ""),
+		IdleTimeout:      getIntParam(params, "idleTimeout", 0),
+		Observe:          getBoolParam(params, "observe", false),
+		Path:             normalizePath(getParam(params, "path", "")),
+		Token:            getParam(params, "token", ""),
+		APIKey:           getParam(params, "apiKey", ""),
+
+		ReconnectMaxDelay:   getIntParam(params, "reconnectMaxDelay", int(maxWidgetReconnectDelay/time.Millisecond)),
+		ReconnectMultiplier: clampFloat(getFloatParam(params, "reconnectMultiplier", 2), 1, 10),
+		ReconnectJitter:     clampFloat(getFloatParam(params, "reconnectJitter", 0.5), 0, 1),
+
+		ServerVersion: Version,
+	}
+	if checkDedupMode(config.Dedup) != nil {
+		config.Dedup = ""
+	}
+	// 退避上限不低于基础间隔
+	config.ReconnectMaxDelay = max(config.ReconnectMaxDelay, config.ReconnectDelay)
+
+	// 测试人数仅在开发模式下生效
+	if *devMode {
+		config.TestCount = getIntParam(params, "__testCount", -1)
 	}
 
 	if config.SiteID == "" {
@@ -288,9 +1240,21 @@ func parseJSConfig(r *http.Request) JSConfig {
 			}
 		}
 		if config.SiteID == "" {
-			config.SiteID = "default-site"
+			deprecations.recordRequest(deprecatedDefaultSite, r, "")
+			if !strictMode(deprecatedDefaultSite) {
+				config.SiteID = "default-site"
+			}
 		}
 	}
+	config.SiteID = resolveSiteID(config.SiteID)
+	// 按站点开启子域名合并：挂件加入时直接使用可注册域名
+	config.GroupByRegistrableDomain = *groupByRegistrableDomain || getBoolParam(params, "groupByRegistrableDomain", false)
+	if config.GroupByRegistrableDomain {
+		config.SiteID = registrableSiteID(config.SiteID)
+	}
+
+	// 站点的挂件配置优先于 URL 参数，挂件启动时应用，与 configUpdate 推送的内容一致
+	config.Widget = widgetConfigJSON(config.SiteID)
 
 	return config
 }
@@ -313,6 +1277,21 @@ func getIntParam(params url.Values, key string, defaultValue int) int {
 	return defaultValue
 }
 
+// 获取浮点数参数，NaN 与无穷大视为无效
+func getFloatParam(params url.Values, key string, defaultValue float64) float64 {
+	if value := params.Get(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(floatValue) && !math.IsInf(floatValue, 0) {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// 把数值限制在 [low, high] 内
+func clampFloat(value, low, high float64) float64 {
+	return math.Max(low, math.Min(value, high))
+}
+
 // 获取布尔参数
 func getBoolParam(params url.Values, key string, defaultValue bool) bool {
 	if value := params.Get(key); value != "" {
@@ -325,42 +1304,176 @@ func getBoolParam(params url.Values, key string, defaultValue bool) bool {
 
 // 处理演示页面请求
 func handleDemoPage(w http.ResponseWriter, r *http.Request) {
+	locale := selectLocale(r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"))
+
+	w.Header().Add("Vary", "Accept-Language")
+	w, gzipped, done := compressResponse(w, r)
+	defer done()
+	if !checkContentNotModified(w, r, encodedETag(contentETag(demoHash, locale.Code), gzipped)) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", locale.Code)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(demoHTML))
+
+	demoTemplate.Execute(w, locale)
+}
+
+// 校验配置文件与语言包
+func checkConfig() error {
+	if err := checkStrict(); err != nil {
+		return err
+	}
+	if err := checkDedupMode(*dedupMode); err != nil {
+		return err
+	}
+	if _, err := parseRestartNotices(*restartNotices); err != nil {
+		return err
+	}
+	if _, err := parseAllowedOrigins(*allowedOrigins); err != nil {
+		return err
+	}
+	if _, err := parseCORSOrigins(*corsOrigins); err != nil {
+		return err
+	}
+	if _, err := parseTrustedProxies(*trustedProxiesFlag); err != nil {
+		return err
+	}
+	if _, err := compileSiteIDPattern(*siteIDPatternFlag); err != nil {
+		return err
+	}
+	if _, err := loadCloudflareRanges(); err != nil {
+		return err
+	}
+	if _, err := loadBotPatterns(); err != nil {
+		return err
+	}
+	if _, _, err := loadBlocklist(); err != nil {
+		return err
+	}
+	if _, err := loadSiteTokens(); err != nil {
+		return err
+	}
+	if _, err := loadTenants(); err != nil {
+		return err
+	}
+	if err := checkPeakReset(*peakReset); err != nil {
+		return err
+	}
+	if err := checkLimits(); err != nil {
+		return err
+	}
+	if err := checkTLS(); err != nil {
+		return err
+	}
+	if err := checkListenAddr(); err != nil {
+		return err
+	}
+	if _, err := newWebhookNotifier(); err != nil {
+		return err
+	}
+	if _, err := loadJSTemplate(); err != nil {
+		return err
+	}
+	if _, _, _, err := loadDemoTemplate(); err != nil {
+		return err
+	}
+	if _, err := newBroker(); err != nil {
+		return err
+	}
+	if _, err := LoadSettings(*sitesFile); err != nil {
+		return fmt.Errorf("站点配置: %v", err)
+	}
+	_, err := loadLocales(*localesDir)
+	return err
 }
 
 // 处理WebSocket连接
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
+	if hub.closed.Load() {
+		writeError(w, ErrHubClosed)
 		return
 	}
 
 	clientIP := getRealIP(r)
+	if err := checkBlocklist(clientIP); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// 升级前检查实例连接总数并按 IP 限制连接数，名额在 readPump 结束时归还
+	if err := hub.acquireConn(); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(serverFullRetryAfter))
+		writeError(w, err)
+		return
+	}
+	if err := hub.acquireIP(clientIP, time.Now()); err != nil {
+		hub.releaseConn()
+		writeError(w, err)
+		return
+	}
+	bot, err := classifyBot(r, transportWebSocket)
+	if err != nil {
+		hub.releaseIP(clientIP)
+		hub.releaseConn()
+		writeError(w, err)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		hub.releaseIP(clientIP)
+		hub.releaseConn()
+		return
+	}
 
 	client := &Client{
-		conn: conn,
-		hub:  hub,
-		send: make(chan Message, 16),
-		ip:   clientIP,
+		conn:        conn,
+		hub:         hub,
+		send:        make(chan Message, 16),
+		id:          nextClientID.Add(1),
+		ip:          clientIP,
+		origin:      r.Header.Get("Origin"),
+		originHost:  requestOriginHost(r),
+		connectedAt: time.Now(),
+		device:      deviceClass(r.UserAgent()),
+		referrer:    referrerHost(r.Referer()),
+		bot:         bot,
+		kind:        newConnKind(transportWebSocket),
+	}
+	client.kind.Protocol = negotiateProtocol(r.URL.Query().Get("protocol"))
+	if client.kind.Protocol < protocolVersionV2 {
+		// v1 连接按消息中的 timestamp 判断先后，已废弃，v2 改用连接内的 seq
+		deprecations.recordRequest(deprecatedV1Timestamp, r, "")
+		client.omitTimestamp = strictMode(deprecatedV1Timestamp)
 	}
+	recordTransport(client.kind)
 
+	hub.trackPumps(client)
 	go client.readPump()
 	go client.writePump()
 }
 
 // 读取客户端消息
 func (c *Client) readPump() {
+	// 当前加入的站点，只在本协程中使用
+	joined := ""
+
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.unsubscribeTotal(c)
+		c.hub.queueUnregister(c, joined)
 		c.conn.Close()
+		c.hub.releaseIP(c.ip)
+		c.hub.releaseConn()
+		c.hub.untrackPumps(c)
 	}()
 
-	c.conn.SetReadLimit(256)
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	// 读取上限取站点可配置的最大值，实际限制在读取后按站点校验
+	c.conn.SetReadLimit(messageBytesCap)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 
@@ -370,51 +1483,252 @@ func (c *Client) readPump() {
 			break
 		}
 
+		// 加入消息按目标站点的限制校验，其他消息按已加入站点的限制校验
 		var msg Message
-		if err := json.Unmarshal(msgData, &msg); err != nil {
+		valid := json.Unmarshal(msgData, &msg) == nil
+		siteID := joined
+		if valid && multiTenant() && (msg.Type == "join" || msg.Type == "query") {
+			if err := c.authTenant(msg.APIKey); err != nil {
+				c.enqueue(errorMessage(msg.SiteID, err))
+				continue
+			}
+		}
+		if valid && msg.Type == "join" && msg.SiteID != "" {
+			siteID = c.scopeSiteID(joinSiteID(msg.SiteID, c.origin, transportWebSocket))
+		}
+		limits := siteLimits(siteID)
+		size := len(msgData)
+		switch {
+		case valid && msg.Type == "join":
+			size -= siteIDsBytes(msg.SiteIDs, *maxSubscriptions)
+		case valid && msg.Type == "query":
+			size -= siteIDsBytes(msg.SiteIDs, maxCountSites)
+		}
+		if err := limits.checkMessage(size); err != nil {
+			c.enqueue(errorMessage(siteID, err))
 			continue
 		}
+		if !valid {
+			continue
+		}
+
+		// 订阅全站总数；保留的 siteId 不再按站点加入
+		if msg.Type == "join" && isTotalJoin(msg) {
+			c.hub.subscribeTotal(c)
+			if strings.TrimSpace(msg.SiteID) == totalSiteID {
+				msg.SiteID = ""
+			}
+		}
 
 		if msg.Type == "join" && msg.SiteID != "" {
-			siteID := strings.TrimSpace(msg.SiteID)
+			err := c.hub.checkJoin(siteID)
+			if err == nil {
+				err = c.hub.checkOrigin(c.originHost, siteID, msg.AdminToken)
+			}
+			if err == nil {
+				err = checkSiteToken(siteID, msg.Token)
+			}
+			if err != nil {
+				c.enqueue(errorMessage(siteID, err))
+				continue
+			}
+			_, isGroup := groupName(siteID)
 
-			if c.site != nil && c.site.ID != siteID {
-				c.hub.unregister <- c
+			meta, err := validateMeta(siteID, msg.Meta, limits)
+			if err == nil {
+				err = limits.checkVisitorID(strings.TrimSpace(msg.VisitorID))
+			}
+			if err == nil {
+				err = limits.checkVisitorID(strings.TrimSpace(msg.ClientID))
+			}
+			if err != nil {
+				c.enqueue(Message{Type: "error", SiteID: siteID, Code: errorCode(err), Message: err.Error()})
+				continue
 			}
 
-			if c.site == nil || c.site.ID != siteID {
-				site := c.hub.getSite(siteID)
-				c.site = site
-				c.hub.register <- c
+			if siteID != joined {
+				join := siteJoin{
+					client:    c,
+					meta:      meta,
+					visitorID: validVisitorID(msg.VisitorID),
+					clientID:  validVisitorID(msg.ClientID),
+					version:   validVersion(msg.Version),
+					path:      normalizePath(msg.Path),
+					referrer:  referrerHost(msg.Referrer),
+				}
+				if checkDedupMode(msg.Dedup) == nil {
+					join.dedup = msg.Dedup
+				}
+				join.observer = msg.Observe || isGroup || c.bot || settings.Get(siteID).Excludes(join.visitorID)
+				site, err := c.hub.getSite(siteID)
+				if err != nil {
+					// 新站点被拒绝：回复 rejected 后断开
+					c.enqueue(Message{
+						Type:      "rejected",
+						SiteID:    siteID,
+						Message:   "too many sites",
+						closeCode: errorCloseCode(err),
+						closeText: http.StatusText(errorStatus(err)),
+					})
+					continue
+				}
+				join.site = site
+				// 加入成功后由 Hub 回复 joined 确认，告知生效限制
+				join.limits = limits
+				joined = siteID
+				c.hub.queueJoin(join)
+			} else {
+				c.hub.queueRejoin(c, siteID)
 			}
 		}
+
+		// siteIds 订阅的站点不计入人数，可与 siteId 同时使用
+		if msg.Type == "join" && msg.SiteIDs != nil {
+			c.readSubscribe(msg.SiteIDs, msg.AdminToken, msg.Version)
+		}
+		if msg.Type == "query" {
+			c.handleQuery(msg.SiteIDs, time.Now())
+		}
+		if msg.Type == "unjoin" && strings.TrimSpace(msg.SiteID) == totalSiteID {
+			c.hub.unsubscribeTotal(c)
+			c.enqueue(Message{Type: "unjoined", SiteID: totalSiteID})
+		} else if msg.Type == "unjoin" && msg.SiteID != "" {
+			siteID = c.scopeSiteID(joinSiteID(msg.SiteID, c.origin, transportWebSocket))
+			if siteID == joined {
+				joined = ""
+			}
+			c.hub.queueUnjoin(c, siteID)
+		}
+	}
+}
+
+// 加入时使用的 siteId
+//
+// 主机部分总是规范化（见 resolveSiteID）；路径部分的大小写目前仍按原样区分，严格模式下统一转为小写。
+func joinSiteID(raw, origin, protocol string) string {
+	siteID := strings.TrimSpace(raw)
+	if canonical := canonicalSiteID(raw); canonical != raw {
+		deprecations.record(deprecatedSiteID, DeprecationSample{
+			Origin:      origin,
+			SiteIDShape: siteIDShape(raw),
+			Protocol:    protocol,
+		})
+		if strictMode(deprecatedSiteID) {
+			siteID = canonical
+		}
+	}
+	return resolveSiteID(siteID)
+}
+
+// 强制断开客户端
+func (c *Client) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		return
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// 非阻塞写入发送队列，队列已满或已关闭时返回 false
+//
+// 发往客户端的消息都经由此处进入同一个队列，按写入顺序发送。
+// v2 连接的每条消息带连接内递增的 seq，因队列已满而丢弃的消息同样占用序号，
+// 客户端据此发现缺失；预编码的广播帧不含该序号，v2 连接改为单独编码。
+func (c *Client) enqueue(message Message) bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.sendClosed {
+		return false
+	}
+	if c.kind.Protocol >= protocolVersionV2 {
+		c.sendSeq++
+		message.CountSeq, message.Seq = message.Seq, c.sendSeq
+		message.prepared = nil
+	}
+	if c.omitTimestamp {
+		message.Timestamp = 0
+		message.prepared = nil
 	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// 关闭发送队列结束 writePump，之后的 enqueue 直接丢弃；返回本次调用是否关闭了队列
+//
+// 广播丢弃慢客户端、注销、站点过期或清除、Hub 停止都可能关闭同一个队列，
+// 以 sendClosed 保证只关闭一次，先后以任何顺序发生都不会 panic。发送队列不得在别处直接 close。
+func (c *Client) closeSend() bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.sendClosed {
+		return false
+	}
+	c.sendClosed = true
+	close(c.send)
+	return true
+}
+
+// 发送队列是否已关闭
+func (c *Client) sendIsClosed() bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	return c.sendClosed
 }
 
 // 向客户端发送消息
+//
+// 单帧写入以 -write-timeout 为上限，超时后连接已无法继续使用（帧可能只写了一半），直接断开；
+// 超过其 1/4 的写入计为一次慢写，按 strikeSlowWrite 的策略容忍或以 4429 断开，
+// 慢写之间照常发送心跳。关闭帧使用独立的短超时，不会拖住关闭与排空。
 func (c *Client) writePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		c.hub.pumps.Done()
 	}()
 
+	strikes := 0
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				writeClose(c.conn, 0, "")
+				return
+			}
+
+			elapsed, err := c.writeMessage(message)
+			if err != nil {
+				if isWriteTimeout(err) {
+					c.hub.slowWriteClosed.Add(1)
+					log.Printf("客户端 %s 写入超时（%v），断开", c.ip, *writeTimeout)
+				}
 				return
 			}
 
-			if err := c.conn.WriteJSON(message); err != nil {
+			if message.closeCode != 0 {
+				writeClose(c.conn, message.closeCode, message.closeText)
 				return
 			}
 
+			if elapsed > slowWriteBudget() {
+				strikes++
+				if c.strikeSlowWrite(elapsed, strikes) {
+					writeClose(c.conn, slowClientCloseCode, "slow client")
+					return
+				}
+			}
+
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(*writeTimeout)); err != nil {
 				return
 			}
 		}
@@ -423,55 +1737,350 @@ func (c *Client) writePump() {
 
 // 主函数
 func main() {
+	// liveuser check：只校验配置，不启动服务
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		flag.CommandLine.Parse(os.Args[2:])
+		if err := checkConfig(); err != nil {
+			log.Fatalf("配置检查失败: %v", err)
+		}
+		log.Println("配置检查通过")
+		return
+	}
+	// liveuser capacity：对以 -allow-loadtest 启动的实例做容量测试
+	if len(os.Args) > 1 && os.Args[1] == "capacity" {
+		if err := runCapacity(os.Args[2:]); err != nil {
+			log.Fatalf("容量测试失败: %v", err)
+		}
+		return
+	}
+
 	flag.Parse()
+	if *showVersion {
+		fmt.Print(versionText())
+		return
+	}
+	if err := checkStrict(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := checkDedupMode(*dedupMode); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if _, err := parseRestartNotices(*restartNotices); err != nil {
+		log.Fatalf("%v", err)
+	}
+	origins, err := parseAllowedOrigins(*allowedOrigins)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	originAllowlist = origins
+	cors, err := parseCORSOrigins(*corsOrigins)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	corsAllowlist = cors
+	proxies, err := parseTrustedProxies(*trustedProxiesFlag)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	trustedProxies = proxies
+	if siteIDPattern, err = compileSiteIDPattern(*siteIDPatternFlag); err != nil {
+		log.Fatalf("%v", err)
+	}
+	cloudflareRanges, err := loadCloudflareRanges()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	setCloudflareRanges(cloudflareRanges)
+	botPatterns, err := loadBotPatterns()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	setBotPatterns(botPatterns)
+	blocked, blocklistModTime, err := loadBlocklist()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	setBlocklist(blocked, blocklistModTime)
+	tokens, err := loadSiteTokens()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	setSiteTokens(tokens)
+	tenantKeys, err := loadTenants()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	setTenants(tenantKeys)
+	if err := checkPeakReset(*peakReset); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := checkLimits(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := checkTLS(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := checkListenAddr(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if tlsEnabled() {
+		if err := loadTLSCertificate(); err != nil {
+			log.Fatalf("读取 TLS 证书失败: %v", err)
+		}
+	}
+	if *minClientVersion != "" && compareVersions(scriptVersion, *minClientVersion) < 0 {
+		log.Printf("警告: -min-client-version %s 高于内置脚本版本 %s，重新加载后的挂件仍会被要求升级", *minClientVersion, scriptVersion)
+	}
+	if *enforceOrigin && *adminToken == "" {
+		log.Printf("警告: 已开启 -enforce-origin 但未设置 -admin-token，管理面板等跨站连接无法豁免来源检查")
+	}
+	loadAssets()
+	startBroker, err := newBroker()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	webhooks, err := newWebhookNotifier()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// 应用运行时调优参数
+	tuning, err := applyRuntimeTuning()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	runtimeSettings = tuning
 
-	// 初始化Hub
+	// 加载站点配置
+	store, err := LoadSettings(*sitesFile)
+	if err != nil {
+		log.Fatalf("加载站点配置失败: %v", err)
+	}
+	settings = store
+	warnUnnormalizedSites()
+
+	// 加载语言包
+	loaded, err := loadLocales(*localesDir)
+	if err != nil {
+		log.Fatalf("加载语言包失败: %v", err)
+	}
+	locales = loaded
+
+	// 初始化Hub，并恢复上次保存的累计计数与站点统计
 	hub = NewHub()
+	if err := hub.loadState(*stateFile); err != nil {
+		log.Fatalf("加载状态文件失败: %v", err)
+	}
+	hub.webhooks = webhooks
+	if webhooks != nil {
+		go webhooks.run()
+		log.Printf("Webhook 通知已启用，阈值 %v，冷却 %v", webhooks.thresholds, webhooks.cooldown)
+	}
 	go hub.Run()
+	alerts.setRules(settings.Alerts())
+	go alerts.run(*alertInterval)
+	if startBroker != nil {
+		broker = startBroker()
+		broker.Subscribe(hub.remoteChanged)
+	}
+	go hub.runStateFlusher(*stateFile, *stateFlushInterval)
 
 	// 设置路由
-	http.HandleFunc("/", handleRequest)
+	http.HandleFunc("/", withCORS(handleRequest))
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("/api/version", withCORS(handleVersion))
+	http.HandleFunc("/.well-known/liveuser.json", handleWellKnown)
+	http.HandleFunc("/config.json", handleWellKnown)
+	http.HandleFunc("/api/stats", withCORS(handleStats))
+	http.HandleFunc("/api/count", withCORS(tenantScoped(handleCount)))
+	http.HandleFunc("/api/sites", withCORS(handleTenantSites))
+	http.HandleFunc("/api/total", withCORS(handleTotal))
+	http.HandleFunc("/api/pages", withCORS(tenantScoped(handlePages)))
+	http.HandleFunc("/api/breakdown", withCORS(tenantScoped(handleBreakdown)))
+	http.HandleFunc("/api/site/", withCORS(tenantDisabled(handleSiteDetail)))
+	http.HandleFunc("/api/trending", withCORS(tenantDisabled(handleTrending)))
+	http.HandleFunc("/api/uniques", withCORS(tenantScoped(handleUniques)))
+	http.HandleFunc("/api/peak", withCORS(tenantScoped(handlePeak)))
+	http.HandleFunc("/api/history", withCORS(tenantScoped(handleHistory)))
+	http.HandleFunc("/sse", handleSSE)
+	http.HandleFunc("/poll/join", handlePollJoin)
+	http.HandleFunc("/poll/wait", handlePollWait)
+	http.HandleFunc("/poll/leave", handlePollLeave)
+	http.HandleFunc("/beacon.gif", handleBeacon)
+	http.HandleFunc("/badge", withCORS(tenantDisabled(handleBadge)))
+	http.HandleFunc("/embed", tenantDisabled(handleEmbed))
+	http.HandleFunc("/badge/", withCORS(tenantDisabled(handleBadge)))
+	http.HandleFunc("/api/claim", handleClaim)
+	http.HandleFunc("/api/claim/verify", handleClaimVerify)
+	http.HandleFunc("/owner/ws", handleOwnerWS)
+	http.HandleFunc("/owner/api/sites/", handleOwnerAPI)
+	http.HandleFunc("/dev/ws", handleDevWS)
+	http.HandleFunc("/admin/feed", handleAdminFeed)
+	http.HandleFunc("/admin/api/connections", handleAdminConnections)
+	http.HandleFunc("/admin/api/sites", handleAdminSites)
+	http.HandleFunc("/admin/api/sites/", handleAdminSite)
+	http.HandleFunc("/admin/api/groups", handleAdminGroups)
+	http.HandleFunc("/admin/api/groups/", handleAdminGroup)
+	http.HandleFunc("/admin/api/deprecations", handleAdminDeprecations)
+	http.HandleFunc("/admin/api/schedule-restart", handleAdminScheduleRestart)
+	http.HandleFunc("/admin/api/queues", handleAdminQueues)
+	http.HandleFunc("/admin/api/keys", handleAdminKeys)
+	http.HandleFunc("/admin/api/alerts", handleAdminAlerts)
+	http.HandleFunc("/admin/api/capacity", handleAdminCapacity)
+	metricsServer := setupMetrics()
 
 	// 创建服务器
 	server := &http.Server{
 		Addr:    *addr,
 		Handler: nil,
 	}
+	if tlsEnabled() {
+		server.TLSConfig = newTLSConfig()
+	}
+
+	// 计划重启排空期间关闭 keep-alive，响应带 Connection: close，负载均衡随之摘除实例
+	restarts.onDrain = func(draining bool) {
+		server.SetKeepAlivesEnabled(!draining)
+	}
+
+	// 先创建监听，地址被占用或套接字无法创建时直接退出
+	listener, listenDesc, err := openListener()
+	if err != nil {
+		log.Fatalf("服务器启动失败: %v", err)
+	}
+	var redirectServer *http.Server
+	if *tlsRedirect != "" {
+		redirectServer = newRedirectServer(listener.Addr().String())
+	}
 
 	// 启动服务器
 	go func() {
-		log.Printf("LiveUser v%s 启动成功，监听 %s", Version, *addr)
-
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if tlsEnabled() {
+			log.Printf("LiveUser v%s 启动成功，监听 %s（TLS）", Version, listenDesc)
+		} else {
+			log.Printf("LiveUser v%s 启动成功，监听 %s", Version, listenDesc)
+		}
+		if *devMode {
+			log.Println("开发模式已开启，请勿用于生产环境")
+		}
+		log.Printf("运行参数: GOMAXPROCS=%d（%s），GOGC=%d，GOMEMLIMIT=%s",
+			runtimeSettings.GOMAXPROCS, runtimeSettings.GOMAXPROCSSource,
+			runtimeSettings.GOGC, formatMemLimit(runtimeSettings.GOMEMLIMIT))
+
+		var err error
+		if tlsEnabled() {
+			// 证书由 TLSConfig.GetCertificate 提供
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("服务器启动失败: %v", err)
 		}
 	}()
 
-	// 等待关闭信号
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	// 明文 HTTP 请求跳转到 HTTPS
+	if redirectServer != nil {
+		go func() {
+			log.Printf("HTTP 跳转服务监听 %s", redirectServer.Addr)
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("HTTP 跳转服务启动失败: %v", err)
+			}
+		}()
+	}
 
-	log.Println("正在关闭服务器...")
+	// 指标使用独立地址时单独监听
+	if metricsServer != nil {
+		go func() {
+			log.Printf("指标服务监听 %s", metricsServer.Addr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("指标服务启动失败: %v", err)
+			}
+		}()
+	}
 
-	// 通知所有客户端即将关闭
-	hub.mutex.RLock()
-	for _, site := range hub.sites {
-		site.mutex.RLock()
-		for client := range site.Connections {
-			shutdownMsg := Message{
-				Type:    "shutdown",
-				Message: "服务器重启中，请稍后重连",
+	// SIGHUP 重新读取 TLS 证书、站点配置、Cloudflare IP 段、爬虫规则、封禁列表、加入令牌与租户
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			// 证书与站点配置无关，未使用站点配置文件时同样重新读取
+			reloadTLSCertificate()
+
+			// 爬虫规则、封禁列表、加入令牌与租户同样与站点配置无关，读取失败时保留原有内容
+			if patterns, err := loadBotPatterns(); err != nil {
+				log.Printf("重新加载爬虫规则失败: %v", err)
+			} else {
+				setBotPatterns(patterns)
+			}
+			if *blocklistFile != "" {
+				reloadBlocklist()
+			}
+			if tokens, err := loadSiteTokens(); err != nil {
+				log.Printf("重新加载站点加入令牌失败: %v", err)
+			} else {
+				setSiteTokens(tokens)
 			}
-			select {
-			case client.send <- shutdownMsg:
-			default:
+			if tenantKeys, err := loadTenants(); err != nil {
+				log.Printf("重新加载租户失败: %v", err)
+			} else {
+				setTenants(tenantKeys)
+			}
+
+			groups, err := settings.Reload()
+			if err != nil {
+				log.Printf("重新加载站点配置失败: %v", err)
+				continue
+			}
+			for _, name := range groups {
+				hub.queueGroupChanged(name)
+			}
+			warnUnnormalizedSites()
+			alerts.setRules(settings.Alerts())
+			log.Printf("站点配置已重新加载，共 %d 个站点", settings.Len())
+
+			// Cloudflare IP 段读取失败时保留原有列表
+			if ranges, err := loadCloudflareRanges(); err != nil {
+				log.Printf("重新加载 Cloudflare IP 段失败: %v", err)
+			} else {
+				setCloudflareRanges(ranges)
 			}
-			client.conn.Close()
 		}
-		site.mutex.RUnlock()
+	}()
+
+	// 等待关闭信号或计划重启
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	waitForShutdown(quit, restarts.fire)
+
+	log.Println("正在关闭服务器...")
+
+	stages := []shutdownStage{
+		{"标记为未就绪", func(ctx context.Context) error {
+			hub.markClosing()
+			return nil
+		}},
+		{"停止接受新连接", server.Shutdown},
+		{"排空 WebSocket 连接", hub.drain},
+		{"等待连接协程退出", hub.waitPumps},
+		{"停止 Hub", func(ctx context.Context) error {
+			hub.Stop()
+			return nil
+		}},
+		{"保存 Hub 状态", func(ctx context.Context) error {
+			return hub.saveState(*stateFile)
+		}},
+		{"投递剩余的 Webhook 通知", webhooks.Close},
+		{"停止多实例同步", broker.Close},
+	}
+	if metricsServer != nil {
+		stages = append(stages, shutdownStage{"关闭指标服务", metricsServer.Shutdown})
+	}
+	if redirectServer != nil {
+		stages = append(stages, shutdownStage{"关闭 HTTP 跳转服务", redirectServer.Shutdown})
 	}
-	hub.mutex.RUnlock()
+	runShutdown(stages, *shutdownTimeout)
 
 	log.Println("服务器已关闭")
 }
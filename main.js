@@ -14,44 +14,285 @@
     
     // 配置项（由服务器动态生成）
     const CONFIG = {
-        serverUrl: '{{.ServerURL}}',
-        siteId: '{{.SiteID}}',
-        displayElementId: '{{.DisplayElementID}}',
+        serverUrl: '{{js .ServerURL}}',
+        siteId: '{{js .SiteID}}',
+        displayElementId: '{{js .DisplayElementID}}',
         reconnectDelay: {{.ReconnectDelay}},
-        debug: {{.Debug}}
+        // 重连退避：每次重连的间隔乘以 reconnectMultiplier，最长 reconnectMaxDelay，
+        // 并在 [1 - reconnectJitter, 1] 倍之间随机取值；连上后恢复为 reconnectDelay
+        reconnectMaxDelay: {{.ReconnectMaxDelay}},
+        reconnectMultiplier: {{.ReconnectMultiplier}},
+        reconnectJitter: {{.ReconnectJitter}},
+        debug: {{.Debug}},
+        testCount: {{.TestCount}},
+        lang: '{{js .Lang}}',
+        format: '{{js .Format}}',
+        // 人数为 1 时的显示格式，为空时使用 format
+        formatOne: '{{js .FormatOne}}',
+        // 人数不足该值时清空并隐藏显示元素，0 表示总是显示
+        hideBelow: {{.HideBelow}},
+        label: '{{js .Label}}',
+        sseFallback: {{.SSEFallback}},
+        dedup: '{{js .Dedup}}',
+        idleTimeout: {{.IdleTimeout}},
+        observe: {{.Observe}},
+        // 按页面统计人数的路径，默认为当前页面
+        path: '{{js .Path}}' || location.pathname,
+        // 站点加入令牌，站点在服务器的令牌文件中时必须携带
+        token: '{{js .Token}}',
+        // 多租户模式下的 API 密钥
+        apiKey: '{{js .APIKey}}',
+        version: '{{js .ScriptVersion}}',
+        // 生成脚本的服务器版本，仅用于调试输出
+        serverVersion: '{{js .ServerVersion}}'
     };
     
+    // 可由服务器 configUpdate 实时修改的配置项，未下发的项恢复为嵌入时的值
+    const LIVE_CONFIG_KEYS = ['lang', 'format', 'formatOne', 'label', 'reconnectDelay', 'sseFallback', 'idleTimeout'];
+    const EMBED_CONFIG = Object.assign({}, CONFIG);
+    
+    // 站长在服务器上设置的挂件配置，优先于嵌入参数
+    const SITE_CONFIG = {{.Widget}};
+    
+    // WebSocket 连续失败该次数后改用 SSE
+    const SSE_FALLBACK_AFTER = 2;
+    
+    // 服务器因写入过慢断开的关闭码，与其他 44xx 不同，退避后仍会重连
+    const SLOW_CLIENT_CLOSE_CODE = 4429;
+    
+    // 当前脚本的地址，服务器要求升级时据此重新加载
+    const SCRIPT_SRC = document.currentScript ? document.currentScript.src : '';
+    
     // LiveUser 核心类
     class LiveUser {
         constructor() {
             this.ws = null;
+            this.es = null;
+            this.useSSE = false;
+            this.wsFailures = 0;
+            this.backoffAttempts = 0;
+            // 服务器关闭前在 shutdown 中建议的等待秒数，首次重连前生效
+            this.retryAfter = 0;
             this.isActive = true;
             this.reconnectTimer = null;
+            this.idleTimer = null;
+            this.retired = false;
             this.currentCount = 0;
+            this.hasCount = false;
+            this.labelApplied = false;
             this.displayElement = document.getElementById(CONFIG.displayElementId);
+            this.visitorId = this.getStoredId('liveuser_vid');
+            // 同一浏览器的多个标签页共用 clientId，服务器只计一次
+            this.clientId = this.getStoredId('liveuser_cid');
             
             this.init();
         }
         
         init() {
-            this.log('LiveUser 初始化，站点: ' + CONFIG.siteId);
+            this.log('LiveUser 初始化，站点: ' + CONFIG.siteId + '，脚本 ' + CONFIG.version + '，服务器 ' + CONFIG.serverVersion);
+            if (SITE_CONFIG) {
+                LIVE_CONFIG_KEYS.forEach((key) => {
+                    if (Object.prototype.hasOwnProperty.call(SITE_CONFIG, key)) {
+                        CONFIG[key] = SITE_CONFIG[key];
+                    }
+                });
+            }
             this.checkDisplayElement();
+            
+            // 开发模式：显示固定人数，不建立连接
+            if (CONFIG.testCount >= 0) {
+                console.warn('[LiveUser][DEV] 测试模式，固定显示 ' + CONFIG.testCount + '，未连接服务器');
+                this.updateCount(CONFIG.testCount);
+                return;
+            }
+            
             this.setupEventListeners();
+            this.checkExcludeRequest();
             this.connect();
         }
         
+        // 获取保存在 localStorage 中的持久化 ID，不存在时生成
+        getStoredId(key) {
+            try {
+                let id = localStorage.getItem(key);
+                if (!id) {
+                    id = (window.crypto && crypto.randomUUID)
+                        ? crypto.randomUUID()
+                        : Date.now().toString(36) + Math.random().toString(36).slice(2);
+                    localStorage.setItem(key, id);
+                }
+                return id;
+            } catch (err) {
+                return '';
+            }
+        }
+        
+        // 站长排除自己：页面地址带 liveuserExclude=<站点密钥> 时调用站长接口
+        checkExcludeRequest() {
+            let key = null;
+            try {
+                key = new URLSearchParams(window.location.search).get('liveuserExclude');
+            } catch (err) {
+                return;
+            }
+            if (!key || !this.visitorId || typeof fetch === 'undefined') {
+                return;
+            }
+            
+            const apiUrl = CONFIG.serverUrl.replace(/^ws/, 'http').replace(/\/$/, '') +
+                '/owner/api/sites/' + encodeURIComponent(CONFIG.siteId) + '/exclusions';
+            fetch(apiUrl, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Site-Key': key },
+                body: JSON.stringify({ visitorId: this.visitorId })
+            }).then((res) => {
+                if (!res.ok) {
+                    throw new Error('HTTP ' + res.status);
+                }
+                this.log('已将当前访客排除在统计之外');
+                // 重新加入，以观察者身份连接
+                if (this.ws) {
+                    this.ws.close(1000, '重新加入');
+                }
+                if (this.es) {
+                    this.es.close();
+                    this.es = null;
+                    this.connect();
+                }
+            }).catch((err) => {
+                this.log('排除访客失败: ' + err.message);
+            });
+        }
+        
         checkDisplayElement() {
             if (!this.displayElement) {
                 this.log('警告: 找不到元素 #' + CONFIG.displayElementId);
+                return;
+            }
+            if (CONFIG.label && !this.displayElement.hasAttribute('title')) {
+                this.displayElement.setAttribute('title', CONFIG.label);
+                this.labelApplied = true;
             }
         }
         
+        // 应用服务器推送的配置：重新渲染文本与提示，并按新的空闲时长调整断开计时
+        applyConfig(config) {
+            LIVE_CONFIG_KEYS.forEach((key) => {
+                CONFIG[key] = Object.prototype.hasOwnProperty.call(config, key) ? config[key] : EMBED_CONFIG[key];
+            });
+            this.log('配置已更新');
+            
+            if (this.displayElement) {
+                if (this.hasCount) {
+                    this.renderCount(this.currentCount);
+                }
+                // 页面自己设置的 title 保持不变
+                if (this.labelApplied || !this.displayElement.hasAttribute('title')) {
+                    if (CONFIG.label) {
+                        this.displayElement.setAttribute('title', CONFIG.label);
+                    } else {
+                        this.displayElement.removeAttribute('title');
+                    }
+                    this.labelApplied = !!CONFIG.label;
+                }
+            }
+            
+            if (typeof document !== 'undefined' && document.hidden) {
+                this.scheduleIdle();
+            }
+            
+            if (typeof CustomEvent !== 'undefined') {
+                try {
+                    window.dispatchEvent(new CustomEvent('liveuser:config', {
+                        detail: { config: config, siteId: CONFIG.siteId }
+                    }));
+                } catch (err) {
+                    // 忽略事件触发错误
+                }
+            }
+        }
+        
+        // 绕过缓存重新加载脚本，新脚本加载后接替当前实例；每个页面只尝试一次，
+        // 服务器仍返回旧版本时不再重复加载，等待服务器在期限后断开
+        upgradeScript() {
+            if (!SCRIPT_SRC || window.__liveuserUpgraded) {
+                return;
+            }
+            window.__liveuserUpgraded = true;
+            
+            const script = document.createElement('script');
+            script.src = SCRIPT_SRC + (SCRIPT_SRC.indexOf('?') >= 0 ? '&' : '?') + '_lu=' + Date.now();
+            script.onerror = () => {
+                this.log('重新加载脚本失败，继续使用当前版本');
+                this.retired = false;
+                this.reconnect();
+            };
+            // 由新脚本的实例接替，当前实例不再响应页面事件
+            this.retired = true;
+            this.disconnect();
+            this.log('重新加载脚本: ' + script.src);
+            document.head.appendChild(script);
+        }
+        
+        // 页面隐藏超过 idleTimeout 秒后断开，不再计入在线人数；重新显示时重连
+        scheduleIdle() {
+            this.cancelIdle();
+            if (!(CONFIG.idleTimeout > 0)) {
+                return;
+            }
+            this.idleTimer = setTimeout(() => {
+                this.idleTimer = null;
+                if (!document.hidden) {
+                    return;
+                }
+                this.log('页面隐藏超过 ' + CONFIG.idleTimeout + ' 秒，断开连接');
+                if (this.ws) {
+                    this.ws.close(1000, '页面空闲');
+                    this.ws = null;
+                }
+                if (this.es) {
+                    this.es.close();
+                    this.es = null;
+                }
+            }, CONFIG.idleTimeout * 1000);
+        }
+        
+        cancelIdle() {
+            if (this.idleTimer) {
+                clearTimeout(this.idleTimer);
+                this.idleTimer = null;
+            }
+        }
+        
+        // 按显示格式生成文本
+        // 把人数写入显示元素；设置了 hideBelow 且人数不足时清空并隐藏
+        renderCount(count) {
+            const hidden = CONFIG.hideBelow > 0 && count < CONFIG.hideBelow;
+            if (CONFIG.hideBelow > 0) {
+                this.displayElement.hidden = hidden;
+            }
+            this.displayElement.textContent = hidden ? '' : this.formatCount(count);
+        }
+        
+        formatCount(count) {
+            const format = count === 1 && CONFIG.formatOne ? CONFIG.formatOne : CONFIG.format;
+            return format.split('{count}').join(String(count));
+        }
+        
         setupEventListeners() {
             // 页面可见性变化
             if (typeof document !== 'undefined' && 'visibilitychange' in document) {
                 document.addEventListener('visibilitychange', () => {
+                    if (this.retired) {
+                        return;
+                    }
                     this.isActive = !document.hidden;
-                    if (this.isActive && (!this.ws || this.ws.readyState !== WebSocket.OPEN)) {
+                    if (!this.isActive) {
+                        this.scheduleIdle();
+                        return;
+                    }
+                    this.cancelIdle();
+                    if (!this.isConnected()) {
                         this.connect();
                     }
                 });
@@ -64,12 +305,18 @@
                     if (this.ws) {
                         this.ws.close(1000, '页面关闭');
                     }
+                    if (this.es) {
+                        this.es.close();
+                    }
                 });
             }
             
             // 网络状态监听
             if (typeof navigator !== 'undefined' && 'onLine' in navigator) {
                 window.addEventListener('online', () => {
+                    if (this.retired) {
+                        return;
+                    }
                     this.log('网络恢复');
                     this.connect();
                 });
@@ -82,7 +329,12 @@
                 this.reconnectTimer = null;
             }
             
-            if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+            if (this.isConnected()) {
+                return;
+            }
+            
+            if (this.useSSE) {
+                this.connectSSE();
                 return;
             }
             
@@ -90,12 +342,25 @@
             
             try {
                 this.ws = new WebSocket(CONFIG.serverUrl);
+                let opened = false;
                 
                 this.ws.onopen = () => {
+                    opened = true;
+                    this.wsFailures = 0;
+                    this.backoffAttempts = 0;
                     this.log('连接成功');
                     this.ws.send(JSON.stringify({
                         type: 'join',
-                        siteId: CONFIG.siteId
+                        siteId: CONFIG.siteId,
+                        visitorId: this.visitorId,
+                        clientId: this.clientId || undefined,
+                        dedup: CONFIG.dedup || undefined,
+                        observe: CONFIG.observe || undefined,
+                        path: CONFIG.path || undefined,
+                        referrer: document.referrer || undefined,
+                        token: CONFIG.token || undefined,
+                        apiKey: CONFIG.apiKey || undefined,
+                        version: CONFIG.version || undefined
                     }));
                 };
                 
@@ -110,8 +375,25 @@
                 
                 this.ws.onclose = (event) => {
                     this.log('连接关闭: ' + event.code);
+                    // 44xx: 站点不存在、已过期等客户端错误，重连也无法恢复；
+                    // 4429 为网络拥塞导致写入过慢，退避后重连
+                    if (event.code >= 4400 && event.code < 4500 && event.code !== SLOW_CLIENT_CLOSE_CODE) {
+                        this.isActive = false;
+                    }
+                    // 从未连上：可能被代理拦截了 WebSocket 升级
+                    if (!opened && this.isActive && this.canUseSSE()) {
+                        this.wsFailures++;
+                        if (this.wsFailures >= SSE_FALLBACK_AFTER) {
+                            this.log('WebSocket 多次连接失败，改用 SSE');
+                            this.useSSE = true;
+                            this.ws = null;
+                            this.connect();
+                            return;
+                        }
+                    }
                     if (this.isActive) {
-                        this.scheduleReconnect();
+                        // 从未连上（如实例连接数已满返回 503）时退避更久
+                        this.scheduleReconnect(!opened || event.code === SLOW_CLIENT_CLOSE_CODE);
                     }
                 };
                 
@@ -125,26 +407,186 @@
             }
         }
         
+        // 是否可以改用 SSE
+        canUseSSE() {
+            return CONFIG.sseFallback && typeof EventSource !== 'undefined';
+        }
+        
+        // 是否已连接（WebSocket 或 SSE）
+        isConnected() {
+            if (this.es) {
+                return this.es.readyState !== EventSource.CLOSED;
+            }
+            return !!this.ws && this.ws.readyState === WebSocket.OPEN;
+        }
+        
+        // 通过 SSE 接收人数，用于无法建立 WebSocket 的网络环境
+        connectSSE() {
+            const sseUrl = CONFIG.serverUrl.replace(/^ws/, 'http').replace(/\/$/, '') +
+                '/sse?siteId=' + encodeURIComponent(CONFIG.siteId) +
+                (this.visitorId ? '&visitorId=' + encodeURIComponent(this.visitorId) : '') +
+                (this.clientId ? '&clientId=' + encodeURIComponent(this.clientId) : '') +
+                (CONFIG.dedup ? '&dedup=' + encodeURIComponent(CONFIG.dedup) : '') +
+                (CONFIG.observe ? '&observe=true' : '') +
+                (CONFIG.path ? '&path=' + encodeURIComponent(CONFIG.path) : '') +
+                (document.referrer ? '&referrer=' + encodeURIComponent(document.referrer) : '') +
+                (CONFIG.token ? '&token=' + encodeURIComponent(CONFIG.token) : '') +
+                (CONFIG.apiKey ? '&apiKey=' + encodeURIComponent(CONFIG.apiKey) : '') +
+                (CONFIG.version ? '&version=' + encodeURIComponent(CONFIG.version) : '');
+            this.log('连接 SSE: ' + sseUrl);
+            
+            this.es = new EventSource(sseUrl);
+            
+            this.es.onopen = () => {
+                this.backoffAttempts = 0;
+            };
+            
+            this.es.onmessage = (event) => {
+                try {
+                    this.handleMessage(JSON.parse(event.data));
+                } catch (err) {
+                    this.log('解析消息失败: ' + err.message);
+                }
+            };
+            
+            // 服务器结束订阅时给出与 WebSocket 相同的关闭码
+            this.es.addEventListener('close', (event) => {
+                let code = 0;
+                try {
+                    code = JSON.parse(event.data).code;
+                } catch (err) {
+                    // 忽略
+                }
+                this.log('SSE 关闭: ' + code);
+                this.es.close();
+                this.es = null;
+                if (code >= 4400 && code < 4500) {
+                    this.isActive = false;
+                }
+                if (this.isActive) {
+                    this.scheduleReconnect();
+                }
+            });
+            
+            // 浏览器会自动重连，连接被彻底关闭时再由我们重连
+            this.es.onerror = () => {
+                this.log('SSE 连接错误');
+                if (this.es && this.es.readyState === EventSource.CLOSED) {
+                    this.es = null;
+                    if (this.isActive) {
+                        this.scheduleReconnect();
+                    }
+                }
+            };
+        }
+        
         handleMessage(data) {
             switch (data.type) {
+                case 'joined':
+                    // 服务器可能把子域名收拢为可注册域名，之后按实际计数的 siteId 接收消息
+                    if (data.siteId && data.siteId !== CONFIG.siteId) {
+                        this.log('站点按 ' + data.siteId + ' 计数');
+                        CONFIG.siteId = data.siteId;
+                    }
+                    break;
                 case 'update':
+                case 'welcome':
+                    // welcome 是加入后直接发给本连接的当前人数，与 update 同样处理
                     if (data.siteId === CONFIG.siteId) {
-                        this.updateCount(data.count);
+                        if (data.type === 'welcome' && data.version) {
+                            this.log('服务器版本: ' + data.version);
+                        }
+                        if (data.message === 'dev-mode') {
+                            console.warn('[LiveUser][DEV] 脚本化人数: ' + (data.count || 0));
+                        }
+                        // 突发模式下服务器定时同步，并给出 rising/falling 趋势
+                        this.updateCount(data.count || 0, data.trend);
+                    }
+                    break;
+                case 'update_page':
+                    // 同一页面的人数，显示在 #<displayElementId>-page 中
+                    if (data.siteId === CONFIG.siteId) {
+                        this.updatePageCount(data.path, data.count || 0);
                     }
                     break;
                 case 'shutdown':
                     this.log('服务器通知: ' + (data.message || '服务器维护'));
+                    this.retryAfter = data.retryAfter || 0;
                     break;
+                case 'restart':
+                    // 计划重启的倒计时通知，页面可监听 liveuser:restart 自行提示
+                    this.log('服务器将于 ' + new Date(data.restartAt * 1000).toLocaleString() + ' 重启: ' + data.message);
+                    if (typeof CustomEvent !== 'undefined') {
+                        try {
+                            window.dispatchEvent(new CustomEvent('liveuser:restart', {
+                                detail: { message: data.message, restartAt: data.restartAt }
+                            }));
+                        } catch (err) {
+                            // 忽略事件触发错误
+                        }
+                    }
+                    break;
+                case 'expired':
+                    this.log('站点已过期: ' + data.siteId);
+                    this.isActive = false;
+                    break;
+                case 'erased':
+                    this.log('站点数据已清除: ' + data.siteId);
+                    this.isActive = false;
+                    break;
+                case 'error':
+                    this.log('服务器错误: ' + data.message);
+                    break;
+                case 'rejected':
+                    // 站点连接数已满，服务器随后以 4503 断开，按正常间隔重连
+                    this.log('服务器拒绝加入: ' + data.message);
+                    break;
+                case 'custom':
+                    if (data.siteId === CONFIG.siteId) {
+                        this.onCustomEvent(data.event, data.data || {});
+                    }
+                    break;
+                case 'upgrade_required':
+                    this.log('服务器要求升级挂件脚本: ' + data.message);
+                    this.upgradeScript();
+                    break;
+                case 'configUpdate':
+                    // 站长修改了挂件配置，无需刷新页面即可生效
+                    if (data.siteId === CONFIG.siteId) {
+                        this.applyConfig(data.config || {});
+                    }
+                    break;
+            }
+        }
+        
+        // 站长发布的自定义事件；可覆盖 window.LiveUser.onCustomEvent，
+        // 或监听 window 上的 liveuser:custom 事件
+        onCustomEvent(name, data) {
+            this.log('自定义事件: ' + name);
+            if (typeof CustomEvent !== 'undefined') {
+                try {
+                    window.dispatchEvent(new CustomEvent('liveuser:custom', {
+                        detail: { event: name, data: data, siteId: CONFIG.siteId }
+                    }));
+                } catch (err) {
+                    // 忽略事件触发错误
+                }
             }
         }
         
-        updateCount(count) {
+        updateCount(count, trend) {
             const oldCount = this.currentCount;
             this.currentCount = count;
+            this.hasCount = true;
             
             if (this.displayElement) {
+                if (trend) {
+                    this.displayElement.setAttribute('data-trend', trend);
+                } else {
+                    this.displayElement.removeAttribute('data-trend');
+                }
                 this.displayElement.classList.add('updating');
-                this.displayElement.textContent = count;
+                this.renderCount(count);
                 
                 setTimeout(() => {
                     if (this.displayElement) {
@@ -161,7 +603,7 @@
             if (typeof window !== 'undefined' && typeof CustomEvent !== 'undefined') {
                 try {
                     const event = new CustomEvent('liveuser:update', {
-                        detail: { count: count, oldCount: oldCount, siteId: CONFIG.siteId }
+                        detail: { count: count, oldCount: oldCount, siteId: CONFIG.siteId, trend: trend || null }
                     });
                     window.dispatchEvent(event);
                 } catch (err) {
@@ -170,18 +612,54 @@
             }
         }
         
-        scheduleReconnect() {
+        // 当前页面的人数；可监听 window 上的 liveuser:page 事件
+        updatePageCount(path, count) {
+            this.pageCount = count;
+            const element = document.getElementById(CONFIG.displayElementId + '-page');
+            if (element) {
+                element.textContent = String(count);
+            }
+            if (typeof CustomEvent !== 'undefined') {
+                try {
+                    window.dispatchEvent(new CustomEvent('liveuser:page', {
+                        detail: { count: count, path: path, siteId: CONFIG.siteId }
+                    }));
+                } catch (err) {
+                    // 忽略事件触发错误
+                }
+            }
+        }
+        
+        scheduleReconnect(backoff) {
             if (this.reconnectTimer || !this.isActive) {
                 return;
             }
             
-            this.log('将在 ' + (CONFIG.reconnectDelay / 1000) + ' 秒后重连');
+            // 按指数退避并加随机抖动，避免服务器重启后大量挂件同时重连；
+            // 握手失败（backoff）时直接从第二级开始，连上后 backoffAttempts 归零
+            if (backoff && this.backoffAttempts === 0) {
+                this.backoffAttempts = 1;
+            }
+            let delay = Math.min(
+                CONFIG.reconnectDelay * Math.pow(CONFIG.reconnectMultiplier, this.backoffAttempts),
+                CONFIG.reconnectMaxDelay
+            );
+            this.backoffAttempts++;
+            delay = delay * (1 - CONFIG.reconnectJitter) + Math.random() * delay * CONFIG.reconnectJitter;
+            
+            // 服务器关闭时建议的等待时长只用于关闭后的第一次重连
+            if (this.retryAfter > 0) {
+                delay += this.retryAfter * 1000;
+                this.retryAfter = 0;
+            }
+            
+            this.log('将在 ' + Math.round(delay / 1000) + ' 秒后重连');
             this.reconnectTimer = setTimeout(() => {
                 this.reconnectTimer = null;
                 if (this.isActive) {
                     this.connect();
                 }
-            }, CONFIG.reconnectDelay);
+            }, delay);
         }
         
         getCount() {
@@ -189,6 +667,10 @@
         }
         
         getStatus() {
+            if (this.es) {
+                return this.es.readyState === EventSource.OPEN ? 'connected' :
+                    this.es.readyState === EventSource.CONNECTING ? 'connecting' : 'closed';
+            }
             if (!this.ws) return 'disconnected';
             const states = {
                 [WebSocket.CONNECTING]: 'connecting',
@@ -209,6 +691,10 @@
                 this.ws.close(1000, '手动断开');
                 this.ws = null;
             }
+            if (this.es) {
+                this.es.close();
+                this.es = null;
+            }
         }
         
         reconnect() {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 站点数差一个到上限时两个新站点同时到达：只有一个能创建，另一个收到 ErrTooManySites，
+// 已有站点照常加入
+func TestGetSiteMaxSitesRace(t *testing.T) {
+	setFlag(t, "max-sites", "2")
+
+	for i := 0; i < 200; i++ {
+		h := NewHub()
+		if _, err := h.getSite("existing.example"); err != nil {
+			t.Fatal(err)
+		}
+
+		start := make(chan struct{})
+		errs := make(chan error, 2)
+		var wg sync.WaitGroup
+		for _, siteID := range []string{"a.example", "b.example"} {
+			wg.Add(1)
+			go func(siteID string) {
+				defer wg.Done()
+				<-start
+				_, err := h.getSite(siteID)
+				errs <- err
+			}(siteID)
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+
+		created, rejected := 0, 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, ErrTooManySites):
+				rejected++
+			default:
+				t.Fatalf("意外的错误: %v", err)
+			}
+		}
+		if created != 1 || rejected != 1 {
+			t.Fatalf("第 %d 轮: 创建 %d 个、拒绝 %d 个，应各为 1", i, created, rejected)
+		}
+		if len(h.sites) != 2 {
+			t.Fatalf("第 %d 轮: Hub 中有 %d 个站点，应为 2", i, len(h.sites))
+		}
+		if got := h.sitesRejected.Load(); got != 1 {
+			t.Fatalf("第 %d 轮: 拒绝计数为 %d，应为 1", i, got)
+		}
+		if _, err := h.getSite("existing.example"); err != nil {
+			t.Fatalf("第 %d 轮: 已有站点无法加入: %v", i, err)
+		}
+	}
+}
+
+// 站点数已满时新站点的加入收到 rejected 并以 4503 断开，已有站点的加入不受影响
+func TestJoinRejectedAtMaxSites(t *testing.T) {
+	setFlag(t, "max-sites", "1")
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	first := dialTestClient(t, server, "")
+	first.join("a.example")
+
+	second := dialTestClient(t, server, "")
+	second.send(Message{Type: "join", SiteID: "b.example", Version: scriptVersion})
+	rejected := second.expect("rejected")
+	if rejected.SiteID != "b.example" {
+		t.Fatalf("rejected 的 siteId 为 %q", rejected.SiteID)
+	}
+	_, err := second.read(time.Second)
+	if code := closeCode(err); code != errorCloseCode(ErrTooManySites) {
+		t.Fatalf("关闭码为 %d，应为 %d（%v）", code, errorCloseCode(ErrTooManySites), err)
+	}
+
+	third := dialTestClient(t, server, "")
+	third.join("a.example")
+	eventually(t, "a.example 人数变为 2", func() bool { return siteCount(h, "a.example") == 2 })
+	if count := siteCount(h, "b.example"); count != -1 {
+		t.Fatalf("被拒绝的站点仍在 Hub 中，人数 %d", count)
+	}
+}
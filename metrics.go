@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Prometheus 指标：on 挂在主端口，off 关闭，其他值作为独立监听地址
+var metricsMode = flag.String("metrics", "on", "Prometheus 指标：on 挂在主端口 /metrics，off 关闭，或填写独立监听地址（如 127.0.0.1:9100）")
+var metricsTopSites = flag.Int("metrics-top-sites", 50, "按站点输出的指标只包含在线人数最多的前 N 个站点")
+
+// 指标标签值转义
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 站点在线人数
+type siteConnections struct {
+	siteID string
+	count  int
+}
+
+// 在线人数最多的前 n 个站点，不含分组
+func (h *Hub) topSites(n int) []siteConnections {
+	h.mutex.RLock()
+	sites := make([]siteConnections, 0, len(h.sites))
+	for id, site := range h.sites {
+		if _, ok := groupName(id); ok {
+			continue
+		}
+		site.mutex.RLock()
+		sites = append(sites, siteConnections{siteID: id, count: site.Count})
+		site.mutex.RUnlock()
+	}
+	h.mutex.RUnlock()
+
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].count != sites[j].count {
+			return sites[i].count > sites[j].count
+		}
+		return sites[i].siteID < sites[j].siteID
+	})
+	if len(sites) > n {
+		sites = sites[:n]
+	}
+	return sites
+}
+
+// 写入一个无标签指标
+func writeMetric(buf *bytes.Buffer, name, kind, help string, value int64) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+// 处理指标请求：GET /metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	connections, sites := hub.totals()
+	counters := hub.counters.SinceStart()
+	keyTotals := keyVerificationTotals()
+
+	var buf bytes.Buffer
+	writeMetric(&buf, "liveuser_connections_total", "gauge", "当前在线连接数", int64(connections))
+	writeMetric(&buf, "liveuser_sites_total", "gauge", "当前活跃站点数", int64(sites))
+
+	buf.WriteString("# HELP liveuser_site_connections 站点在线连接数（仅在线人数最多的站点）\n")
+	buf.WriteString("# TYPE liveuser_site_connections gauge\n")
+	for _, site := range hub.topSites(*metricsTopSites) {
+		fmt.Fprintf(&buf, "liveuser_site_connections{site=\"%s\"} %d\n",
+			metricsLabelEscaper.Replace(site.siteID), site.count)
+	}
+
+	transports := hub.transportStats()
+	buf.WriteString("# HELP liveuser_transport_connections 按传输方式、协议版本、编码与压缩统计的在线连接数\n")
+	buf.WriteString("# TYPE liveuser_transport_connections gauge\n")
+	for _, t := range transports {
+		fmt.Fprintf(&buf, "liveuser_transport_connections{%s} %d\n", transportLabels(t), t.Live)
+	}
+	buf.WriteString("# HELP liveuser_transport_connections_opened_total 按传输方式、协议版本、编码与压缩统计的累计连接数\n")
+	buf.WriteString("# TYPE liveuser_transport_connections_opened_total counter\n")
+	for _, t := range transports {
+		fmt.Fprintf(&buf, "liveuser_transport_connections_opened_total{%s} %d\n", transportLabels(t), t.Total)
+	}
+
+	hub.writeQueueMetrics(&buf)
+	hub.webhooks.writeMetrics(&buf)
+	alerts.writeMetrics(&buf)
+
+	writeMetric(&buf, "liveuser_registrations_total", "counter", "客户端加入次数", counters.Joins)
+	writeMetric(&buf, "liveuser_unregistrations_total", "counter", "客户端注销次数", counters.Unregistered)
+	writeMetric(&buf, "liveuser_broadcasts_total", "counter", "人数广播次数", counters.Broadcasts)
+	writeMetric(&buf, "liveuser_dropped_messages_total", "counter", "因发送队列已满而丢弃的消息数", counters.DroppedFrames)
+	writeMetric(&buf, "liveuser_beacon_hits_total", "counter", "计入在线的 GIF 信标请求数（不含爬虫）", hub.beaconHits.Load())
+	writeMetric(&buf, "liveuser_beacon_presences", "gauge", "当前的 GIF 信标在线记录数", hub.beaconPresences.Load())
+	writeMetric(&buf, "liveuser_websocket_connections", "gauge", "当前的 WebSocket 连接数（含握手中的连接）", hub.wsConns.Load())
+	writeMetric(&buf, "liveuser_server_full_rejected_total", "counter", "因达到 -max-connections 而拒绝的 WebSocket 握手数", hub.connsRejected.Load())
+	writeMetric(&buf, "liveuser_slow_writes_total", "counter", "单帧写入超过 -write-timeout 1/4 的次数", hub.slowWrites.Load())
+	writeMetric(&buf, "liveuser_slow_write_closed_total", "counter", "因写入过慢或超时断开的 WebSocket 连接数", hub.slowWriteClosed.Load())
+	writeMetric(&buf, "liveuser_upgrade_required_total", "counter", "因挂件脚本版本过低而通知升级的连接数", hub.upgradeRequired.Load())
+	writeMetric(&buf, "liveuser_upgrade_closed_total", "counter", "升级期限已过仍未断开而被关闭的连接数", hub.upgradeClosed.Load())
+	writeMetric(&buf, "liveuser_admin_feed_subscribers", "gauge", "全站人数流（/admin/feed）的订阅者数", int64(hub.adminFeedSubscribers()))
+	writeMetric(&buf, "liveuser_admin_feed_dropped_total", "counter", "因全站人数流订阅者消费过慢而丢弃的消息数", hub.adminFeedDropped.Load())
+	writeMetric(&buf, "liveuser_bot_observed_total", "counter", "被识别为爬虫、作为观察者加入的连接数", bots.observed.Load())
+	writeMetric(&buf, "liveuser_bot_rejected_total", "counter", "被识别为爬虫并拒绝的连接数（-reject-bots）", bots.rejected.Load())
+	writeMetric(&buf, "liveuser_blocklist_rejected_total", "counter", "因 IP 在封禁列表（-blocklist）中而拒绝的连接数", blocklist.rejected.Load())
+	writeMetric(&buf, "liveuser_origin_rejected_total", "counter", "严格来源模式下因 siteId 与来源不符而拒绝的加入数", hub.originRejected.Load())
+	writeMetric(&buf, "liveuser_sites_rejected_total", "counter", "因达到 -max-sites 而拒绝的新站点加入数", hub.sitesRejected.Load())
+	writeMetric(&buf, "liveuser_ip_rejected_total", "counter", "因超过单 IP 连接限制而拒绝的 WebSocket 连接数", hub.ipRejected.Load())
+	writeMetric(&buf, "liveuser_ip_tracked", "gauge", "正在跟踪连接数的 IP 数", int64(hub.trackedIPs()))
+
+	buf.WriteString("# HELP liveuser_site_key_verifications_total 站点密钥校验次数，key 为 current、previous（轮换前的旧密钥）或 invalid\n")
+	buf.WriteString("# TYPE liveuser_site_key_verifications_total counter\n")
+	for _, result := range []string{keyCurrent, keyPrevious, keyInvalid} {
+		fmt.Fprintf(&buf, "liveuser_site_key_verifications_total{key=\"%s\"} %d\n", result, keyTotals[result])
+	}
+
+	buf.WriteString("# HELP liveuser_outbound_attempts_total 外部请求尝试次数\n")
+	buf.WriteString("# TYPE liveuser_outbound_attempts_total counter\n")
+	for _, attempt := range outboundAttemptCounts() {
+		fmt.Fprintf(&buf, "liveuser_outbound_attempts_total{target=\"%s\",outcome=\"%s\"} %d\n",
+			attempt.Target, attempt.Outcome, attempt.Count)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// 传输统计的标签，取值均为固定枚举
+func transportLabels(t TransportStats) string {
+	return fmt.Sprintf("transport=\"%s\",protocol=\"%d\",encoding=\"%s\",compressed=\"%t\"",
+		t.Transport, t.Protocol, t.Encoding, t.Compressed)
+}
+
+// 按 -metrics 注册指标接口，返回独立的指标服务（未启用时为 nil）
+func setupMetrics() *http.Server {
+	switch *metricsMode {
+	case "off":
+		return nil
+	case "on", "":
+		http.HandleFunc("/metrics", handleMetrics)
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	return &http.Server{Addr: *metricsMode, Handler: mux}
+}
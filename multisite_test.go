@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+// 以访客 ID 与会话 ID（同一浏览器的标签页共用）加入站点
+func (c *testClient) joinAs(siteID, visitorID, clientID string) {
+	c.t.Helper()
+	c.send(Message{Type: "join", SiteID: siteID, VisitorID: visitorID, ClientID: clientID, Version: scriptVersion})
+	c.expect("joined")
+}
+
+// 分组人数与成员明细
+func groupCountOf(t *testing.T, name string) CountInfo {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleCount(w, httptest.NewRequest("GET", "/api/count?group="+name, nil))
+	var info CountInfo
+	decodeJSON(t, w, &info)
+	return info
+}
+
+// 检查各站点的本实例人数
+func expectCounts(t *testing.T, h *Hub, what string, want map[string]int) {
+	t.Helper()
+	eventually(t, what, func() bool {
+		for siteID, count := range want {
+			if got := siteCount(h, siteID); got != count && !(count == 0 && got == -1) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// 同一访客同时在两个站点在线：两个站点各计一人，分组只计一人
+func TestVisitorOnTwoSites(t *testing.T) {
+	h := startTestHub(t)
+	settings = loadTestSettings(t, `{"sites":{},"groups":[{"group":"net","sites":["blog.example","shop.example"]}]}`)
+	server := startTestServer(t)
+
+	blog := dialTestClient(t, server, "")
+	blog.joinAs("blog.example", "visitor-1", "tab-1")
+	shop := dialTestClient(t, server, "")
+	shop.joinAs("shop.example", "visitor-1", "tab-1")
+	expectCounts(t, h, "两个站点各一人", map[string]int{"blog.example": 1, "shop.example": 1})
+
+	info := groupCountOf(t, "net")
+	if info.Count != 1 || info.Members["blog.example"] != 1 || info.Members["shop.example"] != 1 {
+		t.Fatalf("分组人数为 %d，明细 %v，应为 1 且每个成员 1", info.Count, info.Members)
+	}
+
+	// 离开一个站点不影响另一个
+	blog.close()
+	expectCounts(t, h, "离开 blog.example", map[string]int{"blog.example": 0, "shop.example": 1})
+	if info := groupCountOf(t, "net"); info.Count != 1 {
+		t.Fatalf("分组人数为 %d，应为 1", info.Count)
+	}
+}
+
+// 同一浏览器在同一站点打开两个标签页只计一人，最后一个关闭时才减少
+func TestTwoTabsOnOneSite(t *testing.T) {
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	first := dialTestClient(t, server, "")
+	first.joinAs("blog.example", "visitor-1", "browser-1")
+	second := dialTestClient(t, server, "")
+	second.joinAs("blog.example", "visitor-1", "browser-1")
+	other := dialTestClient(t, server, "")
+	other.joinAs("blog.example", "visitor-2", "browser-2")
+	expectCounts(t, h, "两个浏览器", map[string]int{"blog.example": 2})
+
+	first.close()
+	eventually(t, "第一个标签页离开", func() bool { return h.counters.unregistered.Load() == 1 })
+	expectCounts(t, h, "还剩一个标签页", map[string]int{"blog.example": 2})
+
+	second.close()
+	expectCounts(t, h, "标签页全部关闭", map[string]int{"blog.example": 1})
+}
+
+// 同一访客在两个站点各开两个标签页，交错关闭：每个站点按自己的标签页独立计数
+func TestInterleavedLeavesAcrossSites(t *testing.T) {
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	tabs := map[string][]*testClient{}
+	for _, siteID := range []string{"blog.example", "shop.example"} {
+		for i := 0; i < 2; i++ {
+			tab := dialTestClient(t, server, "")
+			tab.joinAs(siteID, "visitor-1", "browser-1")
+			tabs[siteID] = append(tabs[siteID], tab)
+		}
+	}
+	expectCounts(t, h, "两个站点各一人", map[string]int{"blog.example": 1, "shop.example": 1})
+
+	steps := []struct {
+		close  *testClient
+		counts map[string]int
+	}{
+		{tabs["blog.example"][0], map[string]int{"blog.example": 1, "shop.example": 1}},
+		{tabs["shop.example"][1], map[string]int{"blog.example": 1, "shop.example": 1}},
+		{tabs["blog.example"][1], map[string]int{"blog.example": 0, "shop.example": 1}},
+		{tabs["shop.example"][0], map[string]int{"blog.example": 0, "shop.example": 0}},
+	}
+	for i, step := range steps {
+		step.close.close()
+		eventually(t, "标签页离开", func() bool { return h.counters.unregistered.Load() == int64(i+1) })
+		expectCounts(t, h, fmt.Sprintf("交错关闭第 %d 个标签页", i+1), step.counts)
+	}
+}
+
+// 切换站点的连接带着访客 ID 离开旧站点，同一访客在旧站点的其他标签页仍计入
+func TestSwitchSiteKeepsOtherTab(t *testing.T) {
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	stay := dialTestClient(t, server, "")
+	stay.joinAs("blog.example", "visitor-1", "browser-1")
+	move := dialTestClient(t, server, "")
+	move.joinAs("blog.example", "visitor-1", "browser-1")
+	move.joinAs("shop.example", "visitor-1", "browser-1")
+	expectCounts(t, h, "切换后两个站点各一人", map[string]int{"blog.example": 1, "shop.example": 1})
+
+	stay.close()
+	expectCounts(t, h, "旧站点的标签页关闭", map[string]int{"blog.example": 0, "shop.example": 1})
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 实例间交换人数的 NATS 主题
+const natsSubject = "liveuser.counts"
+
+// 单次写入或建立连接的超时
+const natsTimeout = 5 * time.Second
+
+// NATS 连接参数
+type natsOptions struct {
+	Addr     string
+	User     string
+	Password string
+	Token    string
+}
+
+// 解析 -broker：nats://[user:password@]host[:port] 或 nats://token@host[:port]
+func parseNATSAddr(value string) (natsOptions, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return natsOptions{}, fmt.Errorf("无效的 NATS 地址 %q", value)
+	}
+	options := natsOptions{Addr: u.Host}
+	if u.Port() == "" {
+		options.Addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			options.User = u.User.Username()
+			options.Password = password
+		} else {
+			options.Token = u.User.Username()
+		}
+	}
+	return options, nil
+}
+
+// 最小的 NATS 客户端（文本协议），只实现发布与订阅单个主题
+type natsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// 发布与回复 PONG 可能来自不同的协程
+	writeMutex sync.Mutex
+}
+
+// CONNECT 的参数；echo 为 false 时服务器不把本连接发布的消息投递回来
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Echo     bool   `json:"echo"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+// 建立连接：读取 INFO，发送 CONNECT 并用 PING 确认认证通过
+func dialNATS(ctx context.Context, options natsOptions) (*natsConn, error) {
+	dialer := net.Dialer{Timeout: natsTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", options.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &natsConn{conn: conn, reader: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	line, err := c.readLine()
+	if err == nil && !strings.HasPrefix(line, "INFO ") {
+		err = fmt.Errorf("nats: 意外的问候 %q", line)
+	}
+	if err == nil {
+		connect, _ := json.Marshal(natsConnect{
+			Echo:    false,
+			Name:    "liveuser",
+			Lang:    "go",
+			Version: "1.0",
+			User:    options.User,
+			Pass:    options.Password,
+			Token:   options.Token,
+		})
+		err = c.write("CONNECT " + string(connect) + "\r\nPING\r\n")
+	}
+	if err == nil {
+		line, err = c.readLine()
+		if err == nil && line != "PONG" {
+			err = fmt.Errorf("nats: 连接被拒绝: %s", line)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *natsConn) Close() error {
+	return c.conn.Close()
+}
+
+// 读取一行协议
+func (c *natsConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// 写入协议数据
+func (c *natsConn) write(data string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	_, err := io.WriteString(c.conn, data)
+	return err
+}
+
+// 订阅主题
+func (c *natsConn) subscribe(subject string) error {
+	return c.write("SUB " + subject + " 1\r\n")
+}
+
+// 发布消息
+func (c *natsConn) publish(subject string, payload []byte) error {
+	return c.write("PUB " + subject + " " + strconv.Itoa(len(payload)) + "\r\n" + string(payload) + "\r\n")
+}
+
+// 发送 PING 并等待 PONG，确认之前发布的消息已被服务器处理
+func (c *natsConn) flush() error {
+	if err := c.write("PING\r\n"); err != nil {
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(natsTimeout))
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// 读取下一条消息的内容，期间回复服务器的 PING；服务器返回错误或 timeout 内
+// 未收到任何数据时结束
+func (c *natsConn) next(timeout time.Duration) ([]byte, error) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line == "PING":
+			if err := c.write("PONG\r\n"); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			n, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("nats: 无效的消息头 %q", line)
+			}
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(c.reader, payload); err != nil {
+				return nil, err
+			}
+			return payload[:n], nil
+		case strings.HasPrefix(line, "-ERR"):
+			return nil, fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK、PONG 与 INFO 更新无需处理
+	}
+}
+
+// 基于 NATS 的消息代理
+//
+// NATS 不保存消息，实例上线时广播 hello，其他实例收到后立即回复快照；
+// 之后每 clusterHeartbeat 广播一次快照，超过 clusterTTL 未收到快照的实例被移除。
+type natsBroker struct {
+	*brokerState
+	options natsOptions
+}
+
+// 创建 NATS 消息代理并在后台连接；连接失败不影响启动
+func startNATSBroker(options natsOptions) *natsBroker {
+	b := &natsBroker{brokerState: newBrokerState("nats"), options: options}
+	b.goLoop(b.run)
+	log.Printf("多实例人数同步已启用（NATS %s），实例 ID %s", options.Addr, b.instance)
+	return b
+}
+
+// 停止同步并广播空快照，等服务器确认收到后再断开
+func (b *natsBroker) Close(ctx context.Context) error {
+	if err := b.stop(ctx); err != nil {
+		return err
+	}
+	conn, err := dialNATS(ctx, b.options)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := b.publish(conn, b.finalSnapshot()); err != nil {
+		return err
+	}
+	return conn.flush()
+}
+
+// 连接、订阅并发布本实例人数；连接中断后按退避间隔重连并重新同步，消息代理关闭时退出
+func (b *natsBroker) run() {
+	for {
+		var conn *natsConn
+		err := b.dial(b.options.Addr, func(ctx context.Context) (err error) {
+			conn, err = dialNATS(ctx, b.options)
+			return err
+		})
+		if err != nil {
+			return
+		}
+
+		// 关闭时断开连接，结束阻塞中的读取与发布循环
+		stop := context.AfterFunc(b.ctx, func() { conn.Close() })
+		err = b.serve(conn)
+		stop()
+		conn.Close()
+		b.setConnected(false, err)
+		if b.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (b *natsBroker) serve(conn *natsConn) error {
+	if err := conn.subscribe(natsSubject); err != nil {
+		return err
+	}
+	// 宣告上线，其他实例回复快照；同时广播本实例的快照
+	if err := b.publish(conn, clusterMessage{Type: "hello", Instance: b.instance}); err != nil {
+		return err
+	}
+	if err := b.publish(conn, clusterMessage{Type: "snapshot", Instance: b.instance, Counts: hub.localCounts()}); err != nil {
+		return err
+	}
+	b.setConnected(true, nil)
+
+	done := make(chan struct{})
+	defer close(done)
+	failed := make(chan error, 1)
+	go func() {
+		failed <- b.publishLoop(conn, done)
+	}()
+
+	for {
+		// 每个心跳周期都会向服务器发送 PING，超时未收到任何数据视为连接失效
+		payload, err := conn.next(clusterTTL)
+		if err != nil {
+			// 发布失败时连接已被关闭，返回发布的错误
+			select {
+			case publishErr := <-failed:
+				return publishErr
+			default:
+				return err
+			}
+		}
+
+		var message clusterMessage
+		if err := json.Unmarshal(payload, &message); err != nil {
+			continue
+		}
+		b.apply(message)
+	}
+}
+
+// 合并发布本实例的人数变化，并定期广播快照
+func (b *natsBroker) publishLoop(conn *natsConn, done <-chan struct{}) error {
+	ticker := time.NewTicker(clusterHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-done:
+			return nil
+		case <-b.wake:
+			for siteID, count := range b.takePending() {
+				if err = b.publish(conn, clusterMessage{Type: "count", Instance: b.instance, SiteID: siteID, Count: count}); err != nil {
+					break
+				}
+			}
+		case <-b.resync:
+			err = b.publish(conn, clusterMessage{Type: "snapshot", Instance: b.instance, Counts: hub.localCounts()})
+		case now := <-ticker.C:
+			err = b.publish(conn, clusterMessage{Type: "snapshot", Instance: b.instance, Counts: hub.localCounts()})
+			if err == nil {
+				err = conn.write("PING\r\n")
+			}
+			b.expire(now)
+		}
+		if err != nil {
+			// 关闭连接使读循环退出，重连后会重新广播快照
+			conn.Close()
+			return err
+		}
+	}
+}
+
+func (b *natsBroker) publish(conn *natsConn, message clusterMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return conn.publish(natsSubject, data)
+}
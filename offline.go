@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+// 无人在线的站点在徽章上使用的颜色
+var badgeOfflineColor = flag.String("badge-offline-color", "lightgrey", "无人在线时徽章的颜色，可被 offlineColor 参数覆盖")
+
+// 站点在线状态，用于区分“无人在线”与“从未见过的站点”
+//
+// 峰值记录随状态文件保存，重启后没有连接的站点仍能给出最近在线时间；
+// lastSeen 为空表示本实例没有该站点的任何记录。
+type SiteStatus struct {
+	Live      bool  `json:"live"`
+	LastSeen  int64 `json:"lastSeen,omitempty"`
+	PeakToday int   `json:"peakToday,omitempty"`
+}
+
+// 站点在线状态，count 为当前人数
+func (h *Hub) siteStatus(siteID string, count int) SiteStatus {
+	status := SiteStatus{Live: count > 0}
+	peak, known := h.peak(siteID, withoutBase(siteID, count))
+	if !known {
+		return status
+	}
+
+	status.LastSeen = peak.LastSeen
+	if status.Live {
+		status.LastSeen = time.Now().Unix()
+	}
+	if *peakReset == peakResetDaily {
+		status.PeakToday = withBase(siteID, peak.Peak)
+	}
+	return status
+}
+
+// 站点最后一个访客离开时通知站长事件流
+func (h *Hub) publishOffline(siteID string, now time.Time) {
+	h.publishEvent(FeedEvent{Type: "site_offline", SiteID: siteID, LastSeen: now.Unix(), Timestamp: now.Unix()})
+}
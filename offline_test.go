@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 以 GET 请求调用读取接口，返回解析后的 JSON 对象
+func getJSONObject(t *testing.T, handler http.HandlerFunc, target string) map[string]any {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", target, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s 返回 %d: %s", target, w.Code, w.Body.String())
+	}
+	var object map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &object); err != nil {
+		t.Fatal(err)
+	}
+	return object
+}
+
+// 重启后三种站点：有人在线、本实例见过但无人在线、从未见过，读取接口与徽章都能区分
+func TestOfflineSiteStates(t *testing.T) {
+	setFlag(t, "peak-reset", peakResetDaily)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	// 重启前 offline.example 同时有两人在线，之后全部离开
+	before := startTestHub(t)
+	server := startTestServer(t)
+	for _, clientID := range []string{"browser-1", "browser-2"} {
+		client := dialTestClient(t, server, "")
+		client.joinAs("offline.example", clientID, clientID)
+		t.Cleanup(client.close)
+	}
+	expectCounts(t, before, "两人在线", map[string]int{"offline.example": 2})
+	for _, client := range before.trackedClients() {
+		client.disconnect()
+	}
+	expectCounts(t, before, "全部离开", map[string]int{"offline.example": 0})
+	if err := before.saveState(statePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 从状态文件恢复的新 Hub 上只有 live.example 有连接
+	restored := NewHub()
+	if err := restored.loadState(statePath); err != nil {
+		t.Fatal(err)
+	}
+	hub = restored
+	go restored.Run()
+	t.Cleanup(func() {
+		waitPumps(t, restored, 5*time.Second)
+		restored.Stop()
+	})
+	live := dialTestClient(t, server, "")
+	live.join("live.example")
+
+	tests := []struct {
+		siteID    string
+		count     float64
+		live      bool
+		lastSeen  bool
+		peakToday float64
+		color     string
+	}{
+		{"live.example", 1, true, true, 1, ""},
+		{"offline.example", 0, false, true, 2, *badgeOfflineColor},
+		{"unknown.example", 0, false, false, 0, *badgeOfflineColor},
+	}
+	batch := getJSONObject(t, handleCount, "/api/count?siteId=live.example&siteId=offline.example&siteId=unknown.example")
+	for _, tt := range tests {
+		single := getJSONObject(t, handleCount, "/api/count?siteId="+tt.siteID)
+		detail := getJSONObject(t, handleSiteDetail, "/api/site/"+tt.siteID)
+		fromBatch, _ := batch[tt.siteID].(map[string]any)
+		for what, info := range map[string]map[string]any{"/api/count": single, "/api/site": detail, "批量 /api/count": fromBatch} {
+			_, hasLastSeen := info["lastSeen"]
+			peakToday, _ := info["peakToday"].(float64)
+			if info["count"] != tt.count && !(tt.count == 0 && info["count"] == nil) || info["live"] != tt.live ||
+				hasLastSeen != tt.lastSeen || peakToday != tt.peakToday {
+				t.Errorf("%s 的 %s 为 %v，应为 count=%v live=%v lastSeen=%v peakToday=%v",
+					tt.siteID, what, info, tt.count, tt.live, tt.lastSeen, tt.peakToday)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		handleBadge(w, httptest.NewRequest("GET", "/badge/"+tt.siteID+".svg", nil))
+		offline := strings.Contains(w.Body.String(), badgeColor(*badgeOfflineColor))
+		if w.Code != http.StatusOK || offline != (tt.color != "") {
+			t.Errorf("%s 的徽章返回 %d，使用离线颜色为 %v", tt.siteID, w.Code, offline)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// v2 连接的 seq 逐条递增，队列已满丢弃的消息同样占用序号，客户端据此发现缺失；
+// v1 连接的 seq 仍是人数序号
+func TestEnqueueSeqGap(t *testing.T) {
+	client := &Client{send: make(chan Message, 2), kind: connKind{Protocol: protocolVersionV2}}
+	for i, want := range []bool{true, true, false, false} {
+		if ok := client.enqueue(Message{Type: "update", Seq: 100}); ok != want {
+			t.Fatalf("第 %d 条入队结果为 %v，应为 %v", i+1, ok, want)
+		}
+	}
+	for _, want := range []int64{1, 2} {
+		if message := <-client.send; message.Seq != want || message.CountSeq != 100 {
+			t.Fatalf("收到 seq=%d countSeq=%d，应为 %d 与 100", message.Seq, message.CountSeq, want)
+		}
+	}
+	client.enqueue(Message{Type: "update"})
+	if message := <-client.send; message.Seq != 5 {
+		t.Fatalf("丢弃两条后 seq 为 %d，应为 5", message.Seq)
+	}
+
+	client.closeSend()
+	if client.enqueue(Message{Type: "update"}) {
+		t.Fatal("队列关闭后仍可入队")
+	}
+
+	v1 := &Client{send: make(chan Message, 1), kind: connKind{Protocol: protocolVersion}}
+	v1.enqueue(Message{Type: "update", Seq: 7})
+	if message := <-v1.send; message.Seq != 7 || message.CountSeq != 0 {
+		t.Fatalf("v1 连接收到 seq=%d countSeq=%d，应保持人数序号 7", message.Seq, message.CountSeq)
+	}
+}
+
+// 随机切换站点的同时不断广播：每个客户端的消息流中 seq 连续，
+// 加入后第一条该站点的消息总是 joined，之后不再收到已离开站点的消息
+func TestMessageOrdering(t *testing.T) {
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	seed := time.Now().UnixNano()
+	t.Logf("随机种子 %d", seed)
+	sites := []string{"a.example", "b.example", "c.example"}
+
+	stop := make(chan struct{})
+	var broadcasters sync.WaitGroup
+	for _, siteID := range sites {
+		broadcasters.Add(1)
+		go func(siteID string) {
+			defer broadcasters.Done()
+			ticker := time.NewTicker(10 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					h.broadcastMessage(siteID, Message{Type: "custom", SiteID: siteID})
+				}
+			}
+		}(siteID)
+	}
+
+	const clientCount, switches = 10, 15
+	clients := make([]*testClient, clientCount)
+	for i := range clients {
+		clients[i] = dialTestClient(t, server, "protocol=2")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, clientCount)
+	for i, client := range clients {
+		wg.Add(1)
+		go func(client *testClient, random *rand.Rand) {
+			defer wg.Done()
+			if problem := checkOrdering(client, sites, switches, random); problem != "" {
+				errs <- problem
+			}
+		}(client, rand.New(rand.NewSource(seed+int64(i))))
+	}
+	wg.Wait()
+	close(stop)
+	broadcasters.Wait()
+	close(errs)
+
+	for problem := range errs {
+		t.Error(problem)
+	}
+	if dropped := h.counters.droppedFrames.Load(); dropped != 0 {
+		t.Fatalf("丢弃了 %d 条消息，seq 缺失来自丢弃而非乱序", dropped)
+	}
+}
+
+// 随机切换站点 switches 次，返回发现的第一个违反顺序规则的问题
+func checkOrdering(client *testClient, sites []string, switches int, random *rand.Rand) string {
+	current, lastSeq := "", int64(0)
+	for i := 0; i < switches; i++ {
+		target := sites[random.Intn(len(sites))]
+		for target == current {
+			target = sites[random.Intn(len(sites))]
+		}
+		if err := client.conn.WriteJSON(Message{Type: "join", SiteID: target, Version: scriptVersion}); err != nil {
+			return "发送 join 失败: " + err.Error()
+		}
+
+		acked := false
+		for !acked {
+			message, err := client.read(3 * time.Second)
+			if err != nil {
+				return "等待 joined 失败: " + err.Error()
+			}
+			if message.Seq != lastSeq+1 {
+				return "seq 不连续：" + message.Type + " " + message.SiteID
+			}
+			lastSeq = message.Seq
+
+			switch message.SiteID {
+			case target:
+				if message.Type != "joined" {
+					return "加入 " + target + " 后先收到了 " + message.Type
+				}
+				if message.Protocol != protocolVersionV2 {
+					return "joined 没有带协商的协议版本"
+				}
+				acked = true
+			case current, "":
+			default:
+				return "收到未加入站点 " + message.SiteID + " 的 " + message.Type
+			}
+		}
+		previous := current
+		current = target
+
+		// 确认之后的消息不应再属于已离开的站点
+		for j := random.Intn(5); j > 0; j-- {
+			message, err := client.read(time.Second)
+			if err != nil {
+				return "读取消息失败: " + err.Error()
+			}
+			if message.Seq != lastSeq+1 {
+				return "seq 不连续：" + message.Type + " " + message.SiteID
+			}
+			lastSeq = message.Seq
+			if message.SiteID == previous && previous != "" {
+				return "离开 " + previous + " 后仍收到 " + message.Type
+			}
+		}
+	}
+
+	// 退出站点后不再收到广播，停止读取也不会因队列积压被丢弃
+	if err := client.conn.WriteJSON(Message{Type: "unjoin", SiteID: current}); err != nil {
+		return "发送 unjoin 失败: " + err.Error()
+	}
+	for {
+		message, err := client.read(3 * time.Second)
+		if err != nil {
+			return "等待 unjoined 失败: " + err.Error()
+		}
+		if message.Seq != lastSeq+1 {
+			return "seq 不连续：" + message.Type + " " + message.SiteID
+		}
+		lastSeq = message.Seq
+		if message.Type == "unjoined" {
+			return ""
+		}
+	}
+}
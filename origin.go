@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 允许的来源，为空时允许所有来源
+var allowedOrigins = flag.String("allowed-origins", "", "允许连接的来源，逗号分隔，支持 *.example.com 通配子域名；同时限制可加入的 siteId，为空时不限制")
+
+// 严格来源模式：加入的 siteId 必须与连接的来源一致
+var (
+	enforceOrigin = flag.Bool("enforce-origin", false, "严格来源模式：加入的 siteId（规范化后）必须与连接的 Origin 或 Referer 主机名一致，否则以 4403 断开；join 中携带 adminToken 的连接不受限制")
+	allowNoOrigin = flag.Bool("allow-no-origin", true, "严格来源模式下是否允许既没有 Origin 也没有 Referer 的连接（原生应用、curl 等）")
+)
+
+// 来源白名单：精确匹配的主机名与通配的域名后缀
+type originPatterns struct {
+	hosts    map[string]bool
+	suffixes []string
+}
+
+// 启动时解析的白名单，nil 表示不限制
+var originAllowlist *originPatterns
+
+// 解析 -allowed-origins，为空时返回 nil
+func parseAllowedOrigins(value string) (*originPatterns, error) {
+	patterns := &originPatterns{hosts: make(map[string]bool)}
+	for _, part := range strings.Split(value, ",") {
+		pattern := strings.ToLower(strings.TrimSpace(part))
+		if pattern == "" {
+			continue
+		}
+		if strings.HasPrefix(pattern, "*.") {
+			suffix := pattern[1:]
+			if strings.ContainsAny(suffix[1:], "*/:") || !strings.Contains(suffix[1:], ".") {
+				return nil, fmt.Errorf("无效的来源 %q，通配只能写成 *.example.com", part)
+			}
+			patterns.suffixes = append(patterns.suffixes, suffix)
+			continue
+		}
+		if strings.ContainsAny(pattern, "*/:") {
+			return nil, fmt.Errorf("无效的来源 %q，只需填写主机名，如 example.com 或 *.example.com", part)
+		}
+		patterns.hosts[pattern] = true
+	}
+	if len(patterns.hosts) == 0 && len(patterns.suffixes) == 0 {
+		return nil, nil
+	}
+	return patterns, nil
+}
+
+// 主机名是否匹配；*.example.com 只匹配子域名，不含 example.com 本身
+func (p *originPatterns) match(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if p.hosts[host] {
+		return true
+	}
+	for _, suffix := range p.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 请求的 Origin 是否在白名单中；没有 Origin 头的请求（非浏览器客户端）不受限制
+func originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if originAllowlist == nil || origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	return originAllowlist.match(u.Hostname())
+}
+
+// siteId 是否允许加入
+//
+// 启用白名单后，siteId 需是白名单中的主机名，防止为他人的域名伪造人数；
+// 站点配置文件中的站点（含通过管理接口创建的临时站点）与分组由管理员维护，不受限制。
+func siteAllowed(siteID string) bool {
+	if originAllowlist == nil {
+		return true
+	}
+	if _, ok := groupName(siteID); ok {
+		return true
+	}
+	if settings.Has(siteID) {
+		return true
+	}
+	host := canonicalSiteID(siteID)
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	return host != "" && originAllowlist.match(host)
+}
+
+// 连接来源的主机名：取 Origin，没有时取 Referer，按 siteId 的规则规范化并去掉端口；
+// 都没有（或为 null）时返回空字符串
+func requestOriginHost(r *http.Request) string {
+	for _, value := range []string{r.Header.Get("Origin"), r.Header.Get("Referer")} {
+		if value == "" || value == "null" {
+			continue
+		}
+		if u, err := url.Parse(value); err == nil && u.Hostname() != "" {
+			return siteIDHost(normalizeSiteID(u.Host))
+		}
+	}
+	return ""
+}
+
+// siteId 的主机名部分，不含端口与路径
+func siteIDHost(siteID string) string {
+	host := siteID
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
+
+// 来源主机名能否加入站点：主机名相同，或站点是来源的可注册域名（groupByRegistrableDomain）
+func originMatches(originHost, siteID string) bool {
+	host := siteIDHost(siteID)
+	return host == originHost || host == siteIDHost(registrableSiteID(originHost))
+}
+
+// 严格来源模式下检查连接能否加入站点，未开启时总是通过
+//
+// 携带 -admin-token 的连接（管理面板、观察者）不受限制；分组按成员站点检查，
+// 来源与任一成员一致即可。
+func (h *Hub) checkOrigin(originHost, siteID, token string) error {
+	if !*enforceOrigin {
+		return nil
+	}
+	if *adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) == 1 {
+		return nil
+	}
+	if originHost == "" {
+		if *allowNoOrigin {
+			return nil
+		}
+		h.originRejected.Add(1)
+		return fmt.Errorf("缺少 Origin 与 Referer: %w", ErrOriginMismatch)
+	}
+
+	members := []string{siteID}
+	if name, ok := groupName(siteID); ok {
+		members, _ = settings.Group(name)
+	}
+	for _, member := range members {
+		if originMatches(originHost, member) {
+			return nil
+		}
+	}
+	h.originRejected.Add(1)
+	return fmt.Errorf("来源 %s 不能加入站点 %s: %w", originHost, siteID, ErrOriginMismatch)
+}
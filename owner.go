@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 每个站点允许的站长事件流数量
+var ownerMaxStreams = flag.Int("owner-max-streams", 3, "每个站点允许的站长事件流数量")
+
+// 每个站点排除列表的最大长度
+const maxExclusions = 100
+
+// 排除列表请求体
+type exclusionsRequest struct {
+	VisitorID  string   `json:"visitorId,omitempty"`
+	VisitorIDs []string `json:"visitorIds"`
+}
+
+// 校验站点密钥，当前密钥与轮换前的旧密钥均可通过
+func checkSiteKey(siteID, key string) bool {
+	result := matchSiteKey(siteID, key)
+	return result == keyCurrent || result == keyPrevious
+}
+
+// 依次尝试当前密钥与未过迁移期限的旧密钥，返回匹配结果并计入校验统计
+func matchSiteKey(siteID, key string) string {
+	if key == "" {
+		return keyInvalid
+	}
+	site := settings.Get(siteID)
+	result := keyInvalid
+	switch {
+	case site.Key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(site.Key)) == 1:
+		result = keyCurrent
+	case site.PreviousKey != "" && !site.PreviousKeyExpired(time.Now()) && subtle.ConstantTimeCompare([]byte(key), []byte(site.PreviousKey)) == 1:
+		result = keyPrevious
+	}
+	recordKeyVerification(siteID, result)
+	return result
+}
+
+// 从请求中读取站点密钥，支持 X-Site-Key 请求头与 key 查询参数
+func siteKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-Site-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("key")
+}
+
+// 处理站长事件流连接
+func handleOwnerWS(w http.ResponseWriter, r *http.Request) {
+	siteID := strings.TrimSpace(r.URL.Query().Get("siteId"))
+	if !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	feed := &Feed{
+		hub:    hub,
+		siteID: siteID,
+		send:   make(chan FeedEvent, 64),
+	}
+	snapshot := hub.siteSnapshot(siteID)
+	feed.send <- snapshot
+	if snapshot.Count == 0 {
+		status := hub.siteStatus(siteID, 0)
+		feed.send <- FeedEvent{Type: "site_offline", SiteID: siteID, LastSeen: status.LastSeen, Timestamp: snapshot.Timestamp}
+	}
+	if !hub.subscribeFeed(feed, *ownerMaxStreams) {
+		http.Error(w, "Too Many Streams", http.StatusTooManyRequests)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		hub.unsubscribeFeed(feed)
+		return
+	}
+	feed.conn = conn
+
+	hub.pumps.Add(2)
+	go feed.readPump()
+	go feed.writePump()
+}
+
+// 处理站长 REST 接口：/owner/api/sites/{id}/exclusions、/publish、/compare 与 /widget
+func handleOwnerAPI(w http.ResponseWriter, r *http.Request) {
+	// 站长接口以密钥鉴权且不依赖 Cookie，允许任意来源调用
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Site-Key")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/owner/api/sites/"), "/")
+	if len(parts) != 2 || parts[0] == "" || (parts[1] != "exclusions" && parts[1] != "publish" && parts[1] != "compare" && parts[1] != "widget") {
+		http.NotFound(w, r)
+		return
+	}
+	siteID := parts[0]
+
+	if !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if parts[1] == "publish" {
+		handleOwnerPublish(w, r, siteID)
+		return
+	}
+	if parts[1] == "widget" {
+		handleOwnerWidget(w, r, siteID)
+		return
+	}
+	if parts[1] == "compare" {
+		handleOwnerCompare(w, r, siteID)
+		return
+	}
+
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, exclusionsRequest{VisitorIDs: settings.Get(siteID).Exclusions})
+		return
+	}
+	if r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req exclusionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// PUT 替换整个列表，POST 追加单个访客
+	var cleaned []string
+	tooMany := false
+	err := settings.Update(siteID, func(site *SiteSettings) {
+		exclusions := req.VisitorIDs
+		if r.Method == "POST" {
+			exclusions = append(append([]string{}, site.Exclusions...), req.VisitorID)
+		}
+		cleaned = cleanExclusions(exclusions)
+		if len(cleaned) > maxExclusions {
+			tooMany = true
+			return
+		}
+		site.Exclusions = cleaned
+	})
+	if err != nil {
+		log.Printf("保存站点配置失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if tooMany {
+		http.Error(w, "Too Many Exclusions", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, exclusionsRequest{VisitorIDs: cleaned})
+}
+
+// 去重并过滤不合法的访客 ID
+func cleanExclusions(exclusions []string) []string {
+	cleaned := make([]string, 0, len(exclusions))
+	seen := make(map[string]bool)
+	for _, visitorID := range exclusions {
+		visitorID = validVisitorID(visitorID)
+		if visitorID == "" || seen[visitorID] {
+			continue
+		}
+		seen[visitorID] = true
+		cleaned = append(cleaned, visitorID)
+	}
+	return cleaned
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 页面路径的最大长度（字节）
+const maxPathLen = 256
+
+// 同一站点内一个页面的连接
+type pageState struct {
+	// 计入人数的连接数
+	count int
+	// 该页面的全部连接（含观察者），接收 update_page
+	clients map[*Client]bool
+}
+
+// 规范化页面路径：去掉查询串与片段，补全开头的 /，去掉结尾的 /，超长时截断；
+// 无法使用的路径返回空字符串，该连接不按页面统计
+func normalizePath(raw string) string {
+	path := strings.TrimSpace(raw)
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" || !utf8.ValidString(path) || strings.ContainsFunc(path, unicode.IsControl) {
+		return ""
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+		path = trimmed
+	} else {
+		path = "/"
+	}
+	if len(path) > maxPathLen {
+		cut := maxPathLen
+		for cut > 0 && !utf8.RuneStart(path[cut]) {
+			cut--
+		}
+		path = path[:cut]
+	}
+	return path
+}
+
+// 把客户端计入所在页面，返回页面人数；没有路径时 ok 为 false（调用方需持有站点锁）
+func (s *Site) joinPageLocked(client *Client, counted bool) (count int, ok bool) {
+	if client.path == "" {
+		return 0, false
+	}
+	if s.pages == nil {
+		s.pages = make(map[string]*pageState)
+	}
+	page, exists := s.pages[client.path]
+	if !exists {
+		page = &pageState{clients: make(map[*Client]bool)}
+		s.pages[client.path] = page
+	}
+	page.clients[client] = true
+	if counted {
+		page.count++
+	}
+	return page.count, true
+}
+
+// 把客户端移出所在页面，返回页面剩余人数；页面没有连接后删除（调用方需持有站点锁）
+func (s *Site) leavePageLocked(client *Client, counted bool) (count int, ok bool) {
+	page, exists := s.pages[client.path]
+	if client.path == "" || !exists || !page.clients[client] {
+		return 0, false
+	}
+	delete(page.clients, client)
+	if counted {
+		page.count--
+	}
+	if len(page.clients) == 0 {
+		delete(s.pages, client.path)
+	}
+	return page.count, true
+}
+
+// 向同一页面的连接推送页面人数（调用方需持有站点锁，与站点广播的顺序一致）
+func (h *Hub) broadcastPageLocked(site *Site, path string, count int) {
+	page, exists := site.pages[path]
+	if !exists {
+		return
+	}
+	message := Message{Type: "update_page", SiteID: site.ID, Path: path, Count: count, Timestamp: time.Now().Unix()}
+	for client := range page.clients {
+		if !client.enqueue(message) {
+			h.counters.droppedFrames.Add(1)
+		}
+	}
+}
+
+// 页面人数
+type PageCount struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// 站点的页面人数
+type PagesInfo struct {
+	SiteID    string      `json:"siteId"`
+	Pages     []PageCount `json:"pages"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// 站点各页面的人数，按人数从高到低排列，只含计入人数的连接
+func (h *Hub) sitePages(siteID string) []PageCount {
+	pages := []PageCount{}
+	h.mutex.RLock()
+	site, exists := h.sites[siteID]
+	h.mutex.RUnlock()
+	if !exists {
+		return pages
+	}
+
+	site.mutex.RLock()
+	for path, page := range site.pages {
+		if page.count > 0 {
+			pages = append(pages, PageCount{Path: path, Count: page.count})
+		}
+	}
+	site.mutex.RUnlock()
+
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Count != pages[j].Count {
+			return pages[i].Count > pages[j].Count
+		}
+		return pages[i].Path < pages[j].Path
+	})
+	return pages
+}
+
+// 处理页面人数请求：GET /api/pages?siteId=example.com&limit=50
+func handlePages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+	siteID := resolveSiteID(strings.TrimSpace(params.Get("siteId")))
+	if siteID == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if _, ok := groupName(siteID); ok {
+		http.Error(w, "Groups have no pages", http.StatusBadRequest)
+		return
+	}
+	if settings.Resolve(siteID).Private && !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 50
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 1000)
+	}
+
+	pages := hub.sitePages(siteID)
+	if len(pages) > limit {
+		pages = pages[:limit]
+	}
+	writeJSON(w, http.StatusOK, PagesInfo{SiteID: siteID, Pages: pages, Timestamp: time.Now().Unix()})
+}
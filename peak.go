@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 峰值重置方式
+const (
+	peakResetDaily = "daily"
+	peakResetNever = "never"
+)
+
+// 峰值是否在本地零点重置；历史最高峰值始终保留
+var peakReset = flag.String("peak-reset", peakResetDaily, "峰值重置方式：daily 每天本地零点重置，never 不重置")
+
+// 校验峰值重置方式
+func checkPeakReset(mode string) error {
+	if mode != peakResetDaily && mode != peakResetNever {
+		return fmt.Errorf("未知的峰值重置方式 %q", mode)
+	}
+	return nil
+}
+
+// 站点峰值，站点从 Hub 移除后仍保留
+type PeakStats struct {
+	Peak      int   `json:"peak"`
+	PeakAt    int64 `json:"peakAt,omitempty"`
+	AllTime   int   `json:"allTime"`
+	AllTimeAt int64 `json:"allTimeAt,omitempty"`
+
+	// 最近一次有访客加入或离开的时间
+	LastSeen int64 `json:"lastSeen,omitempty"`
+
+	// 当前峰值所属的本地日期，daily 模式下跨天时重置
+	day string
+}
+
+// 按 -peak-reset 在跨天时重置当前峰值，新周期从当前人数开始
+func (p *PeakStats) rollover(now time.Time, count int) {
+	day := localDay(now)
+	if *peakReset == peakResetDaily && p.day != day {
+		p.Peak, p.PeakAt = count, now.Unix()
+	}
+	p.day = day
+}
+
+// 记录当前人数，超过峰值时更新
+func (p *PeakStats) observe(now time.Time, count int) {
+	p.rollover(now, count)
+	p.LastSeen = now.Unix()
+	if count > p.Peak {
+		p.Peak, p.PeakAt = count, now.Unix()
+	}
+	if count > p.AllTime {
+		p.AllTime, p.AllTimeAt = count, now.Unix()
+	}
+}
+
+// 加入或离开后更新站点峰值（在 Hub 协程中调用）
+func (h *Hub) observePeak(siteID string, count int) PeakStats {
+	now := time.Now()
+	h.peakMutex.Lock()
+	defer h.peakMutex.Unlock()
+
+	peak := h.peaks[siteID]
+	peak.observe(now, count)
+	h.peaks[siteID] = peak
+	return peak
+}
+
+// 读取站点峰值，count 为当前人数，用于跨天后开始新的周期
+func (h *Hub) peak(siteID string, count int) (PeakStats, bool) {
+	h.peakMutex.Lock()
+	defer h.peakMutex.Unlock()
+
+	peak, exists := h.peaks[siteID]
+	if !exists {
+		return PeakStats{}, false
+	}
+	peak.rollover(time.Now(), count)
+	h.peaks[siteID] = peak
+	return peak, true
+}
+
+// 峰值接口响应
+type PeakInfo struct {
+	SiteID string `json:"siteId"`
+	Count  int    `json:"count"`
+	Reset  string `json:"reset"`
+	PeakStats
+}
+
+// 处理峰值请求：GET /api/peak?siteId=example.com
+func handlePeak(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	siteID := resolveSiteID(strings.TrimSpace(r.URL.Query().Get("siteId")))
+	if siteID == "" {
+		writeError(w, &InvalidSiteIDError{Reason: "缺少 siteId"})
+		return
+	}
+
+	// 私有站点需要站点密钥
+	if settings.Resolve(siteID).Private && !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	count, _ := hub.countAndSeq(siteID)
+	peak, _ := hub.peak(siteID, withoutBase(siteID, count))
+	writeJSON(w, http.StatusOK, PeakInfo{
+		SiteID:    siteID,
+		Count:     count,
+		Reset:     *peakReset,
+		PeakStats: peak.withBase(siteID),
+	})
+}
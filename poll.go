@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 长轮询客户端多久没有发起 wait 即视为离开
+var pollTimeout = flag.Duration("poll-timeout", 60*time.Second, "长轮询客户端无请求多久后视为离开")
+
+// 单次 wait 请求的最长等待时间
+const pollWaitTimeout = 25 * time.Second
+
+// 加入后等待首条人数消息的时间
+const pollJoinWait = 2 * time.Second
+
+// 长轮询会话
+type pollSession struct {
+	token    string
+	client   *Client
+	lastSeen time.Time
+	waiting  bool
+}
+
+// 长轮询加入请求
+type pollJoinRequest struct {
+	SiteID    string `json:"siteId"`
+	VisitorID string `json:"visitorId,omitempty"`
+	Dedup     string `json:"dedup,omitempty"`
+	ClientID  string `json:"clientId,omitempty"`
+	Version   string `json:"version,omitempty"`
+
+	// 以观察者身份加入，不计入在线人数
+	Observe bool `json:"observe,omitempty"`
+
+	// 所在页面的路径，按页面统计人数
+	Path string `json:"path,omitempty"`
+
+	// 来源页面（document.referrer），未提供时取请求的 Referer
+	Referrer string `json:"referrer,omitempty"`
+
+	// 严格来源模式下豁免来源检查的管理令牌
+	AdminToken string `json:"adminToken,omitempty"`
+
+	// 站点加入令牌（-site-tokens-file）
+	Token string `json:"token,omitempty"`
+
+	// 多租户模式下的 API 密钥
+	APIKey string `json:"apiKey,omitempty"`
+}
+
+// 长轮询加入响应
+type PollJoinInfo struct {
+	Token   string `json:"token"`
+	SiteID  string `json:"siteId"`
+	Count   int    `json:"count"`
+	Timeout int64  `json:"timeout"`
+}
+
+// 查找会话并标记为等待中，会话不存在或已有请求在等待时返回状态码
+func (h *Hub) beginPollWait(token string) (*pollSession, int) {
+	h.pollMutex.Lock()
+	defer h.pollMutex.Unlock()
+
+	session, exists := h.polls[token]
+	if !exists {
+		return nil, http.StatusNotFound
+	}
+	if session.waiting {
+		return nil, http.StatusConflict
+	}
+	session.waiting = true
+	session.lastSeen = time.Now()
+	return session, http.StatusOK
+}
+
+// 结束一次等待，刷新最后活跃时间
+func (h *Hub) endPollWait(session *pollSession) {
+	h.pollMutex.Lock()
+	session.waiting = false
+	session.lastSeen = time.Now()
+	h.pollMutex.Unlock()
+}
+
+// 移除会话，只有成功移除的一方负责注销客户端
+func (h *Hub) removePoll(token string) (*pollSession, bool) {
+	h.pollMutex.Lock()
+	defer h.pollMutex.Unlock()
+
+	session, exists := h.polls[token]
+	if exists {
+		delete(h.polls, token)
+	}
+	return session, exists
+}
+
+// 清理超时未轮询的会话（在 Hub 协程中调用）
+func (h *Hub) expirePolls(now time.Time) {
+	h.pollMutex.Lock()
+	var expired []*pollSession
+	for token, session := range h.polls {
+		if !session.waiting && now.Sub(session.lastSeen) > *pollTimeout {
+			delete(h.polls, token)
+			expired = append(expired, session)
+		}
+	}
+	h.pollMutex.Unlock()
+
+	for _, session := range expired {
+		log.Printf("长轮询客户端 %s 超过 %v 未轮询", session.client.ip, *pollTimeout)
+		h.handleUnregister(session.client)
+	}
+}
+
+// 关闭时移除所有未在等待的会话，等待中的请求收到关闭通知后自行移除
+func (h *Hub) dropPolls() {
+	h.pollMutex.Lock()
+	var dropped []*pollSession
+	for token, session := range h.polls {
+		if !session.waiting {
+			delete(h.polls, token)
+			dropped = append(dropped, session)
+		}
+	}
+	h.pollMutex.Unlock()
+
+	for _, session := range dropped {
+		h.queueUnregister(session.client, session.client.site.ID)
+	}
+}
+
+// 读取发送队列中已有的消息，返回最后一条
+func latestMessage(first Message, send chan Message) (Message, bool) {
+	latest := first
+	for {
+		select {
+		case message, ok := <-send:
+			if !ok {
+				return latest, false
+			}
+			latest = message
+			if message.closeCode != 0 {
+				return latest, true
+			}
+		default:
+			return latest, true
+		}
+	}
+}
+
+// 处理长轮询加入：POST /poll/join
+func handlePollJoin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !originAllowed(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req pollJoinRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	origin := r.Header.Get("Origin")
+	if strings.TrimSpace(req.SiteID) == "" {
+		writeError(w, &InvalidSiteIDError{Reason: "缺少 siteId"})
+		return
+	}
+	siteID := joinSiteID(req.SiteID, origin, transportLongPoll)
+	if multiTenant() {
+		tenant, ok := tenantForKey(req.APIKey)
+		if !ok {
+			writeError(w, ErrTenantUnauthorized)
+			return
+		}
+		siteID = tenantSiteID(tenant.Name, siteID)
+	}
+	originHost := requestOriginHost(r)
+	err := checkBlocklist(getRealIP(r))
+	if err == nil {
+		err = hub.checkJoin(siteID)
+	}
+	if err == nil {
+		err = hub.checkOrigin(originHost, siteID, req.AdminToken)
+	}
+	if err == nil {
+		err = checkSiteToken(siteID, req.Token)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	bot, err := classifyBot(r, transportLongPoll)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_, isGroup := groupName(siteID)
+	if req.Referrer == "" {
+		req.Referrer = r.Referer()
+	}
+
+	token, err := randomHex(16)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	client := &Client{
+		hub:         hub,
+		send:        make(chan Message, 16),
+		id:          nextClientID.Add(1),
+		ip:          getRealIP(r),
+		origin:      origin,
+		originHost:  originHost,
+		connectedAt: time.Now(),
+		visitorID:   validVisitorID(req.VisitorID),
+		clientID:    validVisitorID(req.ClientID),
+		version:     validVersion(req.Version),
+		path:        normalizePath(req.Path),
+		device:      deviceClass(r.UserAgent()),
+		bot:         bot,
+		referrer:    referrerHost(req.Referrer),
+		kind:        newConnKind(transportLongPoll),
+	}
+	recordTransport(client.kind)
+	if checkDedupMode(req.Dedup) == nil {
+		client.dedup = req.Dedup
+	}
+	client.observer = req.Observe || isGroup || client.bot || settings.Get(siteID).Excludes(client.visitorID)
+	site, err := hub.getSite(siteID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	client.site = site
+
+	hub.pollMutex.Lock()
+	hub.polls[token] = &pollSession{token: token, client: client, lastSeen: time.Now()}
+	hub.pollMutex.Unlock()
+	hub.queueRegister(client)
+
+	// 加入后 Hub 会推送一次人数；突发模式下可能没有，此时直接读取
+	count := -1
+	timer := time.NewTimer(pollJoinWait)
+	select {
+	case message, ok := <-client.send:
+		timer.Stop()
+		if ok {
+			message, _ = latestMessage(message, client.send)
+			count = message.Count
+		}
+	case <-timer.C:
+	}
+	if count < 0 {
+		count, _ = hub.countAndSeq(siteID)
+	}
+
+	writeJSON(w, http.StatusOK, PollJoinInfo{
+		Token:   token,
+		SiteID:  siteID,
+		Count:   count,
+		Timeout: int64(pollTimeout.Seconds()),
+	})
+}
+
+// 处理长轮询等待：GET /poll/wait?token=...
+//
+// 最多等待 25 秒，期间人数有变化时返回最新的消息，否则返回 204。
+func handlePollWait(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	session, status := hub.beginPollWait(token)
+	if session == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	client := session.client
+
+	// 结束会话并注销客户端
+	leave := func() {
+		if _, removed := hub.removePoll(token); removed {
+			hub.queueUnregister(client, client.site.ID)
+		}
+	}
+
+	timer := time.NewTimer(pollWaitTimeout)
+	defer timer.Stop()
+
+	select {
+	case message, ok := <-client.send:
+		if ok {
+			message, ok = latestMessage(message, client.send)
+		}
+		if !ok {
+			// 发送队列已被 Hub 关闭（如消费过慢被丢弃），需要重新加入
+			leave()
+			http.Error(w, "Gone", http.StatusGone)
+			return
+		}
+		if message.closeCode != 0 {
+			leave()
+		} else {
+			hub.endPollWait(session)
+		}
+		writeJSON(w, http.StatusOK, message)
+
+	case <-hub.closing:
+		leave()
+		writeJSON(w, http.StatusOK, shutdownMessage())
+
+	case <-timer.C:
+		hub.endPollWait(session)
+		w.WriteHeader(http.StatusNoContent)
+
+	case <-r.Context().Done():
+		hub.endPollWait(session)
+	}
+}
+
+// 处理长轮询离开：POST /poll/leave?token=...
+func handlePollLeave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, removed := hub.removePoll(r.URL.Query().Get("token"))
+	if !removed {
+		http.NotFound(w, r)
+		return
+	}
+	hub.queueUnregister(session.client, session.client.site.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 每个站点在配额周期内允许发布的自定义事件数
+var publishQuota = flag.Int("publish-quota", 1000, "每个站点在配额周期内允许发布的自定义事件数")
+var publishQuotaWindow = flag.Duration("publish-quota-window", 24*time.Hour, "自定义事件发布配额的周期")
+
+// 每个站点每秒最多发布的自定义事件数
+const publishPerSecond = 5
+
+// 自定义事件请求体的最大字节数
+const maxPublishBytes = 4096
+
+// 自定义事件名格式
+var publishEventPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// 发布请求体
+type publishRequest struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// 发布结果
+type PublishInfo struct {
+	Event     string `json:"event"`
+	Delivered int    `json:"delivered"`
+	Remaining int    `json:"remaining"`
+	ResetAt   int64  `json:"resetAt"`
+}
+
+// 发布被限流时的响应
+type PublishRejected struct {
+	Error   string `json:"error"`
+	ResetAt int64  `json:"resetAt"`
+}
+
+// 固定窗口计数
+type publishWindow struct {
+	start time.Time
+	used  int
+}
+
+// 窗口过期时重新开始，返回窗口结束时间
+func (w *publishWindow) roll(now time.Time, length time.Duration) time.Time {
+	if now.Sub(w.start) >= length {
+		w.start = now
+		w.used = 0
+	}
+	return w.start.Add(length)
+}
+
+// 站点的发布限额：每秒速率与周期配额
+type publishLimit struct {
+	second publishWindow
+	quota  publishWindow
+}
+
+// 消耗一次发布额度；被拒绝时返回原因与可重试的时间
+func (h *Hub) takePublish(siteID string, now time.Time) (remaining int, resetAt time.Time, reason string) {
+	h.publishMutex.Lock()
+	defer h.publishMutex.Unlock()
+
+	limit, exists := h.publishLimits[siteID]
+	if !exists {
+		limit = &publishLimit{}
+		h.publishLimits[siteID] = limit
+	}
+
+	quotaReset := limit.quota.roll(now, *publishQuotaWindow)
+	secondReset := limit.second.roll(now, time.Second)
+	if limit.quota.used >= *publishQuota {
+		return 0, quotaReset, "quota"
+	}
+	if limit.second.used >= publishPerSecond {
+		return *publishQuota - limit.quota.used, secondReset, "rate"
+	}
+	limit.quota.used++
+	limit.second.used++
+	return *publishQuota - limit.quota.used, quotaReset, ""
+}
+
+// 清理配额周期已结束的限额记录
+func (h *Hub) sweepPublishLimits(now time.Time) {
+	h.publishMutex.Lock()
+	defer h.publishMutex.Unlock()
+
+	for siteID, limit := range h.publishLimits {
+		if now.Sub(limit.quota.start) >= *publishQuotaWindow {
+			delete(h.publishLimits, siteID)
+		}
+	}
+}
+
+// 在站点操作环中记录发布被拒绝，站点不在线时不记录
+func (h *Hub) recordPublishRejected(siteID, reason string) {
+	h.mutex.RLock()
+	site, exists := h.sites[siteID]
+	h.mutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	site.mutex.Lock()
+	site.ops.add(SiteOp{Op: "publish-" + reason, Timestamp: time.Now().Unix()})
+	site.mutex.Unlock()
+}
+
+// 站点当前的连接数（含观察者）
+func (h *Hub) listeners(siteID string) int {
+	h.mutex.RLock()
+	site, exists := h.sites[siteID]
+	h.mutex.RUnlock()
+	if !exists {
+		return 0
+	}
+
+	site.mutex.RLock()
+	defer site.mutex.RUnlock()
+	return len(site.Connections) + len(site.Observers)
+}
+
+// 处理自定义事件发布：POST /owner/api/sites/{id}/publish
+//
+// 请求体 {"event":"comment","data":{...}}，向站点所有连接（含观察者）推送
+// {"type":"custom","event":"comment","data":{...}}。
+func handleOwnerPublish(w http.ResponseWriter, r *http.Request, siteID string) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !settings.Get(siteID).Publish {
+		http.Error(w, "Publishing Disabled", http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPublishBytes+1))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxPublishBytes {
+		http.Error(w, "Payload Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var req publishRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if !publishEventPattern.MatchString(req.Event) {
+		http.Error(w, "Invalid event", http.StatusBadRequest)
+		return
+	}
+	if len(req.Data) > 0 && !bytes.HasPrefix(bytes.TrimSpace(req.Data), []byte("{")) {
+		http.Error(w, "data must be an object", http.StatusBadRequest)
+		return
+	}
+
+	remaining, resetAt, reason := hub.takePublish(siteID, time.Now())
+	if reason != "" {
+		log.Printf("站点 %s 发布自定义事件被拒绝（%s），%s 后重置", siteID, reason, resetAt.Format(time.RFC3339))
+		hub.recordPublishRejected(siteID, reason)
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+		writeJSON(w, http.StatusTooManyRequests, PublishRejected{Error: reason, ResetAt: resetAt.Unix()})
+		return
+	}
+
+	// 所有连接收到的内容相同，预先编码一次
+	message := Message{Type: "custom", SiteID: siteID, Event: req.Event, Data: req.Data, Timestamp: time.Now().Unix()}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	message.prepared, err = websocket.NewPreparedMessage(websocket.TextMessage, payload)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	delivered := hub.listeners(siteID)
+	hub.broadcastMessage(siteID, message)
+
+	writeJSON(w, http.StatusOK, PublishInfo{
+		Event:     req.Event,
+		Delivered: delivered,
+		Remaining: remaining,
+		ResetAt:   resetAt.Unix(),
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+// 同一连接两次 query 之间的最短间隔
+var queryInterval = flag.Duration("query-interval", time.Second, "同一 WebSocket 连接两次 query 之间的最短间隔，过于频繁的 query 以 query_rate_limited 拒绝")
+
+// 处理 query：为每个站点回复一条 count，只发给本连接（在 readPump 中调用）
+//
+// 只读取人数，不在 Hub 中创建站点，不在线或未知的站点回复 0；
+// 私有站点与无效的 siteId 各回复一条 error，连接不会断开。
+func (c *Client) handleQuery(siteIDs []string, now time.Time) {
+	if now.Sub(c.lastQuery) < *queryInterval {
+		c.enqueueError("", &LimitError{Code: "query_rate_limited", Limit: "queryInterval", Max: int(queryInterval.Milliseconds())})
+		return
+	}
+	c.lastQuery = now
+	if len(siteIDs) > maxCountSites {
+		c.enqueueError("", &LimitError{Code: "too_many_sites", Limit: "maxCountSites", Max: maxCountSites})
+		return
+	}
+
+	for _, raw := range siteIDs {
+		siteID := c.scopeSiteID(resolveSiteID(raw))
+		if err := validateSiteID(siteID); err != nil {
+			c.enqueueError(siteID, err)
+			continue
+		}
+		if settings.Resolve(siteID).Private {
+			c.enqueueError(siteID, ErrSiteUnauthorized)
+			continue
+		}
+
+		count, seq := c.hub.countAndSeq(siteID)
+		if name, ok := groupName(siteID); ok {
+			count, _ = c.hub.groupCount(name)
+		}
+		if !c.enqueue(Message{Type: "count", SiteID: siteID, Count: count, Seq: seq, Timestamp: now.Unix()}) {
+			c.hub.counters.droppedFrames.Add(1)
+		}
+	}
+}
+
+// 发送一条不断开连接的错误消息
+func (c *Client) enqueueError(siteID string, err error) {
+	message := errorMessage(siteID, err)
+	message.closeCode, message.closeText = 0, ""
+	c.enqueue(message)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 命令排队延迟超过该值时深度健康检查返回 degraded
+var queueLatencyThreshold = flag.Duration("queue-latency-threshold", 100*time.Millisecond, "Hub 命令排队延迟的告警阈值，p99 或最久等待超过时深度健康检查返回 degraded")
+
+// 排队延迟直方图的桶上界
+var queueLatencyBuckets = []time.Duration{
+	50 * time.Microsecond, 100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+// 近期延迟的统计窗口，p99 取当前与上一个窗口
+const queueWindow = time.Minute
+
+// 排队延迟直方图，最后一个桶为 +Inf
+type latencyHistogram struct {
+	counts [17]int64
+	count  int64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// 记录一次延迟
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := sort.Search(len(queueLatencyBuckets), func(i int) bool { return d <= queueLatencyBuckets[i] })
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// 合并另一个直方图
+func (h *latencyHistogram) merge(other *latencyHistogram) {
+	for i := range h.counts {
+		h.counts[i] += other.counts[i]
+	}
+	h.count += other.count
+	h.sum += other.sum
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// 分位数，取所在桶的上界（不超过最大值）
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(q*float64(h.count) + 0.999999)
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank && i < len(queueLatencyBuckets) {
+			return min(queueLatencyBuckets[i], h.max)
+		}
+	}
+	return h.max
+}
+
+// 按固定窗口轮换的近期延迟
+type latencyWindow struct {
+	start    time.Time
+	current  latencyHistogram
+	previous latencyHistogram
+}
+
+// 窗口结束时轮换，超过两个窗口没有数据时清空
+func (w *latencyWindow) roll(now time.Time) {
+	elapsed := now.Sub(w.start)
+	if elapsed < queueWindow {
+		return
+	}
+	if elapsed < 2*queueWindow {
+		w.previous = w.current
+	} else {
+		w.previous = latencyHistogram{}
+	}
+	w.current = latencyHistogram{}
+	w.start = now
+}
+
+// 当前与上一个窗口合并后的直方图
+func (w *latencyWindow) recent(now time.Time) latencyHistogram {
+	w.roll(now)
+	merged := w.current
+	merged.merge(&w.previous)
+	return merged
+}
+
+// 已入队、尚未被 Hub 协程取出的命令
+type pendingCommand struct {
+	siteID string
+	at     time.Time
+}
+
+// 站点的近期排队延迟
+type siteQueueStats struct {
+	window latencyWindow
+	last   time.Time
+}
+
+// Hub 命令队列的排队统计
+type queueStats struct {
+	mutex   sync.Mutex
+	nextID  uint64
+	pending map[uint64]pendingCommand
+	total   latencyHistogram
+	window  latencyWindow
+	sites   map[string]*siteQueueStats
+}
+
+// 提交给 Hub 协程的命令，siteID 为命令涉及的站点，用于按站点统计排队延迟
+type hubCommand struct {
+	client *Client
+	join   siteJoin
+	group  string
+	siteID string
+	id     uint64
+
+	// 管理员断开或移除站点（siteControl），结果写入 reply；信标命令中 remove 表示移除全部在线记录
+	reason string
+	remove bool
+	reply  chan disconnectResult
+
+	// GIF 信标的客户端 IP（beacon）
+	ip string
+}
+
+// 记录入队时间后提交命令，Hub 协程繁忙时阻塞；Hub 已停止时丢弃命令
+func (h *Hub) enqueue(queue chan<- hubCommand, command hubCommand) {
+	q := &h.queues
+	q.mutex.Lock()
+	q.nextID++
+	command.id = q.nextID
+	q.pending[command.id] = pendingCommand{siteID: command.siteID, at: time.Now()}
+	q.mutex.Unlock()
+
+	select {
+	case queue <- command:
+	case <-h.done:
+		q.mutex.Lock()
+		delete(q.pending, command.id)
+		q.mutex.Unlock()
+	}
+}
+
+// Hub 协程取出命令时记录排队延迟
+func (h *Hub) dequeued(command hubCommand) {
+	now := time.Now()
+	q := &h.queues
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	pending, exists := q.pending[command.id]
+	if !exists {
+		return
+	}
+	delete(q.pending, command.id)
+
+	latency := now.Sub(pending.at)
+	q.total.observe(latency)
+	q.window.roll(now)
+	q.window.current.observe(latency)
+	if pending.siteID == "" {
+		// 未加入站点的连接断开，或订阅变更
+		return
+	}
+
+	site, exists := q.sites[pending.siteID]
+	if !exists {
+		site = &siteQueueStats{window: latencyWindow{start: now}}
+		q.sites[pending.siteID] = site
+	}
+	site.window.roll(now)
+	site.window.current.observe(latency)
+	site.last = now
+}
+
+// 提交加入、注销、切换站点与分组变更
+func (h *Hub) queueRegister(client *Client) {
+	h.enqueue(h.register, hubCommand{client: client, siteID: client.site.ID})
+}
+
+func (h *Hub) queueUnregister(client *Client, siteID string) {
+	h.enqueue(h.unregister, hubCommand{client: client, siteID: siteID})
+}
+
+func (h *Hub) queueJoin(join siteJoin) {
+	h.enqueue(h.join, hubCommand{join: join, siteID: join.site.ID})
+}
+
+func (h *Hub) queueRejoin(client *Client, siteID string) {
+	h.enqueue(h.join, hubCommand{join: siteJoin{client: client, rejoin: true}, siteID: siteID})
+}
+
+func (h *Hub) queueSubscribe(join siteJoin) {
+	h.enqueue(h.join, hubCommand{join: join})
+}
+
+func (h *Hub) queueRelease(site *Site) {
+	h.enqueue(h.join, hubCommand{join: siteJoin{site: site, release: true}, siteID: site.ID})
+}
+
+func (h *Hub) queueUnjoin(client *Client, siteID string) {
+	h.enqueue(h.join, hubCommand{join: siteJoin{client: client, unjoin: siteID}, siteID: siteID})
+}
+
+func (h *Hub) queueGroupChanged(name string) {
+	h.enqueue(h.groupChanged, hubCommand{group: name, siteID: groupPrefix + name})
+}
+
+// 清理两个窗口内没有命令的站点
+func (h *Hub) sweepQueues(now time.Time) {
+	q := &h.queues
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for siteID, site := range q.sites {
+		if now.Sub(site.last) >= 2*queueWindow {
+			delete(q.sites, siteID)
+		}
+	}
+}
+
+// 命令队列状态
+type QueueInfo struct {
+	Depth    int     `json:"depth"`
+	OldestMs float64 `json:"oldestMs"`
+	P50Ms    float64 `json:"p50Ms"`
+	P99Ms    float64 `json:"p99Ms"`
+	MaxMs    float64 `json:"maxMs"`
+	Count    int64   `json:"count"`
+	Degraded bool    `json:"degraded"`
+}
+
+// 站点的命令队列状态
+type SiteQueueInfo struct {
+	SiteID string `json:"siteId"`
+	QueueInfo
+}
+
+// 命令队列查询响应
+type QueueReport struct {
+	Queue QueueInfo       `json:"queue"`
+	Sites []SiteQueueInfo `json:"sites"`
+}
+
+// 毫秒
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// 由排队中的命令与近期直方图生成状态
+func newQueueInfo(depth int, oldest time.Duration, recent *latencyHistogram) QueueInfo {
+	p99 := recent.quantile(0.99)
+	return QueueInfo{
+		Depth:    depth,
+		OldestMs: durationMs(oldest),
+		P50Ms:    durationMs(recent.quantile(0.5)),
+		P99Ms:    durationMs(p99),
+		MaxMs:    durationMs(recent.max),
+		Count:    recent.count,
+		Degraded: p99 > *queueLatencyThreshold || oldest > *queueLatencyThreshold,
+	}
+}
+
+// 整体的命令队列状态，延迟为近期窗口内的值
+func (h *Hub) queueInfo() QueueInfo {
+	now := time.Now()
+	q := &h.queues
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var oldest time.Duration
+	for _, pending := range q.pending {
+		oldest = max(oldest, now.Sub(pending.at))
+	}
+	recent := q.window.recent(now)
+	return newQueueInfo(len(q.pending), oldest, &recent)
+}
+
+// 按 p99 延迟从高到低排列的站点，仍有命令排队的站点包含在内
+func (h *Hub) siteQueues(limit int) []SiteQueueInfo {
+	now := time.Now()
+	q := &h.queues
+	q.mutex.Lock()
+
+	depth := make(map[string]int)
+	oldest := make(map[string]time.Duration)
+	for _, pending := range q.pending {
+		depth[pending.siteID]++
+		oldest[pending.siteID] = max(oldest[pending.siteID], now.Sub(pending.at))
+	}
+
+	var sites []SiteQueueInfo
+	for siteID, site := range q.sites {
+		recent := site.window.recent(now)
+		sites = append(sites, SiteQueueInfo{SiteID: siteID, QueueInfo: newQueueInfo(depth[siteID], oldest[siteID], &recent)})
+	}
+	for siteID, n := range depth {
+		if _, exists := q.sites[siteID]; !exists {
+			sites = append(sites, SiteQueueInfo{SiteID: siteID, QueueInfo: newQueueInfo(n, oldest[siteID], &latencyHistogram{})})
+		}
+	}
+	q.mutex.Unlock()
+
+	sort.Slice(sites, func(i, j int) bool {
+		a, b := max(sites[i].P99Ms, sites[i].OldestMs), max(sites[j].P99Ms, sites[j].OldestMs)
+		if a != b {
+			return a > b
+		}
+		if sites[i].Depth != sites[j].Depth {
+			return sites[i].Depth > sites[j].Depth
+		}
+		return sites[i].SiteID < sites[j].SiteID
+	})
+	if len(sites) > limit {
+		sites = sites[:limit]
+	}
+	return sites
+}
+
+// 写入排队延迟的 Prometheus 直方图（累计值）
+func (h *Hub) writeQueueMetrics(buf *bytes.Buffer) {
+	q := &h.queues
+	q.mutex.Lock()
+	depth := len(q.pending)
+	total := q.total
+	q.mutex.Unlock()
+
+	writeMetric(buf, "liveuser_hub_queue_depth", "gauge", "已入队、尚未被 Hub 协程处理的命令数", int64(depth))
+
+	buf.WriteString("# HELP liveuser_hub_queue_latency_seconds Hub 命令从入队到被处理的延迟\n")
+	buf.WriteString("# TYPE liveuser_hub_queue_latency_seconds histogram\n")
+	var cumulative int64
+	for i, bound := range queueLatencyBuckets {
+		cumulative += total.counts[i]
+		fmt.Fprintf(buf, "liveuser_hub_queue_latency_seconds_bucket{le=\"%g\"} %d\n", bound.Seconds(), cumulative)
+	}
+	fmt.Fprintf(buf, "liveuser_hub_queue_latency_seconds_bucket{le=\"+Inf\"} %d\n", total.count)
+	fmt.Fprintf(buf, "liveuser_hub_queue_latency_seconds_sum %g\n", total.sum.Seconds())
+	fmt.Fprintf(buf, "liveuser_hub_queue_latency_seconds_count %d\n", total.count)
+}
+
+// 处理命令队列查询：GET /admin/api/queues?limit=20
+//
+// 列出排队延迟最高的站点，用于在故障时定位热点站点。
+func handleAdminQueues(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 1000)
+	}
+
+	sites := hub.siteQueues(limit)
+	if sites == nil {
+		sites = []SiteQueueInfo{}
+	}
+	writeJSON(w, http.StatusOK, QueueReport{Queue: hub.queueInfo(), Sites: sites})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 深度健康检查的状态码与响应
+func deepHealth(t *testing.T) (int, HealthInfo) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleHealthz(w, httptest.NewRequest("GET", "/healthz?deep=1", nil))
+	var info HealthInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, info
+}
+
+// 直方图中 le 桶的累计值
+func metricBucket(t *testing.T, metrics, le string) int64 {
+	t.Helper()
+	prefix := fmt.Sprintf("liveuser_hub_queue_latency_seconds_bucket{le=\"%s\"} ", le)
+	for _, line := range strings.Split(metrics, "\n") {
+		if value, ok := strings.CutPrefix(line, prefix); ok {
+			var n int64
+			fmt.Sscan(value, &n)
+			return n
+		}
+	}
+	t.Fatalf("没有桶 le=%s:\n%s", le, metrics)
+	return 0
+}
+
+// Hub 协程被卡住时大量加入排队：排队期间队列降级并指出热点站点，
+// 恢复后直方图、分位数、深度健康检查与 Prometheus 指标都反映出这段延迟
+func TestQueueLatencyUnderStall(t *testing.T) {
+	const (
+		joins = 20
+		stall = 300 * time.Millisecond
+	)
+	h := startTestHub(t)
+	setFlag(t, "queue-latency-threshold", "100ms")
+	server := startTestServer(t)
+
+	dialTestClient(t, server, "").join("hot.example")
+	dialTestClient(t, server, "").join("cool.example")
+	if code, info := deepHealth(t); code != http.StatusOK || info.Queue == nil || info.Queue.Degraded {
+		t.Fatalf("卡住前深度健康检查返回 %d: %+v", code, info.Queue)
+	}
+
+	// 持有站点锁，处理第一个加入的 Hub 协程停在 handleRegister 中，其余命令排队
+	h.mutex.RLock()
+	hot := h.sites["hot.example"]
+	h.mutex.RUnlock()
+	hot.mutex.Lock()
+	clients := make([]*testClient, joins)
+	for i := range clients {
+		clients[i] = dialTestClient(t, server, "")
+		clients[i].send(Message{Type: "join", SiteID: "hot.example", Version: scriptVersion})
+	}
+	eventually(t, "加入命令排队", func() bool { return h.queueInfo().Depth == joins-1 })
+	time.Sleep(stall)
+
+	// 健康检查读取站点人数也要取站点锁，卡住期间直接查看队列
+	if queue := h.queueInfo(); !queue.Degraded || queue.OldestMs < durationMs(stall) {
+		t.Errorf("卡住时队列为 %+v，应为 degraded", queue)
+	}
+	if sites := h.siteQueues(1); len(sites) != 1 || sites[0].SiteID != "hot.example" || sites[0].Depth != joins-1 {
+		t.Errorf("排队最久的站点为 %+v，应为 hot.example", sites)
+	}
+	hot.mutex.Unlock()
+
+	for _, client := range clients {
+		client.expect("joined")
+	}
+	eventually(t, "队列清空", func() bool { return h.queueInfo().Depth == 0 })
+
+	queue := h.queueInfo()
+	if queue.Count < joins+2 {
+		t.Fatalf("恢复后队列为 %+v", queue)
+	}
+	if queue.MaxMs < durationMs(stall) || queue.P99Ms < durationMs(stall) || !queue.Degraded {
+		t.Fatalf("近期延迟为 %+v，应反映 %v 的卡顿", queue, stall)
+	}
+	if code, info := deepHealth(t); code != http.StatusServiceUnavailable || info.Status != "degraded" {
+		t.Fatalf("恢复后近期窗口内深度健康检查返回 %d: %s %+v", code, info.Status, info.Queue)
+	}
+	sites := h.siteQueues(2)
+	if len(sites) != 2 || sites[0].SiteID != "hot.example" || sites[1].P99Ms >= durationMs(stall) {
+		t.Fatalf("站点排队延迟为 %+v，应只有 hot.example 反映卡顿", sites)
+	}
+
+	var buf bytes.Buffer
+	h.writeQueueMetrics(&buf)
+	metrics := buf.String()
+	if slow := metricBucket(t, metrics, "+Inf") - metricBucket(t, metrics, "0.25"); slow < joins-1 {
+		t.Fatalf("超过 250ms 的排队有 %d 次，应至少 %d 次:\n%s", slow, joins-1, metrics)
+	}
+}
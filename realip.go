@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 可信代理，只有来自这些地址的请求才采用转发头中的客户端地址
+var trustedProxiesFlag = flag.String("trusted-proxies", "", "可信反向代理的地址或 CIDR，逗号分隔，如 10.0.0.0/8,::1；为空时忽略 X-Forwarded-For 等转发头")
+
+// 启动时解析的可信代理网段
+var trustedProxies []*net.IPNet
+
+// 解析 -trusted-proxies，单个地址视为 /32 或 /128
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的可信代理 %q", part)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("无效的可信代理 %q", part)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+// 地址是否属于可信代理
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析地址，允许带端口或方括号，无效时返回 nil
+func parseHopIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
+
+// 获取真实 IP
+//
+// 只有对端地址属于 -trusted-proxies 时才采用 X-Forwarded-For 与 X-Real-IP：
+// X-Forwarded-For 从右向左跳过可信代理，第一个不可信的地址即为客户端（更左边的条目可由客户端伪造）。
+// CF-Connecting-IP 只在上述得到的地址属于 Cloudflare 时采用，其他来源带此头时告警并忽略。
+// 返回的地址不带端口。
+func getRealIP(r *http.Request) string {
+	remote := parseHopIP(r.RemoteAddr)
+	unix := remote == nil && viaUnixSocket(r)
+	if unix {
+		// Unix 域套接字的对端是同一主机上的反向代理，没有 IP 地址，按可信的本机代理处理
+		remote = net.IPv4(127, 0, 0, 1)
+	}
+	if remote == nil {
+		return r.RemoteAddr
+	}
+
+	client := remote
+	if unix || isTrustedProxy(remote) {
+		client = forwardedClient(r, remote)
+	}
+
+	if header := r.Header.Get("CF-Connecting-IP"); header != "" {
+		if !isCloudflareIP(client) {
+			warnSpoofedCloudflare(client.String(), header)
+		} else if ip := parseHopIP(header); ip != nil {
+			return ip.String()
+		}
+	}
+	return client.String()
+}
+
+// 按可信代理转发的请求头确定客户端地址，没有转发头时返回代理自身的地址
+func forwardedClient(r *http.Request, remote net.IP) net.IP {
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	if len(hops) > 0 {
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := parseHopIP(hops[i])
+			if ip == nil {
+				// 无法解析的条目之后的内容都不可信，取最近一个可信代理
+				break
+			}
+			client = ip
+			if !isTrustedProxy(ip) {
+				break
+			}
+		}
+		return client
+	}
+
+	if ip := parseHopIP(r.Header.Get("X-Real-IP")); ip != nil {
+		return ip
+	}
+	return remote
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 在测试期间替换可信代理与 Cloudflare IP 段
+func setTestProxies(t *testing.T, proxies, cloudflareRanges string) {
+	t.Helper()
+
+	nets, err := parseTrustedProxies(proxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges, err := parseIPRanges(cloudflareRanges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousProxies := trustedProxies
+	cloudflare.mutex.RLock()
+	previousRanges := cloudflare.ranges
+	cloudflare.mutex.RUnlock()
+
+	trustedProxies = nets
+	setCloudflareRanges(ranges)
+	t.Cleanup(func() {
+		trustedProxies = previousProxies
+		setCloudflareRanges(previousRanges)
+	})
+}
+
+// 只信任可信代理追加的转发头：不可信对端伪造的 XFF、X-Real-IP 与 CF-Connecting-IP 一律忽略
+func TestGetRealIP(t *testing.T) {
+	setTestProxies(t, "10.0.0.0/8,::1,2001:db8:1::/48", "173.245.48.0/20\n2400:cb00::/32")
+
+	tests := []struct {
+		name   string
+		remote string
+		unix   bool
+		header http.Header
+		want   string
+	}{
+		{"直连", "203.0.113.5:1234", false, nil, "203.0.113.5"},
+		{"不可信对端伪造 XFF", "203.0.113.5:1234", false,
+			http.Header{"X-Forwarded-For": {"198.51.100.7"}}, "203.0.113.5"},
+		{"不可信对端伪造 X-Real-IP", "203.0.113.5:1234", false,
+			http.Header{"X-Real-Ip": {"198.51.100.7"}}, "203.0.113.5"},
+		{"可信代理", "10.0.0.2:80", false,
+			http.Header{"X-Forwarded-For": {"198.51.100.7"}}, "198.51.100.7"},
+		{"多跳：从右向左跳过可信代理，忽略客户端伪造的左侧条目", "10.0.0.2:80", false,
+			http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.7, 10.0.0.3"}}, "198.51.100.7"},
+		{"多个 XFF 头按顺序拼接", "10.0.0.2:80", false,
+			http.Header{"X-Forwarded-For": {"1.2.3.4", "198.51.100.7, 10.0.0.3"}}, "198.51.100.7"},
+		{"全部是可信代理时取最左", "10.0.0.2:80", false,
+			http.Header{"X-Forwarded-For": {"10.0.0.4, 10.0.0.3"}}, "10.0.0.4"},
+		{"无法解析的条目之后不可信", "10.0.0.2:80", false,
+			http.Header{"X-Forwarded-For": {"198.51.100.7, not-an-ip, 10.0.0.3"}}, "10.0.0.3"},
+		{"可信代理只带 X-Real-IP", "10.0.0.2:80", false,
+			http.Header{"X-Real-Ip": {"198.51.100.8"}}, "198.51.100.8"},
+		{"可信代理没有转发头", "10.0.0.2:80", false, nil, "10.0.0.2"},
+		{"IPv6 可信代理", "[::1]:5555", false,
+			http.Header{"X-Forwarded-For": {"2001:db8::1"}}, "2001:db8::1"},
+		{"IPv6 条目带方括号与端口", "[2001:db8:1::9]:443", false,
+			http.Header{"X-Forwarded-For": {"[2001:db8::2]:51000"}}, "2001:db8::2"},
+		{"IPv6 多跳", "[::1]:5555", false,
+			http.Header{"X-Forwarded-For": {"2001:db8::dead, 2001:db8:3::1, 2001:db8:1::5"}}, "2001:db8:3::1"},
+		{"不可信 IPv6 对端", "[2001:db8:2::5]:80", false,
+			http.Header{"X-Forwarded-For": {"198.51.100.7"}}, "2001:db8:2::5"},
+		{"Unix 套接字按可信代理处理", "@", true,
+			http.Header{"X-Forwarded-For": {"198.51.100.9"}}, "198.51.100.9"},
+		{"Unix 套接字没有转发头", "@", true, nil, "127.0.0.1"},
+		{"无法解析的对端地址原样返回", "pipe", false, nil, "pipe"},
+		{"非 Cloudflare 对端伪造 CF-Connecting-IP", "203.0.113.5:1234", false,
+			http.Header{"Cf-Connecting-Ip": {"198.51.100.7"}}, "203.0.113.5"},
+		{"Cloudflare 直连", "173.245.48.10:443", false,
+			http.Header{"Cf-Connecting-Ip": {"198.51.100.7"}}, "198.51.100.7"},
+		{"经可信代理转发的 Cloudflare 请求", "10.0.0.2:80", false,
+			http.Header{"X-Forwarded-For": {"173.245.48.10"}, "Cf-Connecting-Ip": {"2001:db8::7"}}, "2001:db8::7"},
+		{"可信代理后的非 Cloudflare 客户端伪造 CF-Connecting-IP", "10.0.0.2:80", false,
+			http.Header{"X-Forwarded-For": {"198.51.100.7"}, "Cf-Connecting-Ip": {"1.1.1.1"}}, "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for key, values := range tt.header {
+				r.Header[key] = values
+			}
+			if tt.unix {
+				local := &net.UnixAddr{Name: "/run/liveuser.sock", Net: "unix"}
+				r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+			}
+			if got := getRealIP(r); got != tt.want {
+				t.Fatalf("getRealIP = %q，应为 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 单个 IP 按 /32 或 /128 处理，空条目跳过，无效条目整体拒绝
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := parseTrustedProxies(" 10.0.0.1 , ::1,192.168.0.0/16,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1/32", "::1/128", "192.168.0.0/16"}
+	if len(nets) != len(want) {
+		t.Fatalf("解析出 %d 个网段，应为 %d", len(nets), len(want))
+	}
+	for i, network := range nets {
+		if network.String() != want[i] {
+			t.Errorf("第 %d 个网段为 %s，应为 %s", i, network, want[i])
+		}
+	}
+
+	for _, value := range []string{"10.0.0.256", "10.0.0.0/33", "proxy.local"} {
+		if _, err := parseTrustedProxies(value); err == nil {
+			t.Errorf("%q 应解析失败", value)
+		}
+	}
+}
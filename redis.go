@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 单条 Redis 命令的超时
+const redisCommandTimeout = 5 * time.Second
+
+// Redis 返回的错误
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// 最小的 Redis 客户端（RESP2），只实现多实例计数用到的命令
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Redis 连接参数
+type redisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+}
+
+// 解析 -redis：host:port 或 redis://[:password@]host:port[/db]
+func parseRedisAddr(value string) (redisOptions, error) {
+	if !strings.Contains(value, "://") {
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			return redisOptions{}, fmt.Errorf("无效的 Redis 地址 %q", value)
+		}
+		return redisOptions{Addr: value}, nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return redisOptions{}, fmt.Errorf("无效的 Redis 地址 %q", value)
+	}
+	options := redisOptions{Addr: u.Host}
+	if u.Port() == "" {
+		options.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		options.Password, _ = u.User.Password()
+		if options.Password == "" {
+			options.Password = u.User.Username()
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if options.DB, err = strconv.Atoi(db); err != nil {
+			return redisOptions{}, fmt.Errorf("无效的 Redis 数据库编号 %q", db)
+		}
+	}
+	return options, nil
+}
+
+// 建立连接并完成认证与选库
+func dialRedis(ctx context.Context, options redisOptions) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: redisCommandTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", options.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if options.Password != "" {
+		if _, err := c.do("AUTH", options.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if options.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(options.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// 执行一条命令并读取回复
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisCommandTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// 写入一条命令
+func (c *redisConn) send(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+// 读取一条回复：简单字符串与批量字符串为 string，整数为 int64，
+// 空值为 nil，数组为 []interface{}，错误为 redisError
+func (c *redisConn) receive() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: 空回复")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.receive(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: 无法识别的回复 %q", line)
+}
+
+// 把数组回复转换为字符串列表
+func redisStrings(reply interface{}) []string {
+	items, _ := reply.([]interface{})
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// Redis 键与频道
+const (
+	redisKeyPrefix = "liveuser:counts:"
+	redisChannel   = "liveuser:counts"
+)
+
+// 基于 Redis 的消息代理
+//
+// 每个实例把本实例各站点的人数写入自己的哈希 liveuser:counts:{实例 ID}
+// （带 TTL，由心跳刷新），并通过频道 liveuser:counts 广播变化。新实例启动时
+// 从哈希读取已有实例的人数，因此不必等待下一次心跳。
+type redisBroker struct {
+	*brokerState
+	options redisOptions
+}
+
+// 创建 Redis 消息代理并在后台连接；连接失败不影响启动
+func startRedisBroker(options redisOptions) *redisBroker {
+	b := &redisBroker{brokerState: newBrokerState("redis"), options: options}
+	b.goLoop(b.runPublisher)
+	b.goLoop(b.runSubscriber)
+	log.Printf("多实例人数同步已启用（Redis %s），实例 ID %s", options.Addr, b.instance)
+	return b
+}
+
+// 停止同步，删除本实例的哈希并广播空快照
+func (b *redisBroker) Close(ctx context.Context) error {
+	if err := b.stop(ctx); err != nil {
+		return err
+	}
+	conn, err := dialRedis(ctx, b.options)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.do("DEL", redisKeyPrefix+b.instance); err != nil {
+		return err
+	}
+	return b.publish(conn, b.finalSnapshot())
+}
+
+// 按退避间隔连接 Redis，消息代理关闭时返回错误
+func (b *redisBroker) connect() (*redisConn, error) {
+	var conn *redisConn
+	err := b.dial(b.options.Addr, func(ctx context.Context) (err error) {
+		conn, err = dialRedis(ctx, b.options)
+		return err
+	})
+	return conn, err
+}
+
+// 写入本实例人数：连接后先写入完整快照，之后合并写入变化，并定期心跳；消息代理关闭时退出
+func (b *redisBroker) runPublisher() {
+	key := redisKeyPrefix + b.instance
+	ttl := strconv.Itoa(int(clusterTTL.Seconds()))
+
+	for {
+		conn, err := b.connect()
+		if err != nil {
+			return
+		}
+		err = b.publishSnapshot(conn, key, ttl, true)
+
+		ticker := time.NewTicker(clusterHeartbeat)
+		for err == nil {
+			select {
+			case <-b.ctx.Done():
+				err = b.ctx.Err()
+			case <-b.wake:
+				err = b.flushPending(conn, key, ttl)
+			case <-b.resync:
+				err = b.publishSnapshot(conn, key, ttl, false)
+			case now := <-ticker.C:
+				err = b.publishSnapshot(conn, key, ttl, false)
+				b.expire(now)
+			}
+		}
+		ticker.Stop()
+		conn.Close()
+		if b.ctx.Err() != nil {
+			return
+		}
+		log.Printf("警告: 写入 Redis 失败，重连后重新同步: %v", err)
+	}
+}
+
+// 写入本实例全部站点的人数并广播快照；replace 为 true 时先清空旧的哈希
+func (b *redisBroker) publishSnapshot(conn *redisConn, key, ttl string, replace bool) error {
+	counts := hub.localCounts()
+
+	if replace {
+		if _, err := conn.do("DEL", key); err != nil {
+			return err
+		}
+		if len(counts) > 0 {
+			args := []string{"HSET", key}
+			for siteID, count := range counts {
+				args = append(args, siteID, strconv.Itoa(count))
+			}
+			if _, err := conn.do(args...); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := conn.do("EXPIRE", key, ttl); err != nil {
+		return err
+	}
+	return b.publish(conn, clusterMessage{Type: "snapshot", Instance: b.instance, Counts: counts})
+}
+
+// 写入并广播合并后的人数变化
+func (b *redisBroker) flushPending(conn *redisConn, key, ttl string) error {
+	for siteID, count := range b.takePending() {
+		var err error
+		if count > 0 {
+			_, err = conn.do("HSET", key, siteID, strconv.Itoa(count))
+		} else {
+			_, err = conn.do("HDEL", key, siteID)
+		}
+		if err == nil {
+			err = b.publish(conn, clusterMessage{Type: "count", Instance: b.instance, SiteID: siteID, Count: count})
+		}
+		if err != nil {
+			// 重连后会写入完整快照，未写入的变化不会丢失
+			return err
+		}
+	}
+	_, err := conn.do("EXPIRE", key, ttl)
+	return err
+}
+
+func (b *redisBroker) publish(conn *redisConn, message clusterMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = conn.do("PUBLISH", redisChannel, string(data))
+	return err
+}
+
+// 订阅其他实例的人数：先从 Redis 读取已有实例的人数，再接收变化；消息代理关闭时退出
+func (b *redisBroker) runSubscriber() {
+	for {
+		conn, err := b.connect()
+		if err != nil {
+			return
+		}
+		// 关闭时断开连接，结束阻塞中的读取
+		stop := context.AfterFunc(b.ctx, func() { conn.Close() })
+		err = b.subscribe(conn)
+		stop()
+		conn.Close()
+		b.setConnected(false, err)
+		if b.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (b *redisBroker) subscribe(conn *redisConn) error {
+	// 先订阅再读取现有人数，避免两者之间的变化丢失
+	if err := conn.send("SUBSCRIBE", redisChannel); err != nil {
+		return err
+	}
+	if _, err := conn.receive(); err != nil {
+		return err
+	}
+	if err := b.loadRemote(); err != nil {
+		return err
+	}
+	b.setConnected(true, nil)
+
+	for {
+		// 本实例每个心跳周期都会收到自己的快照，超时未收到任何消息视为连接失效
+		conn.conn.SetReadDeadline(time.Now().Add(clusterTTL))
+		reply, err := conn.receive()
+		if err != nil {
+			return err
+		}
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 3 || items[0] != "message" {
+			continue
+		}
+		payload, _ := items[2].(string)
+
+		var message clusterMessage
+		if err := json.Unmarshal([]byte(payload), &message); err != nil {
+			continue
+		}
+		b.apply(message)
+	}
+}
+
+// 读取 Redis 中其他实例已写入的人数
+func (b *redisBroker) loadRemote() error {
+	conn, err := dialRedis(b.ctx, b.options)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cursor := "0"
+	for {
+		reply, err := conn.do("SCAN", cursor, "MATCH", redisKeyPrefix+"*", "COUNT", "100")
+		if err != nil {
+			return err
+		}
+		items, _ := reply.([]interface{})
+		if len(items) != 2 {
+			return redisError("SCAN 回复格式错误")
+		}
+		cursor, _ = items[0].(string)
+
+		for _, key := range redisStrings(items[1]) {
+			instance := strings.TrimPrefix(key, redisKeyPrefix)
+			if instance == b.instance {
+				continue
+			}
+			reply, err := conn.do("HGETALL", key)
+			if err != nil {
+				return err
+			}
+			fields := redisStrings(reply)
+			counts := make(map[string]int)
+			for i := 0; i+1 < len(fields); i += 2 {
+				if n, err := strconv.Atoi(fields[i+1]); err == nil && n > 0 {
+					counts[fields[i]] = n
+				}
+			}
+			b.apply(clusterMessage{Type: "snapshot", Instance: instance, Counts: counts})
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 计划重启前向访客推送倒计时通知的时间点
+var restartNotices = flag.String("restart-notices", "10m,1m", "计划重启前推送倒计时通知的时间点，逗号分隔")
+
+// 计划重启到点后只排空连接、不退出进程，由外部编排系统负责重启
+var noExit = flag.Bool("no-exit", false, "计划重启到点后只排空连接，不退出进程")
+
+// 未指定时的重启通知内容
+const defaultRestartMessage = "服务器将进行维护重启"
+
+// 解析倒计时通知时间点，按从早到晚（距离重启从远到近）排序
+func parseRestartNotices(value string) ([]time.Duration, error) {
+	var notices []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("无效的重启通知时间点 %q", part)
+		}
+		notices = append(notices, d)
+	}
+	sort.Slice(notices, func(i, j int) bool { return notices[i] > notices[j] })
+	return notices, nil
+}
+
+// 计划重启请求体
+type restartRequest struct {
+	At      time.Time `json:"at"`
+	Message string    `json:"message"`
+}
+
+// 计划重启状态
+type RestartInfo struct {
+	At       time.Time `json:"at"`
+	Message  string    `json:"message"`
+	DrainAt  time.Time `json:"drainAt"`
+	Notices  []string  `json:"notices"`
+	Draining bool      `json:"draining"`
+}
+
+// 计划重启调度器，只保存在内存中，进程重启后不保留
+type restartScheduler struct {
+	mutex    sync.Mutex
+	current  *RestartInfo
+	stop     chan struct{}
+	draining bool
+
+	// 到点时通知主协程执行关闭流程
+	fire chan struct{}
+
+	// 进入排空时调用，用于关闭 HTTP keep-alive，提示负载均衡摘除实例
+	onDrain func(draining bool)
+
+	// 时间来源，测试中替换为虚拟时钟
+	clock restartClock
+}
+
+// 计划重启使用的时钟
+type restartClock interface {
+	Now() time.Time
+	// 等待到 t，stop 关闭时返回 false
+	WaitUntil(t time.Time, stop <-chan struct{}) bool
+}
+
+// 系统时钟
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) WaitUntil(t time.Time, stop <-chan struct{}) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// 计划重启调度器
+var restarts = &restartScheduler{fire: make(chan struct{}, 1), clock: systemClock{}}
+
+// 是否已进入计划重启的排空阶段
+func (s *restartScheduler) Draining() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.draining
+}
+
+// 当前计划，没有计划时返回 nil
+func (s *restartScheduler) Get() *RestartInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.current == nil {
+		return nil
+	}
+	info := *s.current
+	info.Draining = s.draining
+	return &info
+}
+
+// 设置计划，替换已有的计划
+func (s *restartScheduler) Schedule(at time.Time, message string, notices []time.Duration, budget time.Duration) *RestartInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cancelLocked()
+
+	now := s.clock.Now()
+	info := &RestartInfo{At: at, Message: message, DrainAt: at.Add(-budget)}
+	var pending []time.Duration
+	for _, before := range notices {
+		if at.Add(-before).After(now) {
+			pending = append(pending, before)
+			info.Notices = append(info.Notices, before.String())
+		}
+	}
+	s.current = info
+	s.stop = make(chan struct{})
+	go s.run(*info, pending, s.stop)
+	return info
+}
+
+// 取消计划，返回是否存在计划
+func (s *restartScheduler) Cancel() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	exists := s.current != nil
+	s.cancelLocked()
+	return exists
+}
+
+// 停止计划协程并撤销排空状态（调用方需持有锁）
+func (s *restartScheduler) cancelLocked() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.current = nil
+	if s.draining {
+		s.setDrainingLocked(false)
+	}
+}
+
+// 切换排空状态（调用方需持有锁）
+func (s *restartScheduler) setDrainingLocked(draining bool) {
+	s.draining = draining
+	if s.onDrain != nil {
+		s.onDrain(draining)
+	}
+}
+
+// 计划中的一个时间点：推送通知（before > 0）或进入排空（before == 0）
+type restartStep struct {
+	at     time.Time
+	before time.Duration
+}
+
+// 按时间顺序推送倒计时通知、进入排空，到点触发关闭
+func (s *restartScheduler) run(info RestartInfo, notices []time.Duration, stop chan struct{}) {
+	steps := []restartStep{{at: info.DrainAt}}
+	for _, before := range notices {
+		steps = append(steps, restartStep{at: info.At.Add(-before), before: before})
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].at.Before(steps[j].at) })
+
+	for _, step := range steps {
+		if !s.clock.WaitUntil(step.at, stop) {
+			return
+		}
+		if step.before > 0 {
+			hub.broadcastAll(Message{
+				Type:      "restart",
+				Message:   info.Message,
+				RestartAt: info.At.Unix(),
+				Timestamp: s.clock.Now().Unix(),
+			})
+			log.Printf("已推送重启通知，距计划重启 %v", step.before)
+			continue
+		}
+
+		s.mutex.Lock()
+		if s.stop != stop {
+			s.mutex.Unlock()
+			return
+		}
+		s.setDrainingLocked(true)
+		s.mutex.Unlock()
+		log.Printf("计划重启进入排空阶段，/readyz 返回 503")
+	}
+
+	if !s.clock.WaitUntil(info.At, stop) {
+		return
+	}
+	s.mutex.Lock()
+	if s.stop != stop {
+		s.mutex.Unlock()
+		return
+	}
+	s.current, s.stop = nil, nil
+	s.mutex.Unlock()
+
+	log.Printf("到达计划重启时间")
+	select {
+	case s.fire <- struct{}{}:
+	default:
+	}
+}
+
+// 等待关闭信号或计划重启到点
+//
+// 计划重启到点且指定了 -no-exit 时，只排空连接并保存状态，进程继续运行直到收到关闭信号。
+func waitForShutdown(quit <-chan os.Signal, fire <-chan struct{}) {
+	select {
+	case <-quit:
+	case <-fire:
+		if *noExit {
+			log.Println("计划重启：排空连接，不退出进程")
+			runShutdown([]shutdownStage{
+				{"排空 WebSocket 连接", hub.drain},
+				{"等待连接协程退出", hub.waitPumps},
+				{"保存 Hub 状态", func(ctx context.Context) error {
+					return hub.saveState(*stateFile)
+				}},
+			}, *shutdownTimeout)
+			<-quit
+		}
+	}
+}
+
+// 向所有站点的连接（含观察者）推送消息
+func (h *Hub) broadcastAll(message Message) {
+	h.mutex.RLock()
+	siteIDs := make([]string, 0, len(h.sites))
+	for id := range h.sites {
+		siteIDs = append(siteIDs, id)
+	}
+	h.mutex.RUnlock()
+
+	for _, siteID := range siteIDs {
+		message.SiteID = siteID
+		h.broadcastMessage(siteID, message)
+	}
+}
+
+// 处理计划重启请求：/admin/api/schedule-restart
+//
+// POST 设置（替换已有计划），GET 查看，DELETE 取消。
+func handleAdminScheduleRestart(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		info := restarts.Get()
+		if info == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+
+	case "POST":
+		var req restartRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.At.IsZero() {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if !req.At.After(restarts.clock.Now()) {
+			http.Error(w, "at must be in the future", http.StatusBadRequest)
+			return
+		}
+		message := strings.TrimSpace(req.Message)
+		if message == "" {
+			message = defaultRestartMessage
+		}
+
+		notices, _ := parseRestartNotices(*restartNotices)
+		info := restarts.Schedule(req.At, message, notices, *shutdownTimeout)
+		log.Printf("已计划于 %s 重启", req.At.Format(time.RFC3339))
+		writeJSON(w, http.StatusOK, info)
+
+	case "DELETE":
+		if !restarts.Cancel() {
+			http.NotFound(w, r)
+			return
+		}
+		log.Println("已取消计划重启")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
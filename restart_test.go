@@ -0,0 +1,268 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 由测试推进的虚拟时钟
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters map[chan struct{}]time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, waiters: make(map[chan struct{}]time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) WaitUntil(t time.Time, stop <-chan struct{}) bool {
+	c.mutex.Lock()
+	if !t.After(c.now) {
+		c.mutex.Unlock()
+		return true
+	}
+	wake := make(chan struct{})
+	c.waiters[wake] = t
+	c.mutex.Unlock()
+
+	select {
+	case <-wake:
+		return true
+	case <-stop:
+		c.mutex.Lock()
+		delete(c.waiters, wake)
+		c.mutex.Unlock()
+		return false
+	}
+}
+
+// 等待调度协程开始等待后，把时钟推进到 t
+func (c *fakeClock) advanceTo(t *testing.T, to time.Time) {
+	t.Helper()
+	eventually(t, "调度协程开始等待", func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return len(c.waiters) > 0
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = to
+	for wake, at := range c.waiters {
+		if !at.After(to) {
+			close(wake)
+			delete(c.waiters, wake)
+		}
+	}
+}
+
+// 使用虚拟时钟的调度器，以及一个观察全局 Hub 中站点推送的连接
+func newTestRestarts(t *testing.T, clock *fakeClock) (*restartScheduler, *Client, *[]bool) {
+	t.Helper()
+
+	previousHub := hub
+	hub = NewHub()
+	t.Cleanup(func() { hub = previousHub })
+	site, err := hub.getSite("a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer := &Client{hub: hub, site: site, send: make(chan Message, 16)}
+	site.Observers[viewer] = true
+
+	var drains []bool
+	s := &restartScheduler{fire: make(chan struct{}, 1), clock: clock}
+	s.onDrain = func(draining bool) { drains = append(drains, draining) }
+	t.Cleanup(func() { s.Cancel() })
+	return s, viewer, &drains
+}
+
+// 非阻塞读取已推送的消息
+func pendingMessages(client *Client) []Message {
+	var messages []Message
+	for {
+		select {
+		case message := <-client.send:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+// 完整倒计时：按时间点推送通知，到 At 减去排空时长时进入排空，到点触发关闭
+func TestRestartCountdown(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	s, viewer, drains := newTestRestarts(t, clock)
+
+	at := start.Add(15 * time.Minute)
+	info := s.Schedule(at, "维护", []time.Duration{10 * time.Minute, time.Minute}, 30*time.Second)
+	if len(info.Notices) != 2 || !info.DrainAt.Equal(at.Add(-30*time.Second)) {
+		t.Fatalf("计划为 %+v", info)
+	}
+
+	steps := []struct {
+		at       time.Time
+		notices  int
+		draining bool
+	}{
+		{at.Add(-10*time.Minute - time.Second), 0, false},
+		{at.Add(-10 * time.Minute), 1, false},
+		{at.Add(-time.Minute), 1, false},
+		{at.Add(-30 * time.Second), 0, true},
+	}
+	for _, step := range steps {
+		clock.advanceTo(t, step.at)
+		if step.draining {
+			eventually(t, "进入排空", s.Draining)
+		}
+		if step.notices > 0 {
+			eventually(t, "推送重启通知", func() bool { return len(viewer.send) == step.notices })
+		}
+		messages := pendingMessages(viewer)
+		if len(messages) != step.notices {
+			t.Fatalf("%v 推送了 %d 条通知，应为 %d", step.at.Sub(at), len(messages), step.notices)
+		}
+		for _, message := range messages {
+			if message.Type != "restart" || message.Message != "维护" || message.RestartAt != at.Unix() || message.SiteID != "a.example" {
+				t.Fatalf("重启通知为 %+v", message)
+			}
+		}
+		if s.Draining() != step.draining {
+			t.Fatalf("%v 时排空状态为 %v", step.at.Sub(at), s.Draining())
+		}
+	}
+	if len(*drains) != 1 || !(*drains)[0] {
+		t.Fatalf("排空回调为 %v，应只有一次 true", *drains)
+	}
+
+	select {
+	case <-s.fire:
+		t.Fatal("未到点就触发了关闭")
+	default:
+	}
+	clock.advanceTo(t, at)
+	select {
+	case <-s.fire:
+	case <-time.After(3 * time.Second):
+		t.Fatal("到点后没有触发关闭")
+	}
+	if s.Get() != nil {
+		t.Fatal("触发后仍保留计划")
+	}
+}
+
+// 已经过去的通知时间点不推送
+func TestRestartSkipsPastNotices(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	s, _, _ := newTestRestarts(t, clock)
+
+	info := s.Schedule(start.Add(5*time.Minute), "维护", []time.Duration{10 * time.Minute, time.Minute}, 30*time.Second)
+	if len(info.Notices) != 1 || info.Notices[0] != "1m0s" {
+		t.Fatalf("通知时间点为 %v，应只剩 1m0s", info.Notices)
+	}
+}
+
+// 排空后取消：撤销排空，不再推送通知，到点也不触发关闭
+func TestRestartCancel(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	s, viewer, drains := newTestRestarts(t, clock)
+
+	at := start.Add(15 * time.Minute)
+	s.Schedule(at, "维护", []time.Duration{10 * time.Second}, time.Minute)
+	clock.advanceTo(t, at.Add(-time.Minute))
+	eventually(t, "进入排空", s.Draining)
+
+	if !s.Cancel() {
+		t.Fatal("取消时没有计划")
+	}
+	if s.Draining() || s.Get() != nil {
+		t.Fatal("取消后仍在排空或保留计划")
+	}
+	if len(*drains) != 2 || (*drains)[1] {
+		t.Fatalf("排空回调为 %v，取消时应回调 false", *drains)
+	}
+	if s.Cancel() {
+		t.Fatal("重复取消时仍返回存在计划")
+	}
+
+	// 调度协程已退出，推进时钟不再推送或触发
+	clock.mutex.Lock()
+	clock.now = at.Add(time.Minute)
+	clock.mutex.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	if messages := pendingMessages(viewer); len(messages) != 0 {
+		t.Fatalf("取消后推送了 %v", messages)
+	}
+	select {
+	case <-s.fire:
+		t.Fatal("取消后仍触发了关闭")
+	default:
+	}
+}
+
+// -no-exit：计划重启到点只排空连接，进程继续等待关闭信号
+func TestRestartNoExit(t *testing.T) {
+	setFlag(t, "no-exit", "true")
+	h := startTestHub(t)
+	server := startTestServer(t)
+	client := dialTestClient(t, server, "")
+	client.join("a.example")
+
+	quit := make(chan os.Signal, 1)
+	fire := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		waitForShutdown(quit, fire)
+		close(done)
+	}()
+	fire <- struct{}{}
+
+	// 排空断开连接
+	for {
+		if _, err := client.read(3 * time.Second); err != nil {
+			break
+		}
+	}
+	eventually(t, "站点移出 Hub", func() bool { return siteCount(h, "a.example") == -1 })
+	select {
+	case <-done:
+		t.Fatal("-no-exit 时排空后就返回了")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	quit <- os.Interrupt
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("收到关闭信号后没有返回")
+	}
+}
+
+// 未指定 -no-exit 时到点直接返回，由调用方执行完整的关闭流程
+func TestRestartExit(t *testing.T) {
+	setFlag(t, "no-exit", "false")
+	h := startTestHub(t)
+	server := startTestServer(t)
+	client := dialTestClient(t, server, "")
+	client.join("a.example")
+
+	fire := make(chan struct{}, 1)
+	fire <- struct{}{}
+	waitForShutdown(make(chan os.Signal), fire)
+	if count := siteCount(h, "a.example"); count != 1 {
+		t.Fatalf("返回前在线人数为 %d，不应已排空", count)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 外部请求的重试策略
+//
+// 所有对外请求（认领校验、以及之后的 Webhook 等）都应通过 retry 发起，
+// 不要自行 time.Sleep 重试，以保证退避行为与指标一致。
+type retryPolicy struct {
+	// 指标与日志中使用的目标名称
+	Target string
+
+	MaxAttempts    int
+	MaxElapsed     time.Duration
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	AttemptTimeout time.Duration
+
+	// 判断错误是否值得重试，为 nil 时使用 retryableDefault
+	Retryable func(error) bool
+}
+
+// 对外请求被本地策略拒绝（如地址不在允许范围内），不会重试
+var errOutboundDenied = errors.New("不允许的外部地址")
+
+// 外部请求返回了非预期的状态码
+type outboundStatusError struct {
+	URL    string
+	Status int
+}
+
+func (e *outboundStatusError) Error() string {
+	return fmt.Sprintf("%s 返回 %d", e.URL, e.Status)
+}
+
+// 默认的重试判断：网络错误、429 与 5xx 重试，其他状态码与域名不存在不重试
+func retryableDefault(err error) bool {
+	var status *outboundStatusError
+	if errors.As(err, &status) {
+		return status.Status == 429 || status.Status >= 500
+	}
+	var dns *net.DNSError
+	if errors.As(err, &dns) && dns.IsNotFound {
+		return false
+	}
+	return true
+}
+
+// 重试结果
+const (
+	outcomeSuccess   = "success"
+	outcomeRetry     = "retry"
+	outcomeFailure   = "failure"
+	outcomeCancelled = "cancelled"
+)
+
+// 外部请求尝试次数，按目标与结果统计
+var outboundAttempts = struct {
+	counts map[[2]string]int64
+	mutex  sync.Mutex
+}{counts: make(map[[2]string]int64)}
+
+// 外部请求尝试次数的一条记录
+type outboundAttemptCount struct {
+	Target  string
+	Outcome string
+	Count   int64
+}
+
+// 记录一次尝试
+func recordOutboundAttempt(target, outcome string) {
+	outboundAttempts.mutex.Lock()
+	outboundAttempts.counts[[2]string{target, outcome}]++
+	outboundAttempts.mutex.Unlock()
+}
+
+// 列出外部请求尝试次数，按目标与结果排序
+func outboundAttemptCounts() []outboundAttemptCount {
+	outboundAttempts.mutex.Lock()
+	counts := make([]outboundAttemptCount, 0, len(outboundAttempts.counts))
+	for key, count := range outboundAttempts.counts {
+		counts = append(counts, outboundAttemptCount{Target: key[0], Outcome: key[1], Count: count})
+	}
+	outboundAttempts.mutex.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Target != counts[j].Target {
+			return counts[i].Target < counts[j].Target
+		}
+		return counts[i].Outcome < counts[j].Outcome
+	})
+	return counts
+}
+
+// 第 attempt 次失败后的等待时间：指数增长，上限 max，随机取后一半
+func backoffDelay(base, max time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// 错误是否值得重试
+func (p retryPolicy) retryable(err error) bool {
+	if errors.Is(err, errOutboundDenied) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return retryableDefault(err)
+}
+
+// 按策略执行 fn，直到成功、遇到不可重试的错误、次数或总时长用尽，或 ctx 取消
+func retry(ctx context.Context, policy retryPolicy, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.AttemptTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.AttemptTimeout)
+		}
+		err := fn(attemptCtx)
+		cancel()
+
+		if err == nil {
+			recordOutboundAttempt(policy.Target, outcomeSuccess)
+			return nil
+		}
+		if ctx.Err() != nil {
+			recordOutboundAttempt(policy.Target, outcomeCancelled)
+			return err
+		}
+
+		delay := backoffDelay(policy.BaseDelay, policy.MaxDelay, attempt)
+		exhausted := attempt >= policy.MaxAttempts ||
+			(policy.MaxElapsed > 0 && time.Since(start)+delay > policy.MaxElapsed)
+		if exhausted || !policy.retryable(err) {
+			recordOutboundAttempt(policy.Target, outcomeFailure)
+			log.Printf("外部请求 %s 失败，共尝试 %d 次: %v", policy.Target, attempt, err)
+			return err
+		}
+
+		recordOutboundAttempt(policy.Target, outcomeRetry)
+		log.Printf("外部请求 %s 第 %d 次失败，%v 后重试: %v", policy.Target, attempt, delay.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
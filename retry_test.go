@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 某个目标各结果的尝试次数
+func attemptsOf(target string) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, count := range outboundAttemptCounts() {
+		if count.Target == target {
+			counts[count.Outcome] = count.Count
+		}
+	}
+	return counts
+}
+
+// 第 n 次失败后的等待时间在 [d/2, d] 内，d 从 base 起每次翻倍，不超过 max
+func TestBackoffDelay(t *testing.T) {
+	base, max := time.Second, 30*time.Second
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}
+	for i, d := range want {
+		attempt := i + 1
+		for j := 0; j < 200; j++ {
+			delay := backoffDelay(base, max, attempt)
+			if delay < d/2 || delay > d {
+				t.Fatalf("第 %d 次失败后等待 %v，应在 [%v, %v] 内", attempt, delay, d/2, d)
+			}
+		}
+	}
+
+	// 次数很大时不会溢出
+	if delay := backoffDelay(base, max, 1000); delay < max/2 || delay > max {
+		t.Fatalf("第 1000 次失败后等待 %v", delay)
+	}
+	if delay := backoffDelay(0, max, 3); delay != 0 {
+		t.Fatalf("base 为 0 时等待 %v", delay)
+	}
+}
+
+// 可重试的错误按次数重试直到成功，各次尝试按结果计数
+func TestRetryUntilSuccess(t *testing.T) {
+	policy := retryPolicy{Target: "test-success", MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	calls := 0
+	err := retry(context.Background(), policy, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return &outboundStatusError{URL: "http://example.com", Status: 503}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("返回 %v，调用 %d 次，应成功且调用 3 次", err, calls)
+	}
+	if counts := attemptsOf(policy.Target); counts[outcomeRetry] != 2 || counts[outcomeSuccess] != 1 {
+		t.Fatalf("尝试计数为 %v", counts)
+	}
+}
+
+// 错误分类：网络错误、429 与 5xx 重试；其他状态码、域名不存在、被拒绝的地址与自定义判断不重试
+func TestRetryClassification(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable func(error) bool
+		calls     int
+	}{
+		{"5xx", &outboundStatusError{Status: 502}, nil, 3},
+		{"429", &outboundStatusError{Status: 429}, nil, 3},
+		{"网络错误", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, nil, 3},
+		{"404", &outboundStatusError{Status: 404}, nil, 1},
+		{"400", &outboundStatusError{Status: 400}, nil, 1},
+		{"域名不存在", &net.DNSError{Name: "missing.example", IsNotFound: true}, nil, 1},
+		{"DNS 超时", &net.DNSError{Name: "slow.example", IsTimeout: true}, nil, 3},
+		{"被拒绝的地址", errOutboundDenied, nil, 1},
+		{"包装后被拒绝的地址", &net.OpError{Op: "dial", Err: errOutboundDenied}, func(error) bool { return true }, 1},
+		{"自定义不重试", &outboundStatusError{Status: 503}, func(error) bool { return false }, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := retryPolicy{
+				Target:      "test-classify",
+				MaxAttempts: 3,
+				BaseDelay:   time.Millisecond,
+				MaxDelay:    time.Millisecond,
+				Retryable:   tt.retryable,
+			}
+			calls := 0
+			err := retry(context.Background(), policy, func(ctx context.Context) error {
+				calls++
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("返回 %v，应为最后一次的错误 %v", err, tt.err)
+			}
+			if calls != tt.calls {
+				t.Fatalf("调用 %d 次，应为 %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+// 总时长不够再等一次时提前放弃，不会超出 MaxElapsed
+func TestRetryMaxElapsed(t *testing.T) {
+	policy := retryPolicy{Target: "test-elapsed", MaxAttempts: 100, MaxElapsed: 50 * time.Millisecond, BaseDelay: 40 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+	start := time.Now()
+	calls := 0
+	retry(context.Background(), policy, func(ctx context.Context) error {
+		calls++
+		return &outboundStatusError{Status: 500}
+	})
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("用时 %v，超出 MaxElapsed 过多", elapsed)
+	}
+	if calls < 1 || calls > 3 {
+		t.Fatalf("调用 %d 次", calls)
+	}
+	if counts := attemptsOf(policy.Target); counts[outcomeFailure] != 1 {
+		t.Fatalf("尝试计数为 %v，应记录一次失败", counts)
+	}
+}
+
+// 退避等待期间取消立即返回，不再尝试
+func TestRetryCancelledWhileWaiting(t *testing.T) {
+	policy := retryPolicy{Target: "test-cancel-wait", MaxAttempts: 5, BaseDelay: 10 * time.Second, MaxDelay: 10 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- retry(ctx, policy, func(ctx context.Context) error {
+			calls++
+			return &outboundStatusError{Status: 503}
+		})
+	}()
+	eventually(t, "第一次失败", func() bool { return attemptsOf(policy.Target)[outcomeRetry] == 1 })
+	cancel()
+
+	select {
+	case err := <-done:
+		var status *outboundStatusError
+		if !errors.As(err, &status) {
+			t.Fatalf("返回 %v，应为最后一次的错误", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("取消后仍在等待")
+	}
+	if calls != 1 {
+		t.Fatalf("取消后又调用了 %d 次", calls-1)
+	}
+}
+
+// 尝试进行中取消：单次超时与外层取消都传给 fn，结果计为 cancelled
+func TestRetryCancelledDuringAttempt(t *testing.T) {
+	policy := retryPolicy{Target: "test-cancel-attempt", MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, AttemptTimeout: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	err := retry(ctx, policy, func(attemptCtx context.Context) error {
+		if _, ok := attemptCtx.Deadline(); !ok {
+			t.Error("单次尝试没有超时")
+		}
+		cancel()
+		<-attemptCtx.Done()
+		return attemptCtx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("返回 %v，应为 context.Canceled", err)
+	}
+	if counts := attemptsOf(policy.Target); counts[outcomeCancelled] != 1 || len(counts) != 1 {
+		t.Fatalf("尝试计数为 %v，应只有一次 cancelled", counts)
+	}
+}
+
+// 对外请求的重试必须通过 retry：非测试代码中不允许直接调用 time.Sleep
+//
+// capacity.go 是容量测试的压测端，按固定间隔轮询被测服务器，不是对外请求的重试。
+func TestNoRawSleep(t *testing.T) {
+	allowed := map[string]bool{"capacity.go": true}
+
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") || allowed[name] {
+			continue
+		}
+		src, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := parser.ParseFile(fset, name, src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			selector, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || selector.Sel.Name != "Sleep" {
+				return true
+			}
+			if pkg, ok := selector.X.(*ast.Ident); ok && pkg.Name == "time" {
+				t.Errorf("%s: 直接调用 time.Sleep，对外请求的重试应使用 retry", fset.Position(call.Pos()))
+			}
+			return true
+		})
+	}
+}
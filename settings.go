@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 站点配置
+type SiteSettings struct {
+	Meta      bool       `json:"meta,omitempty"`
+	Publish   bool       `json:"publish,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Key       string     `json:"key,omitempty"`
+	ClaimedAt *time.Time `json:"claimedAt,omitempty"`
+
+	// 轮换前的站点密钥，在迁移期间仍可通过校验；新签发的密钥只写入 Key。
+	// PreviousKeyExpiresAt 为迁移期限，之后旧密钥不再有效，未设置时直到移除前都有效
+	PreviousKey          string     `json:"previousKey,omitempty"`
+	PreviousKeyExpiresAt *time.Time `json:"previousKeyExpiresAt,omitempty"`
+
+	// 以下字段未设置时使用实例默认值，读取生效值请用 SettingsStore.Resolve
+	Private *bool `json:"private,omitempty"`
+
+	// 突发模式的加入速率阈值，0 使用默认值，负数关闭
+	BurstJoins int `json:"burstJoins,omitempty"`
+
+	// 人数去重方式：connection 或 ip
+	Dedup string `json:"dedup,omitempty"`
+
+	Exclusions []string `json:"exclusions,omitempty"`
+
+	// 客户端消息的限制，未设置的字段使用命令行参数
+	Limits *SiteLimits `json:"limits,omitempty"`
+
+	// 站点不在线后统计与人数历史的保留时长，如 "90d"，为空时不按时长清理
+	Retention string `json:"retention,omitempty"`
+
+	// 挂件配置，修改后推送给在线的挂件
+	Widget *WidgetSettings `json:"widget,omitempty"`
+
+	// 挂件脚本的最低版本，覆盖 -min-client-version
+	MinClientVersion string `json:"minClientVersion,omitempty"`
+
+	// 有人在线时对外显示的人数加上的基数，见 withBase
+	AddBase int `json:"addBase,omitempty"`
+}
+
+// 访客是否在排除列表中
+func (s SiteSettings) Excludes(visitorID string) bool {
+	if visitorID == "" {
+		return false
+	}
+	for _, excluded := range s.Exclusions {
+		if excluded == visitorID {
+			return true
+		}
+	}
+	return false
+}
+
+// 配置是否为空
+func (s SiteSettings) empty() bool {
+	return reflect.DeepEqual(s, SiteSettings{})
+}
+
+// 站点是否已过期
+func (s SiteSettings) Expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
+// 旧密钥是否已过迁移期限
+func (s SiteSettings) PreviousKeyExpired(now time.Time) bool {
+	return s.PreviousKeyExpiresAt != nil && !now.Before(*s.PreviousKeyExpiresAt)
+}
+
+// 站点分组
+type SiteGroup struct {
+	Name  string   `json:"group"`
+	Sites []string `json:"sites"`
+}
+
+// 配置文件结构
+type settingsFile struct {
+	Defaults *InstanceDefaults        `json:"defaults,omitempty"`
+	Sites    map[string]*SiteSettings `json:"sites"`
+	Groups   []SiteGroup              `json:"groups,omitempty"`
+	Alerts   []AlertRule              `json:"alerts,omitempty"`
+}
+
+// 站点配置存储
+type SettingsStore struct {
+	path     string
+	defaults InstanceDefaults
+	sites    map[string]*SiteSettings
+	groups   map[string][]string
+	alerts   []AlertRule
+	mutex    sync.RWMutex
+}
+
+// 全局配置存储
+var settings = &SettingsStore{
+	sites:  make(map[string]*SiteSettings),
+	groups: make(map[string][]string),
+}
+
+// 从文件加载配置，文件不存在时视为空配置
+func LoadSettings(path string) (*SettingsStore, error) {
+	store := &SettingsStore{
+		path:   path,
+		sites:  make(map[string]*SiteSettings),
+		groups: make(map[string][]string),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file settingsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Defaults != nil {
+		store.defaults = *file.Defaults
+	}
+	if err := checkDedupMode(store.defaults.Dedup); err != nil {
+		return nil, fmt.Errorf("实例默认值: %v", err)
+	}
+	for id, site := range file.Sites {
+		if site == nil {
+			continue
+		}
+		if err := checkDedupMode(site.Dedup); err != nil {
+			return nil, fmt.Errorf("站点 %s: %v", id, err)
+		}
+		if err := site.Limits.check(); err != nil {
+			return nil, fmt.Errorf("站点 %s: %v", id, err)
+		}
+		if site.Retention != "" {
+			if _, err := parseRetention(site.Retention); err != nil {
+				return nil, fmt.Errorf("站点 %s: retention: %v", id, err)
+			}
+		}
+		if err := site.Widget.check(); err != nil {
+			return nil, fmt.Errorf("站点 %s: %v", id, err)
+		}
+		if err := checkMinVersion(site.MinClientVersion); err != nil {
+			return nil, fmt.Errorf("站点 %s: minClientVersion: %v", id, err)
+		}
+		if site.AddBase < 0 {
+			return nil, fmt.Errorf("站点 %s: addBase 不能为负数", id)
+		}
+		store.sites[id] = site
+	}
+	for _, group := range file.Groups {
+		name := strings.TrimSpace(group.Name)
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("无效的分组名 %q", group.Name)
+		}
+		if _, exists := store.groups[name]; exists {
+			return nil, fmt.Errorf("分组 %q 重复定义", name)
+		}
+		sites, err := cleanGroupSites(group.Sites)
+		if err != nil {
+			return nil, fmt.Errorf("分组 %q: %v", name, err)
+		}
+		store.groups[name] = sites
+	}
+	if err := checkAlertRules(file.Alerts); err != nil {
+		return nil, err
+	}
+	store.alerts = file.Alerts
+
+	return store, nil
+}
+
+// 获取站点配置副本
+func (s *SettingsStore) Get(siteID string) SiteSettings {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if site, exists := s.sites[siteID]; exists {
+		return *site
+	}
+	return SiteSettings{}
+}
+
+// 配置中的全部站点
+func (s *SettingsStore) SiteIDs() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ids := make([]string, 0, len(s.sites))
+	for id := range s.sites {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// 配置中的站点数量
+func (s *SettingsStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.sites)
+}
+
+// 站点是否在配置中
+func (s *SettingsStore) Has(siteID string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, exists := s.sites[siteID]
+	return exists
+}
+
+// 列出设置了过期时间的站点
+func (s *SettingsStore) Expiring() map[string]time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	expiring := make(map[string]time.Time)
+	for id, site := range s.sites {
+		if site.ExpiresAt != nil {
+			expiring[id] = *site.ExpiresAt
+		}
+	}
+	return expiring
+}
+
+// 列出保留了旧密钥的站点
+func (s *SettingsStore) Rotating() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var rotating []string
+	for id, site := range s.sites {
+		if site.PreviousKey != "" {
+			rotating = append(rotating, id)
+		}
+	}
+	return rotating
+}
+
+// 列出设置了保留时长的站点
+func (s *SettingsStore) Retentions() map[string]time.Duration {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	retentions := make(map[string]time.Duration)
+	for id, site := range s.sites {
+		if retention, err := parseRetention(site.Retention); site.Retention != "" && err == nil {
+			retentions[id] = retention
+		}
+	}
+	return retentions
+}
+
+// 获取分组成员
+func (s *SettingsStore) Group(name string) ([]string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sites, exists := s.groups[name]
+	return sites, exists
+}
+
+// 列出全部分组，按名称排序
+func (s *SettingsStore) Groups() []SiteGroup {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.groupListLocked()
+}
+
+// 列出包含指定站点的分组
+func (s *SettingsStore) GroupsOf(siteID string) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var names []string
+	for name, sites := range s.groups {
+		for _, id := range sites {
+			if id == siteID {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// 设置分组成员并写回文件，sites 为空表示删除分组
+func (s *SettingsStore) SetGroup(name string, sites []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(sites) == 0 {
+		delete(s.groups, name)
+	} else {
+		s.groups[name] = sites
+	}
+	return s.save()
+}
+
+// 分组列表（调用方需持有锁）
+func (s *SettingsStore) groupListLocked() []SiteGroup {
+	groups := make([]SiteGroup, 0, len(s.groups))
+	for name, sites := range s.groups {
+		groups = append(groups, SiteGroup{Name: name, Sites: sites})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// 修改站点配置并写回文件
+func (s *SettingsStore) Update(siteID string, fn func(*SiteSettings)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	site, exists := s.sites[siteID]
+	if !exists {
+		site = &SiteSettings{}
+		s.sites[siteID] = site
+	}
+	previous := site.Widget.clientConfig()
+	fn(site)
+	if site.empty() {
+		delete(s.sites, siteID)
+	}
+	if !reflect.DeepEqual(previous, site.Widget.clientConfig()) {
+		queueConfigUpdate(siteID, previous, time.Now())
+	}
+
+	return s.save()
+}
+
+// 删除站点配置并将其移出所在分组，只剩该站点的分组一并删除；返回删除的条目
+func (s *SettingsStore) Erase(siteID string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var removed []string
+	if _, exists := s.sites[siteID]; exists {
+		delete(s.sites, siteID)
+		removed = append(removed, "settings")
+	}
+	for name, sites := range s.groups {
+		kept := make([]string, 0, len(sites))
+		for _, id := range sites {
+			if id != siteID {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == len(sites) {
+			continue
+		}
+		if len(kept) == 0 {
+			delete(s.groups, name)
+		} else {
+			s.groups[name] = kept
+		}
+		removed = append(removed, "group:"+name)
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	return removed, s.save()
+}
+
+// 告警规则副本
+func (s *SettingsStore) Alerts() []AlertRule {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]AlertRule(nil), s.alerts...)
+}
+
+// 原子写入配置文件（调用方需持有写锁）
+func (s *SettingsStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	file := settingsFile{Sites: s.sites, Groups: s.groupListLocked(), Alerts: s.alerts}
+	if s.defaults != (InstanceDefaults{}) {
+		defaults := s.defaults
+		file.Defaults = &defaults
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// 先写临时文件再重命名，避免写到一半的文件
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
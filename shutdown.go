@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// 关闭流程的总时长上限
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "关闭流程的总时长上限")
+
+	// 关闭时建议客户端等待多久再重连，给新进程留出启动时间
+	shutdownRetryAfter = flag.Duration("shutdown-retry-after", 5*time.Second, "关闭时在 shutdown 消息中建议挂件首次重连前等待的时长（retryAfter，按秒取整），0 表示不建议")
+)
+
+// 排空连接时检查 Hub 的间隔
+const drainPollInterval = 50 * time.Millisecond
+
+// 关闭阶段
+type shutdownStage struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// 按顺序执行关闭阶段，所有阶段共享同一个时长预算
+//
+// 顺序为：标记为未就绪 → 停止接受新连接 → 排空 WebSocket 连接 → 等待连接协程退出 → 停止 Hub → 保存 Hub 最终状态
+// → 投递剩余的 Webhook 通知 → 停止多实例同步。
+// 异步投递的组件（如 Webhook 与消息代理）必须提供 Close(ctx) 并在保存状态之后加入一个阶段：
+// 排空之前产生的事件仍能在预算内送达，预算用尽时中止投递而不是拖住进程退出。
+func runShutdown(stages []shutdownStage, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, stage := range stages {
+		start := time.Now()
+		err := stage.run(ctx)
+		elapsed := time.Since(start).Round(time.Millisecond)
+
+		switch {
+		case ctx.Err() != nil:
+			log.Printf("关闭阶段 [%s] 超时，用时 %v", stage.name, elapsed)
+		case err != nil:
+			log.Printf("关闭阶段 [%s] 失败，用时 %v: %v", stage.name, elapsed, err)
+		default:
+			log.Printf("关闭阶段 [%s] 完成，用时 %v", stage.name, elapsed)
+		}
+	}
+}
+
+// 关闭时发送给客户端的消息
+func shutdownMessage() Message {
+	return Message{
+		Type:       "shutdown",
+		Message:    "服务器重启中，请稍后重连",
+		RetryAfter: int(shutdownRetryAfter.Seconds()),
+		closeCode:  websocket.CloseGoingAway,
+		closeText:  "shutdown",
+	}
+}
+
+// 停止 Hub：拒绝新的加入，通知 Run 处理完已提交的命令、关闭所有客户端的发送队列后退出，
+// 并等待其返回。可以重复调用；Run 未启动时直接返回。
+func (h *Hub) Stop() {
+	h.markClosing()
+	h.stopOnce.Do(func() {
+		close(h.done)
+	})
+	if h.running.Load() {
+		<-h.stopped
+	}
+}
+
+// 处理停止前已提交的命令（在 Hub 协程中调用）：注销照常处理，保证人数与统计完整；
+// 加入与切换站点不再生效，直接关闭这些连接的发送队列
+func (h *Hub) drainCommands() {
+	for {
+		select {
+		case command := <-h.register:
+			h.dequeued(command)
+			command.client.closeSend()
+		case command := <-h.unregister:
+			h.dequeued(command)
+			h.handleUnregister(command.client)
+		case command := <-h.join:
+			h.dequeued(command)
+			command.join.client.closeSend()
+		case command := <-h.groupChanged:
+			h.dequeued(command)
+		case command := <-h.siteControl:
+			// 请求方在 Hub 停止后返回 ErrHubClosed
+			h.dequeued(command)
+		case command := <-h.beacon:
+			h.dequeued(command)
+		default:
+			return
+		}
+	}
+}
+
+// 关闭仍在站点中的客户端的发送队列，writePump 随之发送关闭帧后退出（在 Hub 协程中调用）
+func (h *Hub) closeAllSends() {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, site := range h.sites {
+		site.mutex.RLock()
+		for _, clients := range []map[*Client]bool{site.Connections, site.Observers} {
+			for client := range clients {
+				client.closeSend()
+			}
+		}
+		site.mutex.RUnlock()
+	}
+}
+
+// 标记 Hub 进入关闭流程：/readyz 返回 503，拒绝新连接，
+// SSE 请求随之结束，避免阻塞 server.Shutdown
+func (h *Hub) markClosing() {
+	if h.closed.CompareAndSwap(false, true) {
+		close(h.closing)
+	}
+}
+
+// 通知所有客户端即将关闭，并等待 Hub 中的连接全部离开
+func (h *Hub) drain(ctx context.Context) error {
+	h.markClosing()
+	h.dropPolls()
+	h.queueDropBeacons()
+	message := shutdownMessage()
+
+	h.mutex.RLock()
+	for _, site := range h.sites {
+		site.mutex.RLock()
+		for _, clients := range []map[*Client]bool{site.Connections, site.Observers} {
+			for client := range clients {
+				if !client.enqueue(message) {
+					client.disconnect()
+				}
+			}
+		}
+		site.mutex.RUnlock()
+	}
+	h.mutex.RUnlock()
+
+	h.feedMutex.RLock()
+	feeds := make([]*Feed, 0, len(h.feeds))
+	for feed := range h.feeds {
+		feeds = append(feeds, feed)
+	}
+	h.feedMutex.RUnlock()
+	for _, feed := range feeds {
+		h.unsubscribeFeed(feed)
+	}
+
+	h.adminFeedMutex.RLock()
+	adminFeeds := make([]*AdminFeed, 0, len(h.adminFeeds))
+	for feed := range h.adminFeeds {
+		adminFeeds = append(adminFeeds, feed)
+	}
+	h.adminFeedMutex.RUnlock()
+	for _, feed := range adminFeeds {
+		h.unsubscribeAdminFeed(feed)
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	// 正常的连接在一次慢写的时长内就能写完关闭帧，仍未离开的多半阻塞在拥塞的写入上，
+	// 直接关闭底层连接使其立即返回，不再等满 -write-timeout
+	force := time.NewTimer(slowWriteBudget() + closeWriteTimeout)
+	defer force.Stop()
+
+	for {
+		h.mutex.RLock()
+		remaining := len(h.sites)
+		h.mutex.RUnlock()
+		if remaining == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-force.C:
+			if n := h.disconnectAll(); n > 0 {
+				log.Printf("仍有 %d 个连接未完成关闭，强制断开", n)
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// 登记 WebSocket 连接的读写协程
+func (h *Hub) trackPumps(client *Client) {
+	h.wsMutex.Lock()
+	h.wsClients[client] = true
+	h.wsMutex.Unlock()
+	h.pumps.Add(2)
+}
+
+// 读协程退出时注销连接
+func (h *Hub) untrackPumps(client *Client) {
+	h.wsMutex.Lock()
+	delete(h.wsClients, client)
+	h.wsMutex.Unlock()
+	h.pumps.Done()
+}
+
+// 仍未退出的 WebSocket 连接
+func (h *Hub) trackedClients() []*Client {
+	h.wsMutex.Lock()
+	defer h.wsMutex.Unlock()
+
+	clients := make([]*Client, 0, len(h.wsClients))
+	for client := range h.wsClients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// 等待 WebSocket 连接与站长事件流的读写协程全部退出
+//
+// 排空后加入了站点的连接已收到关闭帧，读协程随对端关闭返回；尚未加入站点的连接
+// 在这里收到同样的关闭通知。等待 closeWriteTimeout 后仍未退出的连接直接断开。
+func (h *Hub) waitPumps(ctx context.Context) error {
+	// 已排空的连接发送队列已关闭，入队直接丢弃
+	for _, client := range h.trackedClients() {
+		client.enqueue(shutdownMessage())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.pumps.Wait()
+		close(done)
+	}()
+
+	force := time.NewTimer(closeWriteTimeout)
+	defer force.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-force.C:
+			clients := h.trackedClients()
+			for _, client := range clients {
+				client.disconnect()
+			}
+			if len(clients) > 0 {
+				log.Printf("仍有 %d 个 WebSocket 连接未退出，强制断开", len(clients))
+			}
+		}
+	}
+}
+
+// 关闭所有仍在站点中的连接，返回关闭的数量
+func (h *Hub) disconnectAll() int {
+	var clients []*Client
+	h.mutex.RLock()
+	for _, site := range h.sites {
+		site.mutex.RLock()
+		for _, set := range []map[*Client]bool{site.Connections, site.Observers} {
+			for client := range set {
+				clients = append(clients, client)
+			}
+		}
+		site.mutex.RUnlock()
+	}
+	h.mutex.RUnlock()
+
+	for _, client := range clients {
+		client.disconnect()
+	}
+	return len(clients)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// siteId 的最大长度（字节）
+const maxSiteIDLen = 128
+
+// 错误信息与日志中保留的 siteId 长度
+const siteIDEchoLen = 32
+
+// 默认允许的 siteId：域名或路径风格的字母、数字与 . - / : [ ]（由 Referer 推断的主机名可能带端口或 IPv6 地址）
+const defaultSiteIDPattern = `[A-Za-z0-9._:/\[\]-]+`
+
+// 允许的 siteId，需完整匹配
+var siteIDPatternFlag = flag.String("siteid-pattern", defaultSiteIDPattern, "允许的 siteId 正则表达式，需完整匹配；使用 UUID 等其他格式时可修改，如 [0-9a-f-]{36}")
+
+// 关闭 siteId 规范化
+var noNormalize = flag.Bool("no-normalize", false, "不规范化 siteId：大小写、www. 前缀、结尾的点与默认端口不同的 siteId 分别计数")
+
+// 所有站点按可注册域名计数
+var groupByRegistrableDomain = flag.Bool("group-by-registrable-domain", false, "所有站点按可注册域名（eTLD+1）计数，blog.example.com 与 docs.example.com 计入 example.com；未设置时可在嵌入脚本的 URL 中用 groupByRegistrableDomain=true 按站点开启")
+
+// 启动时编译的 siteId 规则
+var siteIDPattern = regexp.MustCompile("^(?:" + defaultSiteIDPattern + ")$")
+
+// 编译 -siteid-pattern，自动加上首尾锚点
+func compileSiteIDPattern(value string) (*regexp.Regexp, error) {
+	if value == "" {
+		return nil, fmt.Errorf("-siteid-pattern 不能为空")
+	}
+	pattern, err := regexp.Compile("^(?:" + value + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("无效的 -siteid-pattern: %v", err)
+	}
+	return pattern, nil
+}
+
+// 校验 siteId（调用方已去除首尾空白）
+//
+// 控制字符与无效的 UTF-8 无论 -siteid-pattern 如何设置都拒绝；分组 group:xxx 只检查长度，
+// 分组名在站点配置中定义，加入不存在的分组另行拒绝。
+func validateSiteID(siteID string) error {
+	if siteID == "" {
+		return &InvalidSiteIDError{Reason: "缺少 siteId"}
+	}
+	if len(siteID) > maxSiteIDLen {
+		return &InvalidSiteIDError{SiteID: truncateSiteID(siteID), Reason: fmt.Sprintf("长度超过 %d 字节", maxSiteIDLen)}
+	}
+	if !utf8.ValidString(siteID) {
+		return &InvalidSiteIDError{SiteID: truncateSiteID(siteID), Reason: "不是有效的 UTF-8"}
+	}
+	for _, r := range siteID {
+		if unicode.IsControl(r) {
+			return &InvalidSiteIDError{SiteID: truncateSiteID(siteID), Reason: "包含控制字符"}
+		}
+	}
+	if _, ok := groupName(siteID); ok {
+		return nil
+	}
+	if siteID == totalSiteID {
+		return &InvalidSiteIDError{SiteID: siteID, Reason: "为订阅全站总数的保留 siteId"}
+	}
+	if !siteIDPattern.MatchString(stripTenant(siteID)) {
+		return &InvalidSiteIDError{SiteID: truncateSiteID(siteID), Reason: "包含不允许的字符，需匹配 -siteid-pattern"}
+	}
+	return nil
+}
+
+// 截短 siteId，用于错误信息与日志
+func truncateSiteID(siteID string) string {
+	if len(siteID) <= siteIDEchoLen {
+		return siteID
+	}
+	cut := siteIDEchoLen
+	for cut > 0 && !utf8.RuneStart(siteID[cut]) {
+		cut--
+	}
+	return siteID[:cut] + "…"
+}
+
+// 规范化 siteId 的主机部分：转为小写，去掉 www. 前缀、结尾的点与默认端口 80/443，
+// 使 www.example.com、Example.com. 与 example.com:443 计入同一站点；路径部分与分组不变
+//
+// 必须在 getSite 之前调用，同一站点的连接不会分散在多个键下。-no-normalize 时原样返回。
+func normalizeSiteID(siteID string) string {
+	if *noNormalize {
+		return siteID
+	}
+	if _, ok := groupName(siteID); ok {
+		return siteID
+	}
+
+	host, path := siteID, ""
+	if i := strings.IndexByte(siteID, '/'); i >= 0 {
+		host, path = siteID[:i], siteID[i:]
+	}
+	host = strings.ToLower(host)
+	port := ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	host = strings.TrimSuffix(host, ".")
+	// 只去掉后面还有域名的 www.，www.com 保持不变
+	if rest, ok := strings.CutPrefix(host, "www."); ok && strings.Contains(rest, ".") {
+		host = rest
+	}
+	if host == "" {
+		return siteID
+	}
+	if port != "" && port != "80" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if port != "" && strings.Contains(host, ":") {
+		// 去掉默认端口的 IPv6 地址保留方括号
+		host = "[" + host + "]"
+	}
+	return host + path
+}
+
+// 把 siteId 的主机部分收拢为可注册域名（eTLD+1），如 blog.example.com 与 example.co.uk 的子域名
+// 分别计入 example.com 与 example.co.uk；端口与路径部分保留
+//
+// IP 地址、localhost、不含点的主机名、分组以及本身就是公共后缀的主机名原样返回。
+func registrableSiteID(siteID string) string {
+	if _, ok := groupName(siteID); ok {
+		return siteID
+	}
+
+	host, path := siteID, ""
+	if i := strings.IndexByte(siteID, '/'); i >= 0 {
+		host, path = siteID[:i], siteID[i:]
+	}
+	port := ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	if net.ParseIP(host) != nil || strings.EqualFold(host, "localhost") || !strings.Contains(host, ".") {
+		return siteID
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return siteID
+	}
+	if port != "" {
+		domain = net.JoinHostPort(domain, port)
+	}
+	return domain + path
+}
+
+// 计数使用的 siteId：规范化，-group-by-registrable-domain 时再收拢为可注册域名
+//
+// 加入与按 siteId 查询的接口都经过这里，查询子域名得到的是合并后的人数。
+func resolveSiteID(siteID string) string {
+	siteID = normalizeSiteID(siteID)
+	if *groupByRegistrableDomain {
+		siteID = registrableSiteID(siteID)
+	}
+	return siteID
+}
+
+// 站点配置中未规范化的站点在加入时对应不到，启动与重新加载时提示
+func warnUnnormalizedSites() {
+	for _, siteID := range settings.SiteIDs() {
+		if normalized := normalizeSiteID(siteID); normalized != siteID {
+			log.Printf("警告: 站点配置中的 %s 会被规范化为 %s，请改用规范化后的名称，否则该配置对加入的连接不生效（或使用 -no-normalize）", siteID, normalized)
+		}
+	}
+}
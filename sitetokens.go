@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 站点加入令牌文件，为空时所有站点都可以直接加入
+var siteTokensFile = flag.String("site-tokens-file", "", "站点加入令牌文件（JSON，siteId 到令牌的映射）；列出的站点加入时须携带匹配的 token，SIGHUP 重新读取")
+
+// 当前的站点加入令牌，按规范化后的 siteId 索引
+var siteTokens = struct {
+	mutex  sync.RWMutex
+	tokens map[string]string
+}{}
+
+// 读取 -site-tokens-file，未配置时返回空映射
+func loadSiteTokens() (map[string]string, error) {
+	tokens := make(map[string]string)
+	if *siteTokensFile == "" {
+		return tokens, nil
+	}
+	data, err := os.ReadFile(*siteTokensFile)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("站点加入令牌: %v", err)
+	}
+	for siteID, token := range raw {
+		if token == "" {
+			return nil, fmt.Errorf("站点加入令牌: %s 的令牌为空", siteID)
+		}
+		tokens[resolveSiteID(strings.TrimSpace(siteID))] = token
+	}
+	return tokens, nil
+}
+
+// 替换当前的站点加入令牌
+func setSiteTokens(tokens map[string]string) {
+	siteTokens.mutex.Lock()
+	defer siteTokens.mutex.Unlock()
+	siteTokens.tokens = tokens
+}
+
+// 检查加入令牌：令牌文件中没有的站点不需要令牌，其余站点的令牌不匹配时返回 ErrJoinUnauthorized
+func checkSiteToken(siteID, token string) error {
+	siteTokens.mutex.RLock()
+	expected, required := siteTokens.tokens[siteID]
+	siteTokens.mutex.RUnlock()
+	if !required || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+		return nil
+	}
+	return ErrJoinUnauthorized
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SSE 心跳间隔，防止代理断开空闲连接
+const sseHeartbeat = 30 * time.Second
+
+// 处理 SSE 订阅：GET /sse?siteId=example.com
+//
+// 供无法使用 WebSocket 的网络环境使用。订阅者与 WebSocket 客户端一样计入在线人数，
+// 连接时立即收到当前人数，请求结束时注销。
+func handleSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !originAllowed(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	params := r.URL.Query()
+	origin := r.Header.Get("Origin")
+
+	rawSiteID := params.Get("siteId")
+	if strings.TrimSpace(rawSiteID) == "" {
+		writeError(w, &InvalidSiteIDError{Reason: "缺少 siteId"})
+		return
+	}
+	siteID := joinSiteID(rawSiteID, origin, transportSSE)
+	if multiTenant() {
+		tenant, ok := tenantForKey(params.Get("apiKey"))
+		if !ok {
+			writeError(w, ErrTenantUnauthorized)
+			return
+		}
+		siteID = tenantSiteID(tenant.Name, siteID)
+	}
+	originHost := requestOriginHost(r)
+	err := checkBlocklist(getRealIP(r))
+	if err == nil {
+		err = hub.checkJoin(siteID)
+	}
+	if err == nil {
+		err = hub.checkOrigin(originHost, siteID, params.Get("adminToken"))
+	}
+	if err == nil {
+		err = checkSiteToken(siteID, params.Get("token"))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	bot, err := classifyBot(r, transportSSE)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_, isGroup := groupName(siteID)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	client := &Client{
+		hub:         hub,
+		send:        make(chan Message, 16),
+		id:          nextClientID.Add(1),
+		ip:          getRealIP(r),
+		origin:      origin,
+		originHost:  originHost,
+		connectedAt: time.Now(),
+		visitorID:   validVisitorID(params.Get("visitorId")),
+		clientID:    validVisitorID(params.Get("clientId")),
+		version:     validVersion(params.Get("version")),
+		path:        normalizePath(params.Get("path")),
+		device:      deviceClass(r.UserAgent()),
+		bot:         bot,
+		referrer:    referrerHost(getParam(params, "referrer", r.Referer())),
+		kind:        newConnKind(transportSSE),
+		cancel:      cancel,
+	}
+	recordTransport(client.kind)
+	if checkDedupMode(params.Get("dedup")) == nil {
+		client.dedup = params.Get("dedup")
+	}
+	client.observer = params.Get("observe") == "true" || isGroup || client.bot || settings.Get(siteID).Excludes(client.visitorID)
+	site, err := hub.getSite(siteID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	client.site = site
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		// 站点已为这次订阅创建，交由 Hub 协程移出，避免空站点一直占用 -max-sites 名额
+		hub.queueRelease(site)
+		return
+	}
+
+	hub.queueRegister(client)
+	defer func() {
+		hub.queueUnregister(client, siteID)
+	}()
+
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
+	// 写入一条消息，返回是否应结束请求
+	write := func(message Message) bool {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return false
+		}
+		rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return true
+		}
+		// 与 WebSocket 关闭码一致，客户端据此决定是否重连
+		if message.closeCode != 0 {
+			fmt.Fprintf(w, "event: close\ndata: {\"code\":%d,\"reason\":%q}\n\n", message.closeCode, message.closeText)
+			rc.Flush()
+			return true
+		}
+		return rc.Flush() != nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hub.closing:
+			write(shutdownMessage())
+			return
+
+		case message, ok := <-client.send:
+			if !ok || write(message) {
+				return
+			}
+
+		case <-ticker.C:
+			rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// 状态文件路径与写入间隔
+var stateFile = flag.String("state-file", "", "状态文件路径（JSON），用于跨重启保留累计计数与站点统计")
+var stateFlushInterval = flag.Duration("state-flush-interval", time.Minute, "状态文件写入间隔")
+
+// 当前状态文件格式版本
+const stateVersion = 1
+
+// 状态文件结构
+type stateSnapshot struct {
+	Version  int           `json:"version"`
+	SavedAt  time.Time     `json:"savedAt"`
+	Counters CounterValues `json:"counters"`
+
+	// 站点统计（峰值、独立访客、人数历史），不含在线连接
+	Sites map[string]*siteState `json:"sites,omitempty"`
+
+	// 清除数据后禁止重建的站点及截止时间
+	Blocked map[string]time.Time `json:"blocked,omitempty"`
+}
+
+// 单个站点的统计
+type siteState struct {
+	Peak    *peakState    `json:"peak,omitempty"`
+	Uniques *uniquesState `json:"uniques,omitempty"`
+	History *historyState `json:"history,omitempty"`
+	Daily   []*DailyStats `json:"daily,omitempty"`
+}
+
+type peakState struct {
+	PeakStats
+	Day string `json:"day"`
+}
+
+// HyperLogLog 寄存器以 base64 保存
+type uniquesState struct {
+	Day       string `json:"day"`
+	Today     []byte `json:"today"`
+	Yesterday []byte `json:"yesterday,omitempty"`
+}
+
+type historyState struct {
+	Points     []HistoryPoint `json:"points"`
+	LastActive time.Time      `json:"lastActive"`
+}
+
+// 取站点条目，不存在时创建
+func siteStateOf(sites map[string]*siteState, siteID string) *siteState {
+	state, exists := sites[siteID]
+	if !exists {
+		state = &siteState{}
+		sites[siteID] = state
+	}
+	return state
+}
+
+// 汇总各站点的统计
+func (h *Hub) snapshotSites() map[string]*siteState {
+	sites := make(map[string]*siteState)
+
+	h.peakMutex.Lock()
+	for siteID, peak := range h.peaks {
+		siteStateOf(sites, siteID).Peak = &peakState{PeakStats: peak, Day: peak.day}
+	}
+	h.peakMutex.Unlock()
+
+	h.uniquesMutex.Lock()
+	for siteID, stats := range h.uniques {
+		state := &uniquesState{Day: stats.day, Today: append([]byte{}, stats.today[:]...)}
+		if stats.yesterday != nil {
+			state.Yesterday = append([]byte{}, stats.yesterday[:]...)
+		}
+		siteStateOf(sites, siteID).Uniques = state
+	}
+	h.uniquesMutex.Unlock()
+
+	h.historyMutex.Lock()
+	for siteID, ring := range h.history {
+		siteStateOf(sites, siteID).History = &historyState{Points: ring.since(0), LastActive: ring.lastActive}
+	}
+	h.historyMutex.Unlock()
+
+	h.dailyMutex.Lock()
+	for siteID, rows := range h.daily {
+		copied := make([]*DailyStats, len(rows))
+		for i, row := range rows {
+			r := *row
+			copied[i] = &r
+		}
+		siteStateOf(sites, siteID).Daily = copied
+	}
+	h.dailyMutex.Unlock()
+
+	return sites
+}
+
+// 恢复各站点的统计；寄存器长度不符的独立访客数据丢弃
+func (h *Hub) restoreSites(sites map[string]*siteState) {
+	for siteID, state := range sites {
+		if state.Peak != nil {
+			peak := state.Peak.PeakStats
+			peak.day = state.Peak.Day
+			h.peaks[siteID] = peak
+		}
+
+		if state.Uniques != nil && len(state.Uniques.Today) == hllRegisters {
+			stats := &uniqueStats{day: state.Uniques.Day}
+			copy(stats.today[:], state.Uniques.Today)
+			if len(state.Uniques.Yesterday) == hllRegisters {
+				stats.yesterday = &hyperLogLog{}
+				copy(stats.yesterday[:], state.Uniques.Yesterday)
+			}
+			h.uniques[siteID] = stats
+		}
+
+		// 采样数调小后只保留最近的点
+		if state.History != nil && len(state.History.Points) > 0 {
+			ring := &historyRing{lastActive: state.History.LastActive}
+			points := state.History.Points
+			if len(points) > *historySamples {
+				points = points[len(points)-*historySamples:]
+			}
+			for _, point := range points {
+				ring.add(point)
+			}
+			h.history[siteID] = ring
+		}
+
+		// 保留天数调小后只保留最近的行
+		if rows := state.Daily; len(rows) > 0 && *dailyDays > 0 {
+			if len(rows) > *dailyDays {
+				rows = rows[len(rows)-*dailyDays:]
+			}
+			h.daily[siteID] = rows
+		}
+	}
+}
+
+// 从状态文件恢复累计计数与站点统计，文件不存在时视为首次启动；
+// 文件损坏时记录日志并忽略，以空状态启动
+func (h *Hub) loadState(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		log.Printf("状态文件 %s 已损坏，忽略并以空状态启动: %v", path, err)
+		return nil
+	}
+	if snapshot.Version > stateVersion {
+		return fmt.Errorf("状态文件版本 %d 高于当前支持的版本 %d", snapshot.Version, stateVersion)
+	}
+
+	if err := h.counters.restore(snapshot.Counters); err != nil {
+		return err
+	}
+	h.restoreSites(snapshot.Sites)
+	restoreBlocked(snapshot.Blocked, time.Now())
+	log.Printf("已从状态文件恢复累计计数与 %d 个站点的统计（保存于 %s）", len(snapshot.Sites), snapshot.SavedAt.Format(time.RFC3339))
+	return nil
+}
+
+// 写入状态文件
+func (h *Hub) saveState(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(stateSnapshot{
+		Version:  stateVersion,
+		SavedAt:  time.Now(),
+		Counters: h.counters.Lifetime(),
+		Sites:    h.snapshotSites(),
+		Blocked:  blockedSites(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// 定期写入状态文件
+func (h *Hub) runStateFlusher(path string, interval time.Duration) {
+	if path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := h.saveState(path); err != nil {
+			log.Printf("写入状态文件失败: %v", err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"sync/atomic"
+	"time"
+)
+
+// 站点清理后统计数据的保留时长
+var statsTTL = flag.Duration("stats-ttl", 10*time.Minute, "站点无人在线后统计数据的保留时长")
+
+// 站点统计，站点从 Hub 移除后仍按 TTL 保留
+type SiteStats struct {
+	Seq      int64
+	LastSeen time.Time
+	Trend    trendWindow
+
+	// 序号变化时关闭，唤醒等待中的条件请求
+	changed chan struct{}
+}
+
+// 递增序号并唤醒等待者（调用方需持有 Hub 写锁）
+func (s *SiteStats) advanceLocked(now time.Time) int64 {
+	s.Seq++
+	s.LastSeen = now
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+	return s.Seq
+}
+
+// 人数变化时递增站点序号
+func (h *Hub) bumpSeq(siteID string) int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.statsLocked(siteID).advanceLocked(time.Now())
+}
+
+// 读取当前序号，并返回序号变化时会被关闭的通道
+func (h *Hub) watchSeq(siteID string) (int64, <-chan struct{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stats, exists := h.stats[siteID]
+	if !exists {
+		stats = &SiteStats{LastSeen: time.Now()}
+		h.stats[siteID] = stats
+	}
+	if stats.changed == nil {
+		stats.changed = make(chan struct{})
+	}
+	return stats.Seq, stats.changed
+}
+
+// 获取或创建站点统计（调用方需持有写锁）
+func (h *Hub) statsLocked(siteID string) *SiteStats {
+	stats, exists := h.stats[siteID]
+	if !exists {
+		stats = &SiteStats{}
+		h.stats[siteID] = stats
+	}
+	return stats
+}
+
+// 读取站点当前人数与序号
+func (h *Hub) countAndSeq(siteID string) (int, int64) {
+	h.mutex.RLock()
+	site, live := h.sites[siteID]
+	var seq int64
+	if stats, exists := h.stats[siteID]; exists {
+		seq = stats.Seq
+	}
+	h.mutex.RUnlock()
+
+	if !live {
+		return globalCount(siteID, 0), seq
+	}
+
+	site.mutex.RLock()
+	defer site.mutex.RUnlock()
+	return globalCount(siteID, site.Count), seq
+}
+
+// 清理超过保留时长的统计数据
+func (h *Hub) sweepStats(now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for siteID, stats := range h.stats {
+		if _, live := h.sites[siteID]; live {
+			continue
+		}
+		if now.Sub(stats.LastSeen) > *statsTTL {
+			delete(h.stats, siteID)
+		}
+	}
+}
+
+// 累计计数器的值
+type CounterValues struct {
+	Joins         int64 `json:"joins"`
+	Unregistered  int64 `json:"unregistered"`
+	Broadcasts    int64 `json:"broadcasts"`
+	DroppedFrames int64 `json:"droppedFrames"`
+}
+
+// 累计计数器，base 为启动时从状态文件恢复的值
+type hubCounters struct {
+	joins         atomic.Int64
+	unregistered  atomic.Int64
+	broadcasts    atomic.Int64
+	droppedFrames atomic.Int64
+
+	base     CounterValues
+	restored atomic.Bool
+}
+
+// 本次进程启动以来的计数
+func (c *hubCounters) SinceStart() CounterValues {
+	return CounterValues{
+		Joins:         c.joins.Load(),
+		Unregistered:  c.unregistered.Load(),
+		Broadcasts:    c.broadcasts.Load(),
+		DroppedFrames: c.droppedFrames.Load(),
+	}
+}
+
+// 恢复值加上本次进程的计数
+func (c *hubCounters) Lifetime() CounterValues {
+	current := c.SinceStart()
+	return CounterValues{
+		Joins:         c.base.Joins + current.Joins,
+		Unregistered:  c.base.Unregistered + current.Unregistered,
+		Broadcasts:    c.base.Broadcasts + current.Broadcasts,
+		DroppedFrames: c.base.DroppedFrames + current.DroppedFrames,
+	}
+}
+
+// 恢复上次保存的累计值，只允许在 Hub 启动前调用一次
+func (c *hubCounters) restore(values CounterValues) error {
+	if !c.restored.CompareAndSwap(false, true) {
+		return errors.New("计数器已恢复过")
+	}
+	c.base = values
+	return nil
+}
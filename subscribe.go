@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+)
+
+// 单个连接通过 siteIds 同时订阅的站点数上限
+var maxSubscriptions = flag.Int("max-subscriptions", 20, "单个 WebSocket 连接通过 join 的 siteIds 同时订阅的站点数上限，订阅的站点只接收人数、不计入在线")
+
+// siteIds 在消息中占用的字节数，不计入 maxMessageBytes；数量超过 limit 时返回 0，按原大小校验
+func siteIDsBytes(siteIDs []string, limit int) int {
+	if len(siteIDs) > limit {
+		return 0
+	}
+	n := 0
+	for _, siteID := range siteIDs {
+		n += len(siteID) + 3
+	}
+	return n
+}
+
+// 校验 join 中的 siteIds 后提交订阅（在 readPump 中调用）
+//
+// 无法加入的站点各回复一条 error 后跳过，连接不会断开；订阅替换为其余有效的站点，
+// 空数组取消全部订阅。
+func (c *Client) readSubscribe(siteIDs []string, adminToken, version string) {
+	if len(siteIDs) > *maxSubscriptions {
+		err := &LimitError{Code: "too_many_subscriptions", Limit: "maxSubscriptions", Max: *maxSubscriptions}
+		c.enqueue(Message{Type: "error", Code: errorCode(err), Message: err.Error()})
+		return
+	}
+
+	seen := make(map[string]bool, len(siteIDs))
+	valid := make([]string, 0, len(siteIDs))
+	for _, raw := range siteIDs {
+		siteID := c.scopeSiteID(joinSiteID(raw, c.origin, transportWebSocket))
+		if seen[siteID] {
+			continue
+		}
+		seen[siteID] = true
+
+		err := c.hub.checkJoin(siteID)
+		if err == nil {
+			err = c.hub.checkOrigin(c.originHost, siteID, adminToken)
+		}
+		if err != nil {
+			c.enqueueError(siteID, err)
+			continue
+		}
+		valid = append(valid, siteID)
+	}
+	c.hub.queueSubscribe(siteJoin{client: c, subscribe: valid, version: validVersion(version)})
+}
+
+// 把客户端的订阅替换为 siteIDs（在 Hub 协程中调用）
+//
+// 不再订阅的站点先移出，新订阅的站点回复 subscribed 后各补发一次当前人数。
+// 连接计入人数的站点（siteId）已能收到人数，不重复订阅。
+func (h *Hub) handleSubscribe(client *Client, siteIDs []string, version string) {
+	if client.site == nil && version != "" {
+		client.version = version
+	}
+	wanted := make(map[string]bool, len(siteIDs))
+	for _, siteID := range siteIDs {
+		wanted[siteID] = true
+	}
+	for siteID, site := range client.subscriptions {
+		if !wanted[siteID] {
+			h.unsubscribe(client, site)
+		}
+	}
+
+	var added []*Site
+	current := make([]string, 0, len(siteIDs))
+	for _, siteID := range siteIDs {
+		if client.site != nil && client.site.ID == siteID {
+			continue
+		}
+		if _, subscribed := client.subscriptions[siteID]; subscribed {
+			current = append(current, siteID)
+			continue
+		}
+		site, err := h.subscribe(client, siteID)
+		if err != nil {
+			message := errorMessage(siteID, err)
+			message.closeCode, message.closeText = 0, ""
+			if !client.enqueue(message) {
+				h.counters.droppedFrames.Add(1)
+			}
+			continue
+		}
+		added = append(added, site)
+		current = append(current, siteID)
+	}
+
+	if !client.enqueue(Message{Type: "subscribed", SiteIDs: current}) {
+		h.counters.droppedFrames.Add(1)
+	}
+	for _, site := range added {
+		h.welcomeSite(client, site)
+	}
+	if len(added) > 0 {
+		log.Printf("客户端 %s 订阅 %d 个站点，新增 %d 个", client.ip, len(current), len(added))
+	}
+}
+
+// 以观察者身份把客户端登记到站点，站点连接数已满或站点数已达上限时返回错误
+func (h *Hub) subscribe(client *Client, siteID string) (*Site, error) {
+	site, err := h.getSite(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	site.mutex.Lock()
+	if *maxPerSite > 0 && len(site.Connections)+len(site.Observers) >= *maxPerSite {
+		site.mutex.Unlock()
+		return nil, ErrSiteAtCapacity
+	}
+	site.Observers[client] = true
+	site.mutex.Unlock()
+
+	if client.subscriptions == nil {
+		client.subscriptions = make(map[string]*Site)
+	}
+	client.subscriptions[siteID] = site
+	return site, nil
+}
+
+// 取消客户端对站点的订阅，站点随之清空时移出 Hub
+//
+// 订阅不计入人数，也不推送加入与离开事件。
+func (h *Hub) unsubscribe(client *Client, site *Site) {
+	delete(client.subscriptions, site.ID)
+
+	site.mutex.Lock()
+	if _, observing := site.Observers[client]; !observing {
+		// 站点已过期或被清除，连接已不在其中
+		site.mutex.Unlock()
+		return
+	}
+	delete(site.Observers, client)
+	empty := len(site.Connections) == 0 && len(site.Observers) == 0 && len(site.beacons) == 0
+	site.mutex.Unlock()
+
+	if empty && h.removeSite(site) {
+		h.publishOffline(site.ID, time.Now())
+	}
+}
+
+// 取消客户端的全部订阅，连接断开时调用（在 Hub 协程中调用）
+func (h *Hub) unsubscribeAll(client *Client) {
+	for _, site := range client.subscriptions {
+		h.unsubscribe(client, site)
+	}
+}
+
+// 处理 unjoin：取消一个订阅，或离开计入人数的站点，连接保持（在 Hub 协程中调用）
+func (h *Hub) handleUnjoin(client *Client, siteID string) {
+	if site, subscribed := client.subscriptions[siteID]; subscribed {
+		h.unsubscribe(client, site)
+	} else if client.site != nil && client.site.ID == siteID {
+		if !h.leaveSite(client, false) {
+			return
+		}
+	} else {
+		return
+	}
+	if !client.enqueue(Message{Type: "unjoined", SiteID: siteID}) {
+		h.counters.droppedFrames.Add(1)
+	}
+}
+
+// 将空站点移出 Hub，站点已被替换或移除时返回 false
+func (h *Hub) removeSite(site *Site) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.sites[site.ID] != site {
+		return false
+	}
+	delete(h.sites, site.ID)
+	return true
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+// 读取更新直到站点人数为 count
+func (c *testClient) expectCount(siteID string, count int) {
+	c.t.Helper()
+	for {
+		message := c.expect("update")
+		if message.SiteID == siteID && message.Count == count {
+			return
+		}
+	}
+}
+
+// 单个连接从 A 切换到 B：A 变为 0、B 变为 1，两个站点都推送新人数，人数不丢失也不重复
+func TestSwitchSite(t *testing.T) {
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	watchers := map[string]*testClient{}
+	for _, siteID := range []string{"a.example", "b.example"} {
+		watchers[siteID] = dialTestClient(t, server, "")
+		watchers[siteID].send(Message{Type: "join", SiteID: siteID, Observe: true, Version: scriptVersion})
+		watchers[siteID].expect("joined")
+	}
+
+	client := dialTestClient(t, server, "")
+	client.join("a.example")
+	watchers["a.example"].expectCount("a.example", 1)
+	expectCounts(t, h, "加入 A", map[string]int{"a.example": 1, "b.example": 0})
+
+	client.join("b.example")
+	watchers["a.example"].expectCount("a.example", 0)
+	watchers["b.example"].expectCount("b.example", 1)
+	expectCounts(t, h, "切换到 B", map[string]int{"a.example": 0, "b.example": 1})
+	if joins, unregistered := h.counters.joins.Load(), h.counters.unregistered.Load(); joins != 4 || unregistered != 1 {
+		t.Fatalf("加入 %d 次、离开 %d 次，切换应只离开 A 一次、加入 B 一次", joins, unregistered)
+	}
+	if total := h.total.Load(); total != 1 {
+		t.Fatalf("总连接数为 %d，应为 1", total)
+	}
+
+	client.close()
+	watchers["b.example"].expectCount("b.example", 0)
+	expectCounts(t, h, "离开 B", map[string]int{"a.example": 0, "b.example": 0})
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 租户文件，为空时不启用多租户模式
+var tenantsFile = flag.String("tenants-file", "", "租户文件（JSON，API 密钥到租户的映射）；设置后挂件与加入都需要 apiKey，siteId 按租户隔离，SIGHUP 重新读取")
+
+// 租户名只用小写字母、数字与连字符，不含点，不会与主机名混淆
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// 租户及其限制，0 表示不限制
+type Tenant struct {
+	Name           string `json:"name"`
+	MaxSites       int    `json:"maxSites,omitempty"`
+	MaxConnections int    `json:"maxConnections,omitempty"`
+}
+
+// 当前的租户，按 API 密钥与租户名索引
+var tenants = struct {
+	mutex  sync.RWMutex
+	byKey  map[string]Tenant
+	byName map[string]Tenant
+}{}
+
+// 是否启用多租户模式
+func multiTenant() bool {
+	return *tenantsFile != ""
+}
+
+// 读取 -tenants-file，未配置时返回空映射
+func loadTenants() (map[string]Tenant, error) {
+	byKey := make(map[string]Tenant)
+	if *tenantsFile == "" {
+		return byKey, nil
+	}
+	data, err := os.ReadFile(*tenantsFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &byKey); err != nil {
+		return nil, fmt.Errorf("租户文件: %v", err)
+	}
+	names := make(map[string]bool, len(byKey))
+	for key, tenant := range byKey {
+		switch {
+		case key == "":
+			return nil, fmt.Errorf("租户文件: API 密钥为空")
+		case !tenantNamePattern.MatchString(tenant.Name):
+			return nil, fmt.Errorf("租户文件: 无效的租户名 %q，只能使用小写字母、数字与连字符", tenant.Name)
+		case names[tenant.Name]:
+			return nil, fmt.Errorf("租户文件: 租户名 %s 重复", tenant.Name)
+		case tenant.MaxSites < 0 || tenant.MaxConnections < 0:
+			return nil, fmt.Errorf("租户文件: 租户 %s 的限制不能为负数", tenant.Name)
+		}
+		names[tenant.Name] = true
+	}
+	return byKey, nil
+}
+
+// 替换当前的租户
+func setTenants(byKey map[string]Tenant) {
+	byName := make(map[string]Tenant, len(byKey))
+	for _, tenant := range byKey {
+		byName[tenant.Name] = tenant
+	}
+	tenants.mutex.Lock()
+	defer tenants.mutex.Unlock()
+	tenants.byKey = byKey
+	tenants.byName = byName
+}
+
+// 按 API 密钥查找租户
+func tenantForKey(key string) (Tenant, bool) {
+	tenants.mutex.RLock()
+	defer tenants.mutex.RUnlock()
+	tenant, ok := tenants.byKey[key]
+	return tenant, ok
+}
+
+// 站点所属的租户，未启用多租户模式或不属于任何租户时 ok 为 false
+func tenantOfSite(siteID string) (Tenant, bool) {
+	name, _, ok := strings.Cut(siteID, "/")
+	if !ok || !multiTenant() {
+		return Tenant{}, false
+	}
+	tenants.mutex.RLock()
+	defer tenants.mutex.RUnlock()
+	tenant, ok := tenants.byName[name]
+	return tenant, ok
+}
+
+// 租户内部使用的 siteId：tenant/siteid；已带本租户前缀的 siteId 原样返回，
+// 挂件按 joined 中的 siteId 重新加入时不会重复加前缀
+func tenantSiteID(name, siteID string) string {
+	if strings.HasPrefix(siteID, name+"/") {
+		return siteID
+	}
+	return name + "/" + siteID
+}
+
+// 去掉租户前缀，用于按 -siteid-pattern 校验租户自己的 siteId
+func stripTenant(siteID string) string {
+	if !multiTenant() {
+		return siteID
+	}
+	if _, rest, ok := strings.Cut(siteID, "/"); ok {
+		return rest
+	}
+	return siteID
+}
+
+// 请求携带的 API 密钥，取自 X-API-Key 头或 apiKey 参数
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("apiKey")
+}
+
+// 请求所属的租户，API 密钥无效时返回 ErrTenantUnauthorized
+func tenantFromRequest(r *http.Request) (Tenant, error) {
+	tenant, ok := tenantForKey(apiKeyFromRequest(r))
+	if !ok {
+		return Tenant{}, ErrTenantUnauthorized
+	}
+	return tenant, nil
+}
+
+// 按 join 或 query 中的 apiKey 确定连接所属的租户（在 readPump 中调用）；
+// 同一连接之后的消息可以省略 apiKey
+func (c *Client) authTenant(key string) error {
+	if key != "" {
+		tenant, ok := tenantForKey(key)
+		if !ok {
+			return ErrTenantUnauthorized
+		}
+		c.tenant = tenant.Name
+	}
+	if c.tenant == "" {
+		return ErrTenantUnauthorized
+	}
+	return nil
+}
+
+// 把连接上报的 siteId 放到所属租户下，未启用多租户模式时原样返回
+func (c *Client) scopeSiteID(siteID string) string {
+	if c.tenant == "" || siteID == "" {
+		return siteID
+	}
+	return tenantSiteID(c.tenant, siteID)
+}
+
+// 租户当前的站点与连接数（含观察者），遍历所有站点，适合少量租户的小型部署
+func (h *Hub) tenantUsage(name string) (sites []*Site, connections int) {
+	prefix := name + "/"
+	h.mutex.RLock()
+	for id, site := range h.sites {
+		if strings.HasPrefix(id, prefix) {
+			sites = append(sites, site)
+		}
+	}
+	h.mutex.RUnlock()
+
+	for _, site := range sites {
+		site.mutex.RLock()
+		connections += len(site.Connections) + len(site.Observers)
+		site.mutex.RUnlock()
+	}
+	return sites, connections
+}
+
+// 新建站点前检查租户的站点数上限（调用方需持有 Hub 锁）
+func (h *Hub) checkTenantSitesLocked(siteID string) error {
+	tenant, ok := tenantOfSite(siteID)
+	if !ok || tenant.MaxSites <= 0 {
+		return nil
+	}
+	prefix := tenant.Name + "/"
+	sites := 0
+	for id := range h.sites {
+		if strings.HasPrefix(id, prefix) {
+			sites++
+		}
+	}
+	if sites >= tenant.MaxSites {
+		log.Printf("租户 %s 站点数已达上限 %d，拒绝新站点 %q", tenant.Name, tenant.MaxSites, siteID)
+		return ErrTenantSites
+	}
+	return nil
+}
+
+// 租户连接数是否已达上限，客户端加入前在 Hub 协程中检查
+func (h *Hub) tenantFull(client *Client) bool {
+	tenant, ok := tenantOfSite(client.site.ID)
+	if !ok || tenant.MaxConnections <= 0 {
+		return false
+	}
+	_, connections := h.tenantUsage(tenant.Name)
+	if connections < tenant.MaxConnections {
+		return false
+	}
+
+	client.rejected = true
+	client.joinAck = nil
+	log.Printf("租户 %s 连接数已达上限 %d，拒绝客户端 %s", tenant.Name, tenant.MaxConnections, client.ip)
+	rejected := client.enqueue(Message{
+		Type:      "rejected",
+		SiteID:    client.site.ID,
+		Message:   "tenant full",
+		closeCode: errorCloseCode(ErrTenantFull),
+		closeText: http.StatusText(errorStatus(ErrTenantFull)),
+	})
+	if !rejected {
+		h.counters.droppedFrames.Add(1)
+		client.disconnect()
+	}
+	return true
+}
+
+// 多租户模式下按 siteId 查询的公开接口需要 API 密钥，siteId 参数改写为租户内部的 siteId
+func tenantScoped(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !multiTenant() {
+			next(w, r)
+			return
+		}
+		tenant, err := tenantFromRequest(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		query := r.URL.Query()
+		if query.Has("group") {
+			http.Error(w, "Groups are not available to tenants", http.StatusBadRequest)
+			return
+		}
+		for i, siteID := range query["siteId"] {
+			if siteID = resolveSiteID(strings.TrimSpace(siteID)); siteID != "" {
+				query["siteId"][i] = tenantSiteID(tenant.Name, siteID)
+			}
+		}
+
+		scoped := new(http.Request)
+		*scoped = *r
+		scoped.URL = new(url.URL)
+		*scoped.URL = *r.URL
+		scoped.URL.RawQuery = query.Encode()
+		next(w, scoped)
+	}
+}
+
+// 多租户模式下不提供的公开接口（跨租户的排行与不带 API 密钥的徽章、嵌入页）
+func tenantDisabled(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if multiTenant() {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// 租户站点列表中的一项，siteId 不带租户前缀
+type TenantSite struct {
+	SiteID string `json:"siteId"`
+	Count  int    `json:"count"`
+}
+
+// 租户的站点列表与用量
+type TenantSitesInfo struct {
+	Tenant         string       `json:"tenant"`
+	Sites          []TenantSite `json:"sites"`
+	Connections    int          `json:"connections"`
+	MaxSites       int          `json:"maxSites,omitempty"`
+	MaxConnections int          `json:"maxConnections,omitempty"`
+	Timestamp      int64        `json:"timestamp"`
+}
+
+// 处理租户站点列表请求：GET /api/sites，API 密钥取自 X-API-Key 头或 apiKey 参数
+func handleTenantSites(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !multiTenant() {
+		http.NotFound(w, r)
+		return
+	}
+	tenant, err := tenantFromRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sites, connections := hub.tenantUsage(tenant.Name)
+	info := TenantSitesInfo{
+		Tenant:         tenant.Name,
+		Sites:          make([]TenantSite, 0, len(sites)),
+		Connections:    connections,
+		MaxSites:       tenant.MaxSites,
+		MaxConnections: tenant.MaxConnections,
+		Timestamp:      time.Now().Unix(),
+	}
+	for _, site := range sites {
+		site.mutex.RLock()
+		count := site.Count
+		site.mutex.RUnlock()
+		info.Sites = append(info.Sites, TenantSite{SiteID: strings.TrimPrefix(site.ID, tenant.Name+"/"), Count: count})
+	}
+	sort.Slice(info.Sites, func(i, j int) bool { return info.Sites[i].SiteID < info.Sites[j].SiteID })
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 在测试期间启用多租户模式，结束时恢复
+func setTestTenants(t *testing.T, byKey map[string]Tenant) {
+	t.Helper()
+
+	setFlag(t, "tenants-file", "tenants.json")
+	tenants.mutex.RLock()
+	previous := tenants.byKey
+	tenants.mutex.RUnlock()
+	setTenants(byKey)
+	t.Cleanup(func() { setTenants(previous) })
+}
+
+// 租户连接数已满时被拒绝的加入不留下空站点，不占用租户与 -max-sites 的站点配额
+func TestTenantFullReleasesSite(t *testing.T) {
+	setTestTenants(t, map[string]Tenant{"key-acme": {Name: "acme", MaxSites: 2, MaxConnections: 1}})
+	h := startTestHub(t)
+	server := startTestServer(t)
+
+	first := dialTestClient(t, server, "")
+	first.send(Message{Type: "join", SiteID: "a.example", Version: scriptVersion, APIKey: "key-acme"})
+	first.expect("joined")
+
+	second := dialTestClient(t, server, "")
+	second.send(Message{Type: "join", SiteID: "b.example", Version: scriptVersion, APIKey: "key-acme"})
+	second.expect("rejected")
+	_, err := second.read(time.Second)
+	if code := closeCode(err); code != errorCloseCode(ErrTenantFull) {
+		t.Fatalf("关闭码为 %d，应为 %d（%v）", code, errorCloseCode(ErrTenantFull), err)
+	}
+
+	eventually(t, "被拒绝的站点移出 Hub", func() bool { return siteCount(h, "acme/b.example") == -1 })
+	if sites, _ := h.tenantUsage("acme"); len(sites) != 1 {
+		t.Fatalf("租户占用 %d 个站点，应为 1", len(sites))
+	}
+
+	first.close()
+	eventually(t, "全部站点离开 Hub", func() bool {
+		h.mutex.RLock()
+		defer h.mutex.RUnlock()
+		return len(h.sites) == 0
+	})
+}
+
+// 排队加入期间站点因清空被移出 Hub 时，加入重新登记该站点；已有同名新站点时改用新站点
+func TestLiveSiteReattaches(t *testing.T) {
+	h := NewHub()
+	site, err := h.getSite("a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.releaseSite(site)
+	if _, exists := h.sites["a.example"]; exists {
+		t.Fatal("空站点未被移出")
+	}
+	if got := h.liveSite(site); got != site || h.sites["a.example"] != site {
+		t.Fatal("被移出的站点未重新登记")
+	}
+
+	h.releaseSite(site)
+	replacement, err := h.getSite("a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.liveSite(site); got != replacement {
+		t.Fatal("未改用 Hub 中的同名新站点")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// 直接提供 HTTPS/WSS 时使用的证书与私钥，以及跳转到 HTTPS 的明文监听地址
+var (
+	tlsCert     = flag.String("tls-cert", "", "TLS 证书文件（PEM，可含中间证书），与 -tls-key 同时设置时直接提供 HTTPS 与 wss://，SIGHUP 重新读取")
+	tlsKey      = flag.String("tls-key", "", "TLS 私钥文件（PEM）")
+	tlsRedirect = flag.String("tls-redirect", "", "额外监听的明文 HTTP 地址，如 :80，所有请求以 301 跳转到 HTTPS；需要 -tls-cert")
+)
+
+// 当前使用的证书，重新加载时整体替换，已建立的连接不受影响
+var tlsCertificate atomic.Pointer[tls.Certificate]
+
+// 是否直接提供 TLS
+func tlsEnabled() bool {
+	return *tlsCert != "" && *tlsKey != ""
+}
+
+// 校验 TLS 参数，并读取一次证书确认可用
+func checkTLS() error {
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return fmt.Errorf("-tls-cert 与 -tls-key 需要同时设置")
+	}
+	if *tlsRedirect != "" && !tlsEnabled() {
+		return fmt.Errorf("-tls-redirect 需要同时设置 -tls-cert 与 -tls-key")
+	}
+	if !tlsEnabled() {
+		return nil
+	}
+	if _, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey); err != nil {
+		return fmt.Errorf("读取 TLS 证书失败: %v", err)
+	}
+	return nil
+}
+
+// 读取证书与私钥，成功后替换当前证书；失败时保留原有证书
+func loadTLSCertificate() error {
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return err
+	}
+	tlsCertificate.Store(&cert)
+	return nil
+}
+
+// 服务器的 TLS 配置，每次握手取当前证书，续期后重新加载即可生效
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return tlsCertificate.Load(), nil
+		},
+	}
+}
+
+// 跳转到 HTTPS 的明文服务，目标端口取自 HTTPS 的监听地址，443 时省略
+func newRedirectServer(httpsAddr string) *http.Server {
+	port := ""
+	if _, p, err := net.SplitHostPort(httpsAddr); err == nil && p != "443" {
+		port = p
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	return &http.Server{Addr: *tlsRedirect, Handler: handler}
+}
+
+// SIGHUP 时重新读取证书，Let's Encrypt 续期后无需重启
+func reloadTLSCertificate() {
+	if !tlsEnabled() {
+		return
+	}
+	if err := loadTLSCertificate(); err != nil {
+		log.Printf("重新加载 TLS 证书失败，继续使用原有证书: %v", err)
+		return
+	}
+	log.Printf("TLS 证书已重新加载")
+}
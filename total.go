@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 订阅全站在线总数的保留 siteId；含 * 号，不匹配默认的 siteId 规则，也不能认领
+const totalSiteID = "*total*"
+
+// 全站在线总数
+type TotalInfo struct {
+	Total     int64 `json:"total"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// join 是否为订阅全站总数：{"global":true} 或保留的 siteId
+func isTotalJoin(msg Message) bool {
+	return msg.Global || strings.TrimSpace(msg.SiteID) == totalSiteID
+}
+
+// 订阅全站总数，立即发送一次当前值
+func (h *Hub) subscribeTotal(client *Client) {
+	h.totalMutex.Lock()
+	h.totalSubscribers[client] = true
+	h.totalMutex.Unlock()
+
+	client.enqueue(Message{Type: "total", Count: int(h.total.Load()), Timestamp: time.Now().Unix()})
+}
+
+// 取消订阅全站总数，连接断开时调用，可以重复调用
+func (h *Hub) unsubscribeTotal(client *Client) {
+	h.totalMutex.Lock()
+	delete(h.totalSubscribers, client)
+	h.totalMutex.Unlock()
+}
+
+// 总数与上次推送时不同时通知订阅者（在 Hub 协程中每秒调用，期间的多次变化合并为一次）
+func (h *Hub) pushTotal(now time.Time) {
+	total := h.total.Load()
+	if total == h.totalSent {
+		return
+	}
+	h.totalSent = total
+
+	message := Message{Type: "total", Count: int(total), Timestamp: now.Unix()}
+	h.totalMutex.Lock()
+	defer h.totalMutex.Unlock()
+	for client := range h.totalSubscribers {
+		if !client.enqueue(message) {
+			h.counters.droppedFrames.Add(1)
+		}
+	}
+}
+
+// 处理全站在线总数请求：GET /api/total
+//
+// 总数为本实例计入人数的连接数（不含观察者），不按 IP 或会话去重。
+func handleTotal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, TotalInfo{Total: hub.total.Load(), Timestamp: time.Now().Unix()})
+}
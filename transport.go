@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// 客户端使用的传输方式
+const (
+	transportWebSocket = "websocket"
+	transportSSE       = "sse"
+	transportLongPoll  = "long-poll"
+)
+
+// 消息编码
+const encodingJSON = "json"
+
+// 消息协议版本：默认为 1，WebSocket 连接可以通过 ?protocol=2 协商 2；
+// v2 的每条消息带连接内递增的 seq，人数序号改为 countSeq
+const (
+	protocolVersion   = 1
+	protocolVersionV2 = 2
+)
+
+// 全部传输方式与编码，统计与指标只输出这些枚举值
+var (
+	knownTransports = []string{transportWebSocket, transportSSE, transportLongPoll}
+	knownEncodings  = []string{encodingJSON}
+)
+
+// 连接协商的传输参数
+type connKind struct {
+	Transport  string
+	Protocol   int
+	Encoding   string
+	Compressed bool
+}
+
+// 按传输参数组合的连接统计
+type TransportStats struct {
+	Transport  string `json:"transport"`
+	Protocol   int    `json:"protocol"`
+	Encoding   string `json:"encoding"`
+	Compressed bool   `json:"compressed"`
+	Live       int    `json:"live"`
+	Total      int64  `json:"total"`
+}
+
+// 各组合建立过的连接数
+var transportTotals = struct {
+	counts map[connKind]int64
+	mutex  sync.Mutex
+}{counts: make(map[connKind]int64)}
+
+// 新建连接时的传输参数
+func newConnKind(transport string) connKind {
+	return connKind{Transport: transport, Protocol: protocolVersion, Encoding: encodingJSON}
+}
+
+// 按 WebSocket 请求的 protocol 参数协商版本，无法识别时使用 1
+func negotiateProtocol(value string) int {
+	if value == "2" {
+		return protocolVersionV2
+	}
+	return protocolVersion
+}
+
+// 传输方式支持的协议版本
+func transportProtocols(transport string) []int {
+	if transport == transportWebSocket {
+		return []int{protocolVersion, protocolVersionV2}
+	}
+	return []int{protocolVersion}
+}
+
+// 记录一个新连接
+func recordTransport(kind connKind) {
+	transportTotals.mutex.Lock()
+	transportTotals.counts[kind]++
+	transportTotals.mutex.Unlock()
+}
+
+// 各组合的在线连接数与累计连接数，包含计数为 0 的已知组合
+func (h *Hub) transportStats() []TransportStats {
+	live := make(map[connKind]int)
+	h.mutex.RLock()
+	for _, site := range h.sites {
+		site.mutex.RLock()
+		for _, clients := range []map[*Client]bool{site.Connections, site.Observers} {
+			for client := range clients {
+				live[client.kind]++
+			}
+		}
+		site.mutex.RUnlock()
+	}
+	h.mutex.RUnlock()
+
+	transportTotals.mutex.Lock()
+	defer transportTotals.mutex.Unlock()
+
+	stats := []TransportStats{}
+	for _, transport := range knownTransports {
+		for _, protocol := range transportProtocols(transport) {
+			for _, encoding := range knownEncodings {
+				for _, compressed := range []bool{false, true} {
+					kind := connKind{Transport: transport, Protocol: protocol, Encoding: encoding, Compressed: compressed}
+					stats = append(stats, TransportStats{
+						Transport:  transport,
+						Protocol:   protocol,
+						Encoding:   encoding,
+						Compressed: compressed,
+						Live:       live[kind],
+						Total:      transportTotals.counts[kind],
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Total > stats[j].Total })
+	return stats
+}
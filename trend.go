@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 人数变化率的统计窗口（秒）
+const trendWindowSeconds = 60
+
+// 5 分钟 EMA 每秒的衰减系数
+var trendEMADecay = math.Exp(-1.0 / 300)
+
+// trending 接口的默认与最大数量
+const (
+	defaultTrendingLimit = 10
+	maxTrendingLimit     = 100
+)
+
+// 站点人数变化趋势
+type TrendInfo struct {
+	PerMinute int     `json:"perMinute"`
+	EMA5m     float64 `json:"ema5m"`
+}
+
+// 按秒分桶的人数变化窗口，每次事件 O(1)
+//
+// buckets 保存最近 60 秒每秒的净变化（加入减离开），
+// ema 为每分钟净变化的 5 分钟指数移动平均。
+type trendWindow struct {
+	buckets [trendWindowSeconds]int
+	sum     int
+	second  int64
+	ema     float64
+}
+
+// 推进到 now 所在的秒，清空过期的桶
+func (w *trendWindow) advance(now time.Time) {
+	second := now.Unix()
+	if w.second == 0 {
+		w.second = second
+		return
+	}
+	elapsed := second - w.second
+	if elapsed <= 0 {
+		return
+	}
+
+	// 每过一秒，当前桶结束：计入 EMA 后清空最旧的桶
+	steps := elapsed
+	if steps > trendWindowSeconds {
+		steps = trendWindowSeconds
+	}
+	for i := int64(0); i < steps; i++ {
+		w.ema = w.ema*trendEMADecay + (1-trendEMADecay)*float64(w.sum)
+		w.second++
+		slot := w.second % trendWindowSeconds
+		w.sum -= w.buckets[slot]
+		w.buckets[slot] = 0
+	}
+	// 超过窗口的空闲秒数内净变化为 0，EMA 直接按衰减计算
+	if rest := elapsed - steps; rest > 0 {
+		w.ema *= math.Pow(trendEMADecay, float64(rest))
+	}
+	w.second = second
+}
+
+// 记录一次人数变化
+func (w *trendWindow) add(now time.Time, delta int) {
+	w.advance(now)
+	w.buckets[w.second%trendWindowSeconds] += delta
+	w.sum += delta
+}
+
+// 当前趋势
+func (w *trendWindow) info(now time.Time) TrendInfo {
+	w.advance(now)
+	return TrendInfo{
+		PerMinute: w.sum,
+		EMA5m:     math.Round(w.ema*10) / 10,
+	}
+}
+
+// 人数变化时递增站点序号并记录趋势
+func (h *Hub) countChanged(siteID string, delta int) int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	stats := h.statsLocked(siteID)
+	stats.Trend.add(now, delta)
+	return stats.advanceLocked(now)
+}
+
+// 读取站点趋势，统计数据已清理的站点返回零值
+func (h *Hub) trend(siteID string) TrendInfo {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stats, exists := h.stats[siteID]
+	if !exists {
+		return TrendInfo{}
+	}
+	return stats.Trend.info(time.Now())
+}
+
+// 站点趋势条目
+type TrendingSite struct {
+	SiteID string    `json:"siteId"`
+	Count  int       `json:"count"`
+	Trend  TrendInfo `json:"trend"`
+}
+
+// 按最近一分钟净变化排序的站点，不含分组与私有站点
+func (h *Hub) trending(limit int) []TrendingSite {
+	now := time.Now()
+	h.mutex.Lock()
+	sites := make([]TrendingSite, 0, len(h.stats))
+	for id, stats := range h.stats {
+		if _, ok := groupName(id); ok {
+			continue
+		}
+		sites = append(sites, TrendingSite{SiteID: id, Trend: stats.Trend.info(now)})
+	}
+	h.mutex.Unlock()
+
+	filtered := sites[:0]
+	for _, site := range sites {
+		if !settings.Resolve(site.SiteID).Private {
+			filtered = append(filtered, site)
+		}
+	}
+	sites = filtered
+
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].Trend.PerMinute != sites[j].Trend.PerMinute {
+			return sites[i].Trend.PerMinute > sites[j].Trend.PerMinute
+		}
+		return sites[i].SiteID < sites[j].SiteID
+	})
+	if len(sites) > limit {
+		sites = sites[:limit]
+	}
+	for i := range sites {
+		sites[i].Count, _ = h.countAndSeq(sites[i].SiteID)
+	}
+	return sites
+}
+
+// 单个站点信息响应
+type SiteDetail struct {
+	SiteID    string    `json:"siteId"`
+	Count     int       `json:"count"`
+	Seq       int64     `json:"seq"`
+	Trend     TrendInfo `json:"trend"`
+	Timestamp int64     `json:"timestamp"`
+	SiteStatus
+}
+
+// 处理站点信息请求：GET /api/site/{id}
+func handleSiteDetail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	siteID := strings.TrimPrefix(r.URL.Path, "/api/site/")
+	if siteID == "" || strings.Contains(siteID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 私有站点需要站点密钥
+	if settings.Resolve(siteID).Private && !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !checkNotModified(w, r, siteID, "") {
+		return
+	}
+
+	count, seq := hub.countAndSeq(siteID)
+	writeJSON(w, http.StatusOK, SiteDetail{
+		SiteID:     siteID,
+		Count:      count,
+		Seq:        seq,
+		Trend:      hub.trend(siteID),
+		Timestamp:  time.Now().Unix(),
+		SiteStatus: hub.siteStatus(siteID, count),
+	})
+}
+
+// 处理趋势排行请求：GET /api/trending?limit=10
+func handleTrending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultTrendingLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxTrendingLimit)
+	}
+
+	writeJSON(w, http.StatusOK, hub.trending(limit))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// 60 秒窗口逐秒滚动：第 59 秒仍计入，满 60 秒后移出
+func TestTrendWindowRollover(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	var w trendWindow
+	w.add(at(0), 3)
+	w.add(at(0), -1)
+	if got := w.info(at(0)).PerMinute; got != 2 {
+		t.Fatalf("第 0 秒的每分钟净变化为 %d，应为 2", got)
+	}
+	w.add(at(30), 5)
+
+	tests := []struct {
+		second    int
+		perMinute int
+	}{
+		{30, 7},
+		{59, 7},
+		{60, 5}, // 第 0 秒的桶移出
+		{89, 5},
+		{90, 0}, // 第 30 秒的桶移出
+	}
+	for _, tt := range tests {
+		if got := w.info(at(tt.second)).PerMinute; got != tt.perMinute {
+			t.Fatalf("第 %d 秒的每分钟净变化为 %d，应为 %d", tt.second, got, tt.perMinute)
+		}
+	}
+
+	// 回拨的时间不改变窗口
+	w.add(at(100), 4)
+	if got := w.info(at(95)).PerMinute; got != 4 {
+		t.Fatalf("时间回拨后为 %d，应为 4", got)
+	}
+}
+
+// EMA 按秒衰减；空闲超过窗口时桶全部清空，EMA 按空闲时长衰减
+func TestTrendWindowEMA(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	var w trendWindow
+	w.add(start, 60)
+
+	// 窗口内的净变化持续 60 秒，每秒计入一次
+	want := 0.0
+	for i := 0; i < 60; i++ {
+		want = want*trendEMADecay + (1-trendEMADecay)*60
+	}
+	if got := w.info(start.Add(60 * time.Second)).EMA5m; got != math.Round(want*10)/10 {
+		t.Fatalf("60 秒后 EMA 为 %v，应为 %.1f", got, want)
+	}
+
+	idle := 1000
+	w.advance(start.Add(time.Duration(60+idle) * time.Second))
+	want *= math.Pow(trendEMADecay, float64(idle))
+	if math.Abs(w.ema-want) > 1e-9 {
+		t.Fatalf("空闲 %d 秒后 EMA 为 %v，应为 %v", idle, w.ema, want)
+	}
+	for slot, value := range w.buckets {
+		if value != 0 {
+			t.Fatalf("空闲后第 %d 个桶仍为 %d", slot, value)
+		}
+	}
+	if w.sum != 0 {
+		t.Fatalf("空闲后净变化为 %d", w.sum)
+	}
+}
+
+// 统计数据按 TTL 清理后趋势归零，站点再次出现时从空窗口开始
+func TestTrendResetOnEviction(t *testing.T) {
+	h := NewHub()
+	h.countChanged("a.example", 1)
+	h.countChanged("a.example", 1)
+	if trend := h.trend("a.example"); trend.PerMinute != 2 {
+		t.Fatalf("每分钟净变化为 %d，应为 2", trend.PerMinute)
+	}
+
+	// 站点仍在 Hub 中时不清理
+	if _, err := h.getSite("a.example"); err != nil {
+		t.Fatal(err)
+	}
+	h.sweepStats(time.Now().Add(*statsTTL + time.Minute))
+	if trend := h.trend("a.example"); trend.PerMinute != 2 {
+		t.Fatalf("站点在线时趋势被清理：%+v", trend)
+	}
+
+	delete(h.sites, "a.example")
+	h.sweepStats(time.Now().Add(*statsTTL + time.Minute))
+	if trend := h.trend("a.example"); trend != (TrendInfo{}) {
+		t.Fatalf("清理后趋势为 %+v，应为零值", trend)
+	}
+	h.countChanged("a.example", -1)
+	if trend := h.trend("a.example"); trend.PerMinute != -1 || trend.EMA5m != 0 {
+		t.Fatalf("重新出现后趋势为 %+v，应从空窗口开始", trend)
+	}
+}
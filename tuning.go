@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 运行时调优参数
+var maxProcs = flag.Int("maxprocs", 0, "GOMAXPROCS（0 表示按 cgroup CPU 配额自动设置）")
+var goGC = flag.Int("gogc", 0, "GC 百分比（0 保持默认，-1 关闭 GC）")
+var goMemLimit = flag.String("gomemlimit", "", "软内存上限，例如 512MiB（为空保持默认）")
+
+// 生效的运行时参数
+type RuntimeSettings struct {
+	GOMAXPROCS       int    `json:"gomaxprocs"`
+	GOMAXPROCSSource string `json:"gomaxprocsSource"`
+	GOGC             int    `json:"gogc"`
+	GOMEMLIMIT       int64  `json:"gomemlimit"`
+}
+
+// 启动时记录的生效参数
+var runtimeSettings RuntimeSettings
+
+// 应用运行时调优参数
+func applyRuntimeTuning() (RuntimeSettings, error) {
+	effective := RuntimeSettings{GOMAXPROCSSource: "default"}
+
+	switch {
+	case *maxProcs > 0:
+		runtime.GOMAXPROCS(*maxProcs)
+		effective.GOMAXPROCSSource = "flag"
+	case os.Getenv("GOMAXPROCS") != "":
+		effective.GOMAXPROCSSource = "env"
+	default:
+		if procs, ok := cgroupCPUQuota(); ok && procs < runtime.GOMAXPROCS(0) {
+			runtime.GOMAXPROCS(procs)
+			effective.GOMAXPROCSSource = "cgroup"
+		}
+	}
+	effective.GOMAXPROCS = runtime.GOMAXPROCS(0)
+
+	if *goGC != 0 {
+		debug.SetGCPercent(*goGC)
+	}
+	effective.GOGC = currentGCPercent()
+
+	if *goMemLimit != "" {
+		limit, err := parseByteSize(*goMemLimit)
+		if err != nil {
+			return effective, fmt.Errorf("无效的 -gomemlimit: %v", err)
+		}
+		debug.SetMemoryLimit(limit)
+	}
+	effective.GOMEMLIMIT = debug.SetMemoryLimit(-1)
+
+	return effective, nil
+}
+
+// 读取当前 GC 百分比
+func currentGCPercent() int {
+	percent := debug.SetGCPercent(100)
+	debug.SetGCPercent(percent)
+	return percent
+}
+
+// 读取 cgroup CPU 配额，返回向上取整的核数
+func cgroupCPUQuota() (int, bool) {
+	// cgroup v2: "<quota> <period>" 或 "max <period>"
+	if data, err := os.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 && fields[0] != "max" {
+			return quotaToProcs(fields[0], fields[1])
+		}
+		return 0, false
+	}
+
+	// cgroup v1
+	quota, err := os.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	if err != nil {
+		return 0, false
+	}
+	period, err := os.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+	if err != nil {
+		return 0, false
+	}
+	return quotaToProcs(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+// 将配额与周期换算为核数
+func quotaToProcs(quotaStr, periodStr string) (int, bool) {
+	quota, err := strconv.ParseFloat(quotaStr, 64)
+	if err != nil || quota <= 0 {
+		return 0, false
+	}
+	period, err := strconv.ParseFloat(periodStr, 64)
+	if err != nil || period <= 0 {
+		return 0, false
+	}
+
+	procs := int(math.Ceil(quota / period))
+	if procs < 1 {
+		procs = 1
+	}
+	return procs, true
+}
+
+// 解析带单位的字节数，例如 512MiB、1GB
+func parseByteSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		{"B", 1},
+	}
+
+	value = strings.TrimSpace(value)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q 不是有效的大小", value)
+	}
+	return n * multiplier, nil
+}
+
+// 最近 GC 停顿的 p95
+func gcPauseP95() time.Duration {
+	stats := debug.GCStats{PauseQuantiles: make([]time.Duration, 21)}
+	debug.ReadGCStats(&stats)
+	if stats.NumGC == 0 {
+		return 0
+	}
+	return stats.PauseQuantiles[19]
+}
+
+// 格式化内存上限用于日志
+func formatMemLimit(limit int64) string {
+	if limit == math.MaxInt64 {
+		return "无限制"
+	}
+	return fmt.Sprintf("%dMiB", limit>>20)
+}
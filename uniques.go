@@ -0,0 +1,177 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HyperLogLog 精度：2^11 个寄存器，每个站点每天 2KB，标准误差约 2.3%
+const (
+	hllPrecision = 11
+	hllRegisters = 1 << hllPrecision
+)
+
+// HyperLogLog 基数估计
+type hyperLogLog [hllRegisters]uint8
+
+// 计入一个元素
+func (h *hyperLogLog) add(value string) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	x := mix64(hasher.Sum64())
+
+	index := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h[index] {
+		h[index] = rank
+	}
+}
+
+// 估计不同元素的数量
+func (h *hyperLogLog) estimate() int64 {
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// 基数较小时改用线性计数
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+// FNV 的低位分布不够均匀，再做一次 splitmix64 混合
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// 站点按天的独立访客估计，站点从 Hub 移除后仍保留
+type uniqueStats struct {
+	day       string
+	today     hyperLogLog
+	yesterday *hyperLogLog
+}
+
+// 本地时间的日期
+func localDay(t time.Time) string {
+	return t.Local().Format("2006-01-02")
+}
+
+// 跨过本地零点时轮换：前一天的数据成为 yesterday，更早的数据丢弃
+func (u *uniqueStats) rollover(now time.Time) {
+	day := localDay(now)
+	if u.day == day {
+		return
+	}
+	if u.day == localDay(now.AddDate(0, 0, -1)) {
+		previous := u.today
+		u.yesterday = &previous
+	} else {
+		u.yesterday = nil
+	}
+	u.today = hyperLogLog{}
+	u.day = day
+}
+
+// 独立访客接口响应
+type UniquesInfo struct {
+	SiteID    string `json:"siteId"`
+	Date      string `json:"date"`
+	Today     int64  `json:"today"`
+	Yesterday int64  `json:"yesterday"`
+}
+
+// 访客的去重键：优先使用 clientId，否则使用 IP
+func uniqueKey(client *Client) string {
+	if client.clientID != "" {
+		return "c:" + client.clientID
+	}
+	return "ip:" + dedupKey(client.ip)
+}
+
+// 记录一次计入人数的加入
+func (h *Hub) recordUnique(siteID string, client *Client) {
+	now := time.Now()
+	h.uniquesMutex.Lock()
+	defer h.uniquesMutex.Unlock()
+
+	stats, exists := h.uniques[siteID]
+	if !exists {
+		stats = &uniqueStats{}
+		h.uniques[siteID] = stats
+	}
+	stats.rollover(now)
+	stats.today.add(uniqueKey(client))
+}
+
+// 读取站点今天与昨天的独立访客估计
+func (h *Hub) uniqueCounts(siteID string) UniquesInfo {
+	now := time.Now()
+	info := UniquesInfo{SiteID: siteID, Date: localDay(now)}
+
+	h.uniquesMutex.Lock()
+	defer h.uniquesMutex.Unlock()
+
+	stats, exists := h.uniques[siteID]
+	if !exists {
+		return info
+	}
+	stats.rollover(now)
+	info.Today = stats.today.estimate()
+	if stats.yesterday != nil {
+		info.Yesterday = stats.yesterday.estimate()
+	}
+	return info
+}
+
+// 清理昨天起就没有访客的站点
+func (h *Hub) sweepUniques(now time.Time) {
+	yesterday := localDay(now.AddDate(0, 0, -1))
+
+	h.uniquesMutex.Lock()
+	defer h.uniquesMutex.Unlock()
+
+	for siteID, stats := range h.uniques {
+		if stats.day < yesterday {
+			delete(h.uniques, siteID)
+		}
+	}
+}
+
+// 处理独立访客请求：GET /api/uniques?siteId=example.com
+func handleUniques(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	siteID := resolveSiteID(strings.TrimSpace(r.URL.Query().Get("siteId")))
+	if siteID == "" {
+		writeError(w, &InvalidSiteIDError{Reason: "缺少 siteId"})
+		return
+	}
+
+	// 私有站点需要站点密钥
+	if settings.Resolve(siteID).Private && !checkSiteKey(siteID, siteKeyFromRequest(r)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hub.uniqueCounts(siteID))
+}
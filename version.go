@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 内置挂件脚本的版本，随模板嵌入脚本并在 join 中上报；修改 main.js 的行为时递增
+const scriptVersion = "2.11.0"
+
+// 打印版本与构建信息后退出
+var showVersion = flag.Bool("version", false, "打印版本、Go 版本与构建时间后退出")
+
+// -version 的输出，与 GET /api/version 的 version、goVersion、builtAt 相同；未注入构建时间时显示“未知”
+func versionText() string {
+	builtAt := BuiltAt
+	if builtAt == "" {
+		builtAt = "未知"
+	}
+	return fmt.Sprintf("LiveUser %s\nGo 版本: %s\n构建时间: %s\n", Version, runtime.Version(), builtAt)
+}
+
+// 客户端的最低版本与升级期限
+var (
+	minClientVersion = flag.String("min-client-version", "", "挂件脚本的最低版本，如 2.0.0；低于该版本的连接收到 upgrade_required，站点配置 minClientVersion 可覆盖，为空时不检查")
+	upgradeGrace     = flag.Duration("upgrade-grace", 5*time.Minute, "通知升级后等待挂件重新加载的时长，期满仍未断开的旧版本连接以 4426 关闭")
+)
+
+// 解析版本号：以点分隔的非负整数，允许 v 前缀，如 2.0.1
+func parseVersion(value string) ([]int, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "v")
+	if value == "" {
+		return nil, fmt.Errorf("版本号为空")
+	}
+	parts := strings.Split(value, ".")
+	version := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("无效的版本号 %q", value)
+		}
+		version[i] = n
+	}
+	return version, nil
+}
+
+// 比较两个版本号，缺少的部分按 0 处理（2.0 与 2.0.0 相同）；
+// 无法解析或未上报的版本视为最旧
+func compareVersions(a, b string) int {
+	va, errA := parseVersion(a)
+	vb, errB := parseVersion(b)
+	switch {
+	case errA != nil && errB != nil:
+		return 0
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// 校验最低版本配置，空字符串表示不检查
+func checkMinVersion(value string) error {
+	if value == "" {
+		return nil
+	}
+	_, err := parseVersion(value)
+	return err
+}
+
+// 站点要求的最低版本，站点配置优先于 -min-client-version
+func requiredVersion(siteID string) string {
+	if version := settings.Get(siteID).MinClientVersion; version != "" {
+		return version
+	}
+	return *minClientVersion
+}
+
+// 客户端上报的版本低于站点要求时通知升级，并在 -upgrade-grace 后断开（在 Hub 协程中调用）
+//
+// 新版挂件收到 upgrade_required 后重新加载脚本并重连，旧连接随之关闭；
+// 不认识该消息的旧脚本在期满时以 4426 断开，不再重连。未设置最低版本时不做任何事。
+func (h *Hub) checkClientVersion(client *Client, siteID string) {
+	required := requiredVersion(siteID)
+	if required == "" || compareVersions(client.version, required) >= 0 || client.upgradeTimer != nil {
+		return
+	}
+
+	deadline := time.Now().Add(*upgradeGrace)
+	notice := Message{
+		Type:       "upgrade_required",
+		SiteID:     siteID,
+		Message:    fmt.Sprintf("挂件脚本版本 %s 低于要求的 %s，请重新加载", displayVersion(client.version), required),
+		MinVersion: required,
+		Deadline:   deadline.Unix(),
+	}
+	closing := Message{
+		Type:      "error",
+		SiteID:    siteID,
+		Message:   ErrUpgradeRequired.Error(),
+		closeCode: errorCloseCode(ErrUpgradeRequired),
+		closeText: "Upgrade Required",
+	}
+	h.upgradeRequired.Add(1)
+	reported := displayVersion(client.version)
+
+	if *upgradeGrace <= 0 {
+		notice.closeCode, notice.closeText = closing.closeCode, closing.closeText
+		client.enqueue(notice)
+		return
+	}
+	if !client.enqueue(notice) {
+		h.counters.droppedFrames.Add(1)
+	}
+	client.upgradeTimer = time.AfterFunc(*upgradeGrace, func() {
+		if client.enqueue(closing) {
+			h.upgradeClosed.Add(1)
+			log.Printf("客户端 %s 的挂件版本 %s 低于 %s，升级期限已过，断开", client.ip, reported, required)
+		}
+	})
+}
+
+// 版本号的最大长度
+const maxVersionLen = 32
+
+// 客户端上报的版本号，无法解析时视为未上报
+func validVersion(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > maxVersionLen {
+		return ""
+	}
+	if _, err := parseVersion(value); err != nil {
+		return ""
+	}
+	return value
+}
+
+// 日志与提示中的版本号，未上报时显示 unknown
+func displayVersion(version string) string {
+	if version == "" {
+		return "unknown"
+	}
+	return version
+}
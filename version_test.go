@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 版本比较：缺少的部分按 0 处理；预发布后缀与其他无法解析的版本视为最旧
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.0.0", "2.0.0", 0},
+		{"2.0", "2.0.0", 0},
+		{"v2.0.1", "2.0.1", 0},
+		{" 2.1 ", "2.1.0", 0},
+		{"2.0.1", "2.0.0", 1},
+		{"2.0.0", "2.0.1", -1},
+		{"2.10.0", "2.9.0", 1},
+		{"10.0", "9.99.99", 1},
+		{"2.0.0.1", "2.0", 1},
+		{"1.9", "2", -1},
+
+		// 预发布版本无法解析，比任何正式版本都旧
+		{"2.0.0-beta", "1.0.0", -1},
+		{"2.0.0", "2.0.0-rc.1", 1},
+		{"2.0.0-beta", "2.0.0-rc.1", 0},
+
+		// 未上报或无效的版本
+		{"", "0.0.1", -1},
+		{"2.0.0", "", 1},
+		{"", "", 0},
+		{"abc", "1", -1},
+		{"1..0", "1.0", -1},
+		{"-1.0", "0.0", -1},
+		{"2.x", "garbage", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d，应为 %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// 最低版本配置：空字符串不检查，其他值必须能解析
+func TestCheckMinVersion(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"":           true,
+		"2":          true,
+		"v2.0.1":     true,
+		"2.0.0-beta": false,
+		"latest":     false,
+		"2.0.":       false,
+	} {
+		if err := checkMinVersion(value); (err == nil) != valid {
+			t.Errorf("checkMinVersion(%q) 返回 %v", value, err)
+		}
+	}
+}
+
+// 旧版本的连接先收到 upgrade_required，期满后以 4426 关闭；满足要求的连接不受影响
+func TestUpgradeDeadlineClose(t *testing.T) {
+	h := startTestHub(t)
+	server := startTestServer(t)
+	setFlag(t, "min-client-version", "2.0.0")
+	setFlag(t, "upgrade-grace", "200ms")
+
+	current := dialTestClient(t, server, "")
+	current.join("a.example")
+
+	old := dialTestClient(t, server, "")
+	joined := time.Now()
+	old.send(Message{Type: "join", SiteID: "a.example", Version: "1.9.9"})
+	notice := old.expect("upgrade_required")
+	if notice.MinVersion != "2.0.0" || notice.Deadline < joined.Unix() || notice.Deadline > joined.Add(time.Minute).Unix() {
+		t.Fatalf("升级通知为 %+v", notice)
+	}
+	if h.upgradeRequired.Load() != 1 {
+		t.Fatalf("upgradeRequired = %d，应为 1", h.upgradeRequired.Load())
+	}
+
+	// 期满前不关闭
+	for {
+		_, err := old.read(3 * time.Second)
+		if err == nil {
+			continue
+		}
+		if code := closeCode(err); code != 4426 {
+			t.Fatalf("旧版本连接以 %d 关闭（%v），应为 4426", code, err)
+		}
+		break
+	}
+	if elapsed := time.Since(joined); elapsed < 200*time.Millisecond {
+		t.Fatalf("旧版本连接在 %v 后关闭，早于 -upgrade-grace", elapsed)
+	}
+	eventually(t, "计入 upgradeClosed", func() bool { return h.upgradeClosed.Load() == 1 })
+
+	// 新版本的连接仍在站点中
+	eventually(t, "只剩新版本的连接", func() bool { return siteCount(h, "a.example") == 1 })
+	if h.upgradeRequired.Load() != 1 {
+		t.Fatalf("满足要求的连接也收到了升级通知")
+	}
+}
+
+// -upgrade-grace 0 时升级通知本身带 4426，立即关闭
+func TestUpgradeWithoutGrace(t *testing.T) {
+	startTestHub(t)
+	server := startTestServer(t)
+	setFlag(t, "min-client-version", "2.0.0")
+	setFlag(t, "upgrade-grace", "0")
+
+	old := dialTestClient(t, server, "")
+	old.send(Message{Type: "join", SiteID: "a.example"})
+	for {
+		message, err := old.read(3 * time.Second)
+		if err != nil {
+			if code := closeCode(err); code != 4426 {
+				t.Fatalf("未上报版本的连接以 %d 关闭（%v），应为 4426", code, err)
+			}
+			return
+		}
+		if message.Type == "upgrade_required" && message.MinVersion != "2.0.0" {
+			t.Fatalf("升级通知为 %+v", message)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 人数越过阈值时的 Webhook 通知
+var (
+	webhookURL        = flag.String("webhook-url", "", "站点人数越过阈值时通知的 Webhook 地址（兼容 Slack Incoming Webhook），为空时关闭")
+	webhookThresholds = flag.String("webhook-threshold", "", "触发 Webhook 的人数阈值，逗号分隔，如 100,500")
+	webhookCooldown   = flag.Duration("webhook-cooldown", 10*time.Minute, "同一站点同一阈值两次通知的最小间隔")
+	webhookQueueSize  = flag.Int("webhook-queue", 100, "待发送 Webhook 的队列长度，队列已满时丢弃新的通知")
+)
+
+// Webhook 投递的重试策略
+var webhookRetryPolicy = retryPolicy{
+	Target:         "webhook",
+	MaxAttempts:    5,
+	MaxElapsed:     2 * time.Minute,
+	BaseDelay:      time.Second,
+	MaxDelay:       30 * time.Second,
+	AttemptTimeout: 10 * time.Second,
+}
+
+// 人数变化方向
+const (
+	directionRising  = "rising"
+	directionFalling = "falling"
+)
+
+// Webhook 请求体；text 供 Slack 等直接展示，其余字段供程序处理
+type WebhookPayload struct {
+	Text      string `json:"text"`
+	SiteID    string `json:"siteId"`
+	Count     int    `json:"count"`
+	Threshold int    `json:"threshold"`
+	Direction string `json:"direction"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// 站点配置了密钥时 Webhook 请求签名所在的请求头
+const webhookSignatureHeader = "X-LiveUser-Signature"
+
+// 待投递的通知，payload 编码为 JSON 请求体，summary 用于日志；
+// siteID 不为空且站点配置了密钥时，以投递时的当前密钥签名
+type webhookMessage struct {
+	payload interface{}
+	siteID  string
+	summary string
+}
+
+// 站点与阈值
+type webhookKey struct {
+	siteID    string
+	threshold int
+}
+
+// 阈值检测与投递队列
+//
+// observe 在 Hub 协程中调用，只做比较与非阻塞入队；投递在独立协程中
+// 按 webhookRetryPolicy 重试，端点缓慢时队列写满后丢弃新通知，不会阻塞加入与离开。
+// 关闭时 Close 在预算内投递完队列中的通知，预算用尽后未送达的计入丢弃。
+type webhookNotifier struct {
+	url        string
+	thresholds []int
+	cooldown   time.Duration
+	client     *http.Client
+
+	// 各站点上一次的人数与各阈值最近一次通知的时间
+	mutex sync.Mutex
+	last  map[string]int
+	fired map[webhookKey]time.Time
+
+	queue   chan webhookMessage
+	sent    atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+
+	// 关闭后不再入队；ctx 在关闭预算用尽时取消，中止正在进行的投递
+	closeMutex sync.RWMutex
+	closed     bool
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// 解析 -webhook-threshold
+func parseWebhookThresholds(value string) ([]int, error) {
+	var thresholds []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("无效的 Webhook 阈值 %q", part)
+		}
+		thresholds = append(thresholds, n)
+	}
+	sort.Ints(thresholds)
+	return thresholds, nil
+}
+
+// 按命令行参数创建通知器，未设置 -webhook-url 时返回 nil
+//
+// 只配置告警规则时可以不设置 -webhook-threshold。
+func newWebhookNotifier() (*webhookNotifier, error) {
+	if *webhookURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(*webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的 Webhook 地址 %q", *webhookURL)
+	}
+	thresholds, err := parseWebhookThresholds(*webhookThresholds)
+	if err != nil {
+		return nil, err
+	}
+	if *webhookQueueSize <= 0 {
+		return nil, fmt.Errorf("-webhook-queue 须大于 0")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhookNotifier{
+		url:        *webhookURL,
+		thresholds: thresholds,
+		cooldown:   *webhookCooldown,
+		client:     &http.Client{},
+		last:       make(map[string]int),
+		fired:      make(map[webhookKey]time.Time),
+		queue:      make(chan webhookMessage, *webhookQueueSize),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// 站点人数变化后检查是否越过阈值，未启用时为空操作
+func (n *webhookNotifier) observe(siteID string, count int, now time.Time) {
+	if n == nil {
+		return
+	}
+	if _, ok := groupName(siteID); ok {
+		return
+	}
+
+	n.mutex.Lock()
+	previous := n.last[siteID]
+	if count > 0 {
+		n.last[siteID] = count
+	} else {
+		delete(n.last, siteID)
+	}
+
+	var payloads []WebhookPayload
+	for _, threshold := range n.thresholds {
+		direction := ""
+		switch {
+		case previous < threshold && count >= threshold:
+			direction = directionRising
+		case previous >= threshold && count < threshold:
+			direction = directionFalling
+		}
+		if direction == "" {
+			continue
+		}
+
+		// 冷却期内同一阈值只通知一次，避免人数在阈值附近来回波动时刷屏
+		key := webhookKey{siteID: siteID, threshold: threshold}
+		if at, exists := n.fired[key]; exists && now.Sub(at) < n.cooldown {
+			continue
+		}
+		n.fired[key] = now
+		payloads = append(payloads, newWebhookPayload(siteID, count, threshold, direction, now))
+	}
+	n.mutex.Unlock()
+
+	for _, payload := range payloads {
+		n.enqueue(payload, siteID, fmt.Sprintf("站点 %s 阈值 %d", siteID, payload.Threshold))
+	}
+}
+
+// 非阻塞入队，队列已满或已关闭时丢弃；未启用时为空操作
+func (n *webhookNotifier) enqueue(payload interface{}, siteID, summary string) {
+	if n == nil {
+		return
+	}
+	n.closeMutex.RLock()
+	defer n.closeMutex.RUnlock()
+	if n.closed {
+		n.dropped.Add(1)
+		log.Printf("警告: Webhook 已关闭，丢弃%s的通知", summary)
+		return
+	}
+	select {
+	case n.queue <- webhookMessage{payload: payload, siteID: siteID, summary: summary}:
+	default:
+		n.dropped.Add(1)
+		log.Printf("警告: Webhook 队列已满，丢弃%s的通知", summary)
+	}
+}
+
+func newWebhookPayload(siteID string, count, threshold int, direction string, now time.Time) WebhookPayload {
+	text := fmt.Sprintf("站点 %s 在线人数达到 %d（阈值 %d）", siteID, count, threshold)
+	if direction == directionFalling {
+		text = fmt.Sprintf("站点 %s 在线人数降至 %d（低于阈值 %d）", siteID, count, threshold)
+	}
+	return WebhookPayload{
+		Text:      text,
+		SiteID:    siteID,
+		Count:     count,
+		Threshold: threshold,
+		Direction: direction,
+		Timestamp: now.Unix(),
+	}
+}
+
+// 清理冷却期已过的通知记录
+func (n *webhookNotifier) sweep(now time.Time) {
+	if n == nil {
+		return
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for key, at := range n.fired {
+		if now.Sub(at) >= n.cooldown {
+			delete(n.fired, key)
+		}
+	}
+}
+
+// 依次投递队列中的通知，队列关闭并处理完后返回
+func (n *webhookNotifier) run() {
+	defer close(n.done)
+	for message := range n.queue {
+		// 关闭预算已用尽：剩余的通知不再投递
+		if n.ctx.Err() != nil {
+			n.dropped.Add(1)
+			continue
+		}
+		err := retry(n.ctx, webhookRetryPolicy, func(ctx context.Context) error {
+			return n.post(ctx, message)
+		})
+		switch {
+		case err == nil:
+			n.sent.Add(1)
+		case n.ctx.Err() != nil:
+			n.dropped.Add(1)
+			log.Printf("警告: 关闭时%s的通知未能送达", message.summary)
+		default:
+			n.failed.Add(1)
+		}
+	}
+}
+
+// 停止接收新通知并投递队列中剩余的通知；ctx 结束时中止投递，未送达的计入丢弃。
+// 未启用时为空操作
+func (n *webhookNotifier) Close(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+	n.closeMutex.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.closeMutex.Unlock()
+
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-n.done
+		return ctx.Err()
+	}
+}
+
+// 以站点密钥计算请求体的 HMAC-SHA256 签名
+func webhookSignature(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// 发送一次通知，2xx 视为成功
+func (n *webhookNotifier) post(ctx context.Context, message webhookMessage) error {
+	body, err := json.Marshal(message.payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "LiveUser/"+Version)
+	// 每次发送时读取当前密钥，轮换或重新加载配置后立即使用新密钥
+	if message.siteID != "" {
+		if key := settings.Get(message.siteID).Key; key != "" {
+			req.Header.Set(webhookSignatureHeader, webhookSignature(key, body))
+		}
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &outboundStatusError{URL: n.url, Status: resp.StatusCode}
+	}
+	return nil
+}
+
+// 写入 Webhook 指标，未启用时不输出
+func (n *webhookNotifier) writeMetrics(buf *bytes.Buffer) {
+	if n == nil {
+		return
+	}
+	writeMetric(buf, "liveuser_webhook_queue_depth", "gauge", "待发送的 Webhook 通知数", int64(len(n.queue)))
+	writeMetric(buf, "liveuser_webhook_sent_total", "counter", "已成功投递的 Webhook 通知数", n.sent.Load())
+	writeMetric(buf, "liveuser_webhook_failed_total", "counter", "重试用尽仍未投递的 Webhook 通知数", n.failed.Load())
+	writeMetric(buf, "liveuser_webhook_dropped_total", "counter", "因队列已满而丢弃的 Webhook 通知数", n.dropped.Load())
+}
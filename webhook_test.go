@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 启动每个请求耗时 delay 的 Webhook 端点，返回按命令行参数创建并已开始投递的通知器与收到的请求数
+func startSlowWebhook(t *testing.T, delay time.Duration) (*webhookNotifier, *atomic.Int64) {
+	t.Helper()
+
+	var received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			received.Add(1)
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	setFlag(t, "webhook-url", server.URL)
+	n, err := newWebhookNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go n.run()
+	return n, &received
+}
+
+// 预算足够时关闭会投递完队列中的全部通知
+func TestWebhookCloseDeliversQueue(t *testing.T) {
+	n, received := startSlowWebhook(t, 20*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		n.enqueue(WebhookPayload{Text: "test"}, "", "测试")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Close(ctx); err != nil {
+		t.Fatalf("关闭失败: %v", err)
+	}
+	if sent, dropped := n.sent.Load(), n.dropped.Load(); sent != 5 || dropped != 0 {
+		t.Fatalf("投递 %d 条、丢弃 %d 条，应为 5 与 0", sent, dropped)
+	}
+	if got := received.Load(); got != 5 {
+		t.Fatalf("端点收到 %d 条，应为 5", got)
+	}
+
+	// 关闭后入队的通知直接丢弃，不会向已关闭的队列发送
+	n.enqueue(WebhookPayload{Text: "late"}, "", "测试")
+	if dropped := n.dropped.Load(); dropped != 1 {
+		t.Fatalf("关闭后入队的通知未计入丢弃: %d", dropped)
+	}
+}
+
+// 端点缓慢、预算不足时关闭按时返回：已送达的计入 sent，其余（含正在投递的一条）计入 dropped，不计为失败
+func TestWebhookCloseBudgetExceeded(t *testing.T) {
+	n, received := startSlowWebhook(t, 200*time.Millisecond)
+	const total = 10
+	for i := 0; i < total; i++ {
+		n.enqueue(WebhookPayload{Text: "test"}, "", "测试")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := n.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("关闭返回 %v，应为超时", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("关闭用时 %v，超出预算过多", elapsed)
+	}
+
+	sent, dropped, failed := n.sent.Load(), n.dropped.Load(), n.failed.Load()
+	if sent+dropped != total || failed != 0 {
+		t.Fatalf("投递 %d 条、丢弃 %d 条、失败 %d 条，投递与丢弃之和应为 %d 且没有失败", sent, dropped, failed, total)
+	}
+	if sent < 1 || sent > 3 || dropped < 1 {
+		t.Fatalf("投递 %d 条、丢弃 %d 条，预算内应只送达前几条", sent, dropped)
+	}
+	// 端点处理完但回复在预算用尽后才到的一条计入丢弃
+	if got := received.Load(); got < sent || got > sent+1 {
+		t.Fatalf("端点收到 %d 条，计入投递的为 %d 条", got, sent)
+	}
+}
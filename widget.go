@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 挂件配置变更后等待的时长，期间的连续修改合并为一次推送
+var configPushDelay = flag.Duration("config-push-delay", 2*time.Second, "站点挂件配置变更后推送 configUpdate 前等待的时长，期间的连续修改合并为一次推送")
+
+// 挂件显示文本的最大长度
+const maxWidgetTextLen = 200
+
+// 挂件重连间隔的上限，与挂件的最长退避间隔一致
+const maxWidgetReconnectDelay = 5 * time.Minute
+
+// 站点的挂件配置，覆盖嵌入脚本时的 URL 参数，修改后实时推送给在线的挂件
+//
+// 只包含可以下发给浏览器的字段；未设置的字段使用嵌入脚本时的值。
+type WidgetSettings struct {
+	// 显示格式，必须包含 {count}；人数为 1 时使用 formatOne（可以不含 {count}），未设置时同样使用 format
+	Format    string `json:"format,omitempty"`
+	FormatOne string `json:"formatOne,omitempty"`
+	Label     string `json:"label,omitempty"`
+
+	// 语言，未设置 format、label 时使用该语言的默认文本
+	Lang string `json:"lang,omitempty"`
+
+	// 重连间隔（毫秒）与是否允许改用 SSE
+	ReconnectDelay int   `json:"reconnectDelay,omitempty"`
+	SSEFallback    *bool `json:"sseFallback,omitempty"`
+
+	// 页面隐藏超过该秒数后断开，不再计入在线人数
+	IdleTimeout int `json:"idleTimeout,omitempty"`
+}
+
+// 校验挂件配置
+func (w *WidgetSettings) check() error {
+	if w == nil {
+		return nil
+	}
+	if w.Format != "" && !strings.Contains(w.Format, "{count}") {
+		return fmt.Errorf("widget.format 必须包含 {count}")
+	}
+	if len(w.Format) > maxWidgetTextLen || len(w.FormatOne) > maxWidgetTextLen || len(w.Label) > maxWidgetTextLen {
+		return fmt.Errorf("widget.format、widget.formatOne 与 widget.label 不能超过 %d 字节", maxWidgetTextLen)
+	}
+	if w.ReconnectDelay < 0 || w.ReconnectDelay > int(maxWidgetReconnectDelay/time.Millisecond) {
+		return fmt.Errorf("widget.reconnectDelay 必须在 0 到 %d 之间", int(maxWidgetReconnectDelay/time.Millisecond))
+	}
+	if w.IdleTimeout < 0 {
+		return fmt.Errorf("widget.idleTimeout 不能为负数")
+	}
+	return nil
+}
+
+// 下发给挂件的配置：按 lang 补全 format 与 label，未设置任何字段时返回 nil
+func (w *WidgetSettings) clientConfig() *WidgetSettings {
+	if w == nil || reflect.DeepEqual(*w, WidgetSettings{}) {
+		return nil
+	}
+	config := *w
+	if w.SSEFallback != nil {
+		fallback := *w.SSEFallback
+		config.SSEFallback = &fallback
+	}
+	if w.Lang != "" && locales != nil {
+		locale := selectLocale(w.Lang, "")
+		config.Lang = locale.Code
+		if config.Format == "" {
+			config.Format = locale.T("widget.format")
+		}
+		if config.Label == "" {
+			config.Label = locale.T("widget.label")
+		}
+	}
+	// 站点设置了 format 而没有设置 formatOne 时，人数为 1 也使用站点的 format，而不是嵌入时的 formatOne
+	if config.Format != "" && config.FormatOne == "" {
+		config.FormatOne = config.Format
+	}
+	return &config
+}
+
+// 嵌入脚本的站点挂件配置，JSON 编码，没有时为 null
+func widgetConfigJSON(siteID string) string {
+	data, err := json.Marshal(settings.Get(siteID).Widget.clientConfig())
+	if err != nil {
+		return "null"
+	}
+	return string(data)
+}
+
+// 等待推送的挂件配置变更，按站点索引
+var configUpdates = struct {
+	mutex   sync.Mutex
+	pending map[string]*pendingConfig
+}{pending: make(map[string]*pendingConfig)}
+
+// 一个站点待推送的变更
+type pendingConfig struct {
+	// 在线挂件当前使用的配置，即这一轮修改之前的配置
+	previous *WidgetSettings
+	due      time.Time
+}
+
+// 记录挂件配置的变更，previous 为修改前下发的配置；每次修改都推迟推送时间
+func queueConfigUpdate(siteID string, previous *WidgetSettings, now time.Time) {
+	configUpdates.mutex.Lock()
+	defer configUpdates.mutex.Unlock()
+
+	pending, exists := configUpdates.pending[siteID]
+	if !exists {
+		pending = &pendingConfig{previous: previous}
+		configUpdates.pending[siteID] = pending
+	}
+	pending.due = now.Add(*configPushDelay)
+}
+
+// 取出已到推送时间的变更
+func dueConfigUpdates(now time.Time) map[string]*WidgetSettings {
+	configUpdates.mutex.Lock()
+	defer configUpdates.mutex.Unlock()
+
+	var due map[string]*WidgetSettings
+	for siteID, pending := range configUpdates.pending {
+		if now.Before(pending.due) {
+			continue
+		}
+		if due == nil {
+			due = make(map[string]*WidgetSettings)
+		}
+		due[siteID] = pending.previous
+		delete(configUpdates.pending, siteID)
+	}
+	return due
+}
+
+// 推送到期的挂件配置变更（在 Hub 协程中调用）
+//
+// 与修改前的配置比较，这一轮修改最终没有改变下发内容时不推送。
+func (h *Hub) pushConfigUpdates(now time.Time) {
+	for siteID, previous := range dueConfigUpdates(now) {
+		config := settings.Get(siteID).Widget.clientConfig()
+		if reflect.DeepEqual(previous, config) {
+			continue
+		}
+		h.pushConfig(siteID, config, now)
+	}
+}
+
+// 向站点的挂件推送配置，config 为 nil 时推送空配置，挂件恢复嵌入时的值
+func (h *Hub) pushConfig(siteID string, config *WidgetSettings, now time.Time) {
+	if config == nil {
+		config = &WidgetSettings{}
+	}
+	h.broadcastMessage(siteID, Message{
+		Type:      "configUpdate",
+		SiteID:    siteID,
+		Config:    config,
+		Timestamp: now.Unix(),
+	})
+
+	h.mutex.RLock()
+	site, exists := h.sites[siteID]
+	h.mutex.RUnlock()
+	if !exists {
+		return
+	}
+	site.mutex.Lock()
+	site.ops.add(SiteOp{Op: "configUpdate", Timestamp: now.Unix()})
+	count := site.Count
+	site.mutex.Unlock()
+
+	h.publishEvent(FeedEvent{
+		Type:      "configUpdate",
+		SiteID:    siteID,
+		Count:     count,
+		Timestamp: now.Unix(),
+	})
+	log.Printf("站点 %s 的挂件配置已推送给在线挂件", siteID)
+}
+
+// 站长修改挂件配置：GET/PUT /owner/api/sites/{id}/widget
+//
+// PUT 替换整个挂件配置，请求体为 null 或 {} 时清除；在线的挂件在 -config-push-delay 后收到 configUpdate。
+func handleOwnerWidget(w http.ResponseWriter, r *http.Request, siteID string) {
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, settings.Get(siteID).Widget)
+		return
+	}
+	if r.Method != "PUT" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var widget *WidgetSettings
+	if err := json.NewDecoder(r.Body).Decode(&widget); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err := widget.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if widget != nil && reflect.DeepEqual(*widget, WidgetSettings{}) {
+		widget = nil
+	}
+
+	err := settings.Update(siteID, func(site *SiteSettings) {
+		site.Widget = widget
+	})
+	if err != nil {
+		log.Printf("保存站点配置失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, widget)
+}
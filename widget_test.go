@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// 读取下一条 configUpdate，返回下发配置中的字段
+func (c *testClient) expectConfigUpdate() map[string]any {
+	c.t.Helper()
+	message := c.expect("configUpdate")
+	data, err := json.Marshal(message.Config)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var config map[string]any
+	if err := json.Unmarshal(data, &config); err != nil {
+		c.t.Fatal(err)
+	}
+	return config
+}
+
+// 修改站点的挂件配置
+func updateWidget(t *testing.T, siteID string, fn func(widget *WidgetSettings)) {
+	t.Helper()
+	err := settings.Update(siteID, func(site *SiteSettings) {
+		if site.Widget == nil {
+			site.Widget = &WidgetSettings{}
+		}
+		fn(site.Widget)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 在线挂件只收到修改后的配置字段；与挂件无关的修改、改回原值的修改不推送，连续修改合并为一次
+func TestConfigUpdatePush(t *testing.T) {
+	h := startTestHub(t)
+	settings = loadTestSettings(t, `{"sites":{"a.example":{"widget":{"format":"{count} 人"}}}}`)
+	setFlag(t, "config-push-delay", "200ms")
+	server := startTestServer(t)
+
+	client := dialTestClient(t, server, "")
+	client.join("a.example")
+	other := dialTestClient(t, server, "")
+	other.join("b.example")
+
+	keys := func(config map[string]any) []string {
+		var keys []string
+		for key := range config {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	// 与挂件无关的修改，以及改动后又改回原值，都不推送
+	if err := settings.Update("a.example", func(site *SiteSettings) { site.MinClientVersion = "1.0.0" }); err != nil {
+		t.Fatal(err)
+	}
+	updateWidget(t, "a.example", func(widget *WidgetSettings) { widget.Label = "临时" })
+	updateWidget(t, "a.example", func(widget *WidgetSettings) { widget.Label = "" })
+
+	// 连续修改合并为一次推送，内容为最后的配置
+	updateWidget(t, "a.example", func(widget *WidgetSettings) { widget.Label = "在线" })
+	updateWidget(t, "a.example", func(widget *WidgetSettings) { widget.IdleTimeout = 60 })
+
+	config := client.expectConfigUpdate()
+	want := map[string]any{"format": "{count} 人", "formatOne": "{count} 人", "label": "在线", "idleTimeout": float64(60)}
+	if !reflect.DeepEqual(config, want) {
+		t.Fatalf("configUpdate 的配置为 %v，应为 %v", keys(config), keys(want))
+	}
+
+	// 清除站点配置时推送空配置，挂件恢复嵌入时的值；前面的修改没有再次推送
+	if err := settings.Update("a.example", func(site *SiteSettings) { site.Widget = nil }); err != nil {
+		t.Fatal(err)
+	}
+	if config := client.expectConfigUpdate(); len(config) != 0 {
+		t.Fatalf("清除后 configUpdate 的配置为 %v，应为空", config)
+	}
+
+	// 其他站点的挂件收不到推送
+	for {
+		message, err := other.read(300 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		if message.Type == "configUpdate" {
+			t.Fatalf("b.example 收到了 a.example 的配置 %+v", message)
+		}
+	}
+
+	// 每次推送记录在站点事件中
+	h.mutex.RLock()
+	site := h.sites["a.example"]
+	h.mutex.RUnlock()
+	site.mutex.RLock()
+	ops := site.ops.snapshot()
+	site.mutex.RUnlock()
+	pushes := 0
+	for _, op := range ops {
+		if op.Op == "configUpdate" {
+			pushes++
+		}
+	}
+	if pushes != 2 {
+		t.Fatalf("站点事件中有 %d 次 configUpdate，应为 2", pushes)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 向客户端写入的时长限制
+var (
+	writeTimeout     = flag.Duration("write-timeout", 10*time.Second, "向 WebSocket 客户端写入单帧的时长上限，超时即断开")
+	slowWriteStrikes = flag.Int("slow-write-strikes", 3, "单帧写入超过 -write-timeout 的 1/4 计为一次慢写，累计达到该次数时以慢客户端关闭码断开，0 不限制")
+)
+
+// 心跳：每 pingPeriod 发送一次 ping，pongWait 内没有收到任何消息或 pong 即断开
+var (
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
+// 关闭帧使用独立的短超时，拥塞的连接不会拖住关闭与排空
+const closeWriteTimeout = time.Second
+
+// 慢写的判定：单帧写入超过 -write-timeout 的几分之一
+const slowWriteFraction = 4
+
+// 慢客户端的关闭码（4429），挂件收到后退避重连
+var slowClientCloseCode = errorCloseCode(&SlowClientError{})
+
+// 单帧写入超过该时长计为一次慢写
+func slowWriteBudget() time.Duration {
+	return *writeTimeout / slowWriteFraction
+}
+
+// 发送关闭帧，使用独立的短超时；连接已因写入超时损坏时直接放弃
+func writeClose(conn *websocket.Conn, code int, text string) {
+	data := []byte{}
+	if code != 0 {
+		data = websocket.FormatCloseMessage(code, text)
+	}
+	conn.WriteControl(websocket.CloseMessage, data, time.Now().Add(closeWriteTimeout))
+}
+
+// 写入一条消息，返回用时
+func (c *Client) writeMessage(message Message) (time.Duration, error) {
+	start := time.Now()
+	c.conn.SetWriteDeadline(time.Now().Add(*writeTimeout))
+
+	var err error
+	if message.prepared != nil {
+		err = c.conn.WritePreparedMessage(message.prepared)
+	} else {
+		err = c.conn.WriteJSON(message)
+	}
+	return time.Since(start), err
+}
+
+// 记录一次慢写，返回是否应断开
+//
+// 发送队列还有一半以上空间时容忍，直到累计次数达到 -slow-write-strikes；
+// 队列已积压过半时说明客户端跟不上广播，立即断开。
+func (c *Client) strikeSlowWrite(elapsed time.Duration, strikes int) bool {
+	c.hub.slowWrites.Add(1)
+	backlog := len(c.send)
+	if backlog < cap(c.send)/2 && (*slowWriteStrikes == 0 || strikes < *slowWriteStrikes) {
+		return false
+	}
+	c.hub.slowWriteClosed.Add(1)
+	log.Printf("客户端 %s 写入过慢（单帧 %v，第 %d 次慢写，队列积压 %d/%d 条），断开",
+		c.ip, elapsed.Round(time.Millisecond), strikes, backlog, cap(c.send))
+	return true
+}
+
+// 写入是否因超时失败
+func isWriteTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 每次写入前先等待 delay 的连接，模拟带宽很低的客户端
+type throttledConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(p)
+}
+
+// 接受的连接都经过 throttledConn
+type throttledListener struct {
+	net.Listener
+	delay time.Duration
+}
+
+func (l *throttledListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &throttledConn{Conn: conn, delay: l.delay}, nil
+}
+
+// 慢写未达到次数前照常发送消息与心跳，达到 -slow-write-strikes 后以 4429 断开
+func TestSlowWriteStrikes(t *testing.T) {
+	setFlag(t, "write-timeout", "200ms")
+	setFlag(t, "slow-write-strikes", "3")
+	previousPing := pingPeriod
+	pingPeriod = 30 * time.Millisecond
+	t.Cleanup(func() { pingPeriod = previousPing })
+
+	// 每次写入 80ms：超过 -write-timeout 的 1/4（50ms），但不会写入超时
+	h := NewHub()
+	clients := make(chan *Client, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		client := &Client{conn: conn, hub: h, send: make(chan Message, 16), ip: "192.0.2.1"}
+		h.pumps.Add(1)
+		go client.writePump()
+		clients <- client
+	}))
+	server.Listener = &throttledListener{Listener: server.Listener, delay: 80 * time.Millisecond}
+	server.Start()
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 按到达顺序记录消息与 ping
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) {
+		mutex.Lock()
+		events = append(events, event)
+		mutex.Unlock()
+	}
+	conn.SetPingHandler(func(data string) error {
+		record("ping")
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+			record("message")
+		}
+	}()
+
+	// 每条消息之间留出间隔，队列不会积压，只按次数断开
+	client := <-clients
+	for i := 0; i < 3; i++ {
+		client.send <- Message{Type: "update", SiteID: "a.example", Count: i + 1}
+		time.Sleep(150 * time.Millisecond)
+	}
+
+	select {
+	case err := <-closed:
+		if code := closeCode(err); code != slowClientCloseCode {
+			t.Fatalf("以 %v 断开，应为关闭码 %d", err, slowClientCloseCode)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("慢写达到次数后没有断开")
+	}
+	waitPumps(t, h, time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	messages, pingsBetween := 0, 0
+	for _, event := range events {
+		switch {
+		case event == "message":
+			messages++
+		case messages > 0 && messages < 3:
+			pingsBetween++
+		}
+	}
+	if messages != 3 {
+		t.Fatalf("收到 %d 条消息，应为 3：%v", messages, events)
+	}
+	if pingsBetween == 0 {
+		t.Fatalf("慢写之间没有收到心跳：%v", events)
+	}
+	if slow, closedCount := h.slowWrites.Load(), h.slowWriteClosed.Load(); slow != 3 || closedCount != 1 {
+		t.Fatalf("慢写 %d 次、断开 %d 次，应为 3 与 1", slow, closedCount)
+	}
+}